@@ -0,0 +1,429 @@
+package astits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/icza/bitio"
+)
+
+// ErrClipNoStartFound is returned when the input ends before the start of the clip.
+var ErrClipNoStartFound = errors.New("no start found")
+
+// Clipper copies the packets of a time or byte range of a transport stream.
+//
+// Times are measured on the PCRs of the first program, ignoring discontinuities, and byte
+// offsets are relative to the start of the input. The start is moved back to the random access
+// point of the first video stream that precedes it, and the end is moved forward to the next
+// video PES so that the last frame is complete. The last PAT and PMT sent before the start are
+// written first, and packets belonging to PES or sections that are cut at the edges are dropped.
+type Clipper struct {
+	optEnd       *int64 // 90kHz
+	optEndByte   *int64
+	optRebase    bool
+	optStart     int64 // 90kHz
+	optStartByte int64
+	w            *bitio.Writer
+}
+
+type clipper struct {
+	c            *Clipper
+	dmx          *Demuxer
+	elapsed      int64 // 90kHz since the first PCR
+	ended        bool
+	lastPCR      *ClockReference
+	offset       *int64 // Timestamps are rebased on it
+	open         map[uint16]*clipperPES
+	pcrPID       uint16
+	pending      []*Packet // Packets preceding the start, from the last random access point
+	pmtPID       uint16
+	queue        []*Packet              // Packets waiting for the PES they belong to to be complete
+	rap          bool                   // Whether the first pending packet is a random access point
+	sections     map[uint16][][]*Packet // Last sections of the PAT and the PMT
+	started      bool
+	startReached bool
+	startedPIDs  map[uint16]bool
+	videoPID     uint16
+	videoType    StreamType
+}
+
+// clipperPES represents a PES or a section whose packets are being queued.
+type clipperPES struct {
+	first  *Packet
+	length int // Expected payload length, 0 if unknown
+	read   int
+}
+
+// ClipperOptStart returns the option to start the clip at a time. Default is the start of
+// the input.
+func ClipperOptStart(d time.Duration) func(*Clipper) {
+	return func(c *Clipper) {
+		c.optStart = int64(d * 90000 / time.Second)
+	}
+}
+
+// ClipperOptEnd returns the option to end the clip at a time. Default is the end of the input.
+func ClipperOptEnd(d time.Duration) func(*Clipper) {
+	return func(c *Clipper) {
+		v := int64(d * 90000 / time.Second)
+		c.optEnd = &v
+	}
+}
+
+// ClipperOptStartByte returns the option to start the clip at a byte offset.
+func ClipperOptStartByte(off int64) func(*Clipper) {
+	return func(c *Clipper) {
+		c.optStartByte = off
+	}
+}
+
+// ClipperOptEndByte returns the option to end the clip at a byte offset.
+func ClipperOptEndByte(off int64) func(*Clipper) {
+	return func(c *Clipper) {
+		c.optEndByte = &off
+	}
+}
+
+// ClipperOptRebaseTimestamps returns the option to rebase PCRs, OPCRs, PTSs and DTSs so that
+// the clip starts at 0.
+func ClipperOptRebaseTimestamps() func(*Clipper) {
+	return func(c *Clipper) {
+		c.optRebase = true
+	}
+}
+
+// NewClipper creates a new clipper writing 188 bytes packets.
+func NewClipper(w io.Writer, opts ...func(*Clipper)) *Clipper {
+	c := &Clipper{w: bitio.NewWriter(w)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Clip copies the packets of the clip read from r.
+func (c *Clipper) Clip(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) error {
+	cl := &clipper{
+		c:           c,
+		dmx:         NewDemuxer(ctx, r, opts...),
+		open:        make(map[uint16]*clipperPES),
+		sections:    make(map[uint16][][]*Packet),
+		startedPIDs: make(map[uint16]bool),
+	}
+	return cl.clip()
+}
+
+func (c *clipper) clip() error {
+	for !c.ended {
+		// Get next packet
+		p, err := c.dmx.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// Learn programs and random access points
+		c.processData(c.dmx.packetData(p))
+		if err = c.processPacket(p); err != nil {
+			return err
+		}
+	}
+	if !c.started {
+		return ErrClipNoStartFound
+	}
+
+	// Packets of PES whose length is unknown are complete when the input ends
+	if err := c.flush(!c.ended); err != nil {
+		return fmt.Errorf("flushing failed: %w", err)
+	}
+	return c.c.w.Close()
+}
+
+// processData processes the data of a payload unit.
+func (c *clipper) processData(ds []*DemuxerData) {
+	for _, d := range ds {
+		switch {
+		case d.PAT != nil:
+			if c.pmtPID == 0 && len(d.PAT.Programs) > 0 {
+				c.pmtPID = d.PAT.Programs[0].ProgramMapID
+			}
+		case d.PMT != nil:
+			if d.PID != c.pmtPID || c.pcrPID > 0 {
+				continue
+			}
+			c.pcrPID = d.PMT.PCRPID
+			for _, es := range d.PMT.ElementaryStreams {
+				if t := elementaryStreamType(es); t.IsVideo() {
+					c.videoPID = es.ElementaryPID
+					c.videoType = t
+					break
+				}
+			}
+		case d.PES != nil && d.PID == c.videoPID && !c.started:
+			// Packets preceding a random access point are not needed anymore
+			first := d.FirstPacket
+			if videoFrameType(c.videoType, d.PES.Data) == FrameTypeUnknown &&
+				!(first.Header.HasAdaptationField && first.AdaptationField.RandomAccessIndicator) {
+				continue
+			}
+			for idx, v := range c.pending {
+				if v == first {
+					c.pending = c.pending[idx:]
+					c.rap = true
+					break
+				}
+			}
+		}
+	}
+}
+
+func (c *clipper) processPacket(p *Packet) error {
+	// Keep the PAT and the PMT that were sent last before the start
+	pid := p.Header.PID
+	if !c.started && (pid == PIDPAT || (c.pmtPID > 0 && pid == c.pmtPID)) {
+		ss := c.sections[pid]
+		if p.Header.PayloadUnitStartIndicator {
+			ss = append(ss, []*Packet{p})
+		} else if len(ss) > 0 {
+			ss[len(ss)-1] = append(ss[len(ss)-1], p)
+		}
+		first := p.Index
+		if len(c.pending) > 0 {
+			first = c.pending[0].Index
+		}
+		for len(ss) > 1 && ss[1][0].Index < first {
+			ss = ss[1:]
+		}
+		c.sections[pid] = ss
+	}
+
+	// Measure time, discontinuities being ignored
+	if c.pcrPID > 0 && pid == c.pcrPID && p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+		if c.lastPCR != nil && !p.AdaptationField.DiscontinuityIndicator {
+			if d := (p.AdaptationField.PCR.Base - c.lastPCR.Base + clockReferenceBaseModulo) % clockReferenceBaseModulo; d <= timelineMaxTimestampGap {
+				c.elapsed += d
+			}
+		}
+		c.lastPCR = p.AdaptationField.PCR
+	}
+	videoPUSI := c.videoPID > 0 && pid == c.videoPID && p.Header.PayloadUnitStartIndicator
+
+	if c.started {
+		// The end is moved to the next video PES so that the last frame is complete
+		if c.c.optEnd != nil && c.lastPCR != nil && c.elapsed >= *c.c.optEnd ||
+			c.c.optEndByte != nil && p.Offset >= *c.c.optEndByte {
+			c.ended = c.videoPID == 0 || videoPUSI
+		}
+		if c.ended {
+			// The last video PES is complete since the next one is starting
+			if videoPUSI {
+				delete(c.open, pid)
+			}
+			return nil
+		}
+		return c.add(p)
+	}
+
+	// Packets are kept from the last random access point
+	if c.videoPID > 0 {
+		c.pending = append(c.pending, p)
+	}
+	if !c.startReached {
+		c.startReached = p.Offset >= c.c.optStartByte && (c.c.optStart == 0 || c.lastPCR != nil && c.elapsed >= c.c.optStart)
+	}
+
+	// Random access points are only known once the next video PES starts
+	switch {
+	case !c.startReached:
+		return nil
+	case c.videoPID == 0:
+		return c.start([]*Packet{p})
+	case videoPUSI && c.rap:
+		return c.start(c.pending)
+	}
+	return nil
+}
+
+func (c *clipper) start(ps []*Packet) error {
+	c.started = true
+	c.pending = nil
+
+	// Rebase on the first PCR of the clip, or on the last one if the clip has none yet
+	if c.c.optRebase {
+		for _, p := range ps {
+			if p.Header.PID == c.pcrPID && p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+				v := p.AdaptationField.PCR.Base
+				c.offset = &v
+				break
+			}
+		}
+		if c.offset == nil && c.lastPCR != nil {
+			v := c.lastPCR.Base
+			c.offset = &v
+		}
+	}
+
+	// Write the tables sent last before the start, which keeps continuity counters continuous
+	for _, pid := range []uint16{PIDPAT, c.pmtPID} {
+		var section []*Packet
+		for _, s := range c.sections[pid] {
+			if s[0].Index < ps[0].Index {
+				section = s
+			}
+		}
+		for _, p := range section {
+			if err := c.write(p); err != nil {
+				return fmt.Errorf("writing table failed: %w", err)
+			}
+		}
+	}
+	c.sections = nil
+
+	// Add packets
+	for _, p := range ps {
+		if err := c.add(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add queues a packet of the clip and writes the packets whose PES or section is complete.
+func (c *clipper) add(p *Packet) error {
+	// Packets preceding the first payload start of their PID are dropped
+	pid := p.Header.PID
+	if !p.Header.HasPayload {
+		c.queue = append(c.queue, p)
+		return c.flush(false)
+	}
+	if p.Header.PayloadUnitStartIndicator {
+		c.startedPIDs[pid] = true
+		c.open[pid] = &clipperPES{first: p, length: payloadUnitLength(p.Payload)}
+	} else if !c.startedPIDs[pid] {
+		return nil
+	}
+	if pes, ok := c.open[pid]; ok {
+		pes.read += len(p.Payload)
+		if pes.length > 0 && pes.read >= pes.length {
+			delete(c.open, pid)
+		}
+	}
+	c.queue = append(c.queue, p)
+	return c.flush(false)
+}
+
+// flush writes queued packets up to the first one belonging to an incomplete PES or section.
+// When the clip is over, incomplete PES and sections are dropped, unless their length is
+// unknown and the input has ended.
+func (c *clipper) flush(eof bool) error {
+	over := eof || c.ended
+	var idx int
+	for ; idx < len(c.queue); idx++ {
+		p := c.queue[idx]
+		if pes, ok := c.open[p.Header.PID]; ok && p.Header.HasPayload && p.Index >= pes.first.Index {
+			if !over {
+				break
+			} else if pes.length > 0 || !eof {
+				continue
+			}
+		}
+		if err := c.write(p); err != nil {
+			return err
+		}
+	}
+	c.queue = c.queue[idx:]
+	return nil
+}
+
+func (c *clipper) write(p *Packet) error {
+	// Rebase timestamps
+	if c.offset != nil {
+		if p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+			p.AdaptationField.PCR = newClockReference(rebaseTimestamp(p.AdaptationField.PCR.Base, *c.offset), p.AdaptationField.PCR.Extension)
+		}
+		if p.Header.HasAdaptationField && p.AdaptationField.HasOPCR {
+			p.AdaptationField.OPCR = newClockReference(rebaseTimestamp(p.AdaptationField.OPCR.Base, *c.offset), p.AdaptationField.OPCR.Extension)
+		}
+		if p.Header.PayloadUnitStartIndicator && !isPSIPayload(p.Header.PID, c.dmx.programMap) {
+			rebasePESTimestamps(p.Payload, *c.offset)
+		}
+	}
+
+	if _, err := writePacket(c.c.w, p, MpegTsPacketSize); err != nil {
+		return fmt.Errorf("writing packet failed: %w", err)
+	}
+	return nil
+}
+
+// payloadUnitLength returns the length of the PES or of the first section starting a payload,
+// or 0 if it is unknown.
+func payloadUnitLength(b []byte) int {
+	// PES length is unknown when it is 0
+	if isPESPayload(b) {
+		if len(b) < 6 {
+			return 0
+		}
+		if l := int(b[4])<<8 | int(b[5]); l > 0 {
+			return l + 6
+		}
+		return 0
+	}
+
+	// Sections are preceded by a pointer field
+	if len(b) < 1 || len(b) < int(b[0])+4 {
+		return 0
+	}
+	s := b[1+int(b[0]):]
+	return 1 + int(b[0]) + 3 + (int(s[1]&0xf)<<8 | int(s[2]))
+}
+
+// rebasePESTimestamps rebases the PTS and the DTS at the start of a PES payload.
+func rebasePESTimestamps(b []byte, offset int64) {
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 || !hasPESOptionalHeader(b[3]) {
+		return
+	}
+	switch b[7] >> 6 {
+	case PTSDTSIndicatorOnlyPTS:
+		if len(b) >= 14 {
+			rebasePTSOrDTS(b[9:14], offset)
+		}
+	case PTSDTSIndicatorBothPresent:
+		if len(b) >= 19 {
+			rebasePTSOrDTS(b[9:14], offset)
+			rebasePTSOrDTS(b[14:19], offset)
+		}
+	}
+}
+
+// pesPayloadPTS returns the PTS at the start of a PES payload, if any.
+func pesPayloadPTS(b []byte) (int64, bool) {
+	if len(b) < 14 || b[0] != 0 || b[1] != 0 || b[2] != 1 || !hasPESOptionalHeader(b[3]) || b[7]>>6&PTSDTSIndicatorOnlyPTS == 0 {
+		return 0, false
+	}
+	return decodePTSOrDTS(b[9:14]), true
+}
+
+// rebasePTSOrDTS rewrites a PTS or a DTS in place, keeping its prefix and marker bits.
+func rebasePTSOrDTS(b []byte, offset int64) {
+	ts := rebaseTimestamp(decodePTSOrDTS(b), offset)
+	b[0] = b[0]&0xf1 | byte(ts>>29)&0xe
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14)&0xfe | b[2]&0x1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | b[4]&0x1
+}
+
+// decodePTSOrDTS decodes the 5 bytes of a PTS or a DTS.
+func decodePTSOrDTS(b []byte) int64 {
+	return int64(b[0]>>1&0x7)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// rebaseTimestamp subtracts an offset from a 33 bits timestamp.
+func rebaseTimestamp(ts, offset int64) int64 {
+	return ((ts-offset)%clockReferenceBaseModulo + clockReferenceBaseModulo) % clockReferenceBaseModulo
+}
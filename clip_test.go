@@ -0,0 +1,100 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func clipPTSs(t *testing.T, b []byte) (pcrs []int64, ptss map[uint16][]int64, pids []uint16) {
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b), DemuxerOptAdaptationFieldData())
+	ptss = make(map[uint16][]int64)
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if len(pids) == 0 || pids[len(pids)-1] != d.PID {
+			pids = append(pids, d.PID)
+		}
+		switch {
+		case d.AdaptationField != nil && d.AdaptationField.HasPCR:
+			pcrs = append(pcrs, d.AdaptationField.PCR.Base)
+		case d.PES != nil:
+			ptss[d.PID] = append(ptss[d.PID], d.PES.Header.OptionalHeader.PTS.Base)
+		}
+	}
+	return
+}
+
+func TestClipper(t *testing.T) {
+	b := hlsBytes(t)
+
+	// Whole input
+	buf := &bytes.Buffer{}
+	err := NewClipper(buf, ClipperOptEndByte(int64(len(b)))).Clip(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, b, buf.Bytes())
+
+	// Time range
+	buf.Reset()
+	err = NewClipper(buf, ClipperOptStart(1500*time.Millisecond), ClipperOptEnd(3500*time.Millisecond)).Clip(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, PIDPAT, uint16(buf.Bytes()[1]&0x1f)<<8|uint16(buf.Bytes()[2]))
+	_, ptss, pids := clipPTSs(t, buf.Bytes())
+	assert.Equal(t, []uint16{PIDPAT, 0x1000, 0x100}, pids[:3])
+	var expected []int64
+	for idx := 5; idx < 18; idx++ {
+		expected = append(expected, int64(idx*18000+9000))
+	}
+	assert.Equal(t, expected, ptss[0x100])
+	assert.Equal(t, expected, ptss[0x101])
+	a, _ := analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Rebased
+	buf.Reset()
+	err = NewClipper(buf, ClipperOptStart(1500*time.Millisecond), ClipperOptEnd(3500*time.Millisecond), ClipperOptRebaseTimestamps()).Clip(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	pcrs, ptss, _ := clipPTSs(t, buf.Bytes())
+	assert.Equal(t, int64(0), pcrs[0])
+	assert.Equal(t, int64(9000), ptss[0x100][0])
+	assert.Equal(t, int64(12*18000+9000), ptss[0x101][12])
+
+	// Start after the end of the input
+	err = NewClipper(&bytes.Buffer{}, ClipperOptStart(time.Hour)).Clip(context.Background(), bytes.NewReader(b))
+	assert.Equal(t, ErrClipNoStartFound, err)
+}
+
+func TestClipperDropsIncompletePES(t *testing.T) {
+	// The audio PES written last is cut by the end of the input
+	b := hlsBytes(t)
+	b = b[:len(b)-MpegTsPacketSize]
+	buf := &bytes.Buffer{}
+	err := NewClipper(buf).Clip(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	_, ptss, _ := clipPTSs(t, buf.Bytes())
+	assert.Len(t, ptss[0x100], 50)
+	assert.Len(t, ptss[0x101], 49)
+}
+
+func TestRebasePTSOrDTS(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	_, err := writePTSOrDTS(w, 0x3, newClockReference(5, 0))
+	assert.NoError(t, err)
+	b := buf.Bytes()
+	rebasePTSOrDTS(b, 10)
+	buf2 := &bytes.Buffer{}
+	w = bitio.NewWriter(buf2)
+	_, err = writePTSOrDTS(w, 0x3, newClockReference(clockReferenceBaseModulo-5, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, buf2.Bytes(), b)
+}
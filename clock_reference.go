@@ -4,6 +4,13 @@ import (
 	"time"
 )
 
+// clockReferenceBaseModulo is the modulo of the 33 bits PTS, DTS and PCR bases.
+const clockReferenceBaseModulo = 1 << 33
+
+// pcrMaxGap is the gap between 2 consecutive PCRs above which we consider there's an
+// unsignalled discontinuity. PCRs are supposed to be sent at least every 100ms.
+const pcrMaxGap = 90000
+
 // ClockReference represents a clock reference.
 // Base is based on a 90 kHz clock and extension is based on a 27 MHz clock.
 type ClockReference struct {
@@ -27,3 +34,46 @@ func (p ClockReference) Duration() time.Duration {
 func (p ClockReference) Time() time.Time {
 	return time.Unix(0, p.Duration().Nanoseconds())
 }
+
+// Sub returns the duration between 2 clock references. Bases are compared as 33 bits
+// values, and the result is the shortest difference between them so that a wraparound
+// between o and p is handled.
+func (p ClockReference) Sub(o ClockReference) time.Duration {
+	return ClockReference{
+		Base:      clockReferenceBaseDiff(p.Base, o.Base),
+		Extension: p.Extension - o.Extension,
+	}.Duration()
+}
+
+// Add adds a duration to the clock reference, wrapping around the 33 bits base.
+func (p ClockReference) Add(d time.Duration) *ClockReference {
+	// Work on the 27 MHz clock
+	t := p.Base*300 + p.Extension + d.Nanoseconds()*27/1000
+	base, ext := t/300, t%300
+	if ext < 0 {
+		base, ext = base-1, ext+300
+	}
+	return newClockReference((base%clockReferenceBaseModulo+clockReferenceBaseModulo)%clockReferenceBaseModulo, ext)
+}
+
+// Before indicates whether the clock reference is before o, handling wraparounds.
+func (p ClockReference) Before(o ClockReference) bool {
+	return p.Sub(o) < 0
+}
+
+// After indicates whether the clock reference is after o, handling wraparounds.
+func (p ClockReference) After(o ClockReference) bool {
+	return p.Sub(o) > 0
+}
+
+// clockReferenceBaseDiff returns the shortest difference between 2 33 bits bases.
+func clockReferenceBaseDiff(a, b int64) int64 {
+	d := (a - b) % clockReferenceBaseModulo
+	switch {
+	case d >= clockReferenceBaseModulo/2:
+		d -= clockReferenceBaseModulo
+	case d < -clockReferenceBaseModulo/2:
+		d += clockReferenceBaseModulo
+	}
+	return d
+}
@@ -13,3 +13,22 @@ func TestClockReference(t *testing.T) {
 	assert.Equal(t, 36344825768814*time.Nanosecond, clockReference.Duration())
 	assert.Equal(t, int64(36344), clockReference.Time().Unix())
 }
+
+func TestClockReferenceArithmetic(t *testing.T) {
+	// Sub
+	assert.Equal(t, time.Second, newClockReference(90000, 0).Sub(*newClockReference(0, 0)))
+	assert.Equal(t, -time.Second, newClockReference(0, 0).Sub(*newClockReference(90000, 0)))
+	assert.Equal(t, time.Second, newClockReference(45000, 0).Sub(*newClockReference(clockReferenceBaseModulo-45000, 0)))
+	assert.Equal(t, -time.Second, newClockReference(clockReferenceBaseModulo-45000, 0).Sub(*newClockReference(45000, 0)))
+
+	// Add
+	assert.Equal(t, newClockReference(45000, 150), newClockReference(clockReferenceBaseModulo-45000, 150).Add(time.Second))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-45000, 150), newClockReference(45000, 150).Add(-time.Second))
+	assert.Equal(t, newClockReference(1, 0), newClockReference(0, 299).Add(38*time.Nanosecond))
+
+	// Before and after
+	assert.True(t, newClockReference(clockReferenceBaseModulo-1, 0).Before(*newClockReference(1, 0)))
+	assert.True(t, newClockReference(1, 0).After(*newClockReference(clockReferenceBaseModulo-1, 0)))
+	assert.False(t, newClockReference(1, 0).Before(*newClockReference(1, 0)))
+	assert.True(t, newClockReference(1, 1).After(*newClockReference(1, 0)))
+}
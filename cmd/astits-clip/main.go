@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/go-astits"
+)
+
+const (
+	ioBufSize = 10 * 1024 * 1024
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Copy a time range or a byte range of a TS file, starting at a video random access point\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "%s INPUT_FILE [FLAGS]:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	outFile := flag.String("o", "out.ts", "Output file, 'out.ts' by default")
+	start := flag.Duration("start", 0, "Start time, relative to the first PCR")
+	end := flag.Duration("end", 0, "End time, relative to the first PCR (if empty, the clip ends with the input)")
+	startByte := flag.Int64("start-byte", 0, "Start byte offset")
+	endByte := flag.Int64("end-byte", 0, "End byte offset (if empty, the clip ends with the input)")
+	rebase := flag.Bool("rebase", false, "Rebase timestamps to zero")
+	inputFile := astikit.FlagCmd()
+	flag.Parse()
+
+	// Options
+	opts := []func(*astits.Clipper){astits.ClipperOptStart(*start), astits.ClipperOptStartByte(*startByte)}
+	if *end > 0 {
+		opts = append(opts, astits.ClipperOptEnd(*end))
+	}
+	if *endByte > 0 {
+		opts = append(opts, astits.ClipperOptEndByte(*endByte))
+	}
+	if *rebase {
+		opts = append(opts, astits.ClipperOptRebaseTimestamps())
+	}
+
+	infile, err := os.Open(inputFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer infile.Close()
+
+	outfile, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer outfile.Close()
+
+	w := bufio.NewWriterSize(outfile, ioBufSize)
+	if err = astits.NewClipper(w, opts...).Clip(context.Background(), bufio.NewReaderSize(infile, ioBufSize)); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
@@ -14,6 +14,8 @@ import (
 	"os/signal"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/asticode/go-astikit"
 	"github.com/asticode/go-astits"
@@ -22,6 +24,7 @@ import (
 
 // Flags.
 var (
+	bitrate         = flag.Int64("b", 0, "the media bitrate in bps, derived from the PCRs if empty")
 	ctx, cancel     = context.WithCancel(context.Background())
 	cpuProfiling    = flag.Bool("cp", false, "if yes, cpu profiling is enabled")
 	dataTypes       = astikit.NewFlagStrings()
@@ -33,10 +36,10 @@ var (
 func main() { //nolint:funlen
 	// Init
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s <data|packets|default>:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s <data|duration|mdi|packets|pcr|stats|default>:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
-	flag.Var(dataTypes, "d", "the datatypes whitelist (all, pat, pmt, pes, eit, nit, sdt, tot)")
+	flag.Var(dataTypes, "d", "the datatypes whitelist (all, pat, pmt, pes, eit, nit, sdt, tdt, tot)")
 	cmd := astikit.FlagCmd()
 	flag.Parse()
 
@@ -72,11 +75,31 @@ func main() { //nolint:funlen
 		if err = data(dmx); err != nil {
 			log.Fatal(fmt.Errorf("astits: fetching data failed: %w", err))
 		}
+	case "duration":
+		// Probe duration
+		if err = duration(r); err != nil {
+			log.Fatal(fmt.Errorf("astits: probing duration failed: %w", err))
+		}
+	case "mdi":
+		// Measure the media delivery index
+		if err = mdi(dmx); err != nil {
+			log.Fatal(fmt.Errorf("astits: measuring mdi failed: %w", err))
+		}
 	case "packets":
 		// Fetch packets
 		if err = packets(dmx); err != nil {
 			log.Fatal(fmt.Errorf("astits: fetching packets failed: %w", err))
 		}
+	case "pcr":
+		// Analyze PCRs
+		if err = pcr(dmx); err != nil {
+			log.Fatal(fmt.Errorf("astits: analyzing pcrs failed: %w", err))
+		}
+	case "stats":
+		// Collect stats
+		if err = stats(r); err != nil {
+			log.Fatal(fmt.Errorf("astits: collecting stats failed: %w", err))
+		}
 	default:
 		// Fetch the programs
 		var pgms []*Program
@@ -147,7 +170,9 @@ func buildReader() (r io.Reader, err error) {
 			return
 		}
 		c.SetReadBuffer(4096) // nolint:errcheck
-		r = c
+
+		// Stamp datagrams with their arrival time
+		r = astits.NewDatagramReader(c)
 	default:
 		// Open file
 		var f *os.File
@@ -190,9 +215,203 @@ func packets(dmx *astits.Demuxer) (err error) {
 	return nil
 }
 
+func duration(r io.Reader) (err error) {
+	// Reader must be seekable
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		err = errors.New("input is not seekable") // nolint:goerr113
+		return
+	}
+
+	// Probe
+	var i *astits.ProbeInfo
+	if i, err = astits.Probe(ctx, rs); err != nil {
+		err = fmt.Errorf("astits: probing failed: %w", err)
+		return
+	}
+
+	// Print
+	switch *format {
+	case "json":
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err = e.Encode(i); err != nil {
+			err = fmt.Errorf("astits: json encoding to stdout failed: %w", err)
+			return
+		}
+	default:
+		log.Printf("Duration: %s\n", i.Duration)
+		log.Printf("Start time: %s\n", i.StartTime)
+		log.Printf("Bitrate: %d bps\n", i.Bitrate)
+		log.Printf("PCR PID: %d\n", i.PCRPID)
+		if i.Discontinuity {
+			log.Println("Discontinuity detected, duration is estimated from the bitrate")
+		}
+		log.Println("Streams:")
+		for _, s := range i.Streams {
+			log.Printf("  * [%d] - Type: %d - Duration: %s - Bitrate: %d bps\n", s.PID, s.StreamType, s.Duration, s.Bitrate)
+		}
+	}
+	return nil
+}
+
+func pcr(dmx *astits.Demuxer) (err error) {
+	// Loop through packets
+	var p *astits.Packet
+	a := astits.NewPCRAnalyzer()
+	var ss []*astits.PCRSample
+	if *format != "json" {
+		fmt.Println("pid,packet_index,offset,pcr,interval_ns,accuracy_ns,jitter_ns,discontinuity")
+	}
+	for {
+		// Get next packet
+		if p, err = dmx.NextPacket(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			err = fmt.Errorf("astits: getting next packet failed: %w", err)
+			return
+		}
+
+		// Analyze
+		s := a.Analyze(p)
+		if s == nil {
+			continue
+		}
+
+		// Print the time series
+		switch *format {
+		case "json":
+			ss = append(ss, s)
+		default:
+			fmt.Printf("%d,%d,%d,%d,%d,%d,%d,%t\n", s.PID, s.PacketIndex, s.Offset, s.PCR.Base*300+s.PCR.Extension,
+				s.Interval.Nanoseconds(), s.Accuracy.Nanoseconds(), s.Jitter.Nanoseconds(), s.Discontinuity)
+		}
+	}
+
+	// Print stats
+	switch *format {
+	case "json":
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err = e.Encode(struct {
+			Samples []*astits.PCRSample
+			Stats   []*astits.PCRStats
+		}{
+			Samples: ss,
+			Stats:   a.Stats(),
+		}); err != nil {
+			err = fmt.Errorf("astits: json encoding to stdout failed: %w", err)
+			return
+		}
+	default:
+		for _, s := range a.Stats() {
+			log.Printf("[%d] - PCRs: %d - Bitrate: %d bps - Interval: avg %s, max %s - Max accuracy: %s - Max jitter: %s - Discontinuities: %d\n",
+				s.PID, s.Count, s.Bitrate, s.AverageInterval, s.MaxInterval, s.MaxAccuracy, s.MaxJitter, s.Discontinuities)
+		}
+	}
+	return nil
+}
+
+func mdi(dmx *astits.Demuxer) (err error) {
+	// Create analyzer
+	var opts []func(*astits.MDIAnalyzer)
+	if *bitrate > 0 {
+		opts = append(opts, astits.MDIAnalyzerOptBitrate(*bitrate))
+	}
+	a := astits.NewMDIAnalyzer(opts...)
+
+	// Loop through packets
+	var p *astits.Packet
+	e := json.NewEncoder(os.Stdout)
+	log.Println("Measuring MDI...")
+	for {
+		// Get next packet
+		if p, err = dmx.NextPacket(); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				break
+			}
+			err = fmt.Errorf("astits: getting next packet failed: %w", err)
+			return
+		}
+
+		// Analyze
+		s := a.Analyze(p)
+		if s == nil {
+			continue
+		}
+
+		// Print
+		switch *format {
+		case "json":
+			if err = e.Encode(s); err != nil {
+				err = fmt.Errorf("astits: json encoding to stdout failed: %w", err)
+				return
+			}
+		default:
+			log.Printf("MDI: %.2f:%.2f - Bitrate: %d bps - Lost packets: %d\n",
+				float64(s.DelayFactor)/float64(time.Millisecond), s.MediaLossRate, s.Bitrate, s.LostPackets)
+		}
+	}
+	return nil
+}
+
+func stats(r io.Reader) (err error) {
+	// Live streams are timed with the wall clock
+	var opts []func(*astits.Stats)
+	if _, ok := r.(astits.ArrivalTimeReader); ok {
+		opts = append(opts, astits.StatsOptNow(time.Now))
+	}
+	s := astits.NewStats(opts...)
+
+	// Loop through data until the end of the input or until the user stops
+	dmx := astits.NewDemuxer(ctx, r, astits.DemuxerOptResync(), astits.DemuxerOptStats(s))
+	log.Println("Collecting stats...")
+	for {
+		if _, err = dmx.NextData(); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				break
+			}
+			err = fmt.Errorf("astits: getting next data failed: %w", err)
+			return
+		}
+	}
+
+	// Print
+	o := s.Snapshot()
+	switch *format {
+	case "json":
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err = e.Encode(o); err != nil {
+			err = fmt.Errorf("astits: json encoding to stdout failed: %w", err)
+			return
+		}
+	default:
+		fmt.Printf("Packets: %d - Bitrate: %d bps - Null share: %.2f%% - Scrambled packets: %d\n\n",
+			o.Packets, o.Bitrate, o.NullShare*100, o.ScrambledPackets)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PROGRAM\tPMT PID\tPCR PID\tELEMENTARY PIDS")
+		for _, p := range o.Programs {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%v\n", p.ProgramNumber, p.PMTPID, p.PCRPID, p.ElementaryPIDs)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "PID\tTYPE\tPROGRAMS\tPACKETS\tSHARE\tBITRATE\tSCRAMBLED\tPES\tPES AVG SIZE\tPES MAX SIZE")
+		for _, p := range o.PIDs {
+			fmt.Fprintf(w, "%d\t%d\t%v\t%d\t%.2f%%\t%d bps\t%.2f%%\t%d\t%d\t%d\n", p.PID, p.StreamType, p.Programs,
+				p.Packets, p.Share*100, p.Bitrate, p.ScrambledRatio*100, p.PESCount, p.PESAverageSize, p.PESMaxSize)
+		}
+		if err = w.Flush(); err != nil {
+			err = fmt.Errorf("astits: flushing table failed: %w", err)
+			return
+		}
+	}
+	return nil
+}
+
 func data(dmx *astits.Demuxer) (err error) { // nolint:funlen,gocognit,gocyclo
 	// Determine which data to log
-	var logAll, logEIT, logNIT, logPAT, logPES, logPMT, logSDT, logTOT bool
+	var logAll, logEIT, logNIT, logPAT, logPES, logPMT, logSDT, logTDT, logTOT bool
 	if _, ok := dataTypes.Map["all"]; ok {
 		logAll = true
 	}
@@ -214,6 +433,9 @@ func data(dmx *astits.Demuxer) (err error) { // nolint:funlen,gocognit,gocyclo
 	if _, ok := dataTypes.Map["sdt"]; ok {
 		logSDT = true
 	}
+	if _, ok := dataTypes.Map["tdt"]; ok {
+		logTDT = true
+	}
 	if _, ok := dataTypes.Map["tot"]; ok {
 		logTOT = true
 	}
@@ -270,6 +492,10 @@ func data(dmx *astits.Demuxer) (err error) { // nolint:funlen,gocognit,gocyclo
 		case d.SDT != nil && (logAll || logSDT):
 			log.Printf("SDT: %d\n", d.PID)
 
+		case d.TDT != nil && (logAll || logTDT):
+			log.Printf("TDT: %d\n", d.PID)
+			log.Printf("  UTC Time: %s\n", d.TDT.UTCTime)
+
 		case d.TOT != nil && (logAll || logTOT):
 			log.Printf("TOT: %d\n", d.PID)
 		}
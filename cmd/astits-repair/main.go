@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/go-astits"
+)
+
+const (
+	ioBufSize = 10 * 1024 * 1024
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Repair a damaged TS file and report what was changed\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "%s INPUT_FILE [FLAGS]:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	outFile := flag.String("o", "out.ts", "Output file, 'out.ts' by default")
+	probeSize := flag.Int("probe-size", 0, "Number of packets after which missing tables are generated")
+	verbose := flag.Bool("v", false, "Print every repair, not only counters")
+	inputFile := astikit.FlagCmd()
+	flag.Parse()
+
+	// Options
+	var opts []func(*astits.Repairer)
+	if *probeSize > 0 {
+		opts = append(opts, astits.RepairerOptProbeSize(*probeSize))
+	}
+
+	infile, err := os.Open(inputFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer infile.Close()
+
+	outfile, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer outfile.Close()
+
+	w := bufio.NewWriterSize(outfile, ioBufSize)
+	r, err := astits.NewRepairer(w, opts...).Repair(context.Background(), bufio.NewReaderSize(infile, ioBufSize))
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// Report
+	if *verbose {
+		for _, e := range r.Events {
+			fmt.Printf("offset %d, pid %d: %s: %s\n", e.Offset, e.PID, e.Action, e.Message)
+		}
+	}
+	var actions []string
+	for a := range r.Counters {
+		actions = append(actions, string(a))
+	}
+	sort.Strings(actions)
+	for _, a := range actions {
+		fmt.Printf("%s: %d\n", a, r.Counters[astits.RepairAction(a)])
+	}
+	fmt.Printf("skipped bytes: %d\n", r.SkippedBytes)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/asticode/go-astikit"
+	"github.com/asticode/go-astits"
+)
+
+const (
+	ioBufSize = 10 * 1024 * 1024
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Renumber PIDs, program numbers and transport stream ID of a TS file\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "%s INPUT_FILE [FLAGS]:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	outFile := flag.String("o", "out.ts", "Output file, 'out.ts' by default")
+	pids := astikit.NewFlagStrings()
+	flag.Var(pids, "pid", "PID to renumber, as FROM:TO (repeatable argument)")
+	programs := astikit.NewFlagStrings()
+	flag.Var(programs, "program", "Program number to renumber, as FROM:TO (repeatable argument)")
+	tsid := flag.String("tsid", "", "Transport stream ID")
+	inputFile := astikit.FlagCmd()
+	flag.Parse()
+
+	// Options
+	var opts []func(*astits.Rewriter)
+	for _, v := range *pids.Slice {
+		from, to, err := parseMapping(v)
+		if err != nil {
+			log.Fatalf("parsing PID mapping %s failed: %v", v, err)
+		}
+		opts = append(opts, astits.RewriterOptPID(from, to))
+	}
+	for _, v := range *programs.Slice {
+		from, to, err := parseMapping(v)
+		if err != nil {
+			log.Fatalf("parsing program number mapping %s failed: %v", v, err)
+		}
+		opts = append(opts, astits.RewriterOptProgramNumber(from, to))
+	}
+	if *tsid != "" {
+		id, err := parseUint16(*tsid)
+		if err != nil {
+			log.Fatalf("parsing transport stream ID failed: %v", err)
+		}
+		opts = append(opts, astits.RewriterOptTransportStreamID(id))
+	}
+
+	infile, err := os.Open(inputFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer infile.Close()
+
+	outfile, err := os.Create(*outFile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer outfile.Close()
+
+	w := bufio.NewWriterSize(outfile, ioBufSize)
+	if err = astits.NewRewriter(w, opts...).Rewrite(context.Background(), bufio.NewReaderSize(infile, ioBufSize)); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err = w.Flush(); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func parseMapping(s string) (from, to uint16, err error) {
+	items := strings.Split(s, ":")
+	if len(items) != 2 {
+		err = fmt.Errorf("invalid mapping %s", s)
+		return
+	}
+	if from, err = parseUint16(items[0]); err != nil {
+		return
+	}
+	to, err = parseUint16(items[1])
+	return
+}
+
+// parseUint16 parses decimal and 0x prefixed hexadecimal values.
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	return uint16(v), err
+}
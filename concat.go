@@ -0,0 +1,364 @@
+package astits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ErrConcatNoPMTFound is returned when an input ends before its PMT is found.
+var ErrConcatNoPMTFound = errors.New("no PMT found")
+
+const (
+	concatenatorFirstPID = 0x100
+	// Packets buffered while waiting for a PCR, after which the offset is computed on PTSs
+	concatenatorMaxPendingPackets = 5000
+)
+
+// Concatenator appends transport streams into one output.
+//
+// The elementary streams of the first program of each input are mapped on the streams of
+// the output having the same type, in order, and streams are added to or removed from the
+// output PMT when the layout changes, which bumps its version. Continuity counters are kept
+// continuous, and timestamps are offset so that PCRs are continuous unless discontinuity
+// indicators are used. Inputs whose PCR PID carries no PCR are offset so that PTSs are
+// continuous instead. Other PIDs are dropped.
+type Concatenator struct {
+	ccs                        map[uint16]*wrappingCounter
+	lastPCR                    *int64 // Output timestamps
+	lastPTS                    *int64 // Output timestamps
+	mx                         *Muxer
+	optDiscontinuityIndicators bool
+	pcrDelta                   int64
+	ptsDelta                   int64
+}
+
+type concatenation struct {
+	c       *Concatenator
+	dmx     *Demuxer
+	offset  *int64 // Subtracted from timestamps
+	pcrPID  uint16
+	pending []*Packet         // Packets waiting for the layout and the offset to be known
+	pids    map[uint16]uint16 // Input PID --> output PID
+	pmtPID  uint16
+	tables  bool            // Whether tables must be written before the next packet
+	written map[uint16]bool // Output PIDs written by the input
+}
+
+// ConcatenatorOptDiscontinuityIndicators returns the option to keep the timestamps of the
+// inputs and to set the discontinuity indicator on the first packet of each PID instead.
+// When the first packet has no room for it, a packet only holding an adaptation field is
+// inserted before it.
+func ConcatenatorOptDiscontinuityIndicators() func(*Concatenator) {
+	return func(c *Concatenator) {
+		c.optDiscontinuityIndicators = true
+	}
+}
+
+// NewConcatenator creates a new concatenator.
+func NewConcatenator(w WriterAndByteWriter, opts ...func(*Concatenator)) *Concatenator {
+	c := &Concatenator{
+		ccs: make(map[uint16]*wrappingCounter),
+		mx:  NewMuxer(context.Background(), w),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Append appends the input read from r.
+func (c *Concatenator) Append(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) error {
+	a := &concatenation{
+		c:       c,
+		dmx:     NewDemuxer(ctx, r, opts...),
+		written: make(map[uint16]bool),
+	}
+	return a.append()
+}
+
+func (a *concatenation) append() error {
+	for {
+		// Get next packet
+		p, err := a.dmx.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// Learn programs
+		if err = a.processData(a.dmx.packetData(p)); err != nil {
+			return err
+		}
+
+		// Tables are generated
+		if pid := p.Header.PID; pid == PIDPAT || pid == a.pmtPID || pid == PIDNull {
+			continue
+		}
+		a.pending = append(a.pending, p)
+		if err = a.flush(false); err != nil {
+			return err
+		}
+	}
+	if a.pids == nil {
+		return ErrConcatNoPMTFound
+	}
+
+	// Inputs without PCRs nor PTSs are not offset
+	return a.flush(true)
+}
+
+// processData processes the data of a payload unit.
+func (a *concatenation) processData(ds []*DemuxerData) error {
+	for _, d := range ds {
+		switch {
+		case d.PAT != nil:
+			if a.pmtPID == 0 && len(d.PAT.Programs) > 0 {
+				a.pmtPID = d.PAT.Programs[0].ProgramMapID
+			}
+		case d.PMT != nil && d.PID == a.pmtPID:
+			// Tables are written at the start of each input and when the layout changes
+			a.tables = a.tables || a.pids == nil
+			if err := a.updateLayout(d.PMT); err != nil {
+				return fmt.Errorf("updating layout failed: %w", err)
+			}
+			a.tables = a.tables || a.c.mx.pmtUpdated
+		}
+	}
+	return nil
+}
+
+// updateLayout maps the elementary streams of the input on the ones of the output, starting
+// with the ones sharing the same PID.
+func (a *concatenation) updateLayout(pmt *PMTData) error {
+	mx := a.c.mx
+	a.pcrPID = pmt.PCRPID
+	a.pids = make(map[uint16]uint16)
+	mapped := make(map[uint16]bool)
+	match := func(es *PMTElementaryStream, samePID bool) bool {
+		for _, oes := range mx.pmt.ElementaryStreams {
+			if mapped[oes.ElementaryPID] || (samePID && oes.ElementaryPID != es.ElementaryPID) ||
+				elementaryStreamType(oes) != elementaryStreamType(es) {
+				continue
+			}
+			a.pids[es.ElementaryPID] = oes.ElementaryPID
+			mapped[oes.ElementaryPID] = true
+
+			// Descriptors may have changed
+			if oes.StreamType != es.StreamType || !reflect.DeepEqual(oes.ElementaryStreamDescriptors, es.ElementaryStreamDescriptors) {
+				oes.ElementaryStreamDescriptors = es.ElementaryStreamDescriptors
+				oes.StreamType = es.StreamType
+				mx.pmtUpdated = true
+			}
+			return true
+		}
+		return false
+	}
+	var unmapped []*PMTElementaryStream
+	for _, es := range pmt.ElementaryStreams {
+		if !match(es, true) {
+			unmapped = append(unmapped, es)
+		}
+	}
+
+	// Streams that can't be mapped are added
+	var added []*PMTElementaryStream
+	for _, es := range unmapped {
+		if !match(es, false) {
+			added = append(added, es)
+		}
+	}
+	for _, es := range added {
+		oes := *es
+		oes.ElementaryPID = a.c.freePID(es.ElementaryPID)
+		if err := mx.AddElementaryStream(oes); err != nil {
+			return fmt.Errorf("adding elementary stream failed: %w", err)
+		}
+		a.pids[es.ElementaryPID] = oes.ElementaryPID
+		mapped[oes.ElementaryPID] = true
+	}
+
+	// Streams that are not in the input anymore are removed
+	for idx := 0; idx < len(mx.pmt.ElementaryStreams); idx++ {
+		if pid := mx.pmt.ElementaryStreams[idx].ElementaryPID; !mapped[pid] {
+			if err := mx.RemoveElementaryStream(pid); err != nil {
+				return fmt.Errorf("removing elementary stream failed: %w", err)
+			}
+			idx--
+		}
+	}
+
+	// Update PCR PID
+	if pid, ok := a.pids[pmt.PCRPID]; ok && pid != mx.pmt.PCRPID {
+		mx.SetPCRPID(pid)
+	}
+	return nil
+}
+
+// freePID returns the input PID if it is not used by the output, or the first free PID otherwise.
+func (c *Concatenator) freePID(pid uint16) uint16 {
+	used := func(pid uint16) bool {
+		if pid == pmtStartPID {
+			return true
+		}
+		for _, es := range c.mx.pmt.ElementaryStreams {
+			if es.ElementaryPID == pid {
+				return true
+			}
+		}
+		return false
+	}
+	if !used(pid) {
+		return pid
+	}
+	pid = concatenatorFirstPID
+	for used(pid) {
+		pid++
+	}
+	return pid
+}
+
+// flush writes pending packets once the layout and the timestamps offset are known.
+func (a *concatenation) flush(eof bool) error {
+	if a.pids == nil {
+		return nil
+	}
+
+	// The offset makes the first PCR of the input follow the last PCR of the output
+	if a.offset == nil {
+		var v int64
+		if (a.c.lastPCR != nil || a.c.lastPTS != nil) && !a.c.optDiscontinuityIndicators {
+			found := false
+			if a.c.lastPCR != nil {
+				for _, p := range a.pending {
+					if p.Header.PID == a.pcrPID && p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+						v = rebaseTimestamp(p.AdaptationField.PCR.Base, *a.c.lastPCR+a.c.pcrDelta)
+						found = true
+						break
+					}
+				}
+			}
+
+			// Without PCR, the first PTS of the input follows the last PTS of the output
+			full := len(a.pending) >= concatenatorMaxPendingPackets
+			if !found && a.c.lastPTS != nil && (a.c.lastPCR == nil || full || eof) {
+				for _, p := range a.pending {
+					if _, ok := a.pids[p.Header.PID]; ok && p.Header.PayloadUnitStartIndicator {
+						if pts, ok := pesPayloadPTS(p.Payload); ok {
+							v = rebaseTimestamp(pts, *a.c.lastPTS+a.c.ptsDelta)
+							found = true
+							break
+						}
+					}
+				}
+			}
+			if !found && !full && !eof {
+				return nil
+			}
+		}
+		a.offset = &v
+	}
+
+	for _, p := range a.pending {
+		if err := a.write(p); err != nil {
+			return err
+		}
+	}
+	a.pending = nil
+	return nil
+}
+
+func (a *concatenation) write(p *Packet) error {
+	// Remap PID
+	pid, ok := a.pids[p.Header.PID]
+	if !ok {
+		return nil
+	}
+	p.Header.PID = pid
+
+	// Offset timestamps
+	if *a.offset != 0 {
+		if p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+			p.AdaptationField.PCR = newClockReference(rebaseTimestamp(p.AdaptationField.PCR.Base, *a.offset), p.AdaptationField.PCR.Extension)
+		}
+		if p.Header.HasAdaptationField && p.AdaptationField.HasOPCR {
+			p.AdaptationField.OPCR = newClockReference(rebaseTimestamp(p.AdaptationField.OPCR.Base, *a.offset), p.AdaptationField.OPCR.Extension)
+		}
+		if p.Header.PayloadUnitStartIndicator {
+			rebasePESTimestamps(p.Payload, *a.offset)
+		}
+	}
+
+	// Keep track of PCRs
+	if pid == a.c.mx.pmt.PCRPID && p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+		v := p.AdaptationField.PCR.Base
+		if a.c.lastPCR != nil {
+			if d := rebaseTimestamp(v, *a.c.lastPCR); d > 0 && d <= timelineMaxTimestampGap {
+				a.c.pcrDelta = d
+			}
+		}
+		a.c.lastPCR = &v
+	}
+
+	// Keep track of PTSs
+	if p.Header.PayloadUnitStartIndicator {
+		if v, ok := pesPayloadPTS(p.Payload); ok {
+			if a.c.lastPTS == nil {
+				a.c.lastPTS = &v
+			} else if d := rebaseTimestamp(v, *a.c.lastPTS); d > 0 && d <= timelineMaxTimestampGap {
+				a.c.ptsDelta = d
+				a.c.lastPTS = &v
+			}
+		}
+	}
+
+	// Retransmit tables
+	if a.tables || p.Header.PayloadUnitStartIndicator {
+		force := a.tables || (pid == a.c.mx.pmt.PCRPID && p.Header.HasAdaptationField && p.AdaptationField.RandomAccessIndicator)
+		if _, err := a.c.mx.retransmitTables(force); err != nil {
+			return fmt.Errorf("writing tables failed: %w", err)
+		}
+		a.tables = false
+	}
+
+	// Signal discontinuities of PIDs that were written by previous inputs
+	cc, ok := a.c.ccs[pid]
+	if ok && a.c.optDiscontinuityIndicators && !a.written[pid] {
+		if p.Header.HasAdaptationField && !p.AdaptationField.IsOneByteStuffing {
+			p.AdaptationField.DiscontinuityIndicator = true
+		} else {
+			af := newStuffingAdaptationField(MpegTsPacketSize - 1 - mpegTsPacketHeaderSize)
+			af.DiscontinuityIndicator = true
+			if _, err := a.c.mx.WritePacket(&Packet{
+				AdaptationField: af,
+				Header: &PacketHeader{
+					ContinuityCounter:  uint8(cc.get()),
+					HasAdaptationField: true,
+					PID:                pid,
+				},
+			}); err != nil {
+				return fmt.Errorf("writing discontinuity failed: %w", err)
+			}
+		}
+	} else if !ok {
+		v := newWrappingCounter(0b1111) // CC is 4 bits.
+		cc = &v
+		a.c.ccs[pid] = cc
+	}
+	a.written[pid] = true
+
+	// Update continuity counter, which is not incremented by packets without payload
+	if p.Header.HasPayload {
+		cc.inc()
+	}
+	p.Header.ContinuityCounter = uint8(cc.get() & 0xf)
+
+	if _, err := a.c.mx.WritePacket(p); err != nil {
+		return fmt.Errorf("writing packet failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcatenator(t *testing.T) {
+	// Same layout on other PIDs
+	buf := &bytes.Buffer{}
+	c := NewConcatenator(buf)
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))))
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x201, count: 5, interval: 18000, pcr: 1000000, videoPID: 0x200}))))
+	r := testDemux(t, buf.Bytes())
+	assert.Len(t, r.pmts, 1)
+	assert.Equal(t, []uint8{0}, r.versions)
+	assert.Equal(t, uint16(0x100), r.pmts[0].PCRPID)
+	var expected []int64
+	for idx := 0; idx < 10; idx++ {
+		expected = append(expected, int64(idx*18000+9000))
+	}
+	assert.Equal(t, expected, r.ptss[0x100])
+	assert.Equal(t, expected, r.ptss[0x101])
+	assert.Empty(t, r.discontinuities)
+	a, _ := analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Layout change with discontinuity indicators
+	buf.Reset()
+	c = NewConcatenator(buf, ConcatenatorOptDiscontinuityIndicators())
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))))
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{count: 5, interval: 18000, pcr: 1000000, videoPID: 0x200}))))
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x301, count: 5, interval: 18000, pcr: 2000000, videoPID: 0x300}))))
+	r = testDemux(t, buf.Bytes())
+	assert.Equal(t, []uint8{0, 1, 2}, r.versions)
+	assert.Len(t, r.pmts, 3)
+	assert.Len(t, r.pmts[1].ElementaryStreams, 1)
+	assert.Equal(t, uint16(0x100), r.pmts[1].ElementaryStreams[0].ElementaryPID)
+	assert.Len(t, r.pmts[2].ElementaryStreams, 2)
+	assert.Equal(t, uint16(0x301), r.pmts[2].ElementaryStreams[1].ElementaryPID)
+	assert.Equal(t, []uint16{0x100, 0x100}, r.discontinuities)
+	assert.Equal(t, int64(9000), r.ptss[0x100][0])
+	assert.Contains(t, r.ptss[0x100], int64(1009000))
+	assert.Contains(t, r.ptss[0x100], int64(2009000))
+	assert.Equal(t, int64(2009000), r.ptss[0x301][0])
+	a, _ = analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// No PCR
+	buf.Reset()
+	c = NewConcatenator(buf)
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))))
+	assert.NoError(t, c.Append(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, noPCR: true, pcr: 1000000, videoPID: 0x100}))))
+	r = testDemux(t, buf.Bytes())
+	assert.Equal(t, expected, r.ptss[0x100])
+	assert.Equal(t, expected, r.ptss[0x101])
+
+	// No PMT
+	assert.Equal(t, ErrConcatNoPMTFound, NewConcatenator(&bytes.Buffer{}).Append(context.Background(), bytes.NewReader(nil)))
+}
+
+func TestConcatenationPending(t *testing.T) {
+	c := NewConcatenator(&bytes.Buffer{})
+	assert.NoError(t, c.mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video}))
+	c.mx.SetPCRPID(0x100)
+	pcr, pts := int64(0), int64(9000)
+	c.lastPCR, c.lastPTS, c.ptsDelta = &pcr, &pts, 18000
+	a := &concatenation{c: c, pcrPID: 0x100, pids: map[uint16]uint16{0x100: 0x100}, written: make(map[uint16]bool)}
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWrite([]byte{0x0, 0x0, 0x1, 0xe0, 0x0, 0x0, 0x80, 0x80, 0x5})
+	_, err := writePTSOrDTS(w, 0b0010, newClockReference(1000000, 0))
+	assert.NoError(t, err)
+	a.pending = append(a.pending, &Packet{Header: &PacketHeader{HasPayload: true, PayloadUnitStartIndicator: true, PID: 0x100}, Payload: buf.Bytes()})
+
+	// Packets are buffered until a PCR is found or too many packets are pending
+	for len(a.pending) < concatenatorMaxPendingPackets {
+		assert.NoError(t, a.flush(false))
+		assert.Nil(t, a.offset)
+		a.pending = append(a.pending, &Packet{Header: &PacketHeader{HasPayload: true, PID: 0x100}, Payload: bytes.Repeat([]byte{0xff}, 184)})
+	}
+	assert.NoError(t, a.flush(false))
+	assert.Empty(t, a.pending)
+	assert.Equal(t, int64(1000000-27000), *a.offset)
+}
+
+func TestConcatenatorFreePID(t *testing.T) {
+	c := NewConcatenator(&bytes.Buffer{})
+	assert.NoError(t, c.mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video}))
+	assert.Equal(t, uint16(0x200), c.freePID(0x200))
+	assert.Equal(t, uint16(0x101), c.freePID(0x100))
+	assert.Equal(t, uint16(0x101), c.freePID(pmtStartPID))
+}
+
+func TestConcatenationDiscontinuity(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := NewConcatenator(buf, ConcatenatorOptDiscontinuityIndicators())
+	cc := newWrappingCounter(0b1111)
+	cc.inc()
+	c.ccs[0x100] = &cc
+	var offset int64
+	a := &concatenation{c: c, offset: &offset, pids: map[uint16]uint16{0x200: 0x100}, written: make(map[uint16]bool)}
+	assert.NoError(t, a.write(&Packet{Header: &PacketHeader{HasPayload: true, PID: 0x200}, Payload: bytes.Repeat([]byte{0x1}, 184)}))
+	assert.NoError(t, a.write(&Packet{Header: &PacketHeader{HasPayload: true, PID: 0x200}, Payload: bytes.Repeat([]byte{0x1}, 184)}))
+	b := buf.Bytes()
+	assert.Len(t, b, 3*MpegTsPacketSize)
+	assert.Equal(t, []byte{0x47, 0x1, 0x0, 0x20, 0xb7, 0x80}, b[:6])
+	assert.Equal(t, []byte{0x47, 0x1, 0x0, 0x11}, b[MpegTsPacketSize:MpegTsPacketSize+4])
+	assert.Equal(t, []byte{0x47, 0x1, 0x0, 0x12}, b[2*MpegTsPacketSize:2*MpegTsPacketSize+4])
+}
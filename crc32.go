@@ -20,7 +20,7 @@ func NewCRC32Writer(w WriterAndByteWriter) *CRC32Writer {
 func (w *CRC32Writer) Write(p []byte) (int, error) {
 	n, err := w.out.Write(p)
 	for i := 0; i < n; i++ {
-		w.crc32 = updateCRC32(w.crc32, p[n-1])
+		w.crc32 = updateCRC32(w.crc32, p[i])
 	}
 	return n, err
 }
@@ -54,7 +54,7 @@ func NewCRC32Reader(rd ReaderAndByteReader) *CRC32Reader {
 func (r *CRC32Reader) Read(p []byte) (int, error) {
 	n, err := r.rd.Read(p)
 	for i := 0; i < n; i++ {
-		r.crc32 = updateCRC32(r.crc32, p[n-1])
+		r.crc32 = updateCRC32(r.crc32, p[i])
 	}
 	return n, err
 	/*b, err := r.ReadByte()
@@ -0,0 +1,31 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRC32(t *testing.T) {
+	b := []byte{0x0, 0xb0, 0xd, 0x0, 0x1, 0xc1, 0x0, 0x0, 0x0, 0x1, 0xf0, 0x0}
+
+	// Writer
+	w1 := NewCRC32Writer(&bytes.Buffer{})
+	for _, v := range b {
+		assert.NoError(t, w1.WriteByte(v))
+	}
+	w2 := NewCRC32Writer(&bytes.Buffer{})
+	n, err := w2.Write(b)
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, uint32(0x2ab104b2), w1.CRC32())
+	assert.Equal(t, w1.CRC32(), w2.CRC32())
+
+	// Reader
+	r := NewCRC32Reader(bytes.NewReader(b))
+	n, err = r.Read(make([]byte, len(b)))
+	assert.NoError(t, err)
+	assert.Equal(t, len(b), n)
+	assert.Equal(t, w1.CRC32(), r.CRC32())
+}
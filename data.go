@@ -29,14 +29,34 @@ const (
 
 // DemuxerData represents a data parsed by Demuxer.
 type DemuxerData struct {
+	// AdaptationField is only set when DemuxerOptAdaptationFieldData is used, in which
+	// case FirstPacket is the packet carrying it.
+	AdaptationField *PacketAdaptationField
+
+	// ElementaryStreamError is only set when DemuxerOptParseElementaryStreams is used and the
+	// PES data couldn't be parsed, in which case PES is still set.
+	ElementaryStreamError error
+
+	AAC         *AACData
+	AC3         *AC3Data
+	DTS         *DTSData
 	EIT         *EITData
+	EndOffset   int64 // Byte offset following the last packet of the payload unit, only set with DemuxerOptDataPositions.
 	FirstPacket *Packet
+	H264        *H264Data
+	HEVC        *HEVCData
+	MPEG2Video  *MPEG2VideoData
+	MPEGAudio   *MPEGAudioData
 	NIT         *NITData
+	PacketIndex int64 // Ordinal of the first packet of the payload unit, only set with DemuxerOptDataPositions.
 	PAT         *PATData
 	PES         *PESData
 	PID         uint16
 	PMT         *PMTData
 	SDT         *SDTData
+	StartOffset int64 // Byte offset of the first packet of the payload unit, only set with DemuxerOptDataPositions.
+	TDT         *TDTData
+	Timeline    *DemuxerTimeline
 	TOT         *TOTData
 }
 
@@ -0,0 +1,616 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// AAC audio object types.
+// Page: 19 | Chapter: 1.5.1.1 | Link: ISO/IEC 14496-3
+const (
+	AACAudioObjectTypeMain = 1
+	AACAudioObjectTypeLC   = 2
+	AACAudioObjectTypeSSR  = 3
+	AACAudioObjectTypeLTP  = 4
+	AACAudioObjectTypeSBR  = 5
+	AACAudioObjectTypeER   = 17
+	AACAudioObjectTypePS   = 29
+	aacAudioObjectTypeEsc  = 31
+)
+
+const (
+	adtsHeaderLength           = 7
+	adtsSyncWord               = 0xfff
+	loasSyncWord               = 0x2b7
+	aacSyncExtensionSBR        = 0x2b7
+	aacSyncExtensionPS         = 0x548
+	aacFrameSamples            = 1024
+	aacShortFrameSample        = 960
+	aacSamplingFrequencyEscape = 0xf
+)
+
+// Errors.
+var (
+	ErrADTSSyncWordNotFound        = errors.New("ADTS sync word not found")
+	ErrADTSFrameTruncated          = errors.New("ADTS frame is truncated")
+	ErrLOASSyncWordNotFound        = errors.New("LOAS sync word not found")
+	ErrLOASFrameTruncated          = errors.New("LOAS frame is truncated")
+	ErrLATMConfigMissing           = errors.New("LATM stream mux config is missing")
+	ErrLATMUnsupportedConfig       = errors.New("LATM stream mux config is not supported")
+	ErrAACInvalidSamplingFrequency = errors.New("invalid AAC sampling frequency")
+)
+
+// aacSamplingFrequencies are indexed by sampling frequency index.
+var aacSamplingFrequencies = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacChannelConfigurationChannels are indexed by channel configuration.
+var aacChannelConfigurationChannels = []int{0, 1, 2, 3, 4, 5, 6, 8, 0, 0, 0, 7, 8, 24, 8}
+
+// AACData represents the AAC frames carried by a PES payload.
+type AACData struct {
+	Frames []*AACFrame
+}
+
+// AACFrame represents an AAC frame.
+type AACFrame struct {
+	// ADTSHeader is only set for ADTS streams.
+	ADTSHeader *ADTSHeader
+
+	// Config is built from the ADTS header for ADTS streams
+	// and comes from the stream mux config for LATM streams.
+	Config *AudioSpecificConfig
+
+	// Data is the payload of the frame without its ADTS or LATM framing.
+	Data []byte
+
+	// PTS is computed from the PES PTS and the duration of the previous frames.
+	PTS *ClockReference
+}
+
+// ADTSHeader represents an ADTS header.
+// https://wiki.multimedia.cx/index.php/ADTS
+type ADTSHeader struct {
+	MPEGVersion            uint8 // 1 bit, 0 for MPEG-4 and 1 for MPEG-2.
+	Layer                  uint8 // 2 bits.
+	ProtectionAbsent       bool
+	Profile                uint8 // 2 bits, audio object type minus 1.
+	SamplingFrequencyIndex uint8 // 4 bits.
+	PrivateBit             bool
+	ChannelConfiguration   uint8 // 3 bits.
+	OriginalCopy           bool
+	Home                   bool
+	CopyrightIDBit         bool
+	CopyrightIDStart       bool
+	FrameLength            uint16 // 13 bits, header included.
+	BufferFullness         uint16 // 11 bits.
+	NumberOfRawDataBlocks  uint8  // 2 bits, minus 1.
+	CRC                    uint16
+}
+
+// AudioSpecificConfig represents an MPEG-4 audio specific config.
+// Page: 52 | Chapter: 1.6.2.1 | Link: ISO/IEC 14496-3
+type AudioSpecificConfig struct {
+	AudioObjectType            uint8
+	Channels                   int
+	ChannelConfiguration       uint8  // 4 bits.
+	Data                       []byte // Config as found in the stream, padded with zero bits to a byte boundary. Not set for ADTS.
+	ExtensionAudioObjectType   uint8
+	ExtensionSamplingFrequency int
+	FrameLengthFlag            bool
+	PSPresent                  bool
+	SamplingFrequency          int
+	SamplingFrequencyIndex     uint8 // 4 bits.
+	SBRPresent                 bool
+}
+
+// LATMStreamMuxConfig represents a LATM stream mux config.
+// Only configs with a single program and a single layer are supported.
+// Page: 56 | Chapter: 1.7.3 | Link: ISO/IEC 14496-3
+type LATMStreamMuxConfig struct {
+	AllStreamsSameTimeFraming bool
+	AudioMuxVersion           uint8 // 1 bit.
+	AudioMuxVersionA          uint8 // 1 bit.
+	AudioSpecificConfig       *AudioSpecificConfig
+	CRCCheckPresent           bool
+	FrameLengthType           uint8 // 3 bits.
+	LATMBufferFullness        uint8
+	NumSubFrames              uint8 // 6 bits.
+	OtherDataLenBits          uint32
+	OtherDataPresent          bool
+}
+
+// ParseADTSData splits an ADTS byte stream such as a PES payload into AAC frames.
+// pts is the PTS of the first frame and may be nil.
+func ParseADTSData(b []byte, pts *ClockReference) (*AACData, error) {
+	d := &AACData{}
+	var samples int64
+	for i := 0; i < len(b); {
+		// Look for the sync word
+		if len(b)-i < 2 || b[i] != 0xff || b[i+1]&0xf0 != 0xf0 {
+			if i == 0 {
+				return nil, ErrADTSSyncWordNotFound
+			}
+			i++
+			continue
+		}
+
+		if len(b)-i < adtsHeaderLength {
+			return nil, ErrADTSFrameTruncated
+		}
+
+		r := bitio.NewCountReader(bytes.NewReader(b[i:]))
+		h, err := parseADTSHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ADTS header failed: %w", err)
+		}
+
+		if int(h.FrameLength) > len(b)-i {
+			return nil, ErrADTSFrameTruncated
+		}
+
+		c, err := h.audioSpecificConfig()
+		if err != nil {
+			return nil, fmt.Errorf("building audio specific config failed: %w", err)
+		}
+
+		headerLength := int(r.BitsCount / 8)
+		if int(h.FrameLength) < headerLength {
+			return nil, fmt.Errorf("invalid ADTS frame length %d", h.FrameLength)
+		}
+
+		d.Frames = append(d.Frames, &AACFrame{
+			ADTSHeader: h,
+			Config:     c,
+			Data:       b[i+headerLength : i+int(h.FrameLength)],
+			PTS:        addSamplesToPTS(pts, samples, c.SamplingFrequency),
+		})
+		samples += int64(h.NumberOfRawDataBlocks+1) * int64(c.FrameSamples())
+		i += int(h.FrameLength)
+	}
+	return d, nil
+}
+
+// parseADTSHeader parses an ADTS header.
+func parseADTSHeader(r *bitio.CountReader) (*ADTSHeader, error) {
+	if r.TryReadBits(12) != adtsSyncWord {
+		return nil, ErrADTSSyncWordNotFound
+	}
+
+	h := &ADTSHeader{}
+	h.MPEGVersion = uint8(r.TryReadBits(1))
+	h.Layer = uint8(r.TryReadBits(2))
+	h.ProtectionAbsent = r.TryReadBool()
+	h.Profile = uint8(r.TryReadBits(2))
+	h.SamplingFrequencyIndex = uint8(r.TryReadBits(4))
+	h.PrivateBit = r.TryReadBool()
+	h.ChannelConfiguration = uint8(r.TryReadBits(3))
+	h.OriginalCopy = r.TryReadBool()
+	h.Home = r.TryReadBool()
+	h.CopyrightIDBit = r.TryReadBool()
+	h.CopyrightIDStart = r.TryReadBool()
+	h.FrameLength = uint16(r.TryReadBits(13))
+	h.BufferFullness = uint16(r.TryReadBits(11))
+	h.NumberOfRawDataBlocks = uint8(r.TryReadBits(2))
+
+	if !h.ProtectionAbsent {
+		h.CRC = uint16(r.TryReadBits(16))
+	}
+	return h, r.TryError
+}
+
+// audioSpecificConfig builds the audio specific config matching the ADTS header.
+// ADTS can only signal SBR and PS implicitly, therefore SBRPresent and PSPresent are never set.
+func (h *ADTSHeader) audioSpecificConfig() (*AudioSpecificConfig, error) {
+	if int(h.SamplingFrequencyIndex) >= len(aacSamplingFrequencies) {
+		return nil, ErrAACInvalidSamplingFrequency
+	}
+	return &AudioSpecificConfig{
+		AudioObjectType:        h.Profile + 1,
+		Channels:               aacChannelConfigurationChannels[h.ChannelConfiguration],
+		ChannelConfiguration:   h.ChannelConfiguration,
+		SamplingFrequency:      aacSamplingFrequencies[h.SamplingFrequencyIndex],
+		SamplingFrequencyIndex: h.SamplingFrequencyIndex,
+	}, nil
+}
+
+// FrameSamples returns the number of samples per channel of a frame at the core sampling frequency.
+func (c *AudioSpecificConfig) FrameSamples() int {
+	if c.FrameLengthFlag {
+		return aacShortFrameSample
+	}
+	return aacFrameSamples
+}
+
+// OutputSamplingFrequency returns the sampling frequency of the decoded
+// signal, which is doubled when SBR is used.
+func (c *AudioSpecificConfig) OutputSamplingFrequency() int {
+	if c.SBRPresent && c.ExtensionSamplingFrequency > 0 {
+		return c.ExtensionSamplingFrequency
+	}
+	return c.SamplingFrequency
+}
+
+// ParseAudioSpecificConfig parses an audio specific config such as the one found in MP4 esds boxes.
+func ParseAudioSpecificConfig(b []byte) (*AudioSpecificConfig, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	c, err := parseAudioSpecificConfig(r, int64(len(b))*8)
+	if err != nil {
+		return nil, err
+	}
+	c.Data = append([]byte{}, b...)
+	return c, nil
+}
+
+// parseAudioSpecificConfig parses an audio specific config. Backward compatible
+// SBR and PS signalling is only looked for when the config length is known,
+// in which case length must be the number of bits available to the config.
+func parseAudioSpecificConfig(r *bitio.CountReader, length int64) (*AudioSpecificConfig, error) {
+	start := r.BitsCount
+	c := &AudioSpecificConfig{}
+
+	c.AudioObjectType = readAACAudioObjectType(r)
+	var err error
+	if c.SamplingFrequencyIndex, c.SamplingFrequency, err = readAACSamplingFrequency(r); err != nil {
+		return nil, err
+	}
+	c.ChannelConfiguration = uint8(r.TryReadBits(4))
+	if int(c.ChannelConfiguration) < len(aacChannelConfigurationChannels) {
+		c.Channels = aacChannelConfigurationChannels[c.ChannelConfiguration]
+	}
+
+	// Explicit hierarchical signalling
+	if c.AudioObjectType == AACAudioObjectTypeSBR || c.AudioObjectType == AACAudioObjectTypePS {
+		c.ExtensionAudioObjectType = AACAudioObjectTypeSBR
+		c.SBRPresent = true
+		c.PSPresent = c.AudioObjectType == AACAudioObjectTypePS
+		if _, c.ExtensionSamplingFrequency, err = readAACSamplingFrequency(r); err != nil {
+			return nil, err
+		}
+		c.AudioObjectType = readAACAudioObjectType(r)
+	}
+
+	switch c.AudioObjectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		if err = parseAACGASpecificConfig(r, c, start); err != nil {
+			return nil, fmt.Errorf("parsing GA specific config failed: %w", err)
+		}
+	default:
+		// Other audio object types have configs we don't need to parse
+		// and can only be found when the config length is known.
+		return c, r.TryError
+	}
+
+	// Backward compatible signalling
+	if length > 0 && c.ExtensionAudioObjectType != AACAudioObjectTypeSBR && length-(r.BitsCount-start) >= 16 {
+		if r.TryReadBits(11) == aacSyncExtensionSBR {
+			if readAACAudioObjectType(r) == AACAudioObjectTypeSBR {
+				c.ExtensionAudioObjectType = AACAudioObjectTypeSBR
+				if c.SBRPresent = r.TryReadBool(); c.SBRPresent {
+					if _, c.ExtensionSamplingFrequency, err = readAACSamplingFrequency(r); err != nil {
+						return nil, err
+					}
+					if length-(r.BitsCount-start) >= 12 && r.TryReadBits(11) == aacSyncExtensionPS {
+						c.PSPresent = r.TryReadBool()
+					}
+				}
+			}
+		}
+	}
+	return c, r.TryError
+}
+
+// readAACAudioObjectType reads an audio object type.
+func readAACAudioObjectType(r *bitio.CountReader) uint8 {
+	t := uint8(r.TryReadBits(5))
+	if t == aacAudioObjectTypeEsc {
+		t = 32 + uint8(r.TryReadBits(6))
+	}
+	return t
+}
+
+// readAACSamplingFrequency reads a sampling frequency index and its explicit value if any.
+func readAACSamplingFrequency(r *bitio.CountReader) (idx uint8, f int, err error) {
+	idx = uint8(r.TryReadBits(4))
+	if idx == aacSamplingFrequencyEscape {
+		f = int(r.TryReadBits(24))
+		return
+	}
+	if int(idx) >= len(aacSamplingFrequencies) {
+		err = ErrAACInvalidSamplingFrequency
+		return
+	}
+	f = aacSamplingFrequencies[idx]
+	return
+}
+
+// parseAACGASpecificConfig parses a GA specific config.
+// Page: 487 | Chapter: 4.4.1 | Link: ISO/IEC 14496-3
+func parseAACGASpecificConfig(r *bitio.CountReader, c *AudioSpecificConfig, ascStart int64) error {
+	c.FrameLengthFlag = r.TryReadBool()
+	if r.TryReadBool() { // dependsOnCoreCoder
+		_ = r.TryReadBits(14) // coreCoderDelay
+	}
+	extensionFlag := r.TryReadBool()
+
+	if c.ChannelConfiguration == 0 {
+		channels, err := parseAACProgramConfigElement(r, ascStart)
+		if err != nil {
+			return fmt.Errorf("parsing program config element failed: %w", err)
+		}
+		c.Channels = channels
+	}
+
+	if c.AudioObjectType == 6 || c.AudioObjectType == 20 {
+		_ = r.TryReadBits(3) // layerNr
+	}
+
+	if extensionFlag {
+		if c.AudioObjectType == 22 {
+			_ = r.TryReadBits(5)  // numOfSubFrame
+			_ = r.TryReadBits(11) // layer_length
+		}
+		if c.AudioObjectType == 17 || c.AudioObjectType == 19 ||
+			c.AudioObjectType == 20 || c.AudioObjectType == 23 {
+			_ = r.TryReadBits(3) // resilience flags
+		}
+		_ = r.TryReadBool() // extensionFlag3
+	}
+	return r.TryError
+}
+
+// parseAACProgramConfigElement parses a program config element and returns its number of channels.
+// Page: 496 | Chapter: 4.4.1.1 | Link: ISO/IEC 14496-3
+func parseAACProgramConfigElement(r *bitio.CountReader, ascStart int64) (channels int, err error) {
+	_ = r.TryReadBits(4) // element_instance_tag
+	_ = r.TryReadBits(2) // object_type
+	_ = r.TryReadBits(4) // sampling_frequency_index
+	numFront := int(r.TryReadBits(4))
+	numSide := int(r.TryReadBits(4))
+	numBack := int(r.TryReadBits(4))
+	numLFE := int(r.TryReadBits(2))
+	numAssocData := int(r.TryReadBits(3))
+	numValidCC := int(r.TryReadBits(4))
+	if r.TryReadBool() { // mono_mixdown_present
+		_ = r.TryReadBits(4)
+	}
+	if r.TryReadBool() { // stereo_mixdown_present
+		_ = r.TryReadBits(4)
+	}
+	if r.TryReadBool() { // matrix_mixdown_idx_present
+		_ = r.TryReadBits(3)
+	}
+
+	for i := 0; i < numFront+numSide+numBack; i++ {
+		channels++
+		if r.TryReadBool() { // is_cpe
+			channels++
+		}
+		_ = r.TryReadBits(4) // tag_select
+	}
+	channels += numLFE
+	for i := 0; i < numLFE+numAssocData; i++ {
+		_ = r.TryReadBits(4) // element_tag_select
+	}
+	for i := 0; i < numValidCC; i++ {
+		_ = r.TryReadBits(5) // cc_element_is_ind_sw + valid_cc_element_tag_select
+	}
+
+	// Byte alignment is relative to the start of the audio specific config
+	if n := (r.BitsCount - ascStart) % 8; n > 0 {
+		_ = r.TryReadBits(uint8(8 - n))
+	}
+
+	commentFieldBytes := int(r.TryReadByte())
+	for i := 0; i < commentFieldBytes; i++ {
+		_ = r.TryReadByte()
+	}
+	return channels, r.TryError
+}
+
+// AACLATMParser splits LOAS/LATM byte streams into AAC frames. It keeps
+// track of the stream mux config since it may be sent only once in a while.
+type AACLATMParser struct {
+	config *LATMStreamMuxConfig
+}
+
+// NewAACLATMParser creates a new LOAS/LATM parser.
+func NewAACLATMParser() *AACLATMParser {
+	return &AACLATMParser{}
+}
+
+// Config returns the last stream mux config found in the stream.
+func (p *AACLATMParser) Config() *LATMStreamMuxConfig {
+	return p.config
+}
+
+// Parse splits a LOAS byte stream such as a PES payload into AAC frames.
+// pts is the PTS of the first frame and may be nil.
+func (p *AACLATMParser) Parse(b []byte, pts *ClockReference) (*AACData, error) {
+	d := &AACData{}
+	var samples int64
+	for i := 0; i < len(b); {
+		// AudioSyncStream
+		if len(b)-i < 3 || uint16(b[i])<<3|uint16(b[i+1]>>5) != loasSyncWord {
+			if i == 0 {
+				return nil, ErrLOASSyncWordNotFound
+			}
+			i++
+			continue
+		}
+
+		length := int(b[i+1]&0x1f)<<8 | int(b[i+2])
+		i += 3
+		if length > len(b)-i {
+			return nil, ErrLOASFrameTruncated
+		}
+
+		fs, err := p.parseAudioMuxElement(b[i : i+length])
+		if err != nil {
+			return nil, fmt.Errorf("parsing audio mux element failed: %w", err)
+		}
+		i += length
+
+		for _, f := range fs {
+			f.PTS = addSamplesToPTS(pts, samples, f.Config.SamplingFrequency)
+			samples += int64(f.Config.FrameSamples())
+			d.Frames = append(d.Frames, f)
+		}
+	}
+	return d, nil
+}
+
+// parseAudioMuxElement parses an AudioMuxElement with muxConfigPresent set to 1.
+// Page: 57 | Chapter: 1.7.3 | Link: ISO/IEC 14496-3
+func (p *AACLATMParser) parseAudioMuxElement(b []byte) (fs []*AACFrame, err error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+
+	if useSameStreamMux := r.TryReadBool(); !useSameStreamMux {
+		if p.config, err = parseLATMStreamMuxConfig(r, b); err != nil {
+			return nil, fmt.Errorf("parsing stream mux config failed: %w", err)
+		}
+	}
+
+	if p.config == nil {
+		return nil, ErrLATMConfigMissing
+	}
+
+	if p.config.AudioMuxVersionA != 0 {
+		return nil, ErrLATMUnsupportedConfig
+	}
+
+	for i := 0; i <= int(p.config.NumSubFrames); i++ {
+		// PayloadLengthInfo
+		var length int
+		for {
+			tmp := int(r.TryReadByte())
+			length += tmp
+			if tmp != 0xff || r.TryError != nil {
+				break
+			}
+		}
+
+		// PayloadMux
+		f := &AACFrame{
+			Config: p.config.AudioSpecificConfig,
+			Data:   make([]byte, length),
+		}
+		for j := 0; j < length; j++ {
+			f.Data[j] = r.TryReadByte()
+		}
+		if r.TryError != nil {
+			return nil, fmt.Errorf("reading payload failed: %w", r.TryError)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// copyBits returns the bits of b located between the bit offsets start and end, padded
+// with zero bits to a byte boundary.
+func copyBits(b []byte, start, end int64) []byte {
+	o := make([]byte, (end-start+7)/8)
+	for i := int64(0); i < end-start; i++ {
+		if b[(start+i)/8]&(0x80>>uint((start+i)%8)) != 0 {
+			o[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return o
+}
+
+// parseLATMStreamMuxConfig parses a StreamMuxConfig.
+// Page: 57 | Chapter: 1.7.3 | Link: ISO/IEC 14496-3
+func parseLATMStreamMuxConfig(r *bitio.CountReader, b []byte) (*LATMStreamMuxConfig, error) {
+	c := &LATMStreamMuxConfig{}
+	length := int64(len(b)) * 8
+
+	c.AudioMuxVersion = uint8(r.TryReadBits(1))
+	if c.AudioMuxVersion == 1 {
+		c.AudioMuxVersionA = uint8(r.TryReadBits(1))
+	}
+	if c.AudioMuxVersionA != 0 {
+		return nil, ErrLATMUnsupportedConfig
+	}
+
+	if c.AudioMuxVersion == 1 {
+		_ = readLATMValue(r) // taraBufferFullness
+	}
+
+	c.AllStreamsSameTimeFraming = r.TryReadBool()
+	c.NumSubFrames = uint8(r.TryReadBits(6))
+	numProgram := r.TryReadBits(4)
+	numLayer := r.TryReadBits(3)
+	if numProgram != 0 || numLayer != 0 {
+		return nil, fmt.Errorf("%w: %d programs and %d layers", ErrLATMUnsupportedConfig, numProgram+1, numLayer+1)
+	}
+
+	var err error
+	if c.AudioMuxVersion == 0 {
+		start := r.BitsCount
+		if c.AudioSpecificConfig, err = parseAudioSpecificConfig(r, 0); err != nil {
+			return nil, fmt.Errorf("parsing audio specific config failed: %w", err)
+		}
+		c.AudioSpecificConfig.Data = copyBits(b, start, r.BitsCount)
+	} else {
+		ascLen := int64(readLATMValue(r))
+		start := r.BitsCount
+		if c.AudioSpecificConfig, err = parseAudioSpecificConfig(r, ascLen); err != nil {
+			return nil, fmt.Errorf("parsing audio specific config failed: %w", err)
+		}
+		c.AudioSpecificConfig.Data = copyBits(b, start, r.BitsCount)
+		if fill := ascLen - (r.BitsCount - start); fill > 0 && fill < length {
+			for ; fill > 64; fill -= 64 {
+				_ = r.TryReadBits(64)
+			}
+			_ = r.TryReadBits(uint8(fill))
+		}
+	}
+
+	c.FrameLengthType = uint8(r.TryReadBits(3))
+	if c.FrameLengthType != 0 {
+		return nil, fmt.Errorf("%w: frame length type %d", ErrLATMUnsupportedConfig, c.FrameLengthType)
+	}
+	c.LATMBufferFullness = r.TryReadByte()
+
+	if c.OtherDataPresent = r.TryReadBool(); c.OtherDataPresent {
+		if c.AudioMuxVersion == 1 {
+			c.OtherDataLenBits = readLATMValue(r)
+		} else {
+			for {
+				c.OtherDataLenBits <<= 8
+				escape := r.TryReadBool()
+				c.OtherDataLenBits += uint32(r.TryReadByte())
+				if !escape || r.TryError != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if c.CRCCheckPresent = r.TryReadBool(); c.CRCCheckPresent {
+		_ = r.TryReadByte() // crcCheckSum
+	}
+	return c, r.TryError
+}
+
+// readLATMValue reads a LatmGetValue() value.
+func readLATMValue(r *bitio.CountReader) (v uint32) {
+	bytesForValue := int(r.TryReadBits(2))
+	for i := 0; i <= bytesForValue; i++ {
+		v = v<<8 | uint32(r.TryReadByte())
+	}
+	return
+}
+
+// addSamplesToPTS returns the PTS of a frame starting samples after the first frame.
+func addSamplesToPTS(pts *ClockReference, samples int64, samplingFrequency int) *ClockReference {
+	if pts == nil {
+		return nil
+	}
+	if samplingFrequency <= 0 {
+		return newClockReference(pts.Base, pts.Extension)
+	}
+	return newClockReference((pts.Base+samples*90000/int64(samplingFrequency))%clockReferenceBaseModulo, pts.Extension)
+}
@@ -0,0 +1,238 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func adtsFrameBytes(payload []byte, protectionAbsent bool) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	length := len(payload) + 7
+	if !protectionAbsent {
+		length += 2
+	}
+	w.TryWriteBits(0xfff, 12)          // Sync word.
+	WriteBinary(w, "0")                // MPEG version.
+	WriteBinary(w, "00")               // Layer.
+	w.TryWriteBool(protectionAbsent)   // Protection absent.
+	WriteBinary(w, "01")               // Profile.
+	w.TryWriteBits(3, 4)               // Sampling frequency index.
+	WriteBinary(w, "0")                // Private bit.
+	w.TryWriteBits(2, 3)               // Channel configuration.
+	WriteBinary(w, "0000")             // Original, home and copyright bits.
+	w.TryWriteBits(uint64(length), 13) // Frame length.
+	w.TryWriteBits(0x7ff, 11)          // Buffer fullness.
+	WriteBinary(w, "00")               // Number of raw data blocks.
+	if !protectionAbsent {
+		w.TryWriteBits(0x1234, 16) // CRC.
+	}
+	w.Write(payload)
+	return buf.Bytes()
+}
+
+func TestParseADTSData(t *testing.T) {
+	b := append(adtsFrameBytes([]byte{0x1, 0x2}, true), adtsFrameBytes([]byte{0x3}, false)...)
+	d, err := ParseADTSData(b, newClockReference(90000, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 2)
+	assert.Equal(t, &ADTSHeader{
+		BufferFullness:         0x7ff,
+		ChannelConfiguration:   2,
+		FrameLength:            9,
+		Profile:                1,
+		ProtectionAbsent:       true,
+		SamplingFrequencyIndex: 3,
+	}, d.Frames[0].ADTSHeader)
+	assert.Equal(t, &AudioSpecificConfig{
+		AudioObjectType:        AACAudioObjectTypeLC,
+		Channels:               2,
+		ChannelConfiguration:   2,
+		SamplingFrequency:      48000,
+		SamplingFrequencyIndex: 3,
+	}, d.Frames[0].Config)
+	assert.Equal(t, []byte{0x1, 0x2}, d.Frames[0].Data)
+	assert.Equal(t, int64(90000), d.Frames[0].PTS.Base)
+	assert.Equal(t, uint16(0x1234), d.Frames[1].ADTSHeader.CRC)
+	assert.Equal(t, []byte{0x3}, d.Frames[1].Data)
+	assert.Equal(t, int64(90000+1920), d.Frames[1].PTS.Base)
+
+	// No PTS
+	d, err = ParseADTSData(b, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, d.Frames[1].PTS)
+
+	// Errors
+	_, err = ParseADTSData([]byte{0x1, 0x2}, nil)
+	assert.ErrorIs(t, err, ErrADTSSyncWordNotFound)
+	_, err = ParseADTSData(b[:len(b)-1], nil)
+	assert.ErrorIs(t, err, ErrADTSFrameTruncated)
+}
+
+func audioSpecificConfigHEAACv2Bytes(w *bitio.Writer) {
+	w.TryWriteBits(AACAudioObjectTypePS, 5) // Audio object type.
+	w.TryWriteBits(6, 4)                    // Sampling frequency index.
+	w.TryWriteBits(1, 4)                    // Channel configuration.
+	w.TryWriteBits(3, 4)                    // Extension sampling frequency index.
+	w.TryWriteBits(AACAudioObjectTypeLC, 5) // Audio object type.
+	WriteBinary(w, "000")                   // GA specific config.
+}
+
+var audioSpecificConfigHEAACv2 = &AudioSpecificConfig{
+	AudioObjectType:            AACAudioObjectTypeLC,
+	Channels:                   1,
+	ChannelConfiguration:       1,
+	Data:                       []byte{0xeb, 0x9, 0x88, 0x0},
+	ExtensionAudioObjectType:   AACAudioObjectTypeSBR,
+	ExtensionSamplingFrequency: 48000,
+	PSPresent:                  true,
+	SamplingFrequency:          24000,
+	SamplingFrequencyIndex:     6,
+	SBRPresent:                 true,
+}
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	// Explicit signalling
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	audioSpecificConfigHEAACv2Bytes(w)
+	w.Align() //nolint:errcheck
+	c, err := ParseAudioSpecificConfig(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, audioSpecificConfigHEAACv2, c)
+	assert.Equal(t, 48000, c.OutputSamplingFrequency())
+	assert.Equal(t, 1024, c.FrameSamples())
+
+	// Backward compatible signalling
+	buf.Reset()
+	w = bitio.NewWriter(buf)
+	w.TryWriteBits(AACAudioObjectTypeLC, 5)  // Audio object type.
+	w.TryWriteBits(6, 4)                     // Sampling frequency index.
+	w.TryWriteBits(2, 4)                     // Channel configuration.
+	WriteBinary(w, "100")                    // GA specific config.
+	w.TryWriteBits(0x2b7, 11)                // Sync extension type.
+	w.TryWriteBits(AACAudioObjectTypeSBR, 5) // Extension audio object type.
+	WriteBinary(w, "1")                      // SBR present.
+	w.TryWriteBits(3, 4)                     // Extension sampling frequency index.
+	w.TryWriteBits(0x548, 11)                // Sync extension type.
+	WriteBinary(w, "1")                      // PS present.
+	w.Align()                                //nolint:errcheck
+	c, err = ParseAudioSpecificConfig(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, &AudioSpecificConfig{
+		AudioObjectType:            AACAudioObjectTypeLC,
+		Channels:                   2,
+		ChannelConfiguration:       2,
+		Data:                       buf.Bytes(),
+		ExtensionAudioObjectType:   AACAudioObjectTypeSBR,
+		ExtensionSamplingFrequency: 48000,
+		FrameLengthFlag:            true,
+		PSPresent:                  true,
+		SamplingFrequency:          24000,
+		SamplingFrequencyIndex:     6,
+		SBRPresent:                 true,
+	}, c)
+	assert.Equal(t, 960, c.FrameSamples())
+
+	// Program config element
+	buf.Reset()
+	w = bitio.NewWriter(buf)
+	w.TryWriteBits(AACAudioObjectTypeLC, 5) // Audio object type.
+	w.TryWriteBits(3, 4)                    // Sampling frequency index.
+	w.TryWriteBits(0, 4)                    // Channel configuration.
+	WriteBinary(w, "000")                   // GA specific config.
+	w.TryWriteBits(0, 4)                    // Element instance tag.
+	w.TryWriteBits(1, 2)                    // Object type.
+	w.TryWriteBits(3, 4)                    // Sampling frequency index.
+	w.TryWriteBits(2, 4)                    // Num front channel elements.
+	w.TryWriteBits(0, 4)                    // Num side channel elements.
+	w.TryWriteBits(1, 4)                    // Num back channel elements.
+	w.TryWriteBits(1, 2)                    // Num lfe channel elements.
+	w.TryWriteBits(0, 3)                    // Num assoc data elements.
+	w.TryWriteBits(0, 4)                    // Num valid cc elements.
+	WriteBinary(w, "000")                   // Mixdowns.
+	WriteBinary(w, "00000")                 // Front #1: SCE.
+	WriteBinary(w, "10000")                 // Front #2: CPE.
+	WriteBinary(w, "10001")                 // Back #1: CPE.
+	WriteBinary(w, "0000")                  // LFE #1.
+	w.Align()                               //nolint:errcheck
+	w.TryWriteByte(1)                       // Comment field bytes.
+	w.TryWriteByte('a')                     // Comment.
+	c, err = ParseAudioSpecificConfig(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 6, c.Channels)
+
+	// Invalid sampling frequency
+	_, err = ParseAudioSpecificConfig([]byte{0x17, 0x00})
+	assert.ErrorIs(t, err, ErrAACInvalidSamplingFrequency)
+}
+
+func loasFrameBytes(withConfig bool, payloads ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBool(!withConfig) // Use same stream mux.
+	if withConfig {
+		WriteBinary(w, "0")                        // Audio mux version.
+		WriteBinary(w, "1")                        // All streams same time framing.
+		w.TryWriteBits(uint64(len(payloads)-1), 6) // Num sub frames.
+		w.TryWriteBits(0, 4)                       // Num program.
+		w.TryWriteBits(0, 3)                       // Num layer.
+		audioSpecificConfigHEAACv2Bytes(w)
+		w.TryWriteBits(0, 3) // Frame length type.
+		w.TryWriteByte(0xff) // LATM buffer fullness.
+		WriteBinary(w, "0")  // Other data present.
+		WriteBinary(w, "0")  // CRC check present.
+	}
+	for _, p := range payloads {
+		w.TryWriteByte(uint8(len(p)))
+		w.Write(p)
+	}
+	w.Align() //nolint:errcheck
+
+	b := buf.Bytes()
+	return append([]byte{0x56, 0xe0 | uint8(len(b)>>8), uint8(len(b))}, b...)
+}
+
+func TestAACLATMParser(t *testing.T) {
+	p := NewAACLATMParser()
+
+	// Missing config
+	_, err := p.Parse(loasFrameBytes(false, []byte{0x1}), nil)
+	assert.ErrorIs(t, err, ErrLATMConfigMissing)
+
+	// Config + same config
+	b := append(loasFrameBytes(true, []byte{0x1, 0x2}, []byte{0x3}), loasFrameBytes(false, []byte{0x4}, []byte{0x5})...)
+	d, err := p.Parse(b, newClockReference(0, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 4)
+	assert.Equal(t, audioSpecificConfigHEAACv2, p.Config().AudioSpecificConfig)
+	assert.Equal(t, uint8(1), p.Config().NumSubFrames)
+	for i, e := range []struct {
+		data []byte
+		pts  int64
+	}{
+		{data: []byte{0x1, 0x2}, pts: 0},
+		{data: []byte{0x3}, pts: 3840},
+		{data: []byte{0x4}, pts: 7680},
+		{data: []byte{0x5}, pts: 11520},
+	} {
+		assert.Equal(t, e.data, d.Frames[i].Data)
+		assert.Equal(t, e.pts, d.Frames[i].PTS.Base)
+		assert.Equal(t, audioSpecificConfigHEAACv2, d.Frames[i].Config)
+		assert.Nil(t, d.Frames[i].ADTSHeader)
+	}
+
+	// Errors
+	_, err = p.Parse([]byte{0x1, 0x2, 0x3}, nil)
+	assert.ErrorIs(t, err, ErrLOASSyncWordNotFound)
+	_, err = p.Parse(b[:5], nil)
+	assert.ErrorIs(t, err, ErrLOASFrameTruncated)
+}
+
+func TestAddSamplesToPTS(t *testing.T) {
+	assert.Nil(t, addSamplesToPTS(nil, 1024, 48000))
+	assert.Equal(t, newClockReference(1919, 0), addSamplesToPTS(newClockReference(1<<33-1, 0), 1024, 48000))
+}
@@ -0,0 +1,433 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// AC-3 audio coding modes.
+// Page: 38 | Chapter: 5.4.2.3 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+const (
+	AC3AudioCodingMode1Plus1 = 0
+	AC3AudioCodingMode1_0    = 1
+	AC3AudioCodingMode2_0    = 2
+	AC3AudioCodingMode3_0    = 3
+	AC3AudioCodingMode2_1    = 4
+	AC3AudioCodingMode3_1    = 5
+	AC3AudioCodingMode2_2    = 6
+	AC3AudioCodingMode3_2    = 7
+)
+
+// E-AC-3 stream types.
+// Page: 150 | Chapter: E.1.3.1.1 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+const (
+	EAC3StreamTypeIndependent = 0
+	EAC3StreamTypeDependent   = 1
+	EAC3StreamTypeAC3Convert  = 2
+)
+
+const (
+	ac3SyncWord         = 0x0b77
+	ac3HeaderLength     = 7
+	ac3BlockSamples     = 256
+	ac3MaxBSID          = 8
+	eac3MinBSID         = 11
+	eac3MaxBSID         = 16
+	ac3SampleRateCodeRS = 3
+)
+
+// Errors.
+var (
+	ErrAC3SyncWordNotFound = errors.New("AC-3 sync word not found")
+	ErrAC3FrameTruncated   = errors.New("AC-3 frame is truncated")
+	ErrAC3InvalidHeader    = errors.New("invalid AC-3 header")
+)
+
+var (
+	ac3SampleRates     = []int{48000, 44100, 32000}
+	eac3SampleRates2   = []int{24000, 22050, 16000}
+	eac3Blocks         = []int{1, 2, 3, 6}
+	ac3Bitrates        = []int{32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 448, 512, 576, 640}
+	ac3FrameSizes44100 = []int{69, 87, 104, 121, 139, 174, 208, 243, 278, 348, 417, 487, 557, 696, 835, 975, 1114, 1253, 1393}
+	ac3ModeChannels    = []int{2, 1, 2, 3, 3, 4, 4, 5}
+)
+
+// AC3Data represents the AC-3 or E-AC-3 sync frames carried by a PES payload.
+type AC3Data struct {
+	Frames []*AC3Frame
+}
+
+// AC3Frame represents an AC-3 or E-AC-3 sync frame.
+// Page: 30 | Chapter: 5.3 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+type AC3Frame struct {
+	AudioCodingMode uint8 // 3 bits, acmod.
+	Bitrate         int   // In bits per second.
+	BitstreamMode   uint8 // 3 bits, bsmod.
+	BSID            uint8 // 5 bits.
+
+	// ChannelMap is the custom channel map of an E-AC-3 dependent substream.
+	// Bit 15 is the left channel, bit 1 the LFE2 channel and bit 0 the LFE channel.
+	ChannelMap       uint16
+	ChannelMapExists bool
+
+	// ComplexityIndexTypeA is the number of objects of a JOC stream.
+	ComplexityIndexTypeA uint8
+
+	Data                []byte // The whole sync frame.
+	DolbyHeadphoneMode  uint8  // 2 bits, E-AC-3 only.
+	DolbySurroundEXMode uint8  // 2 bits, E-AC-3 only.
+	DolbySurroundMode   uint8  // 2 bits.
+
+	// ExtensionTypeA is set when the E-AC-3 stream carries Joint Object Coding (Dolby Atmos).
+	ExtensionTypeA bool
+
+	FrameSizeCode   uint8 // 6 bits, AC-3 only.
+	IsEAC3          bool
+	LFEOn           bool
+	NumBlocks       int
+	PTS             *ClockReference
+	SampleRate      int
+	SampleRateCode  uint8 // 2 bits.
+	SampleRateCode2 uint8 // 2 bits, E-AC-3 only.
+	StreamType      uint8 // 2 bits, E-AC-3 only.
+	SubstreamID     uint8 // 3 bits, E-AC-3 only.
+}
+
+// ParseAC3Data splits an AC-3 or E-AC-3 byte stream such as a PES payload into sync frames.
+// pts is the PTS of the first frame and may be nil. E-AC-3 dependent substreams share the
+// PTS of the independent substream they belong to.
+func ParseAC3Data(b []byte, pts *ClockReference) (*AC3Data, error) {
+	d := &AC3Data{}
+	var samples int64
+	var sampleRate int
+	for i := 0; i < len(b); {
+		// Look for the sync word
+		if len(b)-i < 2 || b[i] != ac3SyncWord>>8 || b[i+1] != ac3SyncWord&0xff {
+			if i == 0 {
+				return nil, ErrAC3SyncWordNotFound
+			}
+			i++
+			continue
+		}
+
+		if len(b)-i < ac3HeaderLength {
+			return nil, ErrAC3FrameTruncated
+		}
+
+		f, size, err := parseAC3Frame(b[i:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing AC-3 frame failed: %w", err)
+		}
+
+		if size > len(b)-i {
+			return nil, ErrAC3FrameTruncated
+		}
+		f.Data = b[i : i+size]
+		i += size
+
+		// Only independent substreams make time advance
+		if !f.IsEAC3 || f.StreamType != EAC3StreamTypeDependent {
+			if sampleRate == 0 {
+				sampleRate = f.SampleRate
+			}
+			f.PTS = addSamplesToPTS(pts, samples, sampleRate)
+			samples += int64(f.NumBlocks * ac3BlockSamples)
+		} else if len(d.Frames) > 0 {
+			f.PTS = d.Frames[len(d.Frames)-1].PTS
+		} else {
+			f.PTS = addSamplesToPTS(pts, 0, 0)
+		}
+		d.Frames = append(d.Frames, f)
+	}
+	return d, nil
+}
+
+// parseAC3Frame parses the headers of a sync frame and returns the frame size in bytes.
+func parseAC3Frame(b []byte) (f *AC3Frame, size int, err error) {
+	// bsid is located at the same place in AC-3 and E-AC-3
+	bsid := b[5] >> 3
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	_ = r.TryReadBits(16) // Sync word.
+
+	switch {
+	case bsid <= ac3MaxBSID:
+		f, size, err = parseAC3SyncFrameHeader(r)
+	case bsid >= eac3MinBSID && bsid <= eac3MaxBSID:
+		f, size, err = parseEAC3SyncFrameHeader(r)
+	default:
+		err = fmt.Errorf("%w: unsupported bsid %d", ErrAC3InvalidHeader, bsid)
+	}
+	return
+}
+
+// parseAC3SyncFrameHeader parses AC-3 syncinfo and bsi.
+func parseAC3SyncFrameHeader(r *bitio.CountReader) (*AC3Frame, int, error) {
+	f := &AC3Frame{NumBlocks: 6}
+
+	_ = r.TryReadBits(16) // crc1
+	f.SampleRateCode = uint8(r.TryReadBits(2))
+	f.FrameSizeCode = uint8(r.TryReadBits(6))
+	if int(f.SampleRateCode) >= len(ac3SampleRates) || int(f.FrameSizeCode/2) >= len(ac3Bitrates) {
+		return nil, 0, fmt.Errorf("%w: fscod %d frmsizecod %d", ErrAC3InvalidHeader, f.SampleRateCode, f.FrameSizeCode)
+	}
+	f.SampleRate = ac3SampleRates[f.SampleRateCode]
+	f.Bitrate = ac3Bitrates[f.FrameSizeCode/2] * 1000
+
+	f.BSID = uint8(r.TryReadBits(5))
+	f.BitstreamMode = uint8(r.TryReadBits(3))
+	f.AudioCodingMode = uint8(r.TryReadBits(3))
+	if f.AudioCodingMode&0x1 > 0 && f.AudioCodingMode != AC3AudioCodingMode1_0 {
+		_ = r.TryReadBits(2) // cmixlev
+	}
+	if f.AudioCodingMode&0x4 > 0 {
+		_ = r.TryReadBits(2) // surmixlev
+	}
+	if f.AudioCodingMode == AC3AudioCodingMode2_0 {
+		f.DolbySurroundMode = uint8(r.TryReadBits(2))
+	}
+	f.LFEOn = r.TryReadBool()
+
+	// Frame size in 16 bits words
+	var words int
+	switch f.SampleRate {
+	case 48000:
+		words = ac3Bitrates[f.FrameSizeCode/2] * 2
+	case 44100:
+		words = ac3FrameSizes44100[f.FrameSizeCode/2] + int(f.FrameSizeCode&0x1)
+	default:
+		words = ac3Bitrates[f.FrameSizeCode/2] * 3
+	}
+	return f, words * 2, r.TryError
+}
+
+// parseEAC3SyncFrameHeader parses E-AC-3 syncinfo and bsi.
+// Page: 145 | Chapter: E.1.2.2 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+func parseEAC3SyncFrameHeader(r *bitio.CountReader) (*AC3Frame, int, error) { //nolint:funlen,gocognit
+	f := &AC3Frame{IsEAC3: true}
+
+	f.StreamType = uint8(r.TryReadBits(2))
+	f.SubstreamID = uint8(r.TryReadBits(3))
+	size := (int(r.TryReadBits(11)) + 1) * 2
+	f.SampleRateCode = uint8(r.TryReadBits(2))
+	if f.SampleRateCode == ac3SampleRateCodeRS {
+		f.SampleRateCode2 = uint8(r.TryReadBits(2))
+		if int(f.SampleRateCode2) >= len(eac3SampleRates2) {
+			return nil, 0, fmt.Errorf("%w: fscod2 %d", ErrAC3InvalidHeader, f.SampleRateCode2)
+		}
+		f.SampleRate = eac3SampleRates2[f.SampleRateCode2]
+		f.NumBlocks = 6
+	} else {
+		f.SampleRate = ac3SampleRates[f.SampleRateCode]
+		f.NumBlocks = eac3Blocks[r.TryReadBits(2)]
+	}
+	f.Bitrate = size * 8 * f.SampleRate / (f.NumBlocks * ac3BlockSamples)
+
+	f.AudioCodingMode = uint8(r.TryReadBits(3))
+	f.LFEOn = r.TryReadBool()
+	f.BSID = uint8(r.TryReadBits(5))
+
+	// Dual mono has a second set of dialnorm and compr
+	programs := 1
+	if f.AudioCodingMode == AC3AudioCodingMode1Plus1 {
+		programs = 2
+	}
+	for i := 0; i < programs; i++ {
+		_ = r.TryReadBits(5) // dialnorm
+		if r.TryReadBool() { // compre
+			_ = r.TryReadByte() // compr
+		}
+	}
+
+	if f.StreamType == EAC3StreamTypeDependent {
+		if f.ChannelMapExists = r.TryReadBool(); f.ChannelMapExists {
+			f.ChannelMap = uint16(r.TryReadBits(16))
+		}
+	}
+
+	// Mixing metadata
+	if r.TryReadBool() { // mixmdate
+		if f.AudioCodingMode > AC3AudioCodingMode2_0 {
+			_ = r.TryReadBits(2) // dmixmod
+		}
+		if f.AudioCodingMode&0x1 > 0 && f.AudioCodingMode > AC3AudioCodingMode2_0 {
+			_ = r.TryReadBits(6) // ltrtcmixlev + lorocmixlev
+		}
+		if f.AudioCodingMode&0x4 > 0 {
+			_ = r.TryReadBits(6) // ltrtsurmixlev + lorosurmixlev
+		}
+		if f.LFEOn && r.TryReadBool() { // lfemixlevcode
+			_ = r.TryReadBits(5) // lfemixlevcod
+		}
+		if f.StreamType == EAC3StreamTypeIndependent {
+			for i := 0; i < programs; i++ {
+				if r.TryReadBool() { // pgmscle
+					_ = r.TryReadBits(6) // pgmscl
+				}
+			}
+			if r.TryReadBool() { // extpgmscle
+				_ = r.TryReadBits(6) // extpgmscl
+			}
+			switch r.TryReadBits(2) { // mixdef
+			case 1:
+				_ = r.TryReadBits(5)
+			case 2:
+				_ = r.TryReadBits(12)
+			case 3:
+				mixdeflen := int(r.TryReadBits(5)) + 2
+				for i := 0; i < mixdeflen; i++ {
+					_ = r.TryReadByte()
+				}
+			}
+			if f.AudioCodingMode < AC3AudioCodingMode2_0 {
+				for i := 0; i < programs; i++ {
+					if r.TryReadBool() { // paninfoe
+						_ = r.TryReadBits(14) // panmean + paninfo
+					}
+				}
+			}
+			if r.TryReadBool() { // frmmixcfginfoe
+				for blk := 0; blk < f.NumBlocks; blk++ {
+					if f.NumBlocks == 1 || r.TryReadBool() { // blkmixcfginfoe
+						_ = r.TryReadBits(5) // blkmixcfginfo
+					}
+				}
+			}
+		}
+	}
+
+	// Informational metadata
+	if r.TryReadBool() { // infomdate
+		f.BitstreamMode = uint8(r.TryReadBits(3))
+		_ = r.TryReadBits(2) // copyrightb + origbs
+		if f.AudioCodingMode == AC3AudioCodingMode2_0 {
+			f.DolbySurroundMode = uint8(r.TryReadBits(2))
+			f.DolbyHeadphoneMode = uint8(r.TryReadBits(2))
+		}
+		if f.AudioCodingMode >= AC3AudioCodingMode2_2 {
+			f.DolbySurroundEXMode = uint8(r.TryReadBits(2))
+		}
+		for i := 0; i < programs; i++ {
+			if r.TryReadBool() { // audprodie
+				_ = r.TryReadByte() // mixlevel + roomtyp + adconvtyp
+			}
+		}
+		if f.SampleRateCode < ac3SampleRateCodeRS {
+			_ = r.TryReadBool() // sourcefscod
+		}
+	}
+
+	if f.StreamType == EAC3StreamTypeIndependent && f.NumBlocks != 6 {
+		_ = r.TryReadBool() // convsync
+	}
+
+	if f.StreamType == EAC3StreamTypeAC3Convert && (f.NumBlocks == 6 || r.TryReadBool()) { // blkid
+		_ = r.TryReadBits(6) // frmsizecod
+	}
+
+	// Additional bitstream information
+	if r.TryReadBool() { // addbsie
+		addbsil := int(r.TryReadBits(6)) + 1
+		for i := 0; i < addbsil; i++ {
+			switch i {
+			case 0:
+				_ = r.TryReadBits(7)
+				f.ExtensionTypeA = r.TryReadBool()
+			case 1:
+				// The complexity index is only present with the type A extension
+				if b := r.TryReadByte(); f.ExtensionTypeA {
+					f.ComplexityIndexTypeA = b
+				}
+			default:
+				_ = r.TryReadByte()
+			}
+		}
+	}
+	return f, size, r.TryError
+}
+
+// Channels returns the number of channels of the sync frame, LFE included.
+// For E-AC-3 dependent substreams with a channel map, it is the number of
+// channels described by the map.
+func (f *AC3Frame) Channels() (n int) {
+	if f.ChannelMapExists {
+		for i := uint(0); i < 16; i++ {
+			if f.ChannelMap&(1<<(15-i)) == 0 {
+				continue
+			}
+			switch i {
+			// Channel pairs: Lc/Rc, Lrs/Rrs, Lsd/Rsd, Lw/Rw, Lvh/Rvh, Lts/Rts
+			case 5, 6, 9, 10, 11, 13:
+				n += 2
+			default:
+				n++
+			}
+		}
+		return
+	}
+	n = ac3ModeChannels[f.AudioCodingMode]
+	if f.LFEOn {
+		n++
+	}
+	return
+}
+
+// DescriptorNumberOfChannels returns the number_of_channels field of the DVB AC-3 and
+// Enhanced AC-3 descriptors' component_type matching the sync frame, so that it can be
+// compared to the 3 least significant bits of DescriptorAC3.ComponentType.
+// Page: 97 | Chapter: D.3 | Link: https://www.etsi.org/deliver/etsi_en/300400_300499/300468/01.15.01_60/en_300468v011501p.pdf
+func (f *AC3Frame) DescriptorNumberOfChannels() uint8 {
+	switch {
+	case f.AudioCodingMode == AC3AudioCodingMode1_0:
+		return 0
+	case f.AudioCodingMode == AC3AudioCodingMode1Plus1:
+		return 1
+	case f.AudioCodingMode == AC3AudioCodingMode2_0 && f.DolbySurroundMode == 2:
+		return 3
+	case f.AudioCodingMode == AC3AudioCodingMode2_0:
+		return 2
+	case f.Channels() > 6:
+		return 5
+	default:
+		return 4
+	}
+}
+
+// HasJOC checks whether one of the sync frames signals Joint Object Coding (Dolby Atmos).
+func (d *AC3Data) HasJOC() bool {
+	for _, f := range d.Frames {
+		if f.ExtensionTypeA {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels returns the number of channels of the first access unit, E-AC-3
+// dependent substreams included. Channels of an independent substream that
+// are replaced by those of a dependent substream are not counted twice.
+func (d *AC3Data) Channels() (n int) {
+	for i, f := range d.Frames {
+		if i > 0 && (!f.IsEAC3 || f.StreamType != EAC3StreamTypeDependent) {
+			break
+		}
+		if f.IsEAC3 && f.StreamType == EAC3StreamTypeDependent && f.ChannelMapExists {
+			// The map lists the channels that are added or replaced. Only the ones
+			// that are not already part of a 5.1 independent substream are new.
+			n += f.Channels() - bitsSet(f.ChannelMap&0xf801)
+			continue
+		}
+		n += f.Channels()
+	}
+	return
+}
+
+// bitsSet returns the number of bits set.
+func bitsSet(v uint16) (n int) {
+	for ; v > 0; v &= v - 1 {
+		n++
+	}
+	return
+}
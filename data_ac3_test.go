@@ -0,0 +1,139 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func ac3FrameBytes(acmod uint8, lfeOn bool) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(ac3SyncWord, 16) // Sync word.
+	w.TryWriteBits(0x1234, 16)      // crc1.
+	WriteBinary(w, "00")            // fscod: 48kHz.
+	WriteBinary(w, "000000")        // frmsizecod: 32kbps.
+	w.TryWriteBits(8, 5)            // bsid.
+	WriteBinary(w, "000")           // bsmod.
+	w.TryWriteBits(uint64(acmod), 3)
+	if acmod&0x1 > 0 && acmod != AC3AudioCodingMode1_0 {
+		WriteBinary(w, "00") // cmixlev.
+	}
+	if acmod&0x4 > 0 {
+		WriteBinary(w, "00") // surmixlev.
+	}
+	if acmod == AC3AudioCodingMode2_0 {
+		WriteBinary(w, "10") // dsurmod.
+	}
+	w.TryWriteBool(lfeOn)
+	_ = w.Close()
+	return append(buf.Bytes(), make([]byte, 128-buf.Len())...)
+}
+
+func eac3FrameBytes(strmtyp, acmod uint8, lfeOn bool, chanmap uint16, joc bool) []byte {
+	const size = 256
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(ac3SyncWord, 16)    // Sync word.
+	w.TryWriteBits(uint64(strmtyp), 2) // strmtyp.
+	WriteBinary(w, "000")              // substreamid.
+	w.TryWriteBits(size/2-1, 11)       // frmsiz.
+	WriteBinary(w, "00")               // fscod: 48kHz.
+	WriteBinary(w, "11")               // numblkscod: 6 blocks.
+	w.TryWriteBits(uint64(acmod), 3)
+	w.TryWriteBool(lfeOn)
+	w.TryWriteBits(16, 5)   // bsid.
+	w.TryWriteBits(0x1f, 5) // dialnorm.
+	WriteBinary(w, "0")     // compre.
+	if strmtyp == EAC3StreamTypeDependent {
+		w.TryWriteBool(chanmap > 0) // chanmape.
+		if chanmap > 0 {
+			w.TryWriteBits(uint64(chanmap), 16)
+		}
+	}
+	WriteBinary(w, "0") // mixmdate.
+	WriteBinary(w, "0") // infomdate.
+	w.TryWriteBool(joc) // addbsie.
+	if joc {
+		w.TryWriteBits(1, 6)      // addbsil.
+		WriteBinary(w, "0000000") // flag_ec3_extension_type_reserved.
+		WriteBinary(w, "1")       // flag_ec3_extension_type_a.
+		w.TryWriteByte(16)        // complexity_index_type_a.
+	}
+	_ = w.Close()
+	return append(buf.Bytes(), make([]byte, size-buf.Len())...)
+}
+
+func TestParseAC3Data(t *testing.T) {
+	// AC-3
+	b := append(ac3FrameBytes(AC3AudioCodingMode3_2, true), ac3FrameBytes(AC3AudioCodingMode2_0, false)...)
+	d, err := ParseAC3Data(b, newClockReference(90000, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 2)
+	f := d.Frames[0]
+	assert.Equal(t, &AC3Frame{
+		AudioCodingMode: AC3AudioCodingMode3_2,
+		Bitrate:         32000,
+		BSID:            8,
+		Data:            b[:128],
+		LFEOn:           true,
+		NumBlocks:       6,
+		PTS:             newClockReference(90000, 0),
+		SampleRate:      48000,
+	}, f)
+	assert.Equal(t, 6, f.Channels())
+	assert.Equal(t, uint8(4), f.DescriptorNumberOfChannels())
+	assert.Equal(t, int64(90000+2880), d.Frames[1].PTS.Base)
+	assert.Equal(t, uint8(2), d.Frames[1].DolbySurroundMode)
+	assert.Equal(t, uint8(3), d.Frames[1].DescriptorNumberOfChannels())
+	assert.False(t, d.HasJOC())
+
+	// E-AC-3 5.1 with a dependent substream adding Lrs/Rrs and JOC
+	b = append(eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, true), eac3FrameBytes(EAC3StreamTypeDependent, AC3AudioCodingMode2_0, false, 1<<(15-6), false)...)
+	b = append(b, eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, true)...)
+	d, err = ParseAC3Data(b, newClockReference(90000, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 3)
+	f = d.Frames[0]
+	assert.True(t, f.IsEAC3)
+	assert.Equal(t, 64000, f.Bitrate)
+	assert.Equal(t, uint8(16), f.BSID)
+	assert.True(t, f.ExtensionTypeA)
+	assert.Equal(t, uint8(16), f.ComplexityIndexTypeA)
+	assert.Equal(t, 6, f.Channels())
+	f = d.Frames[1]
+	assert.Equal(t, uint8(EAC3StreamTypeDependent), f.StreamType)
+	assert.True(t, f.ChannelMapExists)
+	assert.Equal(t, 2, f.Channels())
+	assert.Equal(t, int64(90000), f.PTS.Base)
+	assert.Equal(t, int64(90000+2880), d.Frames[2].PTS.Base)
+	assert.True(t, d.HasJOC())
+	assert.Equal(t, 8, d.Channels())
+
+	// Additional bitstream information without the type A extension
+	b = eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, true)
+	b[8] &^= 0x10 // flag_ec3_extension_type_a.
+	d, err = ParseAC3Data(b, nil)
+	assert.NoError(t, err)
+	assert.False(t, d.Frames[0].ExtensionTypeA)
+	assert.Equal(t, uint8(0), d.Frames[0].ComplexityIndexTypeA)
+
+	// Garbage between frames is skipped
+	b = append(append(ac3FrameBytes(AC3AudioCodingMode1_0, false), 0x1, 0x2), ac3FrameBytes(AC3AudioCodingMode1_0, false)...)
+	d, err = ParseAC3Data(b, nil)
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 2)
+	assert.Nil(t, d.Frames[0].PTS)
+
+	// Errors
+	_, err = ParseAC3Data([]byte{0x1, 0x2}, nil)
+	assert.ErrorIs(t, err, ErrAC3SyncWordNotFound)
+	_, err = ParseAC3Data(ac3FrameBytes(AC3AudioCodingMode1_0, false)[:64], nil)
+	assert.ErrorIs(t, err, ErrAC3FrameTruncated)
+	b = ac3FrameBytes(AC3AudioCodingMode1_0, false)
+	b[5] = 10 << 3
+	_, err = ParseAC3Data(b, nil)
+	assert.ErrorIs(t, err, ErrAC3InvalidHeader)
+}
@@ -0,0 +1,267 @@
+package astits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// DTS core extension audio ids.
+// Page: 18 | Chapter: 5.3.1 | Link: https://www.etsi.org/deliver/etsi_ts/102100_102199/102114/01.06.01_60/ts_102114v010601p.pdf
+const (
+	DTSExtensionAudioIDXCh  = 0
+	DTSExtensionAudioIDX96  = 2
+	DTSExtensionAudioIDXXCh = 6
+)
+
+const (
+	dtsCoreSyncWord          = 0x7ffe8001
+	dtsSubstreamSyncWord     = 0x64582025
+	dtsCoreHeaderLength      = 17
+	dtsSubstreamHeaderLength = 8
+	dtsSampleBlockSamples    = 32
+	dtsSyncWordXLL           = 0x41a29547
+	dtsSyncWordXBR           = 0x655e315e
+	dtsSyncWordX96           = 0x1d95f262
+	dtsSyncWordLBR           = 0x0a801921
+	dtsSyncWordXXCh          = 0x47004a03
+)
+
+// Errors.
+var (
+	ErrDTSSyncWordNotFound = errors.New("DTS sync word not found")
+	ErrDTSFrameTruncated   = errors.New("DTS frame is truncated")
+	ErrDTSInvalidHeader    = errors.New("invalid DTS header")
+)
+
+var (
+	// dtsSampleRates are indexed by SFREQ, 0 being invalid.
+	dtsSampleRates = []int{0, 8000, 16000, 32000, 0, 0, 11025, 22050, 44100, 0, 0, 12000, 24000, 48000, 0, 0}
+
+	// dtsBitrates are indexed by RATE and expressed in bits per second, 0 being open, variable or lossless.
+	dtsBitrates = []int{
+		32000, 56000, 64000, 96000, 112000, 128000, 192000, 224000,
+		256000, 320000, 384000, 448000, 512000, 576000, 640000, 768000,
+		960000, 1024000, 1152000, 1280000, 1344000, 1408000, 1411200, 1472000,
+		1536000, 0, 0, 0, 0, 0, 0, 0,
+	}
+
+	// dtsModeChannels are indexed by AMODE, 0 being user defined.
+	dtsModeChannels = []int{1, 2, 2, 2, 2, 3, 3, 4, 4, 5, 6, 6, 6, 7, 8, 8}
+)
+
+// DTSData represents the DTS frames carried by a PES payload.
+type DTSData struct {
+	Frames []*DTSFrame
+}
+
+// DTSFrame represents a DTS frame made of an optional core substream
+// and an optional DTS-HD extension substream.
+type DTSFrame struct {
+	Core      *DTSCoreHeader
+	Data      []byte // The whole frame, extension substream included.
+	PTS       *ClockReference
+	Substream *DTSSubstreamHeader
+}
+
+// DTSCoreHeader represents a DTS core frame header.
+// Page: 13 | Chapter: 5.3.1 | Link: https://www.etsi.org/deliver/etsi_ts/102100_102199/102114/01.06.01_60/ts_102114v010601p.pdf
+type DTSCoreHeader struct {
+	AudioChannelArrangement uint8 // 6 bits, AMODE.
+	Bitrate                 int   // In bits per second.
+	CRCPresent              bool
+	ExtensionAudio          bool
+	ExtensionAudioID        uint8  // 3 bits.
+	FrameSize               uint16 // In bytes.
+	LFE                     uint8  // 2 bits, LFF.
+	NormalFrame             bool   // false for termination frames.
+	NumberOfBlocks          uint8  // 7 bits, NBLKS + 1 is the number of 32 samples blocks.
+	SampleRate              int
+	SourcePCMResolution     uint8 // 3 bits, PCMR.
+	TransmissionBitrate     uint8 // 5 bits, RATE.
+}
+
+// DTSSubstreamHeader represents a DTS-HD extension substream header.
+// Page: 59 | Chapter: 7.4 | Link: https://www.etsi.org/deliver/etsi_ts/102100_102199/102114/01.06.01_60/ts_102114v010601p.pdf
+type DTSSubstreamHeader struct {
+	HasLBR         bool // DTS Express.
+	HasX96         bool
+	HasXBR         bool // DTS-HD High Resolution.
+	HasXLL         bool // DTS-HD Master Audio.
+	HasXXCh        bool
+	HeaderSize     uint16
+	Index          uint8 // 2 bits, nExtSSIndex.
+	Size           uint32
+	UserDefinedBit uint8
+}
+
+// ParseDTSData splits a DTS byte stream such as a PES payload into frames. An extension
+// substream following a core frame is attached to it. pts is the PTS of the first frame
+// and may be nil.
+func ParseDTSData(b []byte, pts *ClockReference) (*DTSData, error) {
+	d := &DTSData{}
+	var samples int64
+	var sampleRate int
+	for i := 0; i < len(b); {
+		if len(b)-i < 4 {
+			if i == 0 {
+				return nil, ErrDTSSyncWordNotFound
+			}
+			break
+		}
+
+		var f *DTSFrame
+		start := i
+		switch binary.BigEndian.Uint32(b[i:]) {
+		case dtsCoreSyncWord:
+			c, err := parseDTSCoreHeader(b[i:])
+			if err != nil {
+				return nil, fmt.Errorf("parsing DTS core header failed: %w", err)
+			}
+			if int(c.FrameSize) > len(b)-i {
+				return nil, ErrDTSFrameTruncated
+			}
+			f = &DTSFrame{Core: c}
+			i += int(c.FrameSize)
+			if sampleRate == 0 {
+				sampleRate = c.SampleRate
+			}
+		case dtsSubstreamSyncWord:
+			f = &DTSFrame{}
+		default:
+			if i == 0 {
+				return nil, ErrDTSSyncWordNotFound
+			}
+			i++
+			continue
+		}
+
+		// Extension substream
+		if len(b)-i >= dtsSubstreamHeaderLength && binary.BigEndian.Uint32(b[i:]) == dtsSubstreamSyncWord {
+			s, err := parseDTSSubstreamHeader(b[i:])
+			if err != nil {
+				return nil, fmt.Errorf("parsing DTS substream header failed: %w", err)
+			}
+			if int(s.Size) > len(b)-i {
+				return nil, ErrDTSFrameTruncated
+			}
+			s.detectAssets(b[i+int(s.HeaderSize) : i+int(s.Size)])
+			f.Substream = s
+			i += int(s.Size)
+		}
+
+		f.Data = b[start:i]
+		f.PTS = addSamplesToPTS(pts, samples, sampleRate)
+		if f.Core != nil {
+			samples += int64(f.Core.Samples())
+		}
+		d.Frames = append(d.Frames, f)
+	}
+	return d, nil
+}
+
+// parseDTSCoreHeader parses a DTS core frame header.
+func parseDTSCoreHeader(b []byte) (*DTSCoreHeader, error) {
+	if len(b) < dtsCoreHeaderLength {
+		return nil, ErrDTSFrameTruncated
+	}
+
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	_ = r.TryReadBits(32) // Sync word.
+
+	h := &DTSCoreHeader{}
+	h.NormalFrame = r.TryReadBool()
+	_ = r.TryReadBits(5) // Deficit sample count.
+	h.CRCPresent = r.TryReadBool()
+	h.NumberOfBlocks = uint8(r.TryReadBits(7)) + 1
+	h.FrameSize = uint16(r.TryReadBits(14)) + 1
+	h.AudioChannelArrangement = uint8(r.TryReadBits(6))
+	sfreq := r.TryReadBits(4)
+	h.TransmissionBitrate = uint8(r.TryReadBits(5))
+	_ = r.TryReadBool() // Fixed bit.
+	_ = r.TryReadBool() // Embedded dynamic range flag.
+	_ = r.TryReadBool() // Embedded time stamp flag.
+	_ = r.TryReadBool() // Auxiliary data flag.
+	_ = r.TryReadBool() // HDCD.
+	h.ExtensionAudioID = uint8(r.TryReadBits(3))
+	h.ExtensionAudio = r.TryReadBool()
+	_ = r.TryReadBool() // Audio sync word insertion flag.
+	h.LFE = uint8(r.TryReadBits(2))
+	_ = r.TryReadBool() // Predictor history flag switch.
+	if h.CRCPresent {
+		_ = r.TryReadBits(16) // Header CRC.
+	}
+	_ = r.TryReadBool()  // Multirate interpolator switch.
+	_ = r.TryReadBits(4) // Encoder software revision.
+	_ = r.TryReadBits(2) // Copy history.
+	h.SourcePCMResolution = uint8(r.TryReadBits(3))
+
+	if h.SampleRate = dtsSampleRates[sfreq]; h.SampleRate == 0 {
+		return nil, fmt.Errorf("%w: sfreq %d", ErrDTSInvalidHeader, sfreq)
+	}
+	if h.FrameSize < 96 {
+		return nil, fmt.Errorf("%w: frame size %d", ErrDTSInvalidHeader, h.FrameSize)
+	}
+	h.Bitrate = dtsBitrates[h.TransmissionBitrate]
+	return h, r.TryError
+}
+
+// Samples returns the number of samples per channel of the core frame.
+func (h *DTSCoreHeader) Samples() int {
+	return int(h.NumberOfBlocks) * dtsSampleBlockSamples
+}
+
+// Channels returns the number of channels of the core frame, LFE included.
+// It returns 0 for user defined channel arrangements.
+func (h *DTSCoreHeader) Channels() (n int) {
+	if int(h.AudioChannelArrangement) >= len(dtsModeChannels) {
+		return 0
+	}
+	n = dtsModeChannels[h.AudioChannelArrangement]
+	if h.LFE > 0 {
+		n++
+	}
+	return
+}
+
+// parseDTSSubstreamHeader parses the beginning of a DTS-HD extension substream header.
+func parseDTSSubstreamHeader(b []byte) (*DTSSubstreamHeader, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	_ = r.TryReadBits(32) // Sync word.
+
+	h := &DTSSubstreamHeader{}
+	h.UserDefinedBit = r.TryReadByte()
+	h.Index = uint8(r.TryReadBits(2))
+	if r.TryReadBool() { // bHeaderSizeType
+		h.HeaderSize = uint16(r.TryReadBits(12)) + 1
+		h.Size = uint32(r.TryReadBits(20)) + 1
+	} else {
+		h.HeaderSize = uint16(r.TryReadBits(8)) + 1
+		h.Size = uint32(r.TryReadBits(16)) + 1
+	}
+	if uint32(h.HeaderSize) > h.Size || h.HeaderSize < dtsSubstreamHeaderLength {
+		return nil, fmt.Errorf("%w: header size %d and size %d", ErrDTSInvalidHeader, h.HeaderSize, h.Size)
+	}
+	return h, r.TryError
+}
+
+// detectAssets looks for the sync words of the coding components of the substream.
+func (h *DTSSubstreamHeader) detectAssets(b []byte) {
+	for i := 0; i+4 <= len(b); i++ {
+		switch binary.BigEndian.Uint32(b[i:]) {
+		case dtsSyncWordXLL:
+			h.HasXLL = true
+		case dtsSyncWordXBR:
+			h.HasXBR = true
+		case dtsSyncWordX96:
+			h.HasX96 = true
+		case dtsSyncWordLBR:
+			h.HasLBR = true
+		case dtsSyncWordXXCh:
+			h.HasXXCh = true
+		}
+	}
+}
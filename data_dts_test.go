@@ -0,0 +1,109 @@
+package astits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func dtsCoreBytes(crcPresent bool) []byte {
+	const size = 128
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(dtsCoreSyncWord, 32) // Sync word.
+	WriteBinary(w, "1")                 // FTYPE.
+	w.TryWriteBits(31, 5)               // SHORT.
+	w.TryWriteBool(crcPresent)          // CPF.
+	w.TryWriteBits(15, 7)               // NBLKS.
+	w.TryWriteBits(size-1, 14)          // FSIZE.
+	w.TryWriteBits(9, 6)                // AMODE.
+	w.TryWriteBits(13, 4)               // SFREQ.
+	w.TryWriteBits(15, 5)               // RATE.
+	WriteBinary(w, "00000")             // FixedBit, DYNF, TIMEF, AUXF and HDCD.
+	WriteBinary(w, "000")               // EXT_AUDIO_ID.
+	WriteBinary(w, "0")                 // EXT_AUDIO.
+	WriteBinary(w, "1")                 // ASPF.
+	WriteBinary(w, "01")                // LFF.
+	WriteBinary(w, "0")                 // HFLAG.
+	if crcPresent {
+		w.TryWriteBits(0x1234, 16) // HCRC.
+	}
+	WriteBinary(w, "0")    // FILTS.
+	WriteBinary(w, "0111") // VERNUM.
+	WriteBinary(w, "00")   // CHIST.
+	WriteBinary(w, "110")  // PCMR.
+	_ = w.Close()
+	return append(buf.Bytes(), make([]byte, size-buf.Len())...)
+}
+
+func dtsSubstreamBytes(assetSyncWord uint32) []byte {
+	const headerSize, size = 16, 64
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(dtsSubstreamSyncWord, 32) // Sync word.
+	w.TryWriteByte(0x1)                      // UserDefinedBits.
+	WriteBinary(w, "00")                     // nExtSSIndex.
+	WriteBinary(w, "0")                      // bHeaderSizeType.
+	w.TryWriteBits(headerSize-1, 8)          // nuExtSSHeaderSize.
+	w.TryWriteBits(size-1, 16)               // nuExtSSFSize.
+	_ = w.Close()
+	b := append(buf.Bytes(), make([]byte, size-buf.Len())...)
+	binary.BigEndian.PutUint32(b[headerSize:], assetSyncWord)
+	return b
+}
+
+func TestParseDTSData(t *testing.T) {
+	// Core with DTS-HD Master Audio substream
+	b := append(dtsCoreBytes(false), dtsSubstreamBytes(dtsSyncWordXLL)...)
+	b = append(b, dtsCoreBytes(true)...)
+	d, err := ParseDTSData(b, newClockReference(90000, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 2)
+	f := d.Frames[0]
+	assert.Equal(t, &DTSCoreHeader{
+		AudioChannelArrangement: 9,
+		Bitrate:                 768000,
+		FrameSize:               128,
+		LFE:                     1,
+		NormalFrame:             true,
+		NumberOfBlocks:          16,
+		SampleRate:              48000,
+		SourcePCMResolution:     6,
+		TransmissionBitrate:     15,
+	}, f.Core)
+	assert.Equal(t, 512, f.Core.Samples())
+	assert.Equal(t, 6, f.Core.Channels())
+	assert.Equal(t, &DTSSubstreamHeader{
+		HasXLL:         true,
+		HeaderSize:     16,
+		Size:           64,
+		UserDefinedBit: 0x1,
+	}, f.Substream)
+	assert.Equal(t, b[:192], f.Data)
+	assert.Equal(t, int64(90000), f.PTS.Base)
+	f = d.Frames[1]
+	assert.True(t, f.Core.CRCPresent)
+	assert.Equal(t, uint8(6), f.Core.SourcePCMResolution)
+	assert.Nil(t, f.Substream)
+	assert.Equal(t, int64(90000+960), f.PTS.Base)
+
+	// Substream only
+	d, err = ParseDTSData(dtsSubstreamBytes(dtsSyncWordLBR), nil)
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 1)
+	assert.Nil(t, d.Frames[0].Core)
+	assert.True(t, d.Frames[0].Substream.HasLBR)
+
+	// Errors
+	_, err = ParseDTSData([]byte{0x1, 0x2, 0x3, 0x4}, nil)
+	assert.ErrorIs(t, err, ErrDTSSyncWordNotFound)
+	_, err = ParseDTSData(dtsCoreBytes(false)[:64], nil)
+	assert.ErrorIs(t, err, ErrDTSFrameTruncated)
+	b = dtsCoreBytes(false)
+	b[8] &= 0xc3 // SFREQ
+	_, err = ParseDTSData(b, nil)
+	assert.ErrorIs(t, err, ErrDTSInvalidHeader)
+}
@@ -49,7 +49,7 @@ func parseEITSection(
 	d.LastTableID = r.TryReadByte()
 
 	// Loop until end of section data is reached.
-	for r.BitsCount < offsetSectionsEnd {
+	for r.TryError == nil && r.BitsCount < offsetSectionsEnd {
 		e := &EITDataEvent{}
 
 		e.EventID = uint16(r.TryReadBits(16))
@@ -46,4 +46,9 @@ func TestParseEITSection(t *testing.T) {
 	d, err := parseEITSection(r, int64(len(b)*8), uint16(1))
 	assert.Equal(t, d, eit)
 	assert.NoError(t, err)
+
+	// Section ends after the end of the data
+	r = bitio.NewCountReader(bytes.NewReader(b))
+	_, err = parseEITSection(r, int64(len(b)*8+32), uint16(1))
+	assert.Error(t, err)
 }
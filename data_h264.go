@@ -0,0 +1,381 @@
+package astits
+
+import (
+	"errors"
+	"fmt"
+)
+
+// H.264 NAL unit types.
+// Page: 65 | Chapter: 7.4.1 | Link: https://www.itu.int/rec/T-REC-H.264
+const (
+	H264NALUnitTypeNonIDRSlice H264NALUnitType = 1
+	H264NALUnitTypeIDRSlice    H264NALUnitType = 5
+	H264NALUnitTypeSEI         H264NALUnitType = 6
+	H264NALUnitTypeSPS         H264NALUnitType = 7
+	H264NALUnitTypePPS         H264NALUnitType = 8
+	H264NALUnitTypeAUD         H264NALUnitType = 9
+	H264NALUnitTypeEOS         H264NALUnitType = 10
+	H264NALUnitTypeEOB         H264NALUnitType = 11
+	H264NALUnitTypeFD          H264NALUnitType = 12
+	H264NALUnitTypeSPSExt      H264NALUnitType = 13
+)
+
+// ErrH264NALUnitTooShort is returned when a NAL unit is shorter than its header.
+var ErrH264NALUnitTooShort = errors.New("H264 NAL unit is too short")
+
+// h264ProfilesWithChromaInfo are the profile idcs whose SPS carries chroma format and bit depth information.
+var h264ProfilesWithChromaInfo = map[uint8]bool{
+	44: true, 83: true, 86: true, 100: true, 110: true, 118: true,
+	122: true, 128: true, 134: true, 135: true, 138: true, 139: true, 244: true,
+}
+
+// H264NALUnitType represents an H.264 NAL unit type.
+type H264NALUnitType uint8
+
+// IsVCL checks whether the NAL unit contains coded slice data.
+func (t H264NALUnitType) IsVCL() bool {
+	return t >= H264NALUnitTypeNonIDRSlice && t <= H264NALUnitTypeIDRSlice
+}
+
+// H264Data represents the H.264 NAL units carried by a PES payload.
+type H264Data struct {
+	NALUnits []*H264NALUnit
+}
+
+// H264NALUnit represents an H.264 NAL unit.
+// Page: 43 | Chapter: 7.3.1 | Link: https://www.itu.int/rec/T-REC-H.264
+type H264NALUnit struct {
+	Data        []byte // NAL unit as found in the stream, header included.
+	PPS         *H264PPS
+	RefIDC      uint8 // 2 bits.
+	SPS         *H264SPS
+	SliceHeader *H264SliceHeader // Only set for slices whose header could be read.
+	Type        H264NALUnitType
+}
+
+// H264SPS represents an H.264 sequence parameter set.
+// Page: 44 | Chapter: 7.3.2.1.1 | Link: https://www.itu.int/rec/T-REC-H.264
+type H264SPS struct {
+	ProfileIDC                  uint8
+	ConstraintFlags             uint8 // constraint_set0_flag to constraint_set5_flag and 2 reserved bits.
+	LevelIDC                    uint8
+	ID                          uint32
+	ChromaFormatIDC             uint32
+	SeparateColourPlaneFlag     bool
+	BitDepthLumaMinus8          uint32
+	BitDepthChromaMinus8        uint32
+	Log2MaxFrameNumMinus4       uint32
+	PicOrderCntType             uint32
+	Log2MaxPicOrderCntLsbMinus4 uint32
+	MaxNumRefFrames             uint32
+	PicWidthInMbsMinus1         uint32
+	PicHeightInMapUnitsMinus1   uint32
+	FrameMbsOnlyFlag            bool
+	FrameCroppingFlag           bool
+	FrameCropLeftOffset         uint32
+	FrameCropRightOffset        uint32
+	FrameCropTopOffset          uint32
+	FrameCropBottomOffset       uint32
+	VUI                         *H264VUI
+}
+
+// H264VUI represents H.264 video usability information.
+// Page: 397 | Chapter: E.1.1 | Link: https://www.itu.int/rec/T-REC-H.264
+type H264VUI struct {
+	AspectRatioIDC     uint8
+	SARWidth           uint16
+	SARHeight          uint16
+	VideoFormat        uint8 // 3 bits.
+	VideoFullRangeFlag bool
+	ColourDescription  *ColourDescription
+	TimingInfoPresent  bool
+	NumUnitsInTick     uint32
+	TimeScale          uint32
+	FixedFrameRateFlag bool
+}
+
+// H264SliceHeader represents the beginning of an H.264 slice header.
+// Chapter: 7.3.3 | Link: https://www.itu.int/rec/T-REC-H.264
+type H264SliceHeader struct {
+	FirstMBInSlice uint32
+	SliceType      uint32
+}
+
+// IsIntra checks whether the slice is an I or an SI slice.
+func (h *H264SliceHeader) IsIntra() bool {
+	return h.SliceType%5 == 2 || h.SliceType%5 == 4
+}
+
+// H264PPS represents the beginning of an H.264 picture parameter set.
+// Page: 47 | Chapter: 7.3.2.2 | Link: https://www.itu.int/rec/T-REC-H.264
+type H264PPS struct {
+	ID    uint32
+	SPSID uint32
+}
+
+// ParseH264Data parses the H.264 NAL units of an Annex B byte stream such as a PES payload.
+func ParseH264Data(b []byte) (*H264Data, error) {
+	d := &H264Data{}
+	for _, nalu := range splitNALUnits(b) {
+		n, err := parseH264NALUnit(nalu)
+		if err != nil {
+			return nil, fmt.Errorf("parsing H264 NAL unit failed: %w", err)
+		}
+		d.NALUnits = append(d.NALUnits, n)
+	}
+	return d, nil
+}
+
+// IsIDR checks whether the data contains an instantaneous decoding refresh picture.
+func (d *H264Data) IsIDR() bool {
+	for _, n := range d.NALUnits {
+		if n.Type == H264NALUnitTypeIDRSlice {
+			return true
+		}
+	}
+	return false
+}
+
+// SPS returns the first sequence parameter set found in the data.
+func (d *H264Data) SPS() *H264SPS {
+	for _, n := range d.NALUnits {
+		if n.SPS != nil {
+			return n.SPS
+		}
+	}
+	return nil
+}
+
+// PPS returns the first picture parameter set found in the data.
+func (d *H264Data) PPS() *H264PPS {
+	for _, n := range d.NALUnits {
+		if n.PPS != nil {
+			return n.PPS
+		}
+	}
+	return nil
+}
+
+// chromaArrayType returns the ChromaArrayType variable of the SPS.
+func (s *H264SPS) chromaArrayType() uint32 {
+	if s.SeparateColourPlaneFlag {
+		return 0
+	}
+	return s.ChromaFormatIDC
+}
+
+// Width returns the width of the decoded pictures once the cropping has been applied.
+func (s *H264SPS) Width() int {
+	cropUnitX := uint32(1)
+	if t := s.chromaArrayType(); t == ChromaFormat420 || t == ChromaFormat422 {
+		cropUnitX = 2
+	}
+	return int((s.PicWidthInMbsMinus1+1)*16 - cropUnitX*(s.FrameCropLeftOffset+s.FrameCropRightOffset))
+}
+
+// Height returns the height of the decoded pictures once the cropping has been applied.
+func (s *H264SPS) Height() int {
+	cropUnitY := uint32(1)
+	if s.chromaArrayType() == ChromaFormat420 {
+		cropUnitY = 2
+	}
+	frameHeightInMbs := s.PicHeightInMapUnitsMinus1 + 1
+	if !s.FrameMbsOnlyFlag {
+		cropUnitY *= 2
+		frameHeightInMbs *= 2
+	}
+	return int(frameHeightInMbs*16 - cropUnitY*(s.FrameCropTopOffset+s.FrameCropBottomOffset))
+}
+
+// BitDepthLuma returns the bit depth of the luma samples.
+func (s *H264SPS) BitDepthLuma() int {
+	return int(s.BitDepthLumaMinus8) + 8
+}
+
+// BitDepthChroma returns the bit depth of the chroma samples.
+func (s *H264SPS) BitDepthChroma() int {
+	return int(s.BitDepthChromaMinus8) + 8
+}
+
+// parseH264NALUnit parses an H.264 NAL unit.
+func parseH264NALUnit(b []byte) (*H264NALUnit, error) {
+	if len(b) < 1 {
+		return nil, ErrH264NALUnitTooShort
+	}
+
+	n := &H264NALUnit{
+		Data:   b,
+		RefIDC: b[0] >> 5 & 0x3,
+		Type:   H264NALUnitType(b[0] & 0x1f),
+	}
+
+	var err error
+	switch n.Type {
+	case H264NALUnitTypeSPS:
+		if n.SPS, err = parseH264SPS(unescapeRBSP(b[1:])); err != nil {
+			return nil, fmt.Errorf("parsing SPS failed: %w", err)
+		}
+	case H264NALUnitTypePPS:
+		if n.PPS, err = parseH264PPS(unescapeRBSP(b[1:])); err != nil {
+			return nil, fmt.Errorf("parsing PPS failed: %w", err)
+		}
+	case H264NALUnitTypeNonIDRSlice, H264NALUnitTypeIDRSlice:
+		// Slice data may be truncated or scrambled, which is not an error
+		n.SliceHeader, _ = parseH264SliceHeader(unescapeRBSP(b[1:]))
+	}
+	return n, nil
+}
+
+// parseH264SPS parses an H.264 sequence parameter set RBSP.
+func parseH264SPS(rbsp []byte) (*H264SPS, error) { //nolint:funlen
+	r := newNALReader(rbsp)
+	s := &H264SPS{ChromaFormatIDC: ChromaFormat420}
+
+	s.ProfileIDC = r.TryReadByte()
+	s.ConstraintFlags = r.TryReadByte()
+	s.LevelIDC = r.TryReadByte()
+	s.ID = r.TryReadUE()
+
+	if h264ProfilesWithChromaInfo[s.ProfileIDC] {
+		s.ChromaFormatIDC = r.TryReadUE()
+		if s.ChromaFormatIDC == ChromaFormat444 {
+			s.SeparateColourPlaneFlag = r.TryReadBool()
+		}
+		s.BitDepthLumaMinus8 = r.TryReadUE()
+		s.BitDepthChromaMinus8 = r.TryReadUE()
+		_ = r.TryReadBool()  // qpprime_y_zero_transform_bypass_flag
+		if r.TryReadBool() { // seq_scaling_matrix_present_flag
+			count := 8
+			if s.ChromaFormatIDC == ChromaFormat444 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if !r.TryReadBool() { // seq_scaling_list_present_flag
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				skipH264ScalingList(r, size)
+			}
+		}
+	}
+
+	s.Log2MaxFrameNumMinus4 = r.TryReadUE()
+	s.PicOrderCntType = r.TryReadUE()
+	switch s.PicOrderCntType {
+	case 0:
+		s.Log2MaxPicOrderCntLsbMinus4 = r.TryReadUE()
+	case 1:
+		_ = r.TryReadBool() // delta_pic_order_always_zero_flag
+		_ = r.TryReadSE()   // offset_for_non_ref_pic
+		_ = r.TryReadSE()   // offset_for_top_to_bottom_field
+		numRefFramesInPicOrderCntCycle := r.TryReadUE()
+		if numRefFramesInPicOrderCntCycle > 255 {
+			return nil, fmt.Errorf("invalid num_ref_frames_in_pic_order_cnt_cycle %d", numRefFramesInPicOrderCntCycle)
+		}
+		for i := uint32(0); i < numRefFramesInPicOrderCntCycle; i++ {
+			_ = r.TryReadSE() // offset_for_ref_frame
+		}
+	}
+
+	s.MaxNumRefFrames = r.TryReadUE()
+	_ = r.TryReadBool() // gaps_in_frame_num_value_allowed_flag
+	s.PicWidthInMbsMinus1 = r.TryReadUE()
+	s.PicHeightInMapUnitsMinus1 = r.TryReadUE()
+	s.FrameMbsOnlyFlag = r.TryReadBool()
+	if !s.FrameMbsOnlyFlag {
+		_ = r.TryReadBool() // mb_adaptive_frame_field_flag
+	}
+	_ = r.TryReadBool() // direct_8x8_inference_flag
+
+	s.FrameCroppingFlag = r.TryReadBool()
+	if s.FrameCroppingFlag {
+		s.FrameCropLeftOffset = r.TryReadUE()
+		s.FrameCropRightOffset = r.TryReadUE()
+		s.FrameCropTopOffset = r.TryReadUE()
+		s.FrameCropBottomOffset = r.TryReadUE()
+	}
+
+	if r.TryReadBool() { // vui_parameters_present_flag
+		s.VUI = parseH264VUI(r)
+	}
+	return s, r.TryError
+}
+
+// skipH264ScalingList skips a scaling_list structure.
+func skipH264ScalingList(r *nalReader, size int) {
+	lastScale, nextScale := int32(8), int32(8)
+	for j := 0; j < size && r.TryError == nil; j++ {
+		if nextScale != 0 {
+			nextScale = (lastScale + r.TryReadSE() + 256) % 256 // delta_scale
+		}
+		if nextScale != 0 {
+			lastScale = nextScale
+		}
+	}
+}
+
+// parseH264VUI parses a vui_parameters structure up to the timing information.
+func parseH264VUI(r *nalReader) *H264VUI {
+	v := &H264VUI{}
+
+	if r.TryReadBool() { // aspect_ratio_info_present_flag
+		v.AspectRatioIDC = r.TryReadByte()
+		if v.AspectRatioIDC == 255 { // Extended_SAR
+			v.SARWidth = uint16(r.TryReadBits(16))
+			v.SARHeight = uint16(r.TryReadBits(16))
+		}
+	}
+
+	if r.TryReadBool() { // overscan_info_present_flag
+		_ = r.TryReadBool() // overscan_appropriate_flag
+	}
+
+	if r.TryReadBool() { // video_signal_type_present_flag
+		v.VideoFormat = uint8(r.TryReadBits(3))
+		v.VideoFullRangeFlag = r.TryReadBool()
+		if r.TryReadBool() { // colour_description_present_flag
+			v.ColourDescription = &ColourDescription{
+				ColourPrimaries:         r.TryReadByte(),
+				TransferCharacteristics: r.TryReadByte(),
+				MatrixCoefficients:      r.TryReadByte(),
+			}
+		}
+	}
+
+	if r.TryReadBool() { // chroma_loc_info_present_flag
+		_ = r.TryReadUE() // chroma_sample_loc_type_top_field
+		_ = r.TryReadUE() // chroma_sample_loc_type_bottom_field
+	}
+
+	v.TimingInfoPresent = r.TryReadBool()
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick = uint32(r.TryReadBits(32))
+		v.TimeScale = uint32(r.TryReadBits(32))
+		v.FixedFrameRateFlag = r.TryReadBool()
+	}
+	return v
+}
+
+// parseH264SliceHeader parses the beginning of an H.264 slice header.
+func parseH264SliceHeader(rbsp []byte) (*H264SliceHeader, error) {
+	r := newNALReader(rbsp)
+	h := &H264SliceHeader{}
+	h.FirstMBInSlice = r.TryReadUE()
+	h.SliceType = r.TryReadUE()
+	if r.TryError != nil {
+		return nil, r.TryError
+	}
+	return h, nil
+}
+
+// parseH264PPS parses the beginning of an H.264 picture parameter set RBSP.
+func parseH264PPS(rbsp []byte) (*H264PPS, error) {
+	r := newNALReader(rbsp)
+	p := &H264PPS{}
+	p.ID = r.TryReadUE()
+	p.SPSID = r.TryReadUE()
+	return p, r.TryError
+}
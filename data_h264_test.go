@@ -0,0 +1,151 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func h264NALUnitHeader(t H264NALUnitType) []byte {
+	return []byte{0x60 | byte(t)}
+}
+
+func h264SPSBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteByte(100)  // Profile idc.
+	w.TryWriteByte(0x0)  // Constraint flags.
+	w.TryWriteByte(40)   // Level idc.
+	writeUE(w, 0)        // SPS id.
+	writeUE(w, 1)        // Chroma format idc.
+	writeUE(w, 0)        // Bit depth luma minus 8.
+	writeUE(w, 0)        // Bit depth chroma minus 8.
+	WriteBinary(w, "0")  // Qpprime y zero transform bypass.
+	WriteBinary(w, "1")  // Seq scaling matrix present.
+	WriteBinary(w, "1")  // #1 Seq scaling list present.
+	writeSE(w, -8)       // #1 Delta scale.
+	WriteBinary(w, "0")  // #2 Seq scaling list present.
+	WriteBinary(w, "0")  // #3 Seq scaling list present.
+	WriteBinary(w, "0")  // #4 Seq scaling list present.
+	WriteBinary(w, "0")  // #5 Seq scaling list present.
+	WriteBinary(w, "0")  // #6 Seq scaling list present.
+	WriteBinary(w, "0")  // #7 Seq scaling list present.
+	WriteBinary(w, "0")  // #8 Seq scaling list present.
+	writeUE(w, 0)        // Log2 max frame num minus 4.
+	writeUE(w, 0)        // Pic order cnt type.
+	writeUE(w, 2)        // Log2 max pic order cnt lsb minus 4.
+	writeUE(w, 4)        // Max num ref frames.
+	WriteBinary(w, "0")  // Gaps in frame num value allowed.
+	writeUE(w, 119)      // Pic width in mbs minus 1.
+	writeUE(w, 67)       // Pic height in map units minus 1.
+	WriteBinary(w, "1")  // Frame mbs only.
+	WriteBinary(w, "1")  // Direct 8x8 inference.
+	WriteBinary(w, "1")  // Frame cropping.
+	writeUE(w, 0)        // Left offset.
+	writeUE(w, 0)        // Right offset.
+	writeUE(w, 0)        // Top offset.
+	writeUE(w, 4)        // Bottom offset.
+	WriteBinary(w, "1")  // VUI present.
+	WriteBinary(w, "1")  // Aspect ratio info present.
+	w.TryWriteByte(1)    // Aspect ratio idc.
+	WriteBinary(w, "0")  // Overscan info present.
+	WriteBinary(w, "1")  // Video signal type present.
+	w.TryWriteBits(5, 3) // Video format.
+	WriteBinary(w, "0")  // Full range.
+	WriteBinary(w, "1")  // Colour description present.
+	w.TryWriteByte(ColourPrimariesBT709)
+	w.TryWriteByte(TransferCharacteristicsBT709)
+	w.TryWriteByte(1)   // Matrix coefficients.
+	WriteBinary(w, "0") // Chroma loc info present.
+	WriteBinary(w, "1") // Timing info present.
+	w.TryWriteBits(1, 32)
+	w.TryWriteBits(50, 32)
+	WriteBinary(w, "1") // Fixed frame rate.
+	WriteBinary(w, "0") // NAL HRD parameters present.
+	WriteBinary(w, "0") // VCL HRD parameters present.
+	WriteBinary(w, "0") // Pic struct present.
+	WriteBinary(w, "0") // Bitstream restriction.
+	writeRBSPTrailingBits(w)
+	return append(h264NALUnitHeader(H264NALUnitTypeSPS), escapeRBSP(buf.Bytes())...)
+}
+
+func h264PPSBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	writeUE(w, 1)       // PPS id.
+	writeUE(w, 0)       // SPS id.
+	WriteBinary(w, "1") // Entropy coding mode.
+	writeRBSPTrailingBits(w)
+	return append(h264NALUnitHeader(H264NALUnitTypePPS), escapeRBSP(buf.Bytes())...)
+}
+
+func h264IDRBytes() []byte {
+	return append(h264NALUnitHeader(H264NALUnitTypeIDRSlice), 0x88, 0x0, 0x0, 0x3, 0x1)
+}
+
+func TestParseH264Data(t *testing.T) {
+	d, err := ParseH264Data(annexB(
+		append(h264NALUnitHeader(H264NALUnitTypeAUD), 0xf0),
+		h264SPSBytes(),
+		h264PPSBytes(),
+		h264IDRBytes(),
+	))
+	assert.NoError(t, err)
+	assert.Len(t, d.NALUnits, 4)
+	assert.Equal(t, H264NALUnitTypeAUD, d.NALUnits[0].Type)
+	assert.Equal(t, uint8(3), d.NALUnits[0].RefIDC)
+	assert.Equal(t, h264IDRBytes(), d.NALUnits[3].Data)
+	assert.True(t, d.IsIDR())
+	assert.True(t, d.NALUnits[3].Type.IsVCL())
+	assert.False(t, d.NALUnits[2].Type.IsVCL())
+
+	// SPS
+	sps := d.SPS()
+	assert.Equal(t, &H264SPS{
+		ProfileIDC:                  100,
+		LevelIDC:                    40,
+		ChromaFormatIDC:             ChromaFormat420,
+		Log2MaxPicOrderCntLsbMinus4: 2,
+		MaxNumRefFrames:             4,
+		PicWidthInMbsMinus1:         119,
+		PicHeightInMapUnitsMinus1:   67,
+		FrameMbsOnlyFlag:            true,
+		FrameCroppingFlag:           true,
+		FrameCropBottomOffset:       4,
+		VUI: &H264VUI{
+			AspectRatioIDC: 1,
+			VideoFormat:    5,
+			ColourDescription: &ColourDescription{
+				ColourPrimaries:         ColourPrimariesBT709,
+				MatrixCoefficients:      1,
+				TransferCharacteristics: TransferCharacteristicsBT709,
+			},
+			TimingInfoPresent:  true,
+			NumUnitsInTick:     1,
+			TimeScale:          50,
+			FixedFrameRateFlag: true,
+		},
+	}, sps)
+	assert.Equal(t, 1920, sps.Width())
+	assert.Equal(t, 1080, sps.Height())
+	assert.Equal(t, 8, sps.BitDepthLuma())
+	assert.Equal(t, 8, sps.BitDepthChroma())
+
+	// PPS
+	assert.Equal(t, &H264PPS{ID: 1}, d.PPS())
+
+	// Slice headers
+	assert.Equal(t, &H264SliceHeader{SliceType: 7}, d.NALUnits[3].SliceHeader)
+	assert.True(t, d.NALUnits[3].SliceHeader.IsIntra())
+	d, err = ParseH264Data(annexB(append(h264NALUnitHeader(H264NALUnitTypeNonIDRSlice), 0xc0), h264NALUnitHeader(H264NALUnitTypeNonIDRSlice)))
+	assert.NoError(t, err)
+	assert.Equal(t, &H264SliceHeader{}, d.NALUnits[0].SliceHeader)
+	assert.False(t, d.NALUnits[0].SliceHeader.IsIntra())
+	assert.Nil(t, d.NALUnits[1].SliceHeader)
+
+	// Errors
+	_, err = ParseH264Data(annexB(append(h264NALUnitHeader(H264NALUnitTypeSPS), 0x64)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,807 @@
+package astits
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HEVC NAL unit types.
+// Page: 68 | Chapter: 7.4.2.2 | Link: https://www.itu.int/rec/T-REC-H.265
+const (
+	HEVCNALUnitTypeTrailN         HEVCNALUnitType = 0
+	HEVCNALUnitTypeTrailR         HEVCNALUnitType = 1
+	HEVCNALUnitTypeRADLN          HEVCNALUnitType = 6
+	HEVCNALUnitTypeRADLR          HEVCNALUnitType = 7
+	HEVCNALUnitTypeRASLN          HEVCNALUnitType = 8
+	HEVCNALUnitTypeRASLR          HEVCNALUnitType = 9
+	HEVCNALUnitTypeBLAWLP         HEVCNALUnitType = 16
+	HEVCNALUnitTypeBLAWRADL       HEVCNALUnitType = 17
+	HEVCNALUnitTypeBLANLP         HEVCNALUnitType = 18
+	HEVCNALUnitTypeIDRWRADL       HEVCNALUnitType = 19
+	HEVCNALUnitTypeIDRNLP         HEVCNALUnitType = 20
+	HEVCNALUnitTypeCRA            HEVCNALUnitType = 21
+	HEVCNALUnitTypeVPS            HEVCNALUnitType = 32
+	HEVCNALUnitTypeSPS            HEVCNALUnitType = 33
+	HEVCNALUnitTypePPS            HEVCNALUnitType = 34
+	HEVCNALUnitTypeAUD            HEVCNALUnitType = 35
+	HEVCNALUnitTypeEOS            HEVCNALUnitType = 36
+	HEVCNALUnitTypeEOB            HEVCNALUnitType = 37
+	HEVCNALUnitTypeFD             HEVCNALUnitType = 38
+	HEVCNALUnitTypePrefixSEI      HEVCNALUnitType = 39
+	HEVCNALUnitTypeSuffixSEI      HEVCNALUnitType = 40
+	hevcNALUnitTypeReservedIRAP23 HEVCNALUnitType = 23
+)
+
+// HEVC SEI payload types.
+// Page: 356 | Chapter: D.2.1 | Link: https://www.itu.int/rec/T-REC-H.265
+const (
+	HEVCSEIPayloadTypeUserDataRegistered           = 4
+	HEVCSEIPayloadTypeUserDataUnregistered         = 5
+	HEVCSEIPayloadTypeMasteringDisplayColourVolume = 137
+	HEVCSEIPayloadTypeContentLightLevelInfo        = 144
+	HEVCSEIPayloadTypeAlternativeTransfer          = 147
+)
+
+// Chroma format idcs.
+const (
+	ChromaFormatMonochrome = 0
+	ChromaFormat420        = 1
+	ChromaFormat422        = 2
+	ChromaFormat444        = 3
+)
+
+// ErrHEVCNALUnitTooShort is returned when a NAL unit is shorter than its header.
+var ErrHEVCNALUnitTooShort = errors.New("HEVC NAL unit is too short")
+
+// HEVCNALUnitType represents an HEVC NAL unit type.
+type HEVCNALUnitType uint8
+
+// IsIRAP checks whether the NAL unit is part of an intra random access point picture.
+func (t HEVCNALUnitType) IsIRAP() bool {
+	return t >= HEVCNALUnitTypeBLAWLP && t <= hevcNALUnitTypeReservedIRAP23
+}
+
+// IsIDR checks whether the NAL unit is part of an instantaneous decoding refresh picture.
+func (t HEVCNALUnitType) IsIDR() bool {
+	return t == HEVCNALUnitTypeIDRWRADL || t == HEVCNALUnitTypeIDRNLP
+}
+
+// IsCRA checks whether the NAL unit is part of a clean random access picture.
+func (t HEVCNALUnitType) IsCRA() bool {
+	return t == HEVCNALUnitTypeCRA
+}
+
+// IsBLA checks whether the NAL unit is part of a broken link access picture.
+func (t HEVCNALUnitType) IsBLA() bool {
+	return t >= HEVCNALUnitTypeBLAWLP && t <= HEVCNALUnitTypeBLANLP
+}
+
+// IsVCL checks whether the NAL unit contains coded slice data.
+func (t HEVCNALUnitType) IsVCL() bool {
+	return t < HEVCNALUnitTypeVPS
+}
+
+// HEVCData represents the HEVC NAL units carried by a PES payload.
+type HEVCData struct {
+	NALUnits []*HEVCNALUnit
+}
+
+// HEVCNALUnit represents an HEVC NAL unit.
+// Page: 45 | Chapter: 7.3.1 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCNALUnit struct {
+	Data            []byte // NAL unit as found in the stream, header included.
+	LayerID         uint8  // 6 bits.
+	PPS             *HEVCPPS
+	SEIMessages     []*HEVCSEIMessage
+	SPS             *HEVCSPS
+	TemporalIDPlus1 uint8 // 3 bits.
+	Type            HEVCNALUnitType
+	VPS             *HEVCVPS
+}
+
+// HEVCProfileTierLevel represents an HEVC profile_tier_level structure.
+// Page: 50 | Chapter: 7.3.3 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCProfileTierLevel struct {
+	// ConstraintIndicatorFlags holds the 48 bits starting with
+	// general_progressive_source_flag, as needed by the hvcC box.
+	ConstraintIndicatorFlags  uint64
+	FrameOnlyConstraintFlag   bool
+	InterlacedSourceFlag      bool
+	LevelIDC                  uint8
+	NonPackedConstraintFlag   bool
+	ProfileCompatibilityFlags uint32
+	ProfileIDC                uint8 // 5 bits.
+	ProfileSpace              uint8 // 2 bits.
+	ProgressiveSourceFlag     bool
+	TierFlag                  bool
+}
+
+// HEVCVPS represents an HEVC video parameter set.
+// Page: 46 | Chapter: 7.3.2.1 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCVPS struct {
+	ID                  uint8 // 4 bits.
+	BaseLayerInternal   bool
+	BaseLayerAvailable  bool
+	MaxLayersMinus1     uint8 // 6 bits.
+	MaxSubLayersMinus1  uint8 // 3 bits.
+	TemporalIDNesting   bool
+	ProfileTierLevel    *HEVCProfileTierLevel
+	SubLayerOrderingAll bool
+	TimingInfoPresent   bool
+	NumUnitsInTick      uint32
+	TimeScale           uint32
+}
+
+// HEVCSPS represents an HEVC sequence parameter set.
+// Page: 47 | Chapter: 7.3.2.2 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCSPS struct {
+	VPSID                             uint8 // 4 bits.
+	MaxSubLayersMinus1                uint8 // 3 bits.
+	TemporalIDNesting                 bool
+	ProfileTierLevel                  *HEVCProfileTierLevel
+	ID                                uint32
+	ChromaFormatIDC                   uint32
+	SeparateColourPlaneFlag           bool
+	PicWidthInLumaSamples             uint32
+	PicHeightInLumaSamples            uint32
+	ConformanceWindowFlag             bool
+	ConformanceWindowLeftOffset       uint32
+	ConformanceWindowRightOffset      uint32
+	ConformanceWindowTopOffset        uint32
+	ConformanceWindowBottomOffset     uint32
+	BitDepthLumaMinus8                uint32
+	BitDepthChromaMinus8              uint32
+	Log2MaxPicOrderCntLsbMinus4       uint32
+	Log2MinLumaCodingBlockSizeMinus3  uint32
+	Log2DiffMaxMinLumaCodingBlockSize uint32
+	ScalingListEnabled                bool
+	AMPEnabled                        bool
+	SampleAdaptiveOffsetEnabled       bool
+	PCMEnabled                        bool
+	NumShortTermRefPicSets            uint32
+	LongTermRefPicsPresent            bool
+	TemporalMVPEnabled                bool
+	StrongIntraSmoothingEnabled       bool
+	VUI                               *HEVCVUI
+}
+
+// HEVCVUI represents HEVC video usability information.
+// Page: 410 | Chapter: E.2.1 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCVUI struct {
+	AspectRatioIDC            uint8
+	SARWidth                  uint16
+	SARHeight                 uint16
+	VideoFormat               uint8 // 3 bits.
+	VideoFullRangeFlag        bool
+	ColourDescription         *ColourDescription
+	ChromaLocInfoPresent      bool
+	ChromaSampleLocTypeTop    uint32
+	ChromaSampleLocTypeBottom uint32
+	FieldSeqFlag              bool
+	FrameFieldInfoPresent     bool
+	TimingInfoPresent         bool
+	NumUnitsInTick            uint32
+	TimeScale                 uint32
+}
+
+// HEVCPPS represents an HEVC picture parameter set.
+// Page: 49 | Chapter: 7.3.2.3 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCPPS struct {
+	ID                              uint32
+	SPSID                           uint32
+	DependentSliceSegmentsEnabled   bool
+	OutputFlagPresent               bool
+	NumExtraSliceHeaderBits         uint8 // 3 bits.
+	SignDataHidingEnabled           bool
+	CabacInitPresent                bool
+	NumRefIdxL0DefaultActiveMinus1  uint32
+	NumRefIdxL1DefaultActiveMinus1  uint32
+	InitQPMinus26                   int32
+	ConstrainedIntraPred            bool
+	TransformSkipEnabled            bool
+	CUQPDeltaEnabled                bool
+	DiffCUQPDeltaDepth              uint32
+	WeightedPred                    bool
+	WeightedBipred                  bool
+	TransquantBypassEnabled         bool
+	TilesEnabled                    bool
+	EntropyCodingSyncEnabled        bool
+	PPSCbQPOffset                   int32
+	PPSCrQPOffset                   int32
+	PPSSliceChromaQPOffsetsPresent  bool
+	LoopFilterAcrossSlicesEnabled   bool
+	DeblockingFilterControlPresent  bool
+	ListsModificationPresent        bool
+	Log2ParallelMergeLevelMinus2    uint32
+	SliceSegmentHeaderExtPresent    bool
+	DeblockingFilterOverrideEnabled bool
+}
+
+// HEVCSEIMessage represents an HEVC SEI message.
+// Page: 54 | Chapter: 7.3.5 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCSEIMessage struct {
+	AlternativeTransfer          *HEVCSEIAlternativeTransfer
+	ContentLightLevelInfo        *HEVCSEIContentLightLevelInfo
+	MasteringDisplayColourVolume *HEVCSEIMasteringDisplayColourVolume
+	Payload                      []byte
+	PayloadType                  uint32
+}
+
+// HEVCSEIMasteringDisplayColourVolume represents a mastering display colour volume SEI message.
+// Primaries and white point are in increments of 0.00002, luminances in increments of 0.0001 cd/m2.
+// Page: 389 | Chapter: D.2.28 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCSEIMasteringDisplayColourVolume struct {
+	DisplayPrimariesX            [3]uint16
+	DisplayPrimariesY            [3]uint16
+	MaxDisplayMasteringLuminance uint32
+	MinDisplayMasteringLuminance uint32
+	WhitePointX                  uint16
+	WhitePointY                  uint16
+}
+
+// HEVCSEIContentLightLevelInfo represents a content light level information SEI message.
+// Values are in cd/m2.
+// Page: 395 | Chapter: D.2.35 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCSEIContentLightLevelInfo struct {
+	MaxContentLightLevel    uint16
+	MaxPicAverageLightLevel uint16
+}
+
+// HEVCSEIAlternativeTransfer represents an alternative transfer characteristics SEI message.
+// It is used by HLG streams to signal a BT.2020 compatible fallback in the VUI.
+// Page: 398 | Chapter: D.2.38 | Link: https://www.itu.int/rec/T-REC-H.265
+type HEVCSEIAlternativeTransfer struct {
+	PreferredTransferCharacteristics uint8
+}
+
+// ParseHEVCData parses the HEVC NAL units of an Annex B byte stream such as a PES payload.
+func ParseHEVCData(b []byte) (*HEVCData, error) {
+	d := &HEVCData{}
+	for _, nalu := range splitNALUnits(b) {
+		n, err := parseHEVCNALUnit(nalu)
+		if err != nil {
+			return nil, fmt.Errorf("parsing HEVC NAL unit failed: %w", err)
+		}
+		d.NALUnits = append(d.NALUnits, n)
+	}
+	return d, nil
+}
+
+// IRAPType returns the NAL unit type of the first IRAP picture
+// slice found in the data, and false if there is none.
+func (d *HEVCData) IRAPType() (HEVCNALUnitType, bool) {
+	for _, n := range d.NALUnits {
+		if n.Type.IsIRAP() {
+			return n.Type, true
+		}
+	}
+	return 0, false
+}
+
+// IsIRAP checks whether the data contains an intra random access point picture.
+func (d *HEVCData) IsIRAP() bool {
+	_, ok := d.IRAPType()
+	return ok
+}
+
+// VPS returns the first video parameter set found in the data.
+func (d *HEVCData) VPS() *HEVCVPS {
+	for _, n := range d.NALUnits {
+		if n.VPS != nil {
+			return n.VPS
+		}
+	}
+	return nil
+}
+
+// SPS returns the first sequence parameter set found in the data.
+func (d *HEVCData) SPS() *HEVCSPS {
+	for _, n := range d.NALUnits {
+		if n.SPS != nil {
+			return n.SPS
+		}
+	}
+	return nil
+}
+
+// PPS returns the first picture parameter set found in the data.
+func (d *HEVCData) PPS() *HEVCPPS {
+	for _, n := range d.NALUnits {
+		if n.PPS != nil {
+			return n.PPS
+		}
+	}
+	return nil
+}
+
+// SEIMessages returns all SEI messages found in the data.
+func (d *HEVCData) SEIMessages() (ms []*HEVCSEIMessage) {
+	for _, n := range d.NALUnits {
+		ms = append(ms, n.SEIMessages...)
+	}
+	return
+}
+
+// Width returns the width of the decoded pictures once the conformance window has been applied.
+func (s *HEVCSPS) Width() int {
+	subWidthC := uint32(1)
+	if s.ChromaFormatIDC == ChromaFormat420 || s.ChromaFormatIDC == ChromaFormat422 {
+		subWidthC = 2
+	}
+	return int(s.PicWidthInLumaSamples - subWidthC*(s.ConformanceWindowLeftOffset+s.ConformanceWindowRightOffset))
+}
+
+// Height returns the height of the decoded pictures once the conformance window has been applied.
+func (s *HEVCSPS) Height() int {
+	subHeightC := uint32(1)
+	if s.ChromaFormatIDC == ChromaFormat420 {
+		subHeightC = 2
+	}
+	return int(s.PicHeightInLumaSamples - subHeightC*(s.ConformanceWindowTopOffset+s.ConformanceWindowBottomOffset))
+}
+
+// BitDepthLuma returns the bit depth of the luma samples.
+func (s *HEVCSPS) BitDepthLuma() int {
+	return int(s.BitDepthLumaMinus8) + 8
+}
+
+// BitDepthChroma returns the bit depth of the chroma samples.
+func (s *HEVCSPS) BitDepthChroma() int {
+	return int(s.BitDepthChromaMinus8) + 8
+}
+
+// IsHDR checks whether the VUI signals an HEVC HDR transfer function (PQ or HLG).
+func (s *HEVCSPS) IsHDR() bool {
+	return s.VUI != nil && s.VUI.ColourDescription != nil && s.VUI.ColourDescription.IsHDR()
+}
+
+// parseHEVCNALUnit parses an HEVC NAL unit.
+func parseHEVCNALUnit(b []byte) (*HEVCNALUnit, error) {
+	if len(b) < 2 {
+		return nil, ErrHEVCNALUnitTooShort
+	}
+
+	n := &HEVCNALUnit{
+		Data:            b,
+		Type:            HEVCNALUnitType(b[0] >> 1 & 0x3f),
+		LayerID:         (b[0]&0x1)<<5 | b[1]>>3,
+		TemporalIDPlus1: b[1] & 0x7,
+	}
+
+	var err error
+	switch n.Type {
+	case HEVCNALUnitTypeVPS:
+		if n.VPS, err = parseHEVCVPS(unescapeRBSP(b[2:])); err != nil {
+			return nil, fmt.Errorf("parsing VPS failed: %w", err)
+		}
+	case HEVCNALUnitTypeSPS:
+		if n.SPS, err = parseHEVCSPS(unescapeRBSP(b[2:])); err != nil {
+			return nil, fmt.Errorf("parsing SPS failed: %w", err)
+		}
+	case HEVCNALUnitTypePPS:
+		if n.PPS, err = parseHEVCPPS(unescapeRBSP(b[2:])); err != nil {
+			return nil, fmt.Errorf("parsing PPS failed: %w", err)
+		}
+	case HEVCNALUnitTypePrefixSEI, HEVCNALUnitTypeSuffixSEI:
+		if n.SEIMessages, err = parseHEVCSEIMessages(unescapeRBSP(b[2:])); err != nil {
+			return nil, fmt.Errorf("parsing SEI failed: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// parseHEVCProfileTierLevel parses a profile_tier_level structure with profilePresentFlag set to 1.
+func parseHEVCProfileTierLevel(r *nalReader, maxSubLayersMinus1 uint8) *HEVCProfileTierLevel {
+	p := &HEVCProfileTierLevel{}
+	p.ProfileSpace = uint8(r.TryReadBits(2))
+	p.TierFlag = r.TryReadBool()
+	p.ProfileIDC = uint8(r.TryReadBits(5))
+	p.ProfileCompatibilityFlags = uint32(r.TryReadBits(32))
+	p.ConstraintIndicatorFlags = r.TryReadBits(48)
+	p.ProgressiveSourceFlag = p.ConstraintIndicatorFlags>>47&0x1 > 0
+	p.InterlacedSourceFlag = p.ConstraintIndicatorFlags>>46&0x1 > 0
+	p.NonPackedConstraintFlag = p.ConstraintIndicatorFlags>>45&0x1 > 0
+	p.FrameOnlyConstraintFlag = p.ConstraintIndicatorFlags>>44&0x1 > 0
+	p.LevelIDC = r.TryReadByte()
+
+	// Sub layers
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := uint8(0); i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i] = r.TryReadBool()
+		subLayerLevelPresent[i] = r.TryReadBool()
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			_ = r.TryReadBits(2) // Reserved.
+		}
+	}
+	for i := uint8(0); i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] {
+			_ = r.TryReadBits(88)
+		}
+		if subLayerLevelPresent[i] {
+			_ = r.TryReadByte()
+		}
+	}
+	return p
+}
+
+// parseHEVCVPS parses an HEVC video parameter set RBSP.
+func parseHEVCVPS(rbsp []byte) (*HEVCVPS, error) {
+	r := newNALReader(rbsp)
+	v := &HEVCVPS{}
+
+	v.ID = uint8(r.TryReadBits(4))
+	v.BaseLayerInternal = r.TryReadBool()
+	v.BaseLayerAvailable = r.TryReadBool()
+	v.MaxLayersMinus1 = uint8(r.TryReadBits(6))
+	v.MaxSubLayersMinus1 = uint8(r.TryReadBits(3))
+	v.TemporalIDNesting = r.TryReadBool()
+	_ = r.TryReadBits(16) // Reserved.
+	v.ProfileTierLevel = parseHEVCProfileTierLevel(r, v.MaxSubLayersMinus1)
+
+	v.SubLayerOrderingAll = r.TryReadBool()
+	first := v.MaxSubLayersMinus1
+	if v.SubLayerOrderingAll {
+		first = 0
+	}
+	for i := first; i <= v.MaxSubLayersMinus1; i++ {
+		_ = r.TryReadUE() // vps_max_dec_pic_buffering_minus1
+		_ = r.TryReadUE() // vps_max_num_reorder_pics
+		_ = r.TryReadUE() // vps_max_latency_increase_plus1
+	}
+
+	maxLayerID := uint8(r.TryReadBits(6))
+	numLayerSetsMinus1 := r.TryReadUE()
+	for i := uint32(1); i <= numLayerSetsMinus1 && r.TryError == nil; i++ {
+		_ = r.TryReadBits(maxLayerID + 1) // layer_id_included_flag
+	}
+
+	v.TimingInfoPresent = r.TryReadBool()
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick = uint32(r.TryReadBits(32))
+		v.TimeScale = uint32(r.TryReadBits(32))
+	}
+	return v, r.TryError
+}
+
+// parseHEVCSPS parses an HEVC sequence parameter set RBSP.
+func parseHEVCSPS(rbsp []byte) (*HEVCSPS, error) { //nolint:funlen
+	r := newNALReader(rbsp)
+	s := &HEVCSPS{}
+
+	s.VPSID = uint8(r.TryReadBits(4))
+	s.MaxSubLayersMinus1 = uint8(r.TryReadBits(3))
+	s.TemporalIDNesting = r.TryReadBool()
+	s.ProfileTierLevel = parseHEVCProfileTierLevel(r, s.MaxSubLayersMinus1)
+
+	s.ID = r.TryReadUE()
+	s.ChromaFormatIDC = r.TryReadUE()
+	if s.ChromaFormatIDC == ChromaFormat444 {
+		s.SeparateColourPlaneFlag = r.TryReadBool()
+	}
+	s.PicWidthInLumaSamples = r.TryReadUE()
+	s.PicHeightInLumaSamples = r.TryReadUE()
+
+	s.ConformanceWindowFlag = r.TryReadBool()
+	if s.ConformanceWindowFlag {
+		s.ConformanceWindowLeftOffset = r.TryReadUE()
+		s.ConformanceWindowRightOffset = r.TryReadUE()
+		s.ConformanceWindowTopOffset = r.TryReadUE()
+		s.ConformanceWindowBottomOffset = r.TryReadUE()
+	}
+
+	s.BitDepthLumaMinus8 = r.TryReadUE()
+	s.BitDepthChromaMinus8 = r.TryReadUE()
+	s.Log2MaxPicOrderCntLsbMinus4 = r.TryReadUE()
+
+	subLayerOrderingInfoPresent := r.TryReadBool()
+	first := s.MaxSubLayersMinus1
+	if subLayerOrderingInfoPresent {
+		first = 0
+	}
+	for i := first; i <= s.MaxSubLayersMinus1; i++ {
+		_ = r.TryReadUE() // sps_max_dec_pic_buffering_minus1
+		_ = r.TryReadUE() // sps_max_num_reorder_pics
+		_ = r.TryReadUE() // sps_max_latency_increase_plus1
+	}
+
+	s.Log2MinLumaCodingBlockSizeMinus3 = r.TryReadUE()
+	s.Log2DiffMaxMinLumaCodingBlockSize = r.TryReadUE()
+	_ = r.TryReadUE() // log2_min_luma_transform_block_size_minus2
+	_ = r.TryReadUE() // log2_diff_max_min_luma_transform_block_size
+	_ = r.TryReadUE() // max_transform_hierarchy_depth_inter
+	_ = r.TryReadUE() // max_transform_hierarchy_depth_intra
+
+	s.ScalingListEnabled = r.TryReadBool()
+	if s.ScalingListEnabled {
+		if r.TryReadBool() { // sps_scaling_list_data_present_flag
+			skipHEVCScalingListData(r)
+		}
+	}
+
+	s.AMPEnabled = r.TryReadBool()
+	s.SampleAdaptiveOffsetEnabled = r.TryReadBool()
+	s.PCMEnabled = r.TryReadBool()
+	if s.PCMEnabled {
+		_ = r.TryReadBits(4) // pcm_sample_bit_depth_luma_minus1
+		_ = r.TryReadBits(4) // pcm_sample_bit_depth_chroma_minus1
+		_ = r.TryReadUE()    // log2_min_pcm_luma_coding_block_size_minus3
+		_ = r.TryReadUE()    // log2_diff_max_min_pcm_luma_coding_block_size
+		_ = r.TryReadBool()  // pcm_loop_filter_disabled_flag
+	}
+
+	s.NumShortTermRefPicSets = r.TryReadUE()
+	if s.NumShortTermRefPicSets > 64 {
+		return nil, fmt.Errorf("invalid num_short_term_ref_pic_sets %d", s.NumShortTermRefPicSets)
+	}
+	numDeltaPocs := make([]uint32, s.NumShortTermRefPicSets)
+	for i := uint32(0); i < s.NumShortTermRefPicSets && r.TryError == nil; i++ {
+		numDeltaPocs[i] = parseHEVCShortTermRefPicSet(r, i, numDeltaPocs)
+	}
+
+	s.LongTermRefPicsPresent = r.TryReadBool()
+	if s.LongTermRefPicsPresent {
+		numLongTermRefPicsSPS := r.TryReadUE()
+		for i := uint32(0); i < numLongTermRefPicsSPS && r.TryError == nil; i++ {
+			_ = r.TryReadBits(uint8(s.Log2MaxPicOrderCntLsbMinus4 + 4)) // lt_ref_pic_poc_lsb_sps
+			_ = r.TryReadBool()                                         // used_by_curr_pic_lt_sps_flag
+		}
+	}
+
+	s.TemporalMVPEnabled = r.TryReadBool()
+	s.StrongIntraSmoothingEnabled = r.TryReadBool()
+
+	if r.TryReadBool() { // vui_parameters_present_flag
+		s.VUI = parseHEVCVUI(r)
+	}
+	return s, r.TryError
+}
+
+// skipHEVCScalingListData skips a scaling_list_data structure.
+func skipHEVCScalingListData(r *nalReader) {
+	for sizeID := 0; sizeID < 4; sizeID++ {
+		step := 1
+		if sizeID == 3 {
+			step = 3
+		}
+		for matrixID := 0; matrixID < 6; matrixID += step {
+			if !r.TryReadBool() { // scaling_list_pred_mode_flag
+				_ = r.TryReadUE() // scaling_list_pred_matrix_id_delta
+				continue
+			}
+			coefNum := 64
+			if c := 1 << (4 + sizeID<<1); c < coefNum {
+				coefNum = c
+			}
+			if sizeID > 1 {
+				_ = r.TryReadSE() // scaling_list_dc_coef_minus8
+			}
+			for i := 0; i < coefNum; i++ {
+				_ = r.TryReadSE() // scaling_list_delta_coef
+			}
+		}
+	}
+}
+
+// parseHEVCShortTermRefPicSet parses a st_ref_pic_set structure found
+// in an SPS and returns its number of delta POCs.
+func parseHEVCShortTermRefPicSet(r *nalReader, idx uint32, numDeltaPocs []uint32) (n uint32) {
+	if idx != 0 && r.TryReadBool() { // inter_ref_pic_set_prediction_flag
+		_ = r.TryReadBool() // delta_rps_sign
+		_ = r.TryReadUE()   // abs_delta_rps_minus1
+		for j := uint32(0); j <= numDeltaPocs[idx-1]; j++ {
+			used := r.TryReadBool() // used_by_curr_pic_flag
+			useDelta := true
+			if !used {
+				useDelta = r.TryReadBool() // use_delta_flag
+			}
+			if used || useDelta {
+				n++
+			}
+		}
+		return
+	}
+
+	numNegativePics := r.TryReadUE()
+	numPositivePics := r.TryReadUE()
+	if numNegativePics > 16 || numPositivePics > 16 {
+		r.TryError = fmt.Errorf("invalid short term ref pic set %d/%d", numNegativePics, numPositivePics)
+		return
+	}
+	for i := uint32(0); i < numNegativePics+numPositivePics; i++ {
+		_ = r.TryReadUE()   // delta_poc_sX_minus1
+		_ = r.TryReadBool() // used_by_curr_pic_sX_flag
+	}
+	return numNegativePics + numPositivePics
+}
+
+// parseHEVCVUI parses a vui_parameters structure up to the timing information.
+func parseHEVCVUI(r *nalReader) *HEVCVUI {
+	v := &HEVCVUI{}
+
+	if r.TryReadBool() { // aspect_ratio_info_present_flag
+		v.AspectRatioIDC = r.TryReadByte()
+		if v.AspectRatioIDC == 255 { // EXTENDED_SAR
+			v.SARWidth = uint16(r.TryReadBits(16))
+			v.SARHeight = uint16(r.TryReadBits(16))
+		}
+	}
+
+	if r.TryReadBool() { // overscan_info_present_flag
+		_ = r.TryReadBool() // overscan_appropriate_flag
+	}
+
+	if r.TryReadBool() { // video_signal_type_present_flag
+		v.VideoFormat = uint8(r.TryReadBits(3))
+		v.VideoFullRangeFlag = r.TryReadBool()
+		if r.TryReadBool() { // colour_description_present_flag
+			v.ColourDescription = &ColourDescription{
+				ColourPrimaries:         r.TryReadByte(),
+				TransferCharacteristics: r.TryReadByte(),
+				MatrixCoefficients:      r.TryReadByte(),
+			}
+		}
+	}
+
+	v.ChromaLocInfoPresent = r.TryReadBool()
+	if v.ChromaLocInfoPresent {
+		v.ChromaSampleLocTypeTop = r.TryReadUE()
+		v.ChromaSampleLocTypeBottom = r.TryReadUE()
+	}
+
+	_ = r.TryReadBool() // neutral_chroma_indication_flag
+	v.FieldSeqFlag = r.TryReadBool()
+	v.FrameFieldInfoPresent = r.TryReadBool()
+
+	if r.TryReadBool() { // default_display_window_flag
+		_ = r.TryReadUE() // def_disp_win_left_offset
+		_ = r.TryReadUE() // def_disp_win_right_offset
+		_ = r.TryReadUE() // def_disp_win_top_offset
+		_ = r.TryReadUE() // def_disp_win_bottom_offset
+	}
+
+	v.TimingInfoPresent = r.TryReadBool()
+	if v.TimingInfoPresent {
+		v.NumUnitsInTick = uint32(r.TryReadBits(32))
+		v.TimeScale = uint32(r.TryReadBits(32))
+	}
+	return v
+}
+
+// parseHEVCPPS parses an HEVC picture parameter set RBSP.
+func parseHEVCPPS(rbsp []byte) (*HEVCPPS, error) {
+	r := newNALReader(rbsp)
+	p := &HEVCPPS{}
+
+	p.ID = r.TryReadUE()
+	p.SPSID = r.TryReadUE()
+	p.DependentSliceSegmentsEnabled = r.TryReadBool()
+	p.OutputFlagPresent = r.TryReadBool()
+	p.NumExtraSliceHeaderBits = uint8(r.TryReadBits(3))
+	p.SignDataHidingEnabled = r.TryReadBool()
+	p.CabacInitPresent = r.TryReadBool()
+	p.NumRefIdxL0DefaultActiveMinus1 = r.TryReadUE()
+	p.NumRefIdxL1DefaultActiveMinus1 = r.TryReadUE()
+	p.InitQPMinus26 = r.TryReadSE()
+	p.ConstrainedIntraPred = r.TryReadBool()
+	p.TransformSkipEnabled = r.TryReadBool()
+	p.CUQPDeltaEnabled = r.TryReadBool()
+	if p.CUQPDeltaEnabled {
+		p.DiffCUQPDeltaDepth = r.TryReadUE()
+	}
+	p.PPSCbQPOffset = r.TryReadSE()
+	p.PPSCrQPOffset = r.TryReadSE()
+	p.PPSSliceChromaQPOffsetsPresent = r.TryReadBool()
+	p.WeightedPred = r.TryReadBool()
+	p.WeightedBipred = r.TryReadBool()
+	p.TransquantBypassEnabled = r.TryReadBool()
+	p.TilesEnabled = r.TryReadBool()
+	p.EntropyCodingSyncEnabled = r.TryReadBool()
+	if p.TilesEnabled {
+		numTileColumnsMinus1 := r.TryReadUE()
+		numTileRowsMinus1 := r.TryReadUE()
+		if numTileColumnsMinus1 > 64 || numTileRowsMinus1 > 64 {
+			return nil, fmt.Errorf("invalid tiles %dx%d", numTileColumnsMinus1+1, numTileRowsMinus1+1)
+		}
+		if !r.TryReadBool() { // uniform_spacing_flag
+			for i := uint32(0); i < numTileColumnsMinus1; i++ {
+				_ = r.TryReadUE() // column_width_minus1
+			}
+			for i := uint32(0); i < numTileRowsMinus1; i++ {
+				_ = r.TryReadUE() // row_height_minus1
+			}
+		}
+		_ = r.TryReadBool() // loop_filter_across_tiles_enabled_flag
+	}
+	p.LoopFilterAcrossSlicesEnabled = r.TryReadBool()
+	p.DeblockingFilterControlPresent = r.TryReadBool()
+	if p.DeblockingFilterControlPresent {
+		p.DeblockingFilterOverrideEnabled = r.TryReadBool()
+		if !r.TryReadBool() { // pps_deblocking_filter_disabled_flag
+			_ = r.TryReadSE() // pps_beta_offset_div2
+			_ = r.TryReadSE() // pps_tc_offset_div2
+		}
+	}
+	if r.TryReadBool() { // pps_scaling_list_data_present_flag
+		skipHEVCScalingListData(r)
+	}
+	p.ListsModificationPresent = r.TryReadBool()
+	p.Log2ParallelMergeLevelMinus2 = r.TryReadUE()
+	p.SliceSegmentHeaderExtPresent = r.TryReadBool()
+	return p, r.TryError
+}
+
+// parseHEVCSEIMessages parses the SEI messages of an SEI RBSP.
+func parseHEVCSEIMessages(rbsp []byte) (ms []*HEVCSEIMessage, err error) {
+	for i := 0; i < len(rbsp); {
+		// Stop at the rbsp_trailing_bits
+		if rbsp[i] == 0x80 && i == len(rbsp)-1 {
+			break
+		}
+
+		m := &HEVCSEIMessage{}
+		if m.PayloadType, i, err = readSEIValue(rbsp, i); err != nil {
+			return nil, fmt.Errorf("reading payload type failed: %w", err)
+		}
+
+		var size uint32
+		if size, i, err = readSEIValue(rbsp, i); err != nil {
+			return nil, fmt.Errorf("reading payload size failed: %w", err)
+		}
+		if i+int(size) > len(rbsp) {
+			return nil, fmt.Errorf("SEI payload size %d is too big for %d remaining bytes", size, len(rbsp)-i)
+		}
+		m.Payload = rbsp[i : i+int(size)]
+		i += int(size)
+
+		parseHEVCSEIPayload(m)
+		ms = append(ms, m)
+	}
+	return
+}
+
+// readSEIValue reads an SEI payload type or size coded as a sequence of 0xff bytes plus a last byte.
+func readSEIValue(b []byte, i int) (v uint32, n int, err error) {
+	for ; i < len(b); i++ {
+		v += uint32(b[i])
+		if b[i] != 0xff {
+			return v, i + 1, nil
+		}
+	}
+	return 0, i, errors.New("SEI value is truncated")
+}
+
+// parseHEVCSEIPayload parses the payload of the SEI messages we know about.
+func parseHEVCSEIPayload(m *HEVCSEIMessage) {
+	r := newNALReader(m.Payload)
+	switch m.PayloadType {
+	case HEVCSEIPayloadTypeMasteringDisplayColourVolume:
+		v := &HEVCSEIMasteringDisplayColourVolume{}
+		for c := 0; c < 3; c++ {
+			v.DisplayPrimariesX[c] = uint16(r.TryReadBits(16))
+			v.DisplayPrimariesY[c] = uint16(r.TryReadBits(16))
+		}
+		v.WhitePointX = uint16(r.TryReadBits(16))
+		v.WhitePointY = uint16(r.TryReadBits(16))
+		v.MaxDisplayMasteringLuminance = uint32(r.TryReadBits(32))
+		v.MinDisplayMasteringLuminance = uint32(r.TryReadBits(32))
+		if r.TryError == nil {
+			m.MasteringDisplayColourVolume = v
+		}
+	case HEVCSEIPayloadTypeContentLightLevelInfo:
+		v := &HEVCSEIContentLightLevelInfo{
+			MaxContentLightLevel:    uint16(r.TryReadBits(16)),
+			MaxPicAverageLightLevel: uint16(r.TryReadBits(16)),
+		}
+		if r.TryError == nil {
+			m.ContentLightLevelInfo = v
+		}
+	case HEVCSEIPayloadTypeAlternativeTransfer:
+		v := &HEVCSEIAlternativeTransfer{PreferredTransferCharacteristics: r.TryReadByte()}
+		if r.TryError == nil {
+			m.AlternativeTransfer = v
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+// escapeRBSP inserts emulation prevention bytes in an RBSP.
+func escapeRBSP(b []byte) []byte {
+	var o []byte
+	var zeros int
+	for _, c := range b {
+		if zeros >= 2 && c <= 3 {
+			o = append(o, 3)
+			zeros = 0
+		}
+		o = append(o, c)
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return o
+}
+
+// annexB builds an Annex B byte stream out of NAL units.
+func annexB(nalus ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	for _, n := range nalus {
+		buf.Write([]byte{0x0, 0x0, 0x0, 0x1})
+		buf.Write(n)
+	}
+	return buf.Bytes()
+}
+
+func hevcNALUnitHeader(t HEVCNALUnitType) []byte {
+	return []byte{byte(t) << 1, 0x1}
+}
+
+func hevcProfileTierLevelBytes(w *bitio.Writer) {
+	WriteBinary(w, "00")               // Profile space.
+	WriteBinary(w, "0")                // Tier flag.
+	w.TryWriteBits(2, 5)               // Profile idc.
+	w.TryWriteBits(0x20000000, 32)     // Profile compatibility flags.
+	w.TryWriteBits(0xb00000000000, 48) // Constraint indicator flags.
+	w.TryWriteByte(120)                // Level idc.
+}
+
+var hevcProfileTierLevel = &HEVCProfileTierLevel{
+	ConstraintIndicatorFlags:  0xb00000000000,
+	FrameOnlyConstraintFlag:   true,
+	LevelIDC:                  120,
+	NonPackedConstraintFlag:   true,
+	ProfileCompatibilityFlags: 0x20000000,
+	ProfileIDC:                2,
+	ProgressiveSourceFlag:     true,
+}
+
+func hevcVPSBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(0, 4)       // VPS id.
+	WriteBinary(w, "11")       // Base layer internal and available flags.
+	w.TryWriteBits(0, 6)       // Max layers minus 1.
+	w.TryWriteBits(0, 3)       // Max sub layers minus 1.
+	WriteBinary(w, "1")        // Temporal id nesting.
+	w.TryWriteBits(0xffff, 16) // Reserved.
+	hevcProfileTierLevelBytes(w)
+	WriteBinary(w, "1")  // Sub layer ordering info present.
+	writeUE(w, 4)        // Max dec pic buffering minus 1.
+	writeUE(w, 2)        // Max num reorder pics.
+	writeUE(w, 0)        // Max latency increase plus 1.
+	w.TryWriteBits(0, 6) // Max layer id.
+	writeUE(w, 0)        // Num layer sets minus 1.
+	WriteBinary(w, "0")  // Timing info present.
+	WriteBinary(w, "0")  // Extension flag.
+	writeRBSPTrailingBits(w)
+	return append(hevcNALUnitHeader(HEVCNALUnitTypeVPS), escapeRBSP(buf.Bytes())...)
+}
+
+func hevcSPSBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(0, 4) // VPS id.
+	w.TryWriteBits(0, 3) // Max sub layers minus 1.
+	WriteBinary(w, "1")  // Temporal id nesting.
+	hevcProfileTierLevelBytes(w)
+	writeUE(w, 0)        // SPS id.
+	writeUE(w, 1)        // Chroma format idc.
+	writeUE(w, 3840)     // Width.
+	writeUE(w, 2176)     // Height.
+	WriteBinary(w, "1")  // Conformance window flag.
+	writeUE(w, 0)        // Left offset.
+	writeUE(w, 0)        // Right offset.
+	writeUE(w, 0)        // Top offset.
+	writeUE(w, 8)        // Bottom offset.
+	writeUE(w, 2)        // Bit depth luma minus 8.
+	writeUE(w, 2)        // Bit depth chroma minus 8.
+	writeUE(w, 4)        // Log2 max pic order cnt lsb minus 4.
+	WriteBinary(w, "1")  // Sub layer ordering info present.
+	writeUE(w, 4)        // Max dec pic buffering minus 1.
+	writeUE(w, 2)        // Max num reorder pics.
+	writeUE(w, 0)        // Max latency increase plus 1.
+	writeUE(w, 0)        // Log2 min luma coding block size minus 3.
+	writeUE(w, 3)        // Log2 diff max min luma coding block size.
+	writeUE(w, 0)        // Log2 min luma transform block size minus 2.
+	writeUE(w, 3)        // Log2 diff max min luma transform block size.
+	writeUE(w, 1)        // Max transform hierarchy depth inter.
+	writeUE(w, 1)        // Max transform hierarchy depth intra.
+	WriteBinary(w, "0")  // Scaling list enabled.
+	WriteBinary(w, "1")  // AMP enabled.
+	WriteBinary(w, "1")  // SAO enabled.
+	WriteBinary(w, "0")  // PCM enabled.
+	writeUE(w, 2)        // Num short term ref pic sets.
+	writeUE(w, 1)        // #1 Num negative pics.
+	writeUE(w, 1)        // #1 Num positive pics.
+	writeUE(w, 0)        // #1 Delta poc s0 minus 1.
+	WriteBinary(w, "1")  // #1 Used by curr pic s0.
+	writeUE(w, 1)        // #1 Delta poc s1 minus 1.
+	WriteBinary(w, "1")  // #1 Used by curr pic s1.
+	WriteBinary(w, "1")  // #2 Inter ref pic set prediction.
+	WriteBinary(w, "0")  // #2 Delta rps sign.
+	writeUE(w, 0)        // #2 Abs delta rps minus 1.
+	WriteBinary(w, "1")  // #2 Used by curr pic #1.
+	WriteBinary(w, "0")  // #2 Used by curr pic #2.
+	WriteBinary(w, "0")  // #2 Use delta #2.
+	WriteBinary(w, "1")  // #2 Used by curr pic #3.
+	WriteBinary(w, "0")  // Long term ref pics present.
+	WriteBinary(w, "1")  // Temporal MVP enabled.
+	WriteBinary(w, "1")  // Strong intra smoothing enabled.
+	WriteBinary(w, "1")  // VUI present.
+	WriteBinary(w, "1")  // Aspect ratio info present.
+	w.TryWriteByte(1)    // Aspect ratio idc.
+	WriteBinary(w, "0")  // Overscan info present.
+	WriteBinary(w, "1")  // Video signal type present.
+	w.TryWriteBits(5, 3) // Video format.
+	WriteBinary(w, "0")  // Full range.
+	WriteBinary(w, "1")  // Colour description present.
+	w.TryWriteByte(ColourPrimariesBT2020)
+	w.TryWriteByte(TransferCharacteristicsSMPTE2084)
+	w.TryWriteByte(9)   // Matrix coefficients.
+	WriteBinary(w, "0") // Chroma loc info present.
+	WriteBinary(w, "0") // Neutral chroma indication.
+	WriteBinary(w, "0") // Field seq.
+	WriteBinary(w, "0") // Frame field info present.
+	WriteBinary(w, "0") // Default display window.
+	WriteBinary(w, "1") // Timing info present.
+	w.TryWriteBits(1001, 32)
+	w.TryWriteBits(60000, 32)
+	WriteBinary(w, "0") // Poc proportional to timing.
+	WriteBinary(w, "0") // HRD parameters present.
+	WriteBinary(w, "0") // Bitstream restriction.
+	WriteBinary(w, "0") // Extension.
+	writeRBSPTrailingBits(w)
+	return append(hevcNALUnitHeader(HEVCNALUnitTypeSPS), escapeRBSP(buf.Bytes())...)
+}
+
+func hevcPPSBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	writeUE(w, 0)        // PPS id.
+	writeUE(w, 0)        // SPS id.
+	WriteBinary(w, "0")  // Dependent slice segments enabled.
+	WriteBinary(w, "0")  // Output flag present.
+	w.TryWriteBits(0, 3) // Num extra slice header bits.
+	WriteBinary(w, "1")  // Sign data hiding enabled.
+	WriteBinary(w, "0")  // Cabac init present.
+	writeUE(w, 0)        // Num ref idx l0 default active minus 1.
+	writeUE(w, 0)        // Num ref idx l1 default active minus 1.
+	writeSE(w, -4)       // Init QP minus 26.
+	WriteBinary(w, "0")  // Constrained intra pred.
+	WriteBinary(w, "0")  // Transform skip enabled.
+	WriteBinary(w, "1")  // CU QP delta enabled.
+	writeUE(w, 1)        // Diff CU QP delta depth.
+	writeSE(w, 0)        // Cb QP offset.
+	writeSE(w, 0)        // Cr QP offset.
+	WriteBinary(w, "0")  // Slice chroma QP offsets present.
+	WriteBinary(w, "0")  // Weighted pred.
+	WriteBinary(w, "0")  // Weighted bipred.
+	WriteBinary(w, "0")  // Transquant bypass enabled.
+	WriteBinary(w, "0")  // Tiles enabled.
+	WriteBinary(w, "1")  // Entropy coding sync enabled.
+	WriteBinary(w, "1")  // Loop filter across slices enabled.
+	WriteBinary(w, "0")  // Deblocking filter control present.
+	WriteBinary(w, "0")  // Scaling list data present.
+	WriteBinary(w, "0")  // Lists modification present.
+	writeUE(w, 0)        // Log2 parallel merge level minus 2.
+	WriteBinary(w, "0")  // Slice segment header extension present.
+	WriteBinary(w, "0")  // Extension present.
+	writeRBSPTrailingBits(w)
+	return append(hevcNALUnitHeader(HEVCNALUnitTypePPS), escapeRBSP(buf.Bytes())...)
+}
+
+func hevcSEIBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteByte(HEVCSEIPayloadTypeMasteringDisplayColourVolume)
+	w.TryWriteByte(24)
+	for _, v := range []uint16{13250, 34500, 7500, 3000, 34000, 16000, 15635, 16450} {
+		w.TryWriteBits(uint64(v), 16)
+	}
+	w.TryWriteBits(10000000, 32)
+	w.TryWriteBits(50, 32)
+	w.TryWriteByte(HEVCSEIPayloadTypeContentLightLevelInfo)
+	w.TryWriteByte(4)
+	w.TryWriteBits(1000, 16)
+	w.TryWriteBits(400, 16)
+	w.TryWriteByte(0xff) // Payload type 255 + 1.
+	w.TryWriteByte(0x1)
+	w.TryWriteByte(1)
+	w.TryWriteByte(0x2a)
+	writeRBSPTrailingBits(w)
+	return append(hevcNALUnitHeader(HEVCNALUnitTypePrefixSEI), escapeRBSP(buf.Bytes())...)
+}
+
+func hevcIDRBytes() []byte {
+	return append(hevcNALUnitHeader(HEVCNALUnitTypeIDRWRADL), 0xaf, 0x0, 0x0, 0x3, 0x1)
+}
+
+func hevcBytes() []byte {
+	return annexB(
+		append(hevcNALUnitHeader(HEVCNALUnitTypeAUD), 0x50),
+		hevcVPSBytes(),
+		hevcSPSBytes(),
+		hevcPPSBytes(),
+		hevcSEIBytes(),
+		hevcIDRBytes(),
+	)
+}
+
+func TestParseHEVCData(t *testing.T) {
+	d, err := ParseHEVCData(hevcBytes())
+	assert.NoError(t, err)
+	assert.Len(t, d.NALUnits, 6)
+	assert.Equal(t, HEVCNALUnitTypeAUD, d.NALUnits[0].Type)
+	assert.Equal(t, uint8(0), d.NALUnits[0].LayerID)
+	assert.Equal(t, uint8(1), d.NALUnits[0].TemporalIDPlus1)
+	assert.Equal(t, hevcIDRBytes(), d.NALUnits[5].Data)
+
+	// IRAP
+	assert.True(t, d.IsIRAP())
+	typ, ok := d.IRAPType()
+	assert.True(t, ok)
+	assert.True(t, typ.IsIDR())
+	assert.False(t, typ.IsCRA())
+	assert.False(t, typ.IsBLA())
+
+	// VPS
+	vps := d.VPS()
+	assert.NotNil(t, vps)
+	assert.Equal(t, hevcProfileTierLevel, vps.ProfileTierLevel)
+	assert.True(t, vps.TemporalIDNesting)
+
+	// SPS
+	sps := d.SPS()
+	assert.NotNil(t, sps)
+	assert.Equal(t, hevcProfileTierLevel, sps.ProfileTierLevel)
+	assert.Equal(t, uint32(ChromaFormat420), sps.ChromaFormatIDC)
+	assert.Equal(t, 3840, sps.Width())
+	assert.Equal(t, 2160, sps.Height())
+	assert.Equal(t, 10, sps.BitDepthLuma())
+	assert.Equal(t, 10, sps.BitDepthChroma())
+	assert.Equal(t, uint32(2), sps.NumShortTermRefPicSets)
+	assert.True(t, sps.StrongIntraSmoothingEnabled)
+	assert.Equal(t, &HEVCVUI{
+		AspectRatioIDC: 1,
+		ColourDescription: &ColourDescription{
+			ColourPrimaries:         ColourPrimariesBT2020,
+			MatrixCoefficients:      9,
+			TransferCharacteristics: TransferCharacteristicsSMPTE2084,
+		},
+		NumUnitsInTick:    1001,
+		TimeScale:         60000,
+		TimingInfoPresent: true,
+		VideoFormat:       5,
+	}, sps.VUI)
+	assert.True(t, sps.IsHDR())
+
+	// PPS
+	pps := d.PPS()
+	assert.NotNil(t, pps)
+	assert.Equal(t, int32(-4), pps.InitQPMinus26)
+	assert.Equal(t, uint32(1), pps.DiffCUQPDeltaDepth)
+	assert.True(t, pps.EntropyCodingSyncEnabled)
+	assert.True(t, pps.LoopFilterAcrossSlicesEnabled)
+
+	// SEI
+	ms := d.SEIMessages()
+	assert.Len(t, ms, 3)
+	assert.Equal(t, &HEVCSEIMasteringDisplayColourVolume{
+		DisplayPrimariesX:            [3]uint16{13250, 7500, 34000},
+		DisplayPrimariesY:            [3]uint16{34500, 3000, 16000},
+		MaxDisplayMasteringLuminance: 10000000,
+		MinDisplayMasteringLuminance: 50,
+		WhitePointX:                  15635,
+		WhitePointY:                  16450,
+	}, ms[0].MasteringDisplayColourVolume)
+	assert.Equal(t, &HEVCSEIContentLightLevelInfo{MaxContentLightLevel: 1000, MaxPicAverageLightLevel: 400}, ms[1].ContentLightLevelInfo)
+	assert.Equal(t, uint32(256), ms[2].PayloadType)
+	assert.Equal(t, []byte{0x2a}, ms[2].Payload)
+
+	// Errors
+	_, err = ParseHEVCData(annexB([]byte{0x40}))
+	assert.ErrorIs(t, err, ErrHEVCNALUnitTooShort)
+}
+
+func TestHEVCNALUnitType(t *testing.T) {
+	for typ := HEVCNALUnitType(0); typ < 64; typ++ {
+		assert.Equal(t, typ >= 16 && typ <= 23, typ.IsIRAP(), typ)
+		assert.Equal(t, typ < 32, typ.IsVCL(), typ)
+	}
+	assert.True(t, HEVCNALUnitTypeCRA.IsCRA())
+	assert.True(t, HEVCNALUnitTypeBLANLP.IsBLA())
+	assert.True(t, HEVCNALUnitTypeIDRNLP.IsIDR())
+}
+
+func TestDemuxerParseElementaryStreamsHEVC(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	mx := NewMuxer(context.Background(), w)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH265Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	_, err = mx.WriteData(&MuxerData{
+		PID: 0x100,
+		PES: &PESData{
+			Data:   hevcBytes(),
+			Header: &PESHeader{OptionalHeader: &PESOptionalHeader{}},
+		},
+	})
+	assert.NoError(t, err)
+
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptParseElementaryStreams())
+	var hevc *HEVCData
+	for {
+		d, err := dmx.NextData()
+		if err != nil {
+			break
+		}
+		if d.HEVC != nil {
+			hevc = d.HEVC
+		}
+	}
+	assert.NotNil(t, hevc)
+	assert.True(t, hevc.IsIRAP())
+	assert.True(t, hevc.SPS().IsHDR())
+}
@@ -0,0 +1,372 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// MPEG-2 video start codes.
+// Page: 24 | Chapter: 6.2.1 | Link: https://www.itu.int/rec/T-REC-H.262
+const (
+	mpeg2VideoStartCodePicture        = 0x00
+	mpeg2VideoStartCodeSliceMin       = 0x01
+	mpeg2VideoStartCodeSliceMax       = 0xaf
+	mpeg2VideoStartCodeUserData       = 0xb2
+	mpeg2VideoStartCodeSequenceHeader = 0xb3
+	mpeg2VideoStartCodeExtension      = 0xb5
+	mpeg2VideoStartCodeGroup          = 0xb8
+)
+
+// MPEG-2 video extension start code identifiers.
+const (
+	mpeg2VideoExtensionIDSequence        = 0x1
+	mpeg2VideoExtensionIDSequenceDisplay = 0x2
+	mpeg2VideoExtensionIDPictureCoding   = 0x8
+)
+
+// afdIdentifier is the "DTG1" user data identifier of the Active Format Description.
+// Page: 138 | Chapter: B.3 | Link: https://www.etsi.org/deliver/etsi_ts/101100_101199/101154/02.06.01_60/ts_101154v020601p.pdf
+const afdIdentifier = 0x44544731
+
+// MPEG-2 video aspect ratios.
+// Page: 41 | Chapter: 6.3.3 | Link: https://www.itu.int/rec/T-REC-H.262
+const (
+	MPEG2VideoAspectRatioSquareSample = 1
+	MPEG2VideoAspectRatio4_3          = 2
+	MPEG2VideoAspectRatio16_9         = 3
+	MPEG2VideoAspectRatio221_100      = 4
+)
+
+// MPEG-2 video picture coding types.
+// Page: 55 | Chapter: 6.3.9 | Link: https://www.itu.int/rec/T-REC-H.262
+const (
+	MPEG2VideoPictureCodingTypeI = 1
+	MPEG2VideoPictureCodingTypeP = 2
+	MPEG2VideoPictureCodingTypeB = 3
+)
+
+// MPEG-2 video picture structures.
+const (
+	MPEG2VideoPictureStructureTopField    = 1
+	MPEG2VideoPictureStructureBottomField = 2
+	MPEG2VideoPictureStructureFrame       = 3
+)
+
+// Errors.
+var (
+	ErrMPEG2VideoStartCodeNotFound = errors.New("MPEG-2 video start code not found")
+)
+
+// mpeg2VideoFrameRates are indexed by frame_rate_code, 0 being forbidden.
+var mpeg2VideoFrameRates = [][2]int{{0, 1}, {24000, 1001}, {24, 1}, {25, 1}, {30000, 1001}, {30, 1}, {50, 1}, {60000, 1001}, {60, 1}}
+
+// MPEG2VideoData represents the MPEG-1/2 video headers carried by a PES payload.
+type MPEG2VideoData struct {
+	AFD                      *MPEG2VideoAFD
+	GOPHeader                *MPEG2VideoGOPHeader
+	Pictures                 []*MPEG2VideoPicture
+	SequenceDisplayExtension *MPEG2VideoSequenceDisplayExtension
+	SequenceExtension        *MPEG2VideoSequenceExtension
+	SequenceHeader           *MPEG2VideoSequenceHeader
+}
+
+// MPEG2VideoSequenceHeader represents an MPEG-2 video sequence header.
+// Page: 26 | Chapter: 6.2.2.1 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoSequenceHeader struct {
+	AspectRatioInformation      uint8
+	BitRateValue                uint32 // In units of 400 bits/s
+	ConstrainedParametersFlag   bool
+	FrameRateCode               uint8
+	HorizontalSize              uint16
+	LoadIntraQuantiserMatrix    bool
+	LoadNonIntraQuantiserMatrix bool
+	VBVBufferSizeValue          uint16
+	VerticalSize                uint16
+}
+
+// MPEG2VideoSequenceExtension represents an MPEG-2 video sequence extension.
+// Page: 27 | Chapter: 6.2.2.3 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoSequenceExtension struct {
+	BitRateExtension          uint16
+	ChromaFormat              uint8
+	FrameRateExtensionD       uint8
+	FrameRateExtensionN       uint8
+	HorizontalSizeExtension   uint8
+	LowDelay                  bool
+	ProfileAndLevelIndication uint8
+	ProgressiveSequence       bool
+	VBVBufferSizeExtension    uint8
+	VerticalSizeExtension     uint8
+}
+
+// MPEG2VideoSequenceDisplayExtension represents an MPEG-2 video sequence display extension.
+// Page: 28 | Chapter: 6.2.2.4 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoSequenceDisplayExtension struct {
+	ColourDescription     *ColourDescription
+	DisplayHorizontalSize uint16
+	DisplayVerticalSize   uint16
+	VideoFormat           uint8
+}
+
+// MPEG2VideoGOPHeader represents an MPEG-2 video group of pictures header.
+// Page: 30 | Chapter: 6.2.2.6 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoGOPHeader struct {
+	BrokenLink bool
+	ClosedGOP  bool
+	TimeCode   *MPEG2VideoTimeCode
+}
+
+// MPEG2VideoTimeCode represents an MPEG-2 video GOP time code.
+type MPEG2VideoTimeCode struct {
+	DropFrame bool
+	Hours     uint8
+	Minutes   uint8
+	Pictures  uint8
+	Seconds   uint8
+}
+
+// MPEG2VideoPicture represents an MPEG-2 video picture header and its coding extension.
+// Page: 31 | Chapter: 6.2.3 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoPicture struct {
+	CodingExtension   *MPEG2VideoPictureCodingExtension
+	CodingType        uint8
+	TemporalReference uint16
+	VBVDelay          uint16
+}
+
+// MPEG2VideoPictureCodingExtension represents an MPEG-2 video picture coding extension.
+// Page: 32 | Chapter: 6.2.3.1 | Link: https://www.itu.int/rec/T-REC-H.262
+type MPEG2VideoPictureCodingExtension struct {
+	IntraDCPrecision uint8
+	PictureStructure uint8
+	ProgressiveFrame bool
+	RepeatFirstField bool
+	TopFieldFirst    bool
+}
+
+// MPEG2VideoAFD represents an Active Format Description carried in picture user data.
+// Page: 138 | Chapter: B.3 | Link: https://www.etsi.org/deliver/etsi_ts/101100_101199/101154/02.06.01_60/ts_101154v020601p.pdf
+type MPEG2VideoAFD struct {
+	ActiveFormat uint8 // 4 bits
+}
+
+// ParseMPEG2VideoData parses the headers of an MPEG-1/2 video byte stream such as a PES payload.
+// Slices are skipped.
+func ParseMPEG2VideoData(b []byte) (*MPEG2VideoData, error) {
+	units := splitStartCodeUnits(b)
+	if len(units) == 0 {
+		return nil, ErrMPEG2VideoStartCodeNotFound
+	}
+
+	d := &MPEG2VideoData{}
+	for _, u := range units {
+		var err error
+		switch c := u[0]; {
+		case c == mpeg2VideoStartCodePicture:
+			var p *MPEG2VideoPicture
+			if p, err = parseMPEG2VideoPicture(u[1:]); err == nil {
+				d.Pictures = append(d.Pictures, p)
+			}
+		case c >= mpeg2VideoStartCodeSliceMin && c <= mpeg2VideoStartCodeSliceMax:
+			continue
+		case c == mpeg2VideoStartCodeUserData:
+			if afd := parseMPEG2VideoAFD(u[1:]); afd != nil {
+				d.AFD = afd
+			}
+		case c == mpeg2VideoStartCodeSequenceHeader:
+			d.SequenceHeader, err = parseMPEG2VideoSequenceHeader(u[1:])
+		case c == mpeg2VideoStartCodeExtension:
+			err = d.parseExtension(u[1:])
+		case c == mpeg2VideoStartCodeGroup:
+			d.GOPHeader, err = parseMPEG2VideoGOPHeader(u[1:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing MPEG-2 video start code 0x%x failed: %w", u[0], err)
+		}
+	}
+	return d, nil
+}
+
+func parseMPEG2VideoSequenceHeader(b []byte) (*MPEG2VideoSequenceHeader, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	h := &MPEG2VideoSequenceHeader{}
+	h.HorizontalSize = uint16(r.TryReadBits(12))
+	h.VerticalSize = uint16(r.TryReadBits(12))
+	h.AspectRatioInformation = uint8(r.TryReadBits(4))
+	h.FrameRateCode = uint8(r.TryReadBits(4))
+	h.BitRateValue = uint32(r.TryReadBits(18))
+	_ = r.TryReadBool() // Marker bit.
+	h.VBVBufferSizeValue = uint16(r.TryReadBits(10))
+	h.ConstrainedParametersFlag = r.TryReadBool()
+	if h.LoadIntraQuantiserMatrix = r.TryReadBool(); h.LoadIntraQuantiserMatrix {
+		skipMPEG2VideoQuantiserMatrix(r)
+	}
+	h.LoadNonIntraQuantiserMatrix = r.TryReadBool()
+	return h, r.TryError
+}
+
+func skipMPEG2VideoQuantiserMatrix(r *bitio.CountReader) {
+	for i := 0; i < 64; i++ {
+		_ = r.TryReadByte()
+	}
+}
+
+func (d *MPEG2VideoData) parseExtension(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("extension is empty")
+	}
+
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	switch r.TryReadBits(4) {
+	case mpeg2VideoExtensionIDSequence:
+		e := &MPEG2VideoSequenceExtension{}
+		e.ProfileAndLevelIndication = r.TryReadByte()
+		e.ProgressiveSequence = r.TryReadBool()
+		e.ChromaFormat = uint8(r.TryReadBits(2))
+		e.HorizontalSizeExtension = uint8(r.TryReadBits(2))
+		e.VerticalSizeExtension = uint8(r.TryReadBits(2))
+		e.BitRateExtension = uint16(r.TryReadBits(12))
+		_ = r.TryReadBool() // Marker bit.
+		e.VBVBufferSizeExtension = r.TryReadByte()
+		e.LowDelay = r.TryReadBool()
+		e.FrameRateExtensionN = uint8(r.TryReadBits(2))
+		e.FrameRateExtensionD = uint8(r.TryReadBits(5))
+		d.SequenceExtension = e
+	case mpeg2VideoExtensionIDSequenceDisplay:
+		e := &MPEG2VideoSequenceDisplayExtension{}
+		e.VideoFormat = uint8(r.TryReadBits(3))
+		if r.TryReadBool() {
+			e.ColourDescription = &ColourDescription{}
+			e.ColourDescription.ColourPrimaries = r.TryReadByte()
+			e.ColourDescription.TransferCharacteristics = r.TryReadByte()
+			e.ColourDescription.MatrixCoefficients = r.TryReadByte()
+		}
+		e.DisplayHorizontalSize = uint16(r.TryReadBits(14))
+		_ = r.TryReadBool() // Marker bit.
+		e.DisplayVerticalSize = uint16(r.TryReadBits(14))
+		d.SequenceDisplayExtension = e
+	case mpeg2VideoExtensionIDPictureCoding:
+		// Picture coding extensions follow the picture header they belong to
+		if len(d.Pictures) == 0 {
+			return nil
+		}
+		e := &MPEG2VideoPictureCodingExtension{}
+		_ = r.TryReadBits(16) // f_code.
+		e.IntraDCPrecision = uint8(r.TryReadBits(2))
+		e.PictureStructure = uint8(r.TryReadBits(2))
+		e.TopFieldFirst = r.TryReadBool()
+		_ = r.TryReadBits(5) // frame_pred_frame_dct to alternate_scan.
+		e.RepeatFirstField = r.TryReadBool()
+		_ = r.TryReadBool() // chroma_420_type.
+		e.ProgressiveFrame = r.TryReadBool()
+		d.Pictures[len(d.Pictures)-1].CodingExtension = e
+	}
+	return r.TryError
+}
+
+func parseMPEG2VideoGOPHeader(b []byte) (*MPEG2VideoGOPHeader, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	h := &MPEG2VideoGOPHeader{TimeCode: &MPEG2VideoTimeCode{}}
+	h.TimeCode.DropFrame = r.TryReadBool()
+	h.TimeCode.Hours = uint8(r.TryReadBits(5))
+	h.TimeCode.Minutes = uint8(r.TryReadBits(6))
+	_ = r.TryReadBool() // Marker bit.
+	h.TimeCode.Seconds = uint8(r.TryReadBits(6))
+	h.TimeCode.Pictures = uint8(r.TryReadBits(6))
+	h.ClosedGOP = r.TryReadBool()
+	h.BrokenLink = r.TryReadBool()
+	return h, r.TryError
+}
+
+func parseMPEG2VideoPicture(b []byte) (*MPEG2VideoPicture, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	p := &MPEG2VideoPicture{}
+	p.TemporalReference = uint16(r.TryReadBits(10))
+	p.CodingType = uint8(r.TryReadBits(3))
+	p.VBVDelay = uint16(r.TryReadBits(16))
+	return p, r.TryError
+}
+
+// parseMPEG2VideoAFD returns the Active Format Description carried by user data, if any.
+func parseMPEG2VideoAFD(b []byte) *MPEG2VideoAFD {
+	if len(b) < 5 || uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]) != afdIdentifier {
+		return nil
+	}
+
+	// active_format_flag
+	if b[4]&0x40 == 0 || len(b) < 6 {
+		return nil
+	}
+	return &MPEG2VideoAFD{ActiveFormat: b[5] & 0xf}
+}
+
+// PictureCodingType returns the coding type of the first picture, or 0 if there is none.
+func (d *MPEG2VideoData) PictureCodingType() uint8 {
+	if len(d.Pictures) == 0 {
+		return 0
+	}
+	return d.Pictures[0].CodingType
+}
+
+// IsKeyFrame checks whether the first picture is an I picture.
+func (d *MPEG2VideoData) IsKeyFrame() bool {
+	return d.PictureCodingType() == MPEG2VideoPictureCodingTypeI
+}
+
+// IsClosedGOP checks whether the data starts a closed GOP, which means its B pictures
+// don't reference pictures of the previous GOP.
+func (d *MPEG2VideoData) IsClosedGOP() bool {
+	return d.GOPHeader != nil && d.GOPHeader.ClosedGOP
+}
+
+// Width returns the picture width, or 0 if there is no sequence header.
+func (d *MPEG2VideoData) Width() int {
+	if d.SequenceHeader == nil {
+		return 0
+	}
+	w := int(d.SequenceHeader.HorizontalSize)
+	if d.SequenceExtension != nil {
+		w |= int(d.SequenceExtension.HorizontalSizeExtension) << 12
+	}
+	return w
+}
+
+// Height returns the picture height, or 0 if there is no sequence header.
+func (d *MPEG2VideoData) Height() int {
+	if d.SequenceHeader == nil {
+		return 0
+	}
+	h := int(d.SequenceHeader.VerticalSize)
+	if d.SequenceExtension != nil {
+		h |= int(d.SequenceExtension.VerticalSizeExtension) << 12
+	}
+	return h
+}
+
+// FrameRate returns the frame rate as a fraction, or 0/1 if it is unknown.
+func (d *MPEG2VideoData) FrameRate() (num, den int) {
+	if d.SequenceHeader == nil || int(d.SequenceHeader.FrameRateCode) >= len(mpeg2VideoFrameRates) {
+		return 0, 1
+	}
+	num, den = mpeg2VideoFrameRates[d.SequenceHeader.FrameRateCode][0], mpeg2VideoFrameRates[d.SequenceHeader.FrameRateCode][1]
+	if d.SequenceExtension != nil {
+		num *= int(d.SequenceExtension.FrameRateExtensionN) + 1
+		den *= int(d.SequenceExtension.FrameRateExtensionD) + 1
+	}
+	return
+}
+
+// Bitrate returns the bitrate in bits per second, or 0 if there is no sequence header.
+func (d *MPEG2VideoData) Bitrate() int {
+	if d.SequenceHeader == nil {
+		return 0
+	}
+	v := int(d.SequenceHeader.BitRateValue)
+	if d.SequenceExtension != nil {
+		v |= int(d.SequenceExtension.BitRateExtension) << 18
+	}
+	return v * 400
+}
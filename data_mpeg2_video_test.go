@@ -0,0 +1,165 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func mpeg2VideoIFrameBytes() []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+
+	// Sequence header
+	w.TryWriteBits(0x1b3, 32)
+	w.TryWriteBits(720, 12)   // horizontal_size_value.
+	w.TryWriteBits(576, 12)   // vertical_size_value.
+	w.TryWriteBits(3, 4)      // aspect_ratio_information.
+	w.TryWriteBits(3, 4)      // frame_rate_code.
+	w.TryWriteBits(15000, 18) // bit_rate_value.
+	WriteBinary(w, "1")       // Marker bit.
+	w.TryWriteBits(112, 10)   // vbv_buffer_size_value.
+	WriteBinary(w, "0")       // constrained_parameters_flag.
+	WriteBinary(w, "1")       // load_intra_quantiser_matrix.
+	w.Write(bytes.Repeat([]byte{0x10}, 64))
+	WriteBinary(w, "1") // load_non_intra_quantiser_matrix.
+	w.Write(bytes.Repeat([]byte{0x10}, 64))
+
+	// Sequence extension
+	w.TryWriteBits(0x1b5, 32)
+	w.TryWriteBits(mpeg2VideoExtensionIDSequence, 4)
+	w.TryWriteByte(0x48)    // profile_and_level_indication.
+	WriteBinary(w, "0")     // progressive_sequence.
+	WriteBinary(w, "01")    // chroma_format.
+	WriteBinary(w, "00")    // horizontal_size_extension.
+	WriteBinary(w, "00")    // vertical_size_extension.
+	w.TryWriteBits(0, 12)   // bit_rate_extension.
+	WriteBinary(w, "1")     // Marker bit.
+	w.TryWriteByte(0)       // vbv_buffer_size_extension.
+	WriteBinary(w, "0")     // low_delay.
+	WriteBinary(w, "00")    // frame_rate_extension_n.
+	WriteBinary(w, "00000") // frame_rate_extension_d.
+
+	// Sequence display extension
+	w.TryWriteBits(0x1b5, 32)
+	w.TryWriteBits(mpeg2VideoExtensionIDSequenceDisplay, 4)
+	WriteBinary(w, "001") // video_format.
+	WriteBinary(w, "1")   // colour_description.
+	w.TryWriteByte(ColourPrimariesBT709)
+	w.TryWriteByte(TransferCharacteristicsBT709)
+	w.TryWriteByte(1)
+	w.TryWriteBits(720, 14)
+	WriteBinary(w, "1") // Marker bit.
+	w.TryWriteBits(576, 14)
+	WriteBinary(w, "000") // Alignment.
+
+	// GOP header
+	w.TryWriteBits(0x1b8, 32)
+	WriteBinary(w, "0")     // drop_frame_flag.
+	w.TryWriteBits(1, 5)    // Hours.
+	w.TryWriteBits(2, 6)    // Minutes.
+	WriteBinary(w, "1")     // Marker bit.
+	w.TryWriteBits(3, 6)    // Seconds.
+	w.TryWriteBits(4, 6)    // Pictures.
+	WriteBinary(w, "1")     // closed_gop.
+	WriteBinary(w, "0")     // broken_link.
+	WriteBinary(w, "00000") // Alignment.
+
+	// Picture header
+	w.TryWriteBits(0x100, 32)
+	w.TryWriteBits(2, 10)      // temporal_reference.
+	w.TryWriteBits(1, 3)       // picture_coding_type.
+	w.TryWriteBits(0xffff, 16) // vbv_delay.
+	WriteBinary(w, "000")      // Alignment.
+
+	// Picture coding extension
+	w.TryWriteBits(0x1b5, 32)
+	w.TryWriteBits(mpeg2VideoExtensionIDPictureCoding, 4)
+	w.TryWriteBits(0xffff, 16) // f_code.
+	WriteBinary(w, "10")       // intra_dc_precision.
+	WriteBinary(w, "11")       // picture_structure.
+	WriteBinary(w, "1")        // top_field_first.
+	WriteBinary(w, "00000")    // frame_pred_frame_dct to alternate_scan.
+	WriteBinary(w, "0")        // repeat_first_field.
+	WriteBinary(w, "0")        // chroma_420_type.
+	WriteBinary(w, "0")        // progressive_frame.
+	WriteBinary(w, "0")        // composite_display_flag.
+	WriteBinary(w, "000000")   // Alignment.
+
+	// AFD user data
+	w.TryWriteBits(0x1b2, 32)
+	w.TryWriteBits(afdIdentifier, 32)
+	WriteBinary(w, "01000001") // active_format_flag.
+	WriteBinary(w, "11111010") // active_format.
+
+	// Slice
+	w.TryWriteBits(0x101, 32)
+	w.Write([]byte{0x1, 0x2, 0x3})
+	return buf.Bytes()
+}
+
+func TestParseMPEG2VideoData(t *testing.T) {
+	d, err := ParseMPEG2VideoData(mpeg2VideoIFrameBytes())
+	assert.NoError(t, err)
+	assert.Equal(t, &MPEG2VideoSequenceHeader{
+		AspectRatioInformation:      MPEG2VideoAspectRatio16_9,
+		BitRateValue:                15000,
+		FrameRateCode:               3,
+		HorizontalSize:              720,
+		LoadIntraQuantiserMatrix:    true,
+		LoadNonIntraQuantiserMatrix: true,
+		VBVBufferSizeValue:          112,
+		VerticalSize:                576,
+	}, d.SequenceHeader)
+	assert.Equal(t, &MPEG2VideoSequenceExtension{
+		ChromaFormat:              1,
+		ProfileAndLevelIndication: 0x48,
+	}, d.SequenceExtension)
+	assert.Equal(t, &MPEG2VideoSequenceDisplayExtension{
+		ColourDescription: &ColourDescription{
+			ColourPrimaries:         ColourPrimariesBT709,
+			MatrixCoefficients:      1,
+			TransferCharacteristics: TransferCharacteristicsBT709,
+		},
+		DisplayHorizontalSize: 720,
+		DisplayVerticalSize:   576,
+		VideoFormat:           1,
+	}, d.SequenceDisplayExtension)
+	assert.Equal(t, &MPEG2VideoGOPHeader{
+		ClosedGOP: true,
+		TimeCode:  &MPEG2VideoTimeCode{Hours: 1, Minutes: 2, Pictures: 4, Seconds: 3},
+	}, d.GOPHeader)
+	assert.Equal(t, []*MPEG2VideoPicture{{
+		CodingExtension: &MPEG2VideoPictureCodingExtension{
+			IntraDCPrecision: 2,
+			PictureStructure: MPEG2VideoPictureStructureFrame,
+			TopFieldFirst:    true,
+		},
+		CodingType:        MPEG2VideoPictureCodingTypeI,
+		TemporalReference: 2,
+		VBVDelay:          0xffff,
+	}}, d.Pictures)
+	assert.Equal(t, &MPEG2VideoAFD{ActiveFormat: 0xa}, d.AFD)
+	assert.True(t, d.IsKeyFrame())
+	assert.True(t, d.IsClosedGOP())
+	assert.Equal(t, 720, d.Width())
+	assert.Equal(t, 576, d.Height())
+	assert.Equal(t, 6000000, d.Bitrate())
+	num, den := d.FrameRate()
+	assert.Equal(t, 25, num)
+	assert.Equal(t, 1, den)
+
+	// B picture only
+	d, err = ParseMPEG2VideoData([]byte{0x0, 0x0, 0x1, 0x0, 0x0, 0x1b, 0xff, 0xf8})
+	assert.NoError(t, err)
+	assert.Equal(t, uint8(MPEG2VideoPictureCodingTypeB), d.PictureCodingType())
+	assert.False(t, d.IsKeyFrame())
+	assert.False(t, d.IsClosedGOP())
+	assert.Equal(t, 0, d.Width())
+
+	// Errors
+	_, err = ParseMPEG2VideoData([]byte{0x1, 0x2})
+	assert.ErrorIs(t, err, ErrMPEG2VideoStartCodeNotFound)
+}
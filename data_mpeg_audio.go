@@ -0,0 +1,213 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// MPEG audio versions.
+// Page: 22 | Chapter: 2.4.2.3 | Link: https://www.iso.org/standard/22412.html
+const (
+	MPEGAudioVersion25 = 0 // Unofficial MPEG-2.5 extension
+	MPEGAudioVersion2  = 2
+	MPEGAudioVersion1  = 3
+)
+
+// MPEG audio layers.
+const (
+	MPEGAudioLayer3 = 1
+	MPEGAudioLayer2 = 2
+	MPEGAudioLayer1 = 3
+)
+
+// MPEG audio modes.
+const (
+	MPEGAudioModeStereo      = 0
+	MPEGAudioModeJointStereo = 1
+	MPEGAudioModeDualChannel = 2
+	MPEGAudioModeSingle      = 3
+)
+
+const mpegAudioHeaderLength = 4
+
+// Errors.
+var (
+	ErrMPEGAudioSyncWordNotFound = errors.New("MPEG audio sync word not found")
+	ErrMPEGAudioFrameTruncated   = errors.New("MPEG audio frame is truncated")
+	ErrMPEGAudioInvalidHeader    = errors.New("invalid MPEG audio header")
+)
+
+var (
+	// mpegAudioBitrates are indexed by [version is 1][layer][bitrate_index] and expressed in kbps,
+	// 0 being free format or invalid.
+	mpegAudioBitrates = [2][4][16]int{
+		{
+			{},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		},
+		{
+			{},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		},
+	}
+
+	// mpegAudioSampleRates are the MPEG-1 sample rates indexed by sampling_frequency.
+	mpegAudioSampleRates = []int{44100, 48000, 32000}
+)
+
+// MPEGAudioData represents the MPEG audio frames carried by a PES payload.
+type MPEGAudioData struct {
+	Frames []*MPEGAudioFrame
+}
+
+// MPEGAudioFrame represents an MPEG audio frame.
+type MPEGAudioFrame struct {
+	Data   []byte // The whole frame, header included.
+	Header *MPEGAudioHeader
+	PTS    *ClockReference
+}
+
+// MPEGAudioHeader represents an MPEG audio frame header.
+// Page: 22 | Chapter: 2.4.1.3 | Link: https://www.iso.org/standard/22412.html
+type MPEGAudioHeader struct {
+	Bitrate                int // In bits per second.
+	BitrateIndex           uint8
+	Copyright              bool
+	Emphasis               uint8
+	FrameSize              int // In bytes, header included.
+	Layer                  uint8
+	Mode                   uint8
+	ModeExtension          uint8
+	Original               bool
+	Padding                bool
+	PrivateBit             bool
+	ProtectionAbsent       bool
+	SampleRate             int
+	SamplingFrequencyIndex uint8
+	Version                uint8
+}
+
+// ParseMPEGAudioData splits an MPEG-1/2 audio byte stream such as a PES payload into frames.
+// pts is the PTS of the first frame and may be nil.
+func ParseMPEGAudioData(b []byte, pts *ClockReference) (*MPEGAudioData, error) {
+	d := &MPEGAudioData{}
+	var samples int64
+	for i := 0; i < len(b); {
+		// Look for the sync word
+		if len(b)-i < 2 || b[i] != 0xff || b[i+1]&0xe0 != 0xe0 {
+			if i == 0 {
+				return nil, ErrMPEGAudioSyncWordNotFound
+			}
+			i++
+			continue
+		}
+
+		if len(b)-i < mpegAudioHeaderLength {
+			return nil, ErrMPEGAudioFrameTruncated
+		}
+
+		h, err := parseMPEGAudioHeader(b[i:])
+		if err != nil {
+			return nil, fmt.Errorf("parsing MPEG audio header failed: %w", err)
+		}
+
+		if h.FrameSize > len(b)-i {
+			return nil, ErrMPEGAudioFrameTruncated
+		}
+
+		d.Frames = append(d.Frames, &MPEGAudioFrame{
+			Data:   b[i : i+h.FrameSize],
+			Header: h,
+			PTS:    addSamplesToPTS(pts, samples, h.SampleRate),
+		})
+		samples += int64(h.Samples())
+		i += h.FrameSize
+	}
+	return d, nil
+}
+
+// parseMPEGAudioHeader parses an MPEG audio frame header.
+func parseMPEGAudioHeader(b []byte) (*MPEGAudioHeader, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+	_ = r.TryReadBits(11) // Sync word.
+
+	h := &MPEGAudioHeader{}
+	h.Version = uint8(r.TryReadBits(2))
+	h.Layer = uint8(r.TryReadBits(2))
+	h.ProtectionAbsent = r.TryReadBool()
+	h.BitrateIndex = uint8(r.TryReadBits(4))
+	h.SamplingFrequencyIndex = uint8(r.TryReadBits(2))
+	h.Padding = r.TryReadBool()
+	h.PrivateBit = r.TryReadBool()
+	h.Mode = uint8(r.TryReadBits(2))
+	h.ModeExtension = uint8(r.TryReadBits(2))
+	h.Copyright = r.TryReadBool()
+	h.Original = r.TryReadBool()
+	h.Emphasis = uint8(r.TryReadBits(2))
+	if r.TryError != nil {
+		return nil, r.TryError
+	}
+
+	// Validate
+	if h.Version == 1 || h.Layer == 0 || int(h.SamplingFrequencyIndex) >= len(mpegAudioSampleRates) {
+		return nil, fmt.Errorf("%w: version %d, layer %d and sampling frequency index %d", ErrMPEGAudioInvalidHeader, h.Version, h.Layer, h.SamplingFrequencyIndex)
+	}
+
+	// Bitrate
+	var v1 int
+	if h.Version == MPEGAudioVersion1 {
+		v1 = 1
+	}
+	if h.Bitrate = mpegAudioBitrates[v1][h.Layer][h.BitrateIndex] * 1000; h.Bitrate == 0 {
+		// Free format frames don't have a size we can compute
+		return nil, fmt.Errorf("%w: bitrate index %d", ErrMPEGAudioInvalidHeader, h.BitrateIndex)
+	}
+
+	// Sample rate
+	h.SampleRate = mpegAudioSampleRates[h.SamplingFrequencyIndex]
+	switch h.Version {
+	case MPEGAudioVersion2:
+		h.SampleRate /= 2
+	case MPEGAudioVersion25:
+		h.SampleRate /= 4
+	}
+
+	// Frame size
+	var padding int
+	if h.Padding {
+		padding = 1
+	}
+	if h.Layer == MPEGAudioLayer1 {
+		h.FrameSize = (12*h.Bitrate/h.SampleRate + padding) * 4
+	} else {
+		h.FrameSize = h.Samples()/8*h.Bitrate/h.SampleRate + padding
+	}
+	return h, nil
+}
+
+// Samples returns the number of samples per channel of the frame.
+func (h *MPEGAudioHeader) Samples() int {
+	switch {
+	case h.Layer == MPEGAudioLayer1:
+		return 384
+	case h.Layer == MPEGAudioLayer3 && h.Version != MPEGAudioVersion1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// Channels returns the number of channels of the frame.
+func (h *MPEGAudioHeader) Channels() int {
+	if h.Mode == MPEGAudioModeSingle {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,67 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func mpegAudioFrameBytes(version, layer, bitrateIndex, samplingFrequencyIndex uint8, padding bool, size int) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	w.TryWriteBits(0x7ff, 11) // Sync word.
+	w.TryWriteBits(uint64(version), 2)
+	w.TryWriteBits(uint64(layer), 2)
+	WriteBinary(w, "1") // Protection absent.
+	w.TryWriteBits(uint64(bitrateIndex), 4)
+	w.TryWriteBits(uint64(samplingFrequencyIndex), 2)
+	w.TryWriteBool(padding)
+	WriteBinary(w, "0")    // Private bit.
+	WriteBinary(w, "11")   // Mode.
+	WriteBinary(w, "00")   // Mode extension.
+	WriteBinary(w, "0100") // Copyright, original and emphasis.
+	return append(buf.Bytes(), make([]byte, size-buf.Len())...)
+}
+
+func TestParseMPEGAudioData(t *testing.T) {
+	// MP2 192kbps 48kHz
+	b := append(mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer2, 10, 1, false, 576), mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer2, 10, 1, false, 576)...)
+	d, err := ParseMPEGAudioData(b, newClockReference(90000, 0))
+	assert.NoError(t, err)
+	assert.Len(t, d.Frames, 2)
+	assert.Equal(t, &MPEGAudioHeader{
+		Bitrate:                192000,
+		BitrateIndex:           10,
+		FrameSize:              576,
+		Layer:                  MPEGAudioLayer2,
+		Mode:                   MPEGAudioModeSingle,
+		Original:               true,
+		ProtectionAbsent:       true,
+		SampleRate:             48000,
+		SamplingFrequencyIndex: 1,
+		Version:                MPEGAudioVersion1,
+	}, d.Frames[0].Header)
+	assert.Equal(t, b[:576], d.Frames[0].Data)
+	assert.Equal(t, 1, d.Frames[0].Header.Channels())
+	assert.Equal(t, int64(90000), d.Frames[0].PTS.Base)
+	assert.Equal(t, int64(90000+2160), d.Frames[1].PTS.Base)
+
+	// Layer 1 and MPEG-2 layer 3 frame sizes
+	d, err = ParseMPEGAudioData(mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer1, 1, 2, true, 52), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 384, d.Frames[0].Header.Samples())
+	d, err = ParseMPEGAudioData(mpegAudioFrameBytes(MPEGAudioVersion2, MPEGAudioLayer3, 8, 0, true, 209), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 22050, d.Frames[0].Header.SampleRate)
+	assert.Equal(t, 576, d.Frames[0].Header.Samples())
+
+	// Errors
+	_, err = ParseMPEGAudioData([]byte{0x1, 0x2}, nil)
+	assert.ErrorIs(t, err, ErrMPEGAudioSyncWordNotFound)
+	_, err = ParseMPEGAudioData(mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer2, 10, 1, false, 100), nil)
+	assert.ErrorIs(t, err, ErrMPEGAudioFrameTruncated)
+	_, err = ParseMPEGAudioData(mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer2, 0, 1, false, 100), nil)
+	assert.ErrorIs(t, err, ErrMPEGAudioInvalidHeader)
+}
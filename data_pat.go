@@ -31,7 +31,7 @@ func parsePATSection(
 ) (*PATData, error) {
 	d := &PATData{TransportStreamID: tableIDExtension}
 
-	for r.BitsCount < offsetSectionsEnd {
+	for r.TryError == nil && r.BitsCount < offsetSectionsEnd {
 		p := &PATProgram{}
 
 		p.ProgramNumber = uint16(r.TryReadBits(16))
@@ -34,6 +34,11 @@ func TestParsePATSection(t *testing.T) {
 	d, err := parsePATSection(r, int64(len(b)*8), uint16(1))
 	assert.Equal(t, d, pat)
 	assert.NoError(t, err)
+
+	// Section ends after the end of the data
+	r = bitio.NewCountReader(bytes.NewReader(b))
+	_, err = parsePATSection(r, int64(len(b)*8+32), uint16(1))
+	assert.Error(t, err)
 }
 
 func TestWritePATSection(t *testing.T) {
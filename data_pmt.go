@@ -97,7 +97,7 @@ func parsePMTSection(
 	}
 
 	// Loop until end of section data is reached.
-	for r.BitsCount < offsetSectionsEnd {
+	for r.TryError == nil && r.BitsCount < offsetSectionsEnd {
 		e := &PMTElementaryStream{}
 
 		typ := r.TryReadByte()
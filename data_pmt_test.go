@@ -40,6 +40,11 @@ func TestParsePMTSection(t *testing.T) {
 	d, err := parsePMTSection(r, int64(len(b)*8), uint16(1))
 	assert.Equal(t, d, pmt)
 	assert.NoError(t, err)
+
+	// Section ends after the end of the data
+	r = bitio.NewCountReader(bytes.NewReader(b))
+	_, err = parsePMTSection(r, int64(len(b)*8+32), uint16(1))
+	assert.Error(t, err)
 }
 
 func TestWritePMTSection(t *testing.T) {
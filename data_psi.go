@@ -1,6 +1,7 @@
 package astits
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -137,6 +138,7 @@ type PSISectionSyntaxData struct {
 	PAT *PATData
 	PMT *PMTData
 	SDT *SDTData
+	TDT *TDTData
 	TOT *TOTData
 }
 
@@ -432,7 +434,9 @@ func parsePSISectionSyntaxData(
 			return nil, fmt.Errorf("parsing TOT section failed: %w", err)
 		}
 	case PSITableIDTDT:
-		// TODO Parse TDT.
+		if d.TDT, err = parseTDTSection(r); err != nil {
+			return nil, fmt.Errorf("parsing TDT section failed: %w", err)
+		}
 	}
 
 	if h.TableID >= PSITableIDEITStart && h.TableID <= PSITableIDEITEnd {
@@ -458,6 +462,8 @@ func (d *PSIData) toData(firstPacket *Packet, pid uint16) (ds []*DemuxerData) {
 			ds = append(ds, &DemuxerData{FirstPacket: firstPacket, PID: pid, PMT: s.Syntax.Data.PMT})
 		case PSITableIDSDTVariant1, PSITableIDSDTVariant2:
 			ds = append(ds, &DemuxerData{FirstPacket: firstPacket, PID: pid, SDT: s.Syntax.Data.SDT})
+		case PSITableIDTDT:
+			ds = append(ds, &DemuxerData{FirstPacket: firstPacket, PID: pid, TDT: s.Syntax.Data.TDT})
 		case PSITableIDTOT:
 			ds = append(ds, &DemuxerData{FirstPacket: firstPacket, PID: pid, TOT: s.Syntax.Data.TOT})
 		}
@@ -473,6 +479,47 @@ func (d *PSIData) toData(firstPacket *Packet, pid uint16) (ds []*DemuxerData) {
 	return
 }
 
+// newPSIPackets builds the packets carrying a long section, continuity counters being left
+// to 0.
+func newPSIPackets(pid uint16, tableID PSITableID, tableIDExtension uint16, version uint8, d *PSISectionSyntaxData) ([]*Packet, error) {
+	// Write section
+	s := &PSISection{
+		Header: &PSISectionHeader{SectionSyntaxIndicator: true, TableID: tableID},
+		Syntax: &PSISectionSyntax{
+			Data: d,
+			Header: &PSISectionSyntaxHeader{
+				CurrentNextIndicator: true,
+				TableIDExtension:     tableIDExtension,
+				VersionNumber:        version,
+			},
+		},
+	}
+	s.Header.SectionLength = calcPSISectionLength(s)
+	buf := &bytes.Buffer{}
+	if err := writePSIData(bitio.NewWriter(buf), &PSIData{Sections: []*PSISection{s}}); err != nil {
+		return nil, fmt.Errorf("writing PSI data failed: %w", err)
+	}
+
+	// Split it into packets
+	var ps []*Packet
+	for b := buf.Bytes(); len(b) > 0; {
+		n := MpegTsPacketSize - mpegTsPacketHeaderSize - 1
+		if n > len(b) {
+			n = len(b)
+		}
+		ps = append(ps, &Packet{
+			Header: &PacketHeader{
+				HasPayload:                true,
+				PayloadUnitStartIndicator: len(ps) == 0,
+				PID:                       pid,
+			},
+			Payload: b[:n],
+		})
+		b = b[n:]
+	}
+	return ps, nil
+}
+
 func writePSIData(w *bitio.Writer, d *PSIData) error {
 	w.TryWriteByte(uint8(d.PointerField))
 	for i := 0; i < d.PointerField; i++ {
@@ -507,6 +554,8 @@ func calcPSISectionLength(s *PSISection) uint16 {
 		ret += calcPATSectionLength(s.Syntax.Data.PAT)
 	case PSITableIDPMT:
 		ret += calcPMTSectionLength(s.Syntax.Data.PMT)
+	case PSITableIDSDTVariant1, PSITableIDSDTVariant2:
+		ret += calcSDTSectionLength(s.Syntax.Data.SDT)
 	}
 
 	if s.Header.TableID.hasCRC32() {
@@ -520,7 +569,9 @@ func calcPSISectionLength(s *PSISection) uint16 {
 var ErrPSIUnsupportedTable = errors.New("unsupported table")
 
 func writePSISection(w *bitio.Writer, s *PSISection) (int, error) {
-	if s.Header.TableID != PSITableIDPAT && s.Header.TableID != PSITableIDPMT {
+	switch s.Header.TableID {
+	case PSITableIDPAT, PSITableIDPMT, PSITableIDSDTVariant1, PSITableIDSDTVariant2:
+	default:
 		return 0, fmt.Errorf("%w: %s", ErrPSIUnsupportedTable, s.Header.TableID.Type())
 	}
 
@@ -597,6 +648,8 @@ func writePSISectionSyntaxData(w *bitio.Writer, d *PSISectionSyntaxData, tableID
 		return writePATSection(w, d.PAT)
 	case PSITableIDPMT:
 		return writePMTSection(w, d.PMT)
+	case PSITableIDSDTVariant1, PSITableIDSDTVariant2:
+		return writeSDTSection(w, d.SDT)
 	}
 
 	return 0, nil
@@ -0,0 +1,114 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/icza/bitio"
+)
+
+// SCTE-35 constants
+const (
+	scte35CommandTypeSpliceInsert = 0x5
+	scte35TableID                 = 0xfc
+)
+
+// ErrSCTE35InvalidTableID is returned when a section is not an SCTE-35 splice_info_section.
+var ErrSCTE35InvalidTableID = errors.New("invalid SCTE-35 table id")
+
+// SpliceInsert represents an SCTE-35 splice_insert command.
+// Link: https://www.scte.org/standards/library/catalog/scte-35-digital-program-insertion-cueing-message/
+type SpliceInsert struct {
+	AutoReturn      bool
+	AvailNum        uint8
+	AvailsExpected  uint8
+	BreakDuration   *ClockReference // Nil if the duration is not signalled.
+	EventID         uint32
+	IsCancel        bool
+	IsImmediate     bool
+	IsOutOfNetwork  bool
+	PTS             *ClockReference // Splice time, PTS adjustment included. Nil if immediate or not specified.
+	UniqueProgramID uint16
+}
+
+// parseSpliceInsertSection parses an SCTE-35 splice_info_section, returning nil if it doesn't
+// carry a splice_insert command or if it is encrypted.
+func parseSpliceInsertSection(b []byte) (*SpliceInsert, error) {
+	r := bitio.NewCountReader(bytes.NewReader(b))
+
+	// Header
+	if tableID := r.TryReadByte(); tableID != scte35TableID {
+		return nil, fmt.Errorf("%w: %#x", ErrSCTE35InvalidTableID, tableID)
+	}
+	_ = r.TryReadBits(4)  // Section syntax indicator, private indicator and SAP type.
+	_ = r.TryReadBits(12) // Section length.
+	_ = r.TryReadByte()   // Protocol version.
+	encrypted := r.TryReadBool()
+	_ = r.TryReadBits(6) // Encryption algorithm.
+	ptsAdjustment := int64(r.TryReadBits(33))
+	_ = r.TryReadByte()   // CW index.
+	_ = r.TryReadBits(12) // Tier.
+	_ = r.TryReadBits(12) // Splice command length.
+	commandType := r.TryReadByte()
+	if r.TryError != nil {
+		return nil, fmt.Errorf("parsing header failed: %w", r.TryError)
+	}
+	if encrypted || commandType != scte35CommandTypeSpliceInsert {
+		return nil, nil
+	}
+
+	// Splice insert
+	s := &SpliceInsert{EventID: uint32(r.TryReadBits(32))}
+	s.IsCancel = r.TryReadBool()
+	_ = r.TryReadBits(7) // Reserved.
+	if s.IsCancel {
+		return s, r.TryError
+	}
+	s.IsOutOfNetwork = r.TryReadBool()
+	programSplice := r.TryReadBool()
+	hasDuration := r.TryReadBool()
+	s.IsImmediate = r.TryReadBool()
+	_ = r.TryReadBits(4) // Event ID compliance flag and reserved.
+
+	// Splice time of the program or of its first component
+	parseTime := func() {
+		if !r.TryReadBool() {
+			_ = r.TryReadBits(7) // Reserved.
+			return
+		}
+		_ = r.TryReadBits(6) // Reserved.
+		pts := rebaseTimestamp(int64(r.TryReadBits(33)), -ptsAdjustment)
+		if s.PTS == nil {
+			s.PTS = newClockReference(pts, 0)
+		}
+	}
+	if programSplice {
+		if !s.IsImmediate {
+			parseTime()
+		}
+	} else {
+		count := r.TryReadByte()
+		for idx := 0; idx < int(count); idx++ {
+			_ = r.TryReadByte() // Component tag.
+			if !s.IsImmediate {
+				parseTime()
+			}
+		}
+	}
+
+	// Break duration
+	if hasDuration {
+		s.AutoReturn = r.TryReadBool()
+		_ = r.TryReadBits(6) // Reserved.
+		s.BreakDuration = newClockReference(int64(r.TryReadBits(33)), 0)
+	}
+
+	s.UniqueProgramID = uint16(r.TryReadBits(16))
+	s.AvailNum = r.TryReadByte()
+	s.AvailsExpected = r.TryReadByte()
+	if r.TryError != nil {
+		return nil, fmt.Errorf("parsing splice insert failed: %w", r.TryError)
+	}
+	return s, nil
+}
@@ -0,0 +1,110 @@
+package astits
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+// scte35SpliceInsertBytes returns the splice_info_section of a program splice_insert command.
+func scte35SpliceInsertBytes(s *SpliceInsert, ptsAdjustment int64) []byte {
+	// Command
+	cmd := &bytes.Buffer{}
+	w := bitio.NewWriter(cmd)
+	w.TryWriteBits(uint64(s.EventID), 32)
+	w.TryWriteBool(s.IsCancel)
+	w.TryWriteBits(0x7f, 7)
+	if !s.IsCancel {
+		w.TryWriteBool(s.IsOutOfNetwork)
+		w.TryWriteBool(true) // Program splice flag
+		w.TryWriteBool(s.BreakDuration != nil)
+		w.TryWriteBool(s.IsImmediate)
+		w.TryWriteBits(0xf, 4)
+		if !s.IsImmediate {
+			w.TryWriteBool(s.PTS != nil)
+			if s.PTS != nil {
+				w.TryWriteBits(0x3f, 6)
+				w.TryWriteBits(uint64(s.PTS.Base), 33)
+			} else {
+				w.TryWriteBits(0x7f, 7)
+			}
+		}
+		if s.BreakDuration != nil {
+			w.TryWriteBool(s.AutoReturn)
+			w.TryWriteBits(0x3f, 6)
+			w.TryWriteBits(uint64(s.BreakDuration.Base), 33)
+		}
+		w.TryWriteBits(uint64(s.UniqueProgramID), 16)
+		w.TryWriteByte(s.AvailNum)
+		w.TryWriteByte(s.AvailsExpected)
+	}
+	_ = w.Close()
+
+	// Section
+	buf := &bytes.Buffer{}
+	w = bitio.NewWriter(buf)
+	w.TryWriteByte(scte35TableID)
+	w.TryWriteBits(0x3, 4) // Section syntax indicator, private indicator and SAP type.
+	w.TryWriteBits(uint64(11+cmd.Len()+2+4), 12)
+	w.TryWriteByte(0) // Protocol version.
+	w.TryWriteBool(false)
+	w.TryWriteBits(0, 6)
+	w.TryWriteBits(uint64(ptsAdjustment), 33)
+	w.TryWriteByte(0)
+	w.TryWriteBits(0xfff, 12)
+	w.TryWriteBits(uint64(cmd.Len()), 12)
+	w.TryWriteByte(scte35CommandTypeSpliceInsert)
+	w.TryWrite(cmd.Bytes())
+	w.TryWriteBits(0, 16) // Descriptor loop length.
+	_ = w.Close()
+	cw := NewCRC32Writer(&bytes.Buffer{})
+	_, _ = cw.Write(buf.Bytes())
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, cw.CRC32())
+	return append(buf.Bytes(), crc...)
+}
+
+func TestParseSpliceInsertSection(t *testing.T) {
+	// Out point with a break duration and a PTS adjustment
+	s := &SpliceInsert{
+		AutoReturn:      true,
+		AvailNum:        1,
+		AvailsExpected:  2,
+		BreakDuration:   newClockReference(2700000, 0),
+		EventID:         0x1234,
+		IsOutOfNetwork:  true,
+		PTS:             newClockReference(clockReferenceBaseModulo-10, 0),
+		UniqueProgramID: 3,
+	}
+	v, err := parseSpliceInsertSection(scte35SpliceInsertBytes(s, 20))
+	assert.NoError(t, err)
+	s.PTS = newClockReference(10, 0)
+	assert.Equal(t, s, v)
+
+	// Immediate in point
+	s = &SpliceInsert{EventID: 2, IsImmediate: true}
+	v, err = parseSpliceInsertSection(scte35SpliceInsertBytes(s, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, s, v)
+
+	// Cancel
+	s = &SpliceInsert{EventID: 3, IsCancel: true}
+	v, err = parseSpliceInsertSection(scte35SpliceInsertBytes(s, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, s, v)
+
+	// Other command
+	b := scte35SpliceInsertBytes(s, 0)
+	b[13] = 0x0
+	v, err = parseSpliceInsertSection(b)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	// Invalid table id
+	b[0] = 0x0
+	_, err = parseSpliceInsertSection(b)
+	assert.ErrorIs(t, err, ErrSCTE35InvalidTableID)
+}
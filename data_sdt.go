@@ -56,7 +56,7 @@ func parseSDTSection(
 	_ = r.TryReadByte() // Reserved.
 
 	// Loop until end of section data is reached.
-	for r.BitsCount < offsetSectionsEnd {
+	for r.TryError == nil && r.BitsCount < offsetSectionsEnd {
 		s := &SDTDataService{}
 
 		s.ServiceID = uint16(r.TryReadBits(16))
@@ -77,3 +77,38 @@ func parseSDTSection(
 	}
 	return d, r.TryError
 }
+
+func calcSDTSectionLength(d *SDTData) uint16 {
+	ret := uint16(3)
+	for _, s := range d.Services {
+		ret += 5
+		ret += calcDescriptorsLength(s.Descriptors)
+	}
+	return ret
+}
+
+func writeSDTSection(w *bitio.Writer, d *SDTData) (int, error) {
+	w.TryWriteBits(uint64(d.OriginalNetworkID), 16)
+	w.TryWriteByte(0xff) // Reserved.
+	bytesWritten := 3
+
+	for _, s := range d.Services {
+		w.TryWriteBits(uint64(s.ServiceID), 16)
+
+		w.TryWriteBits(0xff, 6) // Reserved.
+		w.TryWriteBool(s.HasEITSchedule)
+		w.TryWriteBool(s.HasEITPresentFollowing)
+
+		w.TryWriteBits(uint64(s.RunningStatus), 3)
+		w.TryWriteBool(s.HasFreeCSAMode)
+		w.TryWriteBits(uint64(calcDescriptorsLength(s.Descriptors)), 12)
+		bytesWritten += 5
+
+		n, err := writeDescriptors(w, s.Descriptors)
+		if err != nil {
+			return 0, fmt.Errorf("writing descriptors failed: %w", err)
+		}
+		bytesWritten += n
+	}
+	return bytesWritten, w.TryError
+}
@@ -42,4 +42,23 @@ func TestParseSDTSection(t *testing.T) {
 	d, err := parseSDTSection(r, int64(len(b)*8), uint16(1))
 	assert.Equal(t, d, sdt)
 	assert.NoError(t, err)
+
+	// Section ends after the end of the data
+	r = bitio.NewCountReader(bytes.NewReader(b))
+	_, err = parseSDTSection(r, int64(len(b)*8+32), uint16(1))
+	assert.Error(t, err)
+}
+
+func TestWriteSDTSection(t *testing.T) {
+	bw := &bytes.Buffer{}
+	w := bitio.NewWriter(bw)
+	n, err := writeSDTSection(w, sdt)
+	assert.NoError(t, err)
+	assert.Equal(t, n, bw.Len())
+	assert.Equal(t, int(calcSDTSectionLength(sdt)), n)
+
+	r := bitio.NewCountReader(bytes.NewReader(bw.Bytes()))
+	d, err := parseSDTSection(r, int64(n*8), uint16(1))
+	assert.NoError(t, err)
+	assert.Equal(t, sdt, d)
 }
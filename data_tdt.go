@@ -0,0 +1,26 @@
+package astits
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/icza/bitio"
+)
+
+// TDTData represents a TDT data.
+// Page: 39 | Chapter: 5.2.5 | Link:
+// https://www.dvb.org/resources/public/standards/a38_dvb-si_specification.pdf
+type TDTData struct {
+	UTCTime time.Time
+}
+
+// parseTDTSection parses a TDT section.
+func parseTDTSection(r *bitio.CountReader) (*TDTData, error) {
+	d := &TDTData{}
+
+	var err error
+	if d.UTCTime, err = parseDVBTime(r); err != nil {
+		return nil, fmt.Errorf("parsing DVB time failed: %w", err)
+	}
+	return d, nil
+}
@@ -0,0 +1,16 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTDTSection(t *testing.T) {
+	r := bitio.NewCountReader(bytes.NewReader(dvbTimeBytes))
+	d, err := parseTDTSection(r)
+	assert.Equal(t, &TDTData{UTCTime: dvbTime}, d)
+	assert.NoError(t, err)
+}
@@ -18,14 +18,23 @@ var ErrPacketStartSyncByte = errors.New("packet must start with a sync byte")
 // http://seidl.cs.vsb.cz/download/dvb/DVB_Poster.pdf
 // http://www.etsi.org/deliver/etsi_en/300400_300499/300468/01.13.01_40/en_300468v011301o.pdf
 type Demuxer struct {
-	ctx              context.Context
-	dataBuffer       []*DemuxerData
-	optPacketSize    int
-	optPacketsParser PacketsParser
-	packetBuffer     *packetBuffer
-	packetPool       *packetPool
-	programMap       *programMap
-	r                io.Reader
+	ctx                        context.Context
+	dataBuffer                 []*DemuxerData
+	esParsers                  map[uint16]*elementaryStreamParser // Indexed by elementary PID
+	optAdaptationFieldData     bool
+	optDataPositions           bool
+	optPacketSize              int
+	optPacketsParser           PacketsParser
+	optParseElementaryStreams  bool
+	optResync                  bool
+	optSeekToRandomAccessPoint bool
+	optStats                   *Stats
+	optTimeline                bool
+	packetBuffer               *packetBuffer
+	packetPool                 *packetPool
+	programMap                 *programMap
+	r                          io.Reader
+	timelines                  *demuxerTimelines
 }
 
 // PacketsParser represents an object capable of parsing
@@ -40,8 +49,10 @@ func NewDemuxer(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) (d *De
 	// Init
 	d = &Demuxer{
 		ctx:        ctx,
+		esParsers:  make(map[uint16]*elementaryStreamParser),
 		programMap: newProgramMap(),
 		r:          r,
+		timelines:  newDemuxerTimelines(),
 	}
 	d.packetPool = newPacketPool(d.optPacketsParser, d.programMap)
 
@@ -53,6 +64,23 @@ func NewDemuxer(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) (d *De
 	return
 }
 
+// DemuxerOptAdaptationFieldData returns the option to return, as their own data, the
+// adaptation fields carrying a PCR, an OPCR, a splice countdown or private data. This
+// includes packets without payload, that are often used to carry PCRs on dedicated PIDs.
+func DemuxerOptAdaptationFieldData() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optAdaptationFieldData = true
+	}
+}
+
+// DemuxerOptDataPositions returns the option to set the byte offsets and the packet index
+// of the payload unit of every data.
+func DemuxerOptDataPositions() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optDataPositions = true
+	}
+}
+
 // DemuxerOptPacketSize returns the option to set the packet size.
 func DemuxerOptPacketSize(packetSize int) func(*Demuxer) {
 	return func(d *Demuxer) {
@@ -67,8 +95,50 @@ func DemuxerOptPacketsParser(p PacketsParser) func(*Demuxer) {
 	}
 }
 
+// DemuxerOptParseElementaryStreams returns the option to parse the elementary
+// streams carried by PES data, based on the stream types found in the PMTs.
+func DemuxerOptParseElementaryStreams() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optParseElementaryStreams = true
+	}
+}
+
+// DemuxerOptResync returns the option to skip bytes until 2 sync bytes separated by the
+// packet size are found whenever a packet doesn't start with a sync byte, instead of
+// returning ErrPacketStartSyncByte. Skipped bytes are counted by SkippedBytes.
+func DemuxerOptResync() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optResync = true
+	}
+}
+
+// SkippedBytes returns the number of bytes skipped while looking for sync bytes.
+func (dmx *Demuxer) SkippedBytes() int64 {
+	if dmx.packetBuffer == nil {
+		return 0
+	}
+	return dmx.packetBuffer.skippedBytes
+}
+
 // NextPacket retrieves the next packet.
 func (dmx *Demuxer) NextPacket() (*Packet, error) {
+	// Fetch next packet.
+	p, err := dmx.nextPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect stats.
+	if dmx.optStats != nil {
+		dmx.optStats.addPacket(p, dmx.packetBuffer.packetSize)
+	}
+
+	return p, nil
+}
+
+// nextPacket retrieves the next packet without collecting stats, which is used to look
+// ahead when seeking.
+func (dmx *Demuxer) nextPacket() (*Packet, error) {
 	// Check ctx error
 	// TODO Handle ctx error another way since if the read blocks,
 	// everything blocks Maybe execute everything in a goroutine
@@ -80,8 +150,7 @@ func (dmx *Demuxer) NextPacket() (*Packet, error) {
 
 	// Create packet buffer if not exists.
 	if dmx.packetBuffer == nil {
-		dmx.packetBuffer, err = newPacketBuffer(dmx.r, dmx.optPacketSize)
-		if err != nil {
+		if err = dmx.createPacketBuffer(); err != nil {
 			return nil, fmt.Errorf("creating packet buffer failed: %w", err)
 		}
 	}
@@ -94,10 +163,18 @@ func (dmx *Demuxer) NextPacket() (*Packet, error) {
 		}
 		return nil, fmt.Errorf("fetching next packet from buffer failed: %w", err)
 	}
-
 	return p, nil
 }
 
+// createPacketBuffer creates the packet buffer.
+func (dmx *Demuxer) createPacketBuffer() (err error) {
+	if dmx.packetBuffer, err = newPacketBuffer(dmx.r, dmx.optPacketSize); err != nil {
+		return
+	}
+	dmx.packetBuffer.optResync = dmx.optResync
+	return
+}
+
 // NextData retrieves the next data.
 func (dmx *Demuxer) NextData() (*DemuxerData, error) {
 	// Check data buffer.
@@ -132,42 +209,74 @@ func (dmx *Demuxer) NextData() (*DemuxerData, error) {
 					continue
 				}
 
-				if d := dmx.updateData(ds); d != nil {
+				if d := dmx.updateData(ds, ps); d != nil {
 					return d, nil
 				}
 			}
 			return nil, err
 		}
 
-		if ps = dmx.packetPool.add(p); len(ps) == 0 {
-			continue
+		var d *DemuxerData
+		if ps = dmx.packetPool.add(p); len(ps) > 0 {
+			if ds, err = parseData(ps, dmx.optPacketsParser, dmx.programMap); err != nil {
+				return nil, fmt.Errorf("building new data failed: %w", err)
+			}
+			d = dmx.updateData(ds, ps)
+		}
+
+		// Update timelines once the data preceding the packet has been processed, since
+		// the packet may carry a PCR that doesn't apply to it.
+		if dmx.optTimeline {
+			dmx.timelines.updatePacket(p)
 		}
 
-		if ds, err = parseData(ps, dmx.optPacketsParser, dmx.programMap); err != nil {
-			return nil, fmt.Errorf("building new data failed: %w", err)
+		// Adaptation field data comes after the data preceding the packet.
+		if dmx.optAdaptationFieldData && hasAdaptationFieldData(p) {
+			afd := &DemuxerData{AdaptationField: p.AdaptationField, FirstPacket: p, PID: p.Header.PID}
+			dmx.updatePosition(afd, []*Packet{p})
+			if d == nil {
+				d = afd
+			} else {
+				dmx.dataBuffer = append(dmx.dataBuffer, afd)
+			}
 		}
 
-		if d := dmx.updateData(ds); d != nil {
+		if d != nil {
 			return d, nil
 		}
 	}
 }
 
-func (dmx *Demuxer) updateData(ds []*DemuxerData) (d *DemuxerData) {
+func (dmx *Demuxer) updateData(ds []*DemuxerData, ps []*Packet) (d *DemuxerData) {
 	// Check whether there is data to be processed.
 	if len(ds) > 0 {
 		// Process data.
 		d = ds[0]
 		dmx.dataBuffer = append(dmx.dataBuffer, ds[1:]...)
 
-		// Update program map.
 		for _, v := range ds {
-			if v.PAT != nil {
-				for _, pgm := range v.PAT.Programs {
-					// Program number 0 is reserved to NIT.
-					if pgm.ProgramNumber > 0 {
-						dmx.programMap.set(pgm.ProgramMapID, pgm.ProgramNumber)
-					}
+			// Update position.
+			dmx.updatePosition(v, ps)
+
+			// Update programs.
+			dmx.updatePrograms(v)
+
+			// Collect stats.
+			if dmx.optStats != nil {
+				dmx.optStats.addData(v)
+			}
+
+			// Unwrap timestamps.
+			if dmx.optTimeline {
+				dmx.timelines.updateData(v)
+			}
+
+			// Parse elementary stream.
+			if v.PES != nil && dmx.optParseElementaryStreams {
+				if p, ok := dmx.esParsers[v.PID]; ok {
+					// Elementary stream data may be incomplete or scrambled, in which
+					// case we still want to return the PES data.
+					v.ElementaryStreamError = p.parse(v)
 				}
 			}
 		}
@@ -175,11 +284,91 @@ func (dmx *Demuxer) updateData(ds []*DemuxerData) (d *DemuxerData) {
 	return
 }
 
+// updatePosition sets the position in the input of the payload unit spanning over the
+// packets.
+func (dmx *Demuxer) updatePosition(d *DemuxerData, ps []*Packet) {
+	if !dmx.optDataPositions {
+		return
+	}
+	d.EndOffset = ps[len(ps)-1].Offset + int64(dmx.packetBuffer.packetSize)
+	d.PacketIndex = ps[0].Index
+	d.StartOffset = ps[0].Offset
+}
+
+// packetData adds a packet fetched with NextPacket to the packet pool and returns the data of
+// the payload unit it completes, if any, once the programs have been updated. Incomplete data
+// is skipped, which allows tools to keep reading packets of damaged inputs.
+func (dmx *Demuxer) packetData(p *Packet) []*DemuxerData {
+	return dmx.payloadUnitData(dmx.packetPool.add(p))
+}
+
+// remainingPacketData dumps the packet pool and returns the data of the payload units it
+// contained, once the programs have been updated.
+func (dmx *Demuxer) remainingPacketData() (ds []*DemuxerData) {
+	for ps := dmx.packetPool.dump(); len(ps) > 0; ps = dmx.packetPool.dump() {
+		ds = append(ds, dmx.payloadUnitData(ps)...)
+	}
+	return
+}
+
+// payloadUnitData returns the data of a set of packets containing a unique payload.
+func (dmx *Demuxer) payloadUnitData(ps []*Packet) []*DemuxerData {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	// Incomplete data is skipped
+	ds, err := parseData(ps, dmx.optPacketsParser, dmx.programMap)
+	if err != nil {
+		return nil
+	}
+
+	for _, d := range ds {
+		dmx.updatePosition(d, ps)
+		dmx.updatePrograms(d)
+	}
+	return ds
+}
+
+// updatePrograms updates the program map and the elementary stream parsers.
+func (dmx *Demuxer) updatePrograms(d *DemuxerData) {
+	// Update program map.
+	if d.PAT != nil {
+		for _, pgm := range d.PAT.Programs {
+			// Program number 0 is reserved to NIT.
+			if pgm.ProgramNumber > 0 {
+				dmx.programMap.set(pgm.ProgramMapID, pgm.ProgramNumber)
+			}
+		}
+	}
+
+	// Update elementary stream parsers and timelines.
+	if d.PMT != nil {
+		for _, es := range d.PMT.ElementaryStreams {
+			t := elementaryStreamType(es)
+			if p, ok := dmx.esParsers[es.ElementaryPID]; !ok || p.streamType != t {
+				dmx.esParsers[es.ElementaryPID] = newElementaryStreamParser(t)
+			}
+		}
+		if dmx.optTimeline {
+			dmx.timelines.updatePMT(d.PMT)
+		}
+	}
+}
+
+// hasAdaptationFieldData checks whether the adaptation field of a packet carries
+// information that is not related to its payload.
+func hasAdaptationFieldData(p *Packet) bool {
+	return p.Header.HasAdaptationField && (p.AdaptationField.HasPCR || p.AdaptationField.HasOPCR ||
+		p.AdaptationField.HasSplicingCountdown || p.AdaptationField.HasTransportPrivateData)
+}
+
 // Rewind rewinds the demuxer reader.
 func (dmx *Demuxer) Rewind() (n int64, err error) {
 	dmx.dataBuffer = []*DemuxerData{}
 	dmx.packetBuffer = nil
 	dmx.packetPool = newPacketPool(dmx.optPacketsParser, dmx.programMap)
+	dmx.timelines.reset()
 	if n, err = rewind(dmx.r); err != nil {
 		err = fmt.Errorf("rewinding reader failed: %w", err)
 		return
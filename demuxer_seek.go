@@ -0,0 +1,300 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/icza/bitio"
+)
+
+// seekProbeMaxPackets is the number of packets read after a candidate offset when looking
+// for a timestamp. PCRs are supposed to be sent at least every 100ms.
+const seekProbeMaxPackets = 10000
+
+// Errors.
+var (
+	ErrReaderNotSeekable = errors.New("reader is not seekable")
+	ErrNoTimestampFound  = errors.New("no timestamp found")
+)
+
+// seekTimestampSource indicates where timestamps used to seek are looked for.
+type seekTimestampSource struct {
+	isPCR bool
+	pid   uint16
+}
+
+// DemuxerOptSeekToRandomAccessPoint returns the option to make seeks advance to the next
+// random access point of the first video stream found in the PMTs demuxed so far.
+func DemuxerOptSeekToRandomAccessPoint() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optSeekToRandomAccessPoint = true
+	}
+}
+
+// SeekToByte moves a demuxer reading a seekable reader to the first packet starting
+// at or after the packet containing off, and returns the offset of that packet.
+// The program map learned so far is kept.
+func (dmx *Demuxer) SeekToByte(off int64) (int64, error) {
+	n, err := dmx.seekToByte(off)
+	if err != nil {
+		return 0, err
+	}
+
+	if dmx.optSeekToRandomAccessPoint {
+		if n, err = dmx.seekToNextRandomAccessPoint(); err != nil {
+			return 0, fmt.Errorf("seeking to next random access point failed: %w", err)
+		}
+	}
+	return n, nil
+}
+
+// SeekToTime moves a demuxer reading a seekable reader to the last packet that is
+// located before the point in time d, d being relative to the first timestamp of
+// the stream. It bisects on PCRs or, if there are none, on PES timestamps and
+// returns the offset of the packet. The program map learned so far is kept.
+func (dmx *Demuxer) SeekToTime(d time.Duration) (int64, error) {
+	s, ok := dmx.r.(io.Seeker)
+	if !ok {
+		return 0, ErrReaderNotSeekable
+	}
+
+	// Get size
+	size, err := s.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("seeking to end failed: %w", err)
+	}
+
+	// Get first timestamp
+	if _, err = dmx.seekToByte(0); err != nil {
+		return 0, err
+	}
+	first, src, err := dmx.probeTimestamp(nil)
+	if err != nil {
+		return 0, fmt.Errorf("probing first timestamp failed: %w", err)
+	}
+	target := int64(d * 90000 / time.Second)
+
+	// Bisect
+	lo, hi := int64(0), size
+	for hi-lo > int64(dmx.packetBuffer.packetSize) {
+		mid := lo + (hi-lo)/2
+		if _, err = dmx.seekToByte(mid); err != nil {
+			return 0, err
+		}
+
+		var ts int64
+		if ts, _, err = dmx.probeTimestamp(src); err != nil {
+			if !errors.Is(err, ErrNoTimestampFound) {
+				return 0, fmt.Errorf("probing timestamp at offset %d failed: %w", mid, err)
+			}
+			hi = mid
+			continue
+		}
+
+		if (ts-first+clockReferenceBaseModulo)%clockReferenceBaseModulo < target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return dmx.SeekToByte(lo)
+}
+
+// seekToByte realigns the reader on a packet boundary and resets the packet pool.
+func (dmx *Demuxer) seekToByte(off int64) (int64, error) {
+	s, ok := dmx.r.(io.Seeker)
+	if !ok {
+		return 0, ErrReaderNotSeekable
+	}
+
+	// Create packet buffer if not exists, which requires the reader to be at the start.
+	if dmx.packetBuffer == nil {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("seeking to 0 failed: %w", err)
+		}
+		if err := dmx.createPacketBuffer(); err != nil {
+			return 0, fmt.Errorf("creating packet buffer failed: %w", err)
+		}
+	}
+
+	n, err := dmx.packetBuffer.seek(s, off)
+	if err != nil {
+		return 0, fmt.Errorf("seeking packet buffer failed: %w", err)
+	}
+
+	// Reset accumulators
+	dmx.dataBuffer = []*DemuxerData{}
+	dmx.packetPool = newPacketPool(dmx.optPacketsParser, dmx.programMap)
+	dmx.timelines.reset()
+	return n, nil
+}
+
+// seek seeks the reader to the packet boundary located at or before off, and looks for
+// the next sync bytes in case the reader doesn't start with a packet.
+func (pb *packetBuffer) seek(s io.Seeker, off int64) (int64, error) {
+	size := int64(pb.packetSize)
+	if off < 0 {
+		off = 0
+	}
+	aligned := off - off%size
+	pb.unread = nil
+	if _, err := s.Seek(aligned, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seeking to %d failed: %w", aligned, err)
+	}
+
+	// Look for 2 sync bytes separated by the packet size
+	b := make([]byte, 2*size)
+	n, err := io.ReadFull(pb.r, b)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, fmt.Errorf("reading %d bytes failed: %w", len(b), err)
+	}
+	var shift int64
+	for i := int64(0); i < size && i < int64(n); i++ {
+		if b[i] == syncByte && (i+size >= int64(n) || b[i+size] == syncByte) {
+			shift = i
+			break
+		}
+	}
+
+	if _, err = s.Seek(aligned+shift, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seeking to %d failed: %w", aligned+shift, err)
+	}
+	pb.offset = aligned + shift
+	pb.packetIndex = pb.offset / size
+	return pb.offset, nil
+}
+
+// probeTimestamp reads packets until it finds a timestamp from the source. If the source is
+// nil, the first PCR is used, or the first PES DTS or PTS if there is no PCR.
+func (dmx *Demuxer) probeTimestamp(src *seekTimestampSource) (int64, *seekTimestampSource, error) {
+	var fallback *seekTimestampSource
+	var fallbackTimestamp int64
+	for i := 0; i < seekProbeMaxPackets; i++ {
+		p, err := dmx.nextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, nil, fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// PCR
+		if p.Header.HasAdaptationField && p.AdaptationField.HasPCR && (src == nil || (src.isPCR && src.pid == p.Header.PID)) {
+			return p.AdaptationField.PCR.Base, &seekTimestampSource{isPCR: true, pid: p.Header.PID}, nil
+		}
+
+		// PES timestamp
+		if (src != nil && src.isPCR) || (src == nil && fallback != nil) || (src != nil && src.pid != p.Header.PID) {
+			continue
+		}
+		if ts, ok := dmx.packetPESTimestamp(p); ok {
+			if src != nil {
+				return ts, src, nil
+			}
+			fallback = &seekTimestampSource{pid: p.Header.PID}
+			fallbackTimestamp = ts
+		}
+	}
+
+	if fallback != nil {
+		return fallbackTimestamp, fallback, nil
+	}
+	return 0, nil, ErrNoTimestampFound
+}
+
+// packetPESTimestamp returns the DTS, or the PTS if there is no DTS, of the PES starting
+// in the packet.
+func (dmx *Demuxer) packetPESTimestamp(p *Packet) (int64, bool) {
+	h := packetPESOptionalHeader(p, dmx.programMap)
+	switch {
+	case h == nil:
+		return 0, false
+	case h.DTS != nil:
+		return h.DTS.Base, true
+	case h.PTS != nil:
+		return h.PTS.Base, true
+	}
+	return 0, false
+}
+
+// packetPESOptionalHeader returns the optional header of the PES starting in the packet.
+func packetPESOptionalHeader(p *Packet, pm *programMap) *PESOptionalHeader {
+	if !p.Header.PayloadUnitStartIndicator || isPSIPayload(p.Header.PID, pm) || !isPESPayload(p.Payload) {
+		return nil
+	}
+
+	r := bitio.NewCountReader(bytes.NewReader(p.Payload))
+	_ = r.TryReadBits(24) // Packet start code prefix.
+	h, _, _, err := parsePESHeader(r, int64(len(p.Payload)*8))
+	if err != nil {
+		return nil
+	}
+	return h.OptionalHeader
+}
+
+// seekToNextRandomAccessPoint reads packets until it finds a PES of the first known video
+// stream either flagged with the random access indicator or starting with a key frame,
+// and seeks back to its first packet. If there is none before the end of the input, it seeks
+// back to where it started.
+func (dmx *Demuxer) seekToNextRandomAccessPoint() (int64, error) {
+	// Get video stream
+	var pids []int
+	for pid, p := range dmx.esParsers {
+		if p.streamType.IsVideo() {
+			pids = append(pids, int(pid))
+		}
+	}
+	if len(pids) == 0 {
+		return dmx.packetBuffer.offset, nil
+	}
+	sort.Ints(pids)
+	pid := uint16(pids[0])
+	t := dmx.esParsers[pid].streamType
+	isKeyFrame := func(ps []*Packet) bool {
+		ds, err := parseData(ps, nil, dmx.programMap)
+		return err == nil && len(ds) > 0 && ds[0].PES != nil && videoFrameType(t, ds[0].PES.Data) != FrameTypeUnknown
+	}
+
+	start := dmx.packetBuffer.offset
+	var ps []*Packet
+	for {
+		p, err := dmx.nextPacket()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return 0, fmt.Errorf("fetching next packet failed: %w", err)
+			}
+
+			// The last PES is complete
+			if len(ps) > 0 && isKeyFrame(ps) {
+				return dmx.seekToByte(ps[0].Offset)
+			}
+			return dmx.seekToByte(start)
+		}
+
+		if p.Header.PID != pid || !p.Header.HasPayload {
+			continue
+		}
+
+		if !p.Header.PayloadUnitStartIndicator {
+			if len(ps) > 0 {
+				ps = append(ps, p)
+			}
+			continue
+		}
+
+		// Previous PES is complete
+		if len(ps) > 0 && isKeyFrame(ps) {
+			return dmx.seekToByte(ps[0].Offset)
+		}
+
+		// Random access indicator
+		if p.Header.HasAdaptationField && p.AdaptationField.RandomAccessIndicator {
+			return dmx.seekToByte(p.Offset)
+		}
+		ps = []*Packet{p}
+	}
+}
@@ -0,0 +1,150 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+// seekBytes returns 20 H.264 PES, one per second, with a key frame every 5 seconds.
+func seekBytes(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	mx := NewMuxer(context.Background(), w)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+
+	for idx := 0; idx < 20; idx++ {
+		af := &PacketAdaptationField{HasPCR: true, PCR: newClockReference(int64(idx*90000), 0)}
+		data := h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0)
+		if idx%5 == 0 {
+			af.RandomAccessIndicator = true
+			data = h264Bytes(H264NALUnitTypeIDRSlice, 0x88)
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: af,
+			PID:             0x100,
+			PES: &PESData{
+				Data: data,
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(int64(idx*90000+9000), 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+func nextPESPTS(t *testing.T, dmx *Demuxer) int64 {
+	for {
+		d, err := dmx.NextData()
+		if !assert.NoError(t, err) {
+			return -1
+		}
+		if d.PES != nil {
+			return d.PES.Header.OptionalHeader.PTS.Base
+		}
+	}
+}
+
+type nonSeekableReader struct{ io.Reader }
+
+func TestDemuxerSeekToTime(t *testing.T) {
+	b := seekBytes(t)
+
+	// Without a random access point
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b))
+	assert.Equal(t, int64(9000), nextPESPTS(t, dmx))
+	_, err := dmx.SeekToTime(7*time.Second + 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7*90000+9000), nextPESPTS(t, dmx))
+
+	// Before the first timestamp
+	_, err = dmx.SeekToTime(0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9000), nextPESPTS(t, dmx))
+
+	// With a random access point
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(b), DemuxerOptSeekToRandomAccessPoint())
+	assert.Equal(t, int64(9000), nextPESPTS(t, dmx))
+	_, err = dmx.SeekToTime(7*time.Second + 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10*90000+9000), nextPESPTS(t, dmx))
+
+	// No random access point before the end of the input
+	_, err = dmx.SeekToTime(17*time.Second + 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(17*90000+9000), nextPESPTS(t, dmx))
+
+	// Reader is not seekable
+	dmx = NewDemuxer(context.Background(), nonSeekableReader{Reader: bytes.NewReader(b)})
+	_, err = dmx.SeekToTime(time.Second)
+	assert.ErrorIs(t, err, ErrReaderNotSeekable)
+}
+
+func TestDemuxerSeekToByte(t *testing.T) {
+	b := seekBytes(t)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b))
+	n, err := dmx.SeekToByte(3*MpegTsPacketSize + 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3*MpegTsPacketSize), n)
+	p, err := dmx.NextPacket()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), p.Index)
+	assert.Equal(t, int64(3*MpegTsPacketSize), p.Offset)
+}
+
+func TestDemuxerSeekSideEffects(t *testing.T) {
+	b := seekBytes(t)
+	s := NewStats()
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b), DemuxerOptStats(s), DemuxerOptTimeline())
+
+	nextTimelinePTS := func() int64 {
+		for {
+			d, err := dmx.NextData()
+			if !assert.NoError(t, err) {
+				return -1
+			}
+			if d.PES != nil {
+				assert.False(t, d.Timeline.Discontinuity)
+				return d.Timeline.PTS.Base
+			}
+		}
+	}
+	assert.Equal(t, int64(9000), nextTimelinePTS())
+
+	// Packets read while seeking are not counted
+	n := s.Snapshot().Packets
+	_, err := dmx.SeekToTime(12*time.Second + 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, n, s.Snapshot().Packets)
+
+	// Timelines restart after seeking
+	assert.Equal(t, int64(12*90000+9000), nextTimelinePTS())
+	_, err = dmx.SeekToTime(2*time.Second + 500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*90000+9000), nextTimelinePTS())
+	_, err = dmx.Rewind()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9000), nextTimelinePTS())
+}
+
+func TestPacketBufferSeek(t *testing.T) {
+	// Packets don't start at 0
+	r := bytes.NewReader(append([]byte{0x1, 0x2, 0x3}, seekBytes(t)...))
+	pb := &packetBuffer{packetSize: MpegTsPacketSize, r: r}
+	n, err := pb.seek(r, 2*MpegTsPacketSize)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*MpegTsPacketSize+3), n)
+	p, err := pb.next()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*MpegTsPacketSize+3), p.Offset)
+}
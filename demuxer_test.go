@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -62,6 +63,8 @@ func TestDemuxerNextPacket(t *testing.T) {
 	// Second packet
 	p, err = dmx.NextPacket()
 	assert.NoError(t, err)
+	p2.Index = 1
+	p2.Offset = 192
 	assert.Equal(t, p2, p)
 
 	// EOF
@@ -194,3 +197,342 @@ func BenchmarkDemuxer_NextData(b *testing.B) {
 		}
 	}
 }
+
+func TestDemuxerNextDataAdaptationField(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x101, StreamType: StreamTypeMetadata})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x101)
+	_, err = mx.WriteTables()
+	assert.NoError(t, err)
+
+	// PES without adaptation field data
+	for idx := 0; idx < 2; idx++ {
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x100,
+			PES: &PESData{
+				Data: []byte{0x1},
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(int64(idx), 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+
+		// Adaptation only packet on a dedicated PCR PID
+		_, err = mx.WritePacket(&Packet{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(int64(idx), 0)},
+			Header: &PacketHeader{
+				ContinuityCounter:  uint8(idx),
+				HasAdaptationField: true,
+				PID:                0x101,
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	// Without option
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()))
+	for {
+		d, err := dmx.NextData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		assert.Nil(t, d.AdaptationField)
+	}
+
+	// With option
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptAdaptationFieldData())
+	var kinds []string
+	for {
+		d, err := dmx.NextData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		switch {
+		case d.AdaptationField != nil:
+			assert.Equal(t, uint16(0x101), d.PID)
+			assert.Equal(t, d.FirstPacket.Index*MpegTsPacketSize, d.FirstPacket.Offset)
+			kinds = append(kinds, fmt.Sprintf("pcr:%d", d.AdaptationField.PCR.Base))
+		case d.PES != nil:
+			kinds = append(kinds, "pes")
+		}
+	}
+	assert.Equal(t, []string{"pcr:0", "pes", "pcr:1", "pes"}, kinds)
+}
+
+func TestDemuxerNextDataPosition(t *testing.T) {
+	b := seekBytes(t)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b), DemuxerOptDataPositions())
+	var end int64
+	for {
+		d, err := dmx.NextData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		if d.PES == nil {
+			continue
+		}
+		assert.Equal(t, d.FirstPacket.Offset, d.StartOffset)
+		assert.Equal(t, d.FirstPacket.Index, d.PacketIndex)
+		assert.True(t, d.EndOffset > d.StartOffset && d.StartOffset >= end)
+		end = d.EndOffset
+	}
+	assert.Equal(t, int64(len(b)), end)
+}
+
+func TestDemuxerNextDataElementaryStreamError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeADTS})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for _, b := range [][]byte{adtsFrameBytes([]byte{0x1}, true), {0x1, 0x2, 0x3}} {
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x100,
+			PES: &PESData{Data: b, Header: &PESHeader{OptionalHeader: &PESOptionalHeader{}}},
+		})
+		assert.NoError(t, err)
+	}
+
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptParseElementaryStreams())
+	var errs []error
+	for {
+		d, err := dmx.NextData()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		if d.PES != nil {
+			errs = append(errs, d.ElementaryStreamError)
+		}
+	}
+	assert.Len(t, errs, 2)
+	assert.NoError(t, errs[0])
+	assert.ErrorIs(t, errs[1], ErrADTSSyncWordNotFound)
+}
+
+func TestDemuxerPacketData(t *testing.T) {
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(seekBytes(t)), DemuxerOptDataPositions())
+	var pess int
+	for {
+		p, err := dmx.NextPacket()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		for _, d := range dmx.packetData(p) {
+			if d.PES != nil {
+				assert.Equal(t, d.FirstPacket.Offset, d.StartOffset)
+				pess++
+			}
+		}
+	}
+	assert.Equal(t, 19, pess)
+	assert.Equal(t, map[uint16]uint16{0x1000: 1}, dmx.programMap.p)
+	ds := dmx.remainingPacketData()
+	assert.Len(t, ds, 1)
+	assert.NotNil(t, ds[0].PES)
+}
+
+func TestDemuxerResync(t *testing.T) {
+	b := seekBytes(t)
+	c := append(append(append([]byte{}, b[:2*MpegTsPacketSize]...), 0x1, 0x2, 0x3), b[2*MpegTsPacketSize:]...)
+
+	// Without option
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(c), DemuxerOptPacketSize(MpegTsPacketSize))
+	var err error
+	for err == nil {
+		_, err = dmx.NextPacket()
+	}
+	assert.ErrorIs(t, err, ErrPacketStartSyncByte)
+
+	// With option
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(c), DemuxerOptPacketSize(MpegTsPacketSize), DemuxerOptResync())
+	var n int
+	for {
+		_, err = dmx.NextPacket()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		n++
+	}
+	assert.Equal(t, len(b)/MpegTsPacketSize, n)
+	assert.Equal(t, int64(3), dmx.SkippedBytes())
+}
+
+// testProgram describes the program muxed by testProgramBytes.
+type testProgram struct {
+	audioPID  uint16         // No audio if 0
+	count     int            // Number of video PES
+	countdown int            // Index of the video PES carrying a splicing countdown, if positive
+	cues      map[int][]byte // SCTE-35 sections written on PID 0x102 before some video PES
+	data      byte           // Fills PES payloads
+	interval  int64          // Between PES, 90kHz
+	noPCR     bool           // Whether the PCR PID carries no PCR
+	pcr       int64          // Of the first video PES, whose PTS is 100ms later
+	pcrPID    uint16         // Carries PCR only packets, video PID if 0
+	rap       int            // Number of PES between random access points, only the first one if 0
+	videoPID  uint16
+}
+
+// testProgramBytes returns an H264 video PES and, if there is an audio PID, an AAC audio PES
+// every interval.
+func testProgramBytes(t *testing.T, p testProgram) []byte {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: p.videoPID, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	if p.audioPID > 0 {
+		err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: p.audioPID, StreamType: StreamTypeAACAudio})
+		assert.NoError(t, err)
+	}
+	if p.cues != nil {
+		err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x102, StreamType: StreamTypeSCTE35})
+		assert.NoError(t, err)
+	}
+	if p.pcrPID == 0 {
+		p.pcrPID = p.videoPID
+	} else if p.pcrPID != p.videoPID {
+		err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: p.pcrPID, StreamType: StreamTypeMetadata})
+		assert.NoError(t, err)
+	}
+	mx.SetPCRPID(p.pcrPID)
+	pcr := p.pcr
+	var cc uint8
+	for idx := 0; idx < p.count; idx++ {
+		if cue, ok := p.cues[idx]; ok {
+			_, err = mx.WritePacket(&Packet{
+				Header:  &PacketHeader{ContinuityCounter: cc, HasPayload: true, PayloadUnitStartIndicator: true, PID: 0x102},
+				Payload: append([]byte{0x0}, cue...),
+			})
+			assert.NoError(t, err)
+			cc++
+		}
+		af := &PacketAdaptationField{
+			HasSplicingCountdown:  p.countdown > 0 && idx == p.countdown,
+			RandomAccessIndicator: idx == 0 || (p.rap > 0 && idx%p.rap == 0),
+		}
+		switch {
+		case p.noPCR:
+		case p.pcrPID == p.videoPID:
+			af.HasPCR = true
+			af.PCR = newClockReference(pcr, 0)
+		default:
+			pcrAF := newStuffingAdaptationField(MpegTsPacketSize - mpegTsPacketHeaderSize - 1)
+			pcrAF.HasPCR = true
+			pcrAF.PCR = newClockReference(pcr, 0)
+			pcrAF.StuffingLength -= 6
+			_, err = mx.WritePacket(&Packet{AdaptationField: pcrAF, Header: &PacketHeader{HasAdaptationField: true, PID: p.pcrPID}})
+			assert.NoError(t, err)
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: af,
+			PID:             p.videoPID,
+			PES: &PESData{
+				Data: append([]byte{0x0, 0x0, 0x0, 0x1, 0x9, 0xf0}, bytes.Repeat([]byte{p.data}, 300)...),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(pcr+9000, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+		if p.audioPID > 0 {
+			_, err = mx.WriteData(&MuxerData{
+				PID: p.audioPID,
+				PES: &PESData{
+					Data: bytes.Repeat([]byte{p.data}, 100),
+					Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+						PTS:             newClockReference(pcr+9000, 0),
+						PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+					}},
+				},
+			})
+			assert.NoError(t, err)
+		}
+		pcr += p.interval
+	}
+	return buf.Bytes()
+}
+
+// testDemuxResult holds what testDemux found in a stream.
+type testDemuxResult struct {
+	count           int               // Packets
+	data            map[uint16][]byte // First data byte following the access unit delimiter of each PES
+	discontinuities []uint16          // PIDs of the packets having the discontinuity indicator set
+	pat             *PATData
+	pcrs            map[uint16][]int64 // 27MHz
+	pmts            []*PMTData         // Consecutive identical PMTs are only added once
+	pos             map[uint16][]int64 // Packet index of the PCRs
+	programs        map[uint16]*PMTData
+	ptss            map[uint16][]int64
+	sdt             *SDTData
+	versions        []uint8 // Of the PMT, when it changes
+}
+
+// testDemux demuxes a stream made of 188 bytes packets.
+func testDemux(t *testing.T, b []byte) (r testDemuxResult) {
+	assert.Equal(t, 0, len(b)%MpegTsPacketSize)
+	r.count = len(b) / MpegTsPacketSize
+	r.data = make(map[uint16][]byte)
+	r.pcrs = make(map[uint16][]int64)
+	r.pos = make(map[uint16][]int64)
+	r.programs = make(map[uint16]*PMTData)
+	r.ptss = make(map[uint16][]int64)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b))
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		switch {
+		case d.PAT != nil:
+			r.pat = d.PAT
+		case d.PMT != nil:
+			if len(r.pmts) == 0 || !assert.ObjectsAreEqual(r.pmts[len(r.pmts)-1], d.PMT) {
+				r.pmts = append(r.pmts, d.PMT)
+			}
+			r.programs[d.PMT.ProgramNumber] = d.PMT
+			if v := d.FirstPacket.Payload[6] >> 1 & 0x1f; len(r.versions) == 0 || r.versions[len(r.versions)-1] != v {
+				r.versions = append(r.versions, v)
+			}
+		case d.SDT != nil:
+			r.sdt = d.SDT
+		case d.PES != nil:
+			r.ptss[d.PID] = append(r.ptss[d.PID], d.PES.Header.OptionalHeader.PTS.Base)
+			if len(d.PES.Data) > 6 {
+				r.data[d.PID] = append(r.data[d.PID], d.PES.Data[6])
+			}
+		}
+	}
+
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(b))
+	for {
+		p, err := dmx.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if !p.Header.HasAdaptationField {
+			continue
+		}
+		if p.AdaptationField.DiscontinuityIndicator {
+			r.discontinuities = append(r.discontinuities, p.Header.PID)
+		}
+		if p.AdaptationField.HasPCR {
+			r.pcrs[p.Header.PID] = append(r.pcrs[p.Header.PID], p.AdaptationField.PCR.Base*300+p.AdaptationField.PCR.Extension)
+			r.pos[p.Header.PID] = append(r.pos[p.Header.PID], p.Offset/MpegTsPacketSize)
+		}
+	}
+	return
+}
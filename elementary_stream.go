@@ -0,0 +1,89 @@
+package astits
+
+import "fmt"
+
+// descriptorTagDTS is the tag of the DVB DTS descriptor, which we don't parse.
+const descriptorTagDTS = 0x7b
+
+// elementaryStreamParser parses the elementary stream carried by the PES
+// data of a single PID and keeps the state spanning over several PES data.
+type elementaryStreamParser struct {
+	latm       *AACLATMParser
+	streamType StreamType
+}
+
+// newElementaryStreamParser creates a new elementary stream parser for a stream type.
+func newElementaryStreamParser(t StreamType) *elementaryStreamParser {
+	p := &elementaryStreamParser{streamType: t}
+	if t == StreamTypeAACLATMAudio {
+		p.latm = NewAACLATMParser()
+	}
+	return p
+}
+
+// elementaryStreamType returns the stream type of the elementary stream. DVB signals
+// AC-3, E-AC-3 and DTS streams with a private data stream type and a descriptor, in
+// which case the returned stream type is the one ATSC would have used.
+func elementaryStreamType(es *PMTElementaryStream) StreamType {
+	if es.StreamType != StreamTypePrivateData {
+		return es.StreamType
+	}
+	for _, d := range es.ElementaryStreamDescriptors {
+		switch d.Tag {
+		case DescriptorTagAC3:
+			return StreamTypeAC3Audio
+		case DescriptorTagEnhancedAC3:
+			return StreamTypeEAC3Audio
+		case descriptorTagDTS:
+			return StreamTypeDTSAudio
+		}
+	}
+	return es.StreamType
+}
+
+// parse parses the elementary stream carried by the PES data according to its stream type.
+func (p *elementaryStreamParser) parse(d *DemuxerData) (err error) {
+	switch p.streamType {
+	case StreamTypeADTS:
+		if d.AAC, err = ParseADTSData(d.PES.Data, pesPTS(d.PES)); err != nil {
+			return fmt.Errorf("parsing ADTS data failed: %w", err)
+		}
+	case StreamTypeAACLATMAudio:
+		if d.AAC, err = p.latm.Parse(d.PES.Data, pesPTS(d.PES)); err != nil {
+			return fmt.Errorf("parsing LATM data failed: %w", err)
+		}
+	case StreamTypeAC3Audio, StreamTypeEAC3Audio:
+		if d.AC3, err = ParseAC3Data(d.PES.Data, pesPTS(d.PES)); err != nil {
+			return fmt.Errorf("parsing AC-3 data failed: %w", err)
+		}
+	case StreamTypeDTSAudio:
+		if d.DTS, err = ParseDTSData(d.PES.Data, pesPTS(d.PES)); err != nil {
+			return fmt.Errorf("parsing DTS data failed: %w", err)
+		}
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio:
+		if d.MPEGAudio, err = ParseMPEGAudioData(d.PES.Data, pesPTS(d.PES)); err != nil {
+			return fmt.Errorf("parsing MPEG audio data failed: %w", err)
+		}
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+		if d.MPEG2Video, err = ParseMPEG2VideoData(d.PES.Data); err != nil {
+			return fmt.Errorf("parsing MPEG-2 video data failed: %w", err)
+		}
+	case StreamTypeH264Video:
+		if d.H264, err = ParseH264Data(d.PES.Data); err != nil {
+			return fmt.Errorf("parsing H264 data failed: %w", err)
+		}
+	case StreamTypeH265Video:
+		if d.HEVC, err = ParseHEVCData(d.PES.Data); err != nil {
+			return fmt.Errorf("parsing HEVC data failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// pesPTS returns the PTS of the PES data if any.
+func pesPTS(d *PESData) *ClockReference {
+	if d.Header == nil || d.Header.OptionalHeader == nil {
+		return nil
+	}
+	return d.Header.OptionalHeader.PTS
+}
@@ -0,0 +1,92 @@
+package astits
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElementaryStreamParser(t *testing.T) {
+	pes := func(b []byte) *DemuxerData {
+		return &DemuxerData{PES: &PESData{
+			Data: b,
+			Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+				PTS:             newClockReference(10, 0),
+				PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+			}},
+		}}
+	}
+
+	// ADTS
+	p := newElementaryStreamParser(StreamTypeADTS)
+	d := pes(adtsFrameBytes([]byte{0x1}, true))
+	assert.NoError(t, p.parse(d))
+	assert.Len(t, d.AAC.Frames, 1)
+	assert.Equal(t, int64(10), d.AAC.Frames[0].PTS.Base)
+
+	// LATM config is kept between PES
+	p = newElementaryStreamParser(StreamTypeAACLATMAudio)
+	d = pes(loasFrameBytes(true, []byte{0x1}))
+	assert.NoError(t, p.parse(d))
+	assert.Len(t, d.AAC.Frames, 1)
+	d = pes(loasFrameBytes(false, []byte{0x2}))
+	assert.NoError(t, p.parse(d))
+	assert.Equal(t, []byte{0x2}, d.AAC.Frames[0].Data)
+
+	// AC-3
+	p = newElementaryStreamParser(StreamTypeEAC3Audio)
+	d = pes(eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, true))
+	assert.NoError(t, p.parse(d))
+	assert.True(t, d.AC3.HasJOC())
+
+	// DTS
+	p = newElementaryStreamParser(StreamTypeDTSAudio)
+	d = pes(dtsCoreBytes(false))
+	assert.NoError(t, p.parse(d))
+	assert.Len(t, d.DTS.Frames, 1)
+
+	// MPEG audio
+	p = newElementaryStreamParser(StreamTypeMPEG1Audio)
+	d = pes(mpegAudioFrameBytes(MPEGAudioVersion1, MPEGAudioLayer2, 10, 1, false, 576))
+	assert.NoError(t, p.parse(d))
+	assert.Len(t, d.MPEGAudio.Frames, 1)
+
+	// MPEG-2 video
+	p = newElementaryStreamParser(StreamTypeMPEG2Video)
+	d = pes(mpeg2VideoIFrameBytes())
+	assert.NoError(t, p.parse(d))
+	assert.True(t, d.MPEG2Video.IsKeyFrame())
+
+	// H264
+	p = newElementaryStreamParser(StreamTypeH264Video)
+	d = pes(annexB(h264SPSBytes(), h264PPSBytes(), h264IDRBytes()))
+	assert.NoError(t, p.parse(d))
+	assert.True(t, d.H264.IsIDR())
+
+	// HEVC
+	p = newElementaryStreamParser(StreamTypeH265Video)
+	d = pes(hevcBytes())
+	assert.NoError(t, p.parse(d))
+	assert.True(t, d.HEVC.IsIRAP())
+
+	// Errors
+	p = newElementaryStreamParser(StreamTypeADTS)
+	assert.Error(t, p.parse(pes([]byte{0x1})))
+}
+
+func TestElementaryStreamType(t *testing.T) {
+	assert.Equal(t, StreamTypeH265Video, elementaryStreamType(&PMTElementaryStream{StreamType: StreamTypeH265Video}))
+	assert.Equal(t, StreamTypePrivateData, elementaryStreamType(&PMTElementaryStream{StreamType: StreamTypePrivateData}))
+	assert.Equal(t, StreamTypeAC3Audio, elementaryStreamType(&PMTElementaryStream{
+		ElementaryStreamDescriptors: []*Descriptor{{Tag: DescriptorTagAC3}},
+		StreamType:                  StreamTypePrivateData,
+	}))
+	assert.Equal(t, StreamTypeEAC3Audio, elementaryStreamType(&PMTElementaryStream{
+		ElementaryStreamDescriptors: []*Descriptor{{Tag: DescriptorTagStreamIdentifier}, {Tag: DescriptorTagEnhancedAC3}},
+		StreamType:                  StreamTypePrivateData,
+	}))
+	assert.Equal(t, StreamTypeDTSAudio, elementaryStreamType(&PMTElementaryStream{
+		ElementaryStreamDescriptors: []*Descriptor{{Tag: descriptorTagDTS}},
+		StreamType:                  StreamTypePrivateData,
+	}))
+}
@@ -0,0 +1,845 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/icza/bitio"
+)
+
+// Default fMP4 remuxer options.
+const defaultFMP4FragmentDuration = 2 * time.Second
+
+// fMP4 sample flags.
+// Chapter: 8.8.3.1 | Link: https://www.iso.org/standard/83102.html
+const (
+	fmp4SampleFlagsSync    = 0x02000000 // sample_depends_on is 2
+	fmp4SampleFlagsNonSync = 0x01010000 // sample_depends_on is 1 and sample_is_non_sync_sample is set
+)
+
+// fMP4 box flags.
+// Chapter: 8.8.7.1 and 8.8.8.1 | Link: https://www.iso.org/standard/83102.html
+const (
+	fmp4TfhdDefaultBaseIsMoof                   = 0x20000
+	fmp4TrunDataOffsetPresent                   = 0x1
+	fmp4TrunSampleDurationPresent               = 0x100
+	fmp4TrunSampleSizePresent                   = 0x200
+	fmp4TrunSampleFlagsPresent                  = 0x400
+	fmp4TrunSampleCompositionTimeOffsetsPresent = 0x800
+)
+
+// mp4LanguageUndetermined is the packed ISO-639-2/T "und" language code.
+const mp4LanguageUndetermined = 0x55c4
+
+// mp4Matrix is the unity matrix of mvhd and tkhd boxes.
+var mp4Matrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+// ErrFMP4NoTrack is returned when no stream can be remuxed.
+var ErrFMP4NoTrack = errors.New("no H264, HEVC, AAC, AC-3 or E-AC-3 stream found")
+
+// FMP4Remuxer remuxes the PES data of the first program of a transport stream into a
+// fragmented MP4 stream compatible with CMAF and DASH: an init segment (ftyp and moov)
+// followed by media fragments (moof and mdat).
+//
+// H264, HEVC, AAC (ADTS and LATM), AC-3 and E-AC-3 streams are supported. Codec
+// configurations are built out of the parameter sets and frame headers found in the
+// streams, which is why the init segment is only written once the first fragment is
+// complete. Streams whose configuration is unknown by then are left out. Parameter sets
+// are expected not to change during the stream.
+//
+// Fragments start at the key frames of the first video stream, or at any frame of the
+// first stream if there is no video, once the fragment duration has been reached.
+//
+// Data must be provided in the order of the demuxer, preferably created with
+// DemuxerOptTimeline so that timestamps don't wrap.
+type FMP4Remuxer struct {
+	fragmentStart       *int64 // 90kHz
+	initWritten         bool
+	optFragmentDuration time.Duration
+	optInitWriter       io.Writer
+	origin              int64 // 90kHz DTS mapped to a decode time of 0
+	primary             *fmp4Track
+	sequence            uint32
+	tracks              []*fmp4Track
+	w                   io.Writer
+}
+
+type fmp4Track struct {
+	channels     int
+	entry        []byte // Sample entry, nil until the codec configuration is known
+	hasNextDTS   bool
+	height       int
+	id           uint32
+	lastDuration int64
+	nextDTS      int64 // In timescale, used by audio tracks
+	parser       *elementaryStreamParser
+	pending      *fmp4Sample // Video sample waiting for the next one to know its duration
+	pid          uint16
+	samples      []*fmp4Sample
+	streamType   StreamType
+	synced       bool
+	timescale    uint32
+	width        int
+}
+
+type fmp4TrackSamples struct {
+	samples []*fmp4Sample
+	track   *fmp4Track
+}
+
+type fmp4Sample struct {
+	cto        int64 // Composition time offset, in timescale
+	data       []byte
+	decodeTime int64 // In timescale, set when the sample is written
+	dts        int64 // 90kHz
+	duration   int64 // In timescale
+	sync       bool
+}
+
+// FMP4RemuxerOptFragmentDuration returns the option to set the minimum duration of the
+// fragments. Default is 2s. With 0, fragments start at every key frame.
+func FMP4RemuxerOptFragmentDuration(d time.Duration) func(*FMP4Remuxer) {
+	return func(r *FMP4Remuxer) {
+		r.optFragmentDuration = d
+	}
+}
+
+// FMP4RemuxerOptInitWriter returns the option to write the init segment to its own writer,
+// as DASH and CMAF deliveries require. By default, it is written before the fragments.
+func FMP4RemuxerOptInitWriter(w io.Writer) func(*FMP4Remuxer) {
+	return func(r *FMP4Remuxer) {
+		r.optInitWriter = w
+	}
+}
+
+// NewFMP4Remuxer creates a new fMP4 remuxer.
+func NewFMP4Remuxer(w io.Writer, opts ...func(*FMP4Remuxer)) *FMP4Remuxer {
+	r := &FMP4Remuxer{
+		optFragmentDuration: defaultFMP4FragmentDuration,
+		w:                   w,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.optInitWriter == nil {
+		r.optInitWriter = w
+	}
+	return r
+}
+
+// Remux processes a data. Elementary streams are parsed unless the demuxer already did.
+func (r *FMP4Remuxer) Remux(d *DemuxerData) error {
+	switch {
+	case d.PMT != nil:
+		return r.updatePMT(d.PMT)
+	case d.PES != nil:
+		for _, t := range r.tracks {
+			if t.pid == d.PID {
+				return r.remuxPES(t, d)
+			}
+		}
+	}
+	return nil
+}
+
+// Close writes the remaining samples.
+func (r *FMP4Remuxer) Close() error {
+	// Video samples waiting for their duration are given the one of the previous sample
+	for _, t := range r.tracks {
+		if t.pending != nil {
+			t.pending.duration = t.lastDuration
+			t.samples = append(t.samples, t.pending)
+			t.pending = nil
+		}
+	}
+	if err := r.flush(math.MaxInt64); err != nil {
+		return fmt.Errorf("flushing failed: %w", err)
+	}
+	if !r.initWritten {
+		return ErrFMP4NoTrack
+	}
+	return nil
+}
+
+func (r *FMP4Remuxer) updatePMT(pmt *PMTData) error {
+	// Only the first program is remuxed
+	if r.tracks != nil {
+		return nil
+	}
+
+	// Create tracks
+	for _, es := range pmt.ElementaryStreams {
+		t := elementaryStreamType(es)
+		switch t {
+		case StreamTypeH264Video, StreamTypeH265Video, StreamTypeADTS, StreamTypeAACLATMAudio,
+			StreamTypeAC3Audio, StreamTypeEAC3Audio:
+		default:
+			continue
+		}
+		tr := &fmp4Track{
+			id:         uint32(len(r.tracks) + 1),
+			parser:     newElementaryStreamParser(t),
+			pid:        es.ElementaryPID,
+			streamType: t,
+		}
+		if t.IsVideo() {
+			tr.timescale = 90000
+			if r.primary == nil || !r.primary.streamType.IsVideo() {
+				r.primary = tr
+			}
+		}
+		r.tracks = append(r.tracks, tr)
+	}
+	if len(r.tracks) == 0 {
+		return ErrFMP4NoTrack
+	}
+	if r.primary == nil {
+		r.primary = r.tracks[0]
+	}
+	return nil
+}
+
+func (r *FMP4Remuxer) remuxPES(t *fmp4Track, d *DemuxerData) (err error) {
+	// Get timestamps
+	h := d.PES.Header
+	if h == nil || h.OptionalHeader == nil || h.OptionalHeader.PTS == nil {
+		return nil
+	}
+	pts, dts := h.OptionalHeader.PTS, h.OptionalHeader.DTS
+	if d.Timeline != nil && d.Timeline.PTS != nil {
+		pts, dts = d.Timeline.PTS, d.Timeline.DTS
+	}
+	if dts == nil {
+		dts = pts
+	}
+	// Frame timestamps are computed out of the PES PTS before unwrapping
+	offset := pts.Base - h.OptionalHeader.PTS.Base
+
+	// Parse elementary stream
+	if d.H264 == nil && d.HEVC == nil && d.AAC == nil && d.AC3 == nil {
+		pd := *d
+		if err = t.parser.parse(&pd); err != nil {
+			return fmt.Errorf("parsing elementary stream failed: %w", err)
+		}
+		d = &pd
+	}
+
+	// Build samples
+	switch {
+	case d.H264 != nil:
+		return r.addSample(t, t.h264Sample(d.H264, pts.Base, dts.Base))
+	case d.HEVC != nil:
+		return r.addSample(t, t.hevcSample(d.HEVC, pts.Base, dts.Base))
+	case d.AAC != nil:
+		for _, f := range d.AAC.Frames {
+			if err = r.addSample(t, t.aacSample(f, offset)); err != nil {
+				return err
+			}
+		}
+	case d.AC3 != nil:
+		for _, s := range t.ac3Samples(d.AC3, offset) {
+			if err = r.addSample(t, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *fmp4Track) h264Sample(d *H264Data, pts, dts int64) *fmp4Sample {
+	s := &fmp4Sample{cto: pts - dts, dts: dts, sync: d.IsIDR()}
+	var sps *H264NALUnit
+	var pps []byte
+	for _, n := range d.NALUnits {
+		switch n.Type {
+		case H264NALUnitTypeSPS:
+			if sps == nil {
+				sps = n
+			}
+		case H264NALUnitTypePPS:
+			if pps == nil {
+				pps = n.Data
+			}
+		case H264NALUnitTypeAUD, H264NALUnitTypeFD, H264NALUnitTypeSPSExt:
+		default:
+			s.data = appendLengthPrefixedNALUnit(s.data, n.Data)
+		}
+	}
+	if t.entry == nil && sps != nil && pps != nil {
+		t.width, t.height = sps.SPS.Width(), sps.SPS.Height()
+		t.entry = mp4VisualSampleEntry("avc1", t.width, t.height, mp4AVCConfigurationBox(sps.SPS, sps.Data, pps))
+	}
+	return s
+}
+
+func (t *fmp4Track) hevcSample(d *HEVCData, pts, dts int64) *fmp4Sample {
+	s := &fmp4Sample{cto: pts - dts, dts: dts, sync: d.IsIRAP()}
+	var sps *HEVCNALUnit
+	ps := make(map[HEVCNALUnitType][]byte)
+	for _, n := range d.NALUnits {
+		switch n.Type {
+		case HEVCNALUnitTypeVPS, HEVCNALUnitTypeSPS, HEVCNALUnitTypePPS:
+			if _, ok := ps[n.Type]; !ok {
+				ps[n.Type] = n.Data
+			}
+			if sps == nil && n.SPS != nil {
+				sps = n
+			}
+		case HEVCNALUnitTypeAUD, HEVCNALUnitTypeFD:
+		default:
+			s.data = appendLengthPrefixedNALUnit(s.data, n.Data)
+		}
+	}
+	if t.entry == nil && sps != nil && len(ps) == 3 {
+		t.width, t.height = sps.SPS.Width(), sps.SPS.Height()
+		t.entry = mp4VisualSampleEntry("hvc1", t.width, t.height, mp4HEVCConfigurationBox(sps.SPS, ps))
+	}
+	return s
+}
+
+func (t *fmp4Track) aacSample(f *AACFrame, offset int64) *fmp4Sample {
+	if t.entry == nil && f.Config != nil && f.Config.SamplingFrequency > 0 {
+		t.channels = f.Config.Channels
+		t.timescale = uint32(f.Config.SamplingFrequency)
+		t.entry = mp4AudioSampleEntry("mp4a", t.channels, f.Config.SamplingFrequency, mp4ESDescriptorBox(f.Config))
+	}
+	s := &fmp4Sample{data: f.Data, sync: true}
+	if f.Config != nil {
+		s.duration = int64(f.Config.FrameSamples())
+	}
+	if f.PTS != nil {
+		s.dts = f.PTS.Base + offset
+	}
+	return s
+}
+
+// ac3Samples returns a sample per access unit, which is made of an independent sync frame
+// followed by its E-AC-3 dependent substreams.
+func (t *fmp4Track) ac3Samples(d *AC3Data, offset int64) (ss []*fmp4Sample) {
+	if t.entry == nil && len(d.Frames) > 0 && d.Frames[0].SampleRate > 0 {
+		f := d.Frames[0]
+		t.channels = d.Channels()
+		t.timescale = uint32(f.SampleRate)
+		if f.IsEAC3 {
+			t.entry = mp4AudioSampleEntry("ec-3", t.channels, f.SampleRate, mp4EAC3SpecificBox(d))
+		} else {
+			t.entry = mp4AudioSampleEntry("ac-3", t.channels, f.SampleRate, mp4AC3SpecificBox(f))
+		}
+	}
+	for _, f := range d.Frames {
+		if f.IsEAC3 && f.StreamType == EAC3StreamTypeDependent && len(ss) > 0 {
+			ss[len(ss)-1].data = append(ss[len(ss)-1].data, f.Data...)
+			continue
+		}
+		s := &fmp4Sample{
+			data:     append([]byte{}, f.Data...),
+			duration: int64(f.NumBlocks * 256),
+			sync:     true,
+		}
+		if f.PTS != nil {
+			s.dts = f.PTS.Base + offset
+		}
+		ss = append(ss, s)
+	}
+	return
+}
+
+func (r *FMP4Remuxer) addSample(t *fmp4Track, s *fmp4Sample) error {
+	// Video samples need the next sample to know their duration
+	if t.streamType.IsVideo() {
+		if !t.synced && !s.sync {
+			return nil
+		}
+		t.synced = true
+		if p := t.pending; p != nil {
+			if p.duration = s.dts - p.dts; p.duration <= 0 {
+				p.duration = t.lastDuration
+			}
+			t.lastDuration = p.duration
+			t.samples = append(t.samples, p)
+		}
+	}
+
+	// Cut fragment
+	if t == r.primary && (s.sync || !t.streamType.IsVideo()) {
+		if r.fragmentStart == nil {
+			r.fragmentStart = &s.dts
+		} else if clockReferenceBaseDuration(s.dts-*r.fragmentStart) >= r.optFragmentDuration {
+			if err := r.flush(s.dts); err != nil {
+				return fmt.Errorf("flushing failed: %w", err)
+			}
+		}
+	}
+
+	if t.streamType.IsVideo() {
+		t.pending = s
+	} else {
+		t.samples = append(t.samples, s)
+	}
+	return nil
+}
+
+// flush writes the samples whose DTS is before end in a fragment, and the init segment
+// before the first fragment.
+func (r *FMP4Remuxer) flush(end int64) (err error) {
+	// Init segment
+	if !r.initWritten {
+		if err = r.writeInit(); err != nil {
+			return fmt.Errorf("writing init segment failed: %w", err)
+		}
+		if !r.initWritten {
+			return nil
+		}
+	}
+
+	// Get samples
+	var fs []fmp4TrackSamples
+	for _, t := range r.tracks {
+		var idx int
+		for idx < len(t.samples) && t.samples[idx].dts < end {
+			idx++
+		}
+		if idx == 0 {
+			continue
+		}
+		ss := t.samples[:idx]
+		t.samples = t.samples[idx:]
+		for _, s := range ss {
+			if t.streamType.IsVideo() {
+				s.decodeTime = s.dts - r.origin
+			} else {
+				// Audio decode times are accumulated so that rounding doesn't create gaps
+				if !t.hasNextDTS {
+					t.hasNextDTS = true
+					t.nextDTS = (s.dts - r.origin) * int64(t.timescale) / 90000
+				}
+				s.decodeTime = t.nextDTS
+				t.nextDTS += s.duration
+			}
+		}
+		fs = append(fs, fmp4TrackSamples{samples: ss, track: t})
+	}
+	if len(fs) == 0 {
+		return nil
+	}
+	r.sequence++
+	if end != math.MaxInt64 {
+		r.fragmentStart = &end
+	}
+
+	// Build moof twice since data offsets depend on its size
+	buildMoof := func(offset int) []byte {
+		bs := [][]byte{mp4FullBox("mfhd", 0, 0, mp4Fields(func(w *bitio.Writer) {
+			w.TryWriteBits(uint64(r.sequence), 32)
+		}))}
+		for _, f := range fs {
+			bs = append(bs, f.track.traf(f.samples, offset))
+			for _, s := range f.samples {
+				offset += len(s.data)
+			}
+		}
+		return mp4Box("moof", bs...)
+	}
+	moof := buildMoof(0)
+	moof = buildMoof(len(moof) + 8)
+
+	// Write
+	var mdat [][]byte
+	for _, f := range fs {
+		for _, s := range f.samples {
+			mdat = append(mdat, s.data)
+		}
+	}
+	if _, err = r.w.Write(append(moof, mp4Box("mdat", mdat...)...)); err != nil {
+		return fmt.Errorf("writing fragment failed: %w", err)
+	}
+	return nil
+}
+
+func (r *FMP4Remuxer) writeInit() error {
+	// Tracks whose configuration is unknown are left out
+	var ts []*fmp4Track
+	for _, t := range r.tracks {
+		if t.entry != nil {
+			ts = append(ts, t)
+		}
+	}
+	r.tracks = ts
+	if len(ts) == 0 {
+		return nil
+	}
+
+	// Samples are timed relative to the first one
+	r.origin = math.MaxInt64
+	for _, t := range ts {
+		if len(t.samples) > 0 && t.samples[0].dts < r.origin {
+			r.origin = t.samples[0].dts
+		}
+	}
+	if r.origin == math.MaxInt64 {
+		r.origin = 0
+	}
+
+	// Build boxes
+	var nextTrackID uint32
+	var traks, trexs [][]byte
+	for _, t := range ts {
+		traks = append(traks, t.trak())
+		trexs = append(trexs, mp4FullBox("trex", 0, 0, mp4Fields(func(w *bitio.Writer) {
+			w.TryWriteBits(uint64(t.id), 32)
+			w.TryWriteBits(1, 32) // Default sample description index
+			w.TryWriteBits(0, 32) // Default sample duration
+			w.TryWriteBits(0, 32) // Default sample size
+			w.TryWriteBits(0, 32) // Default sample flags
+		})))
+		if t.id >= nextTrackID {
+			nextTrackID = t.id + 1
+		}
+	}
+	mvhd := mp4FullBox("mvhd", 0, 0, mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteBits(0, 32)          // Creation time
+		w.TryWriteBits(0, 32)          // Modification time
+		w.TryWriteBits(1000, 32)       // Timescale
+		w.TryWriteBits(0, 32)          // Duration
+		w.TryWriteBits(0x00010000, 32) // Rate
+		w.TryWriteBits(0x0100, 16)     // Volume
+		w.TryWriteBits(0, 16)          // Reserved
+		w.TryWriteBits(0, 64)          // Reserved
+		writeMP4Matrix(w)
+		w.TryWrite(make([]byte, 24)) // Pre defined
+		w.TryWriteBits(uint64(nextTrackID), 32)
+	}))
+	moov := mp4Box("moov", append(append([][]byte{mvhd}, traks...), mp4Box("mvex", trexs...))...)
+	ftyp := mp4Box("ftyp", []byte("iso6"), []byte{0, 0, 0, 0}, []byte("iso6cmfcdashmp41"))
+
+	// Write
+	if _, err := r.optInitWriter.Write(append(ftyp, moov...)); err != nil {
+		return fmt.Errorf("writing failed: %w", err)
+	}
+	r.initWritten = true
+	return nil
+}
+
+func (t *fmp4Track) trak() []byte {
+	// Handler
+	handler, name := "soun", "SoundHandler"
+	var volume uint64 = 0x0100
+	mediaHeader := mp4FullBox("smhd", 0, 0, []byte{0, 0, 0, 0})
+	if t.streamType.IsVideo() {
+		handler, name = "vide", "VideoHandler"
+		volume = 0
+		mediaHeader = mp4FullBox("vmhd", 0, 1, make([]byte, 8))
+	}
+
+	tkhd := mp4FullBox("tkhd", 0, 3, mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteBits(0, 32) // Creation time
+		w.TryWriteBits(0, 32) // Modification time
+		w.TryWriteBits(uint64(t.id), 32)
+		w.TryWriteBits(0, 32) // Reserved
+		w.TryWriteBits(0, 32) // Duration
+		w.TryWriteBits(0, 64) // Reserved
+		w.TryWriteBits(0, 16) // Layer
+		w.TryWriteBits(0, 16) // Alternate group
+		w.TryWriteBits(volume, 16)
+		w.TryWriteBits(0, 16) // Reserved
+		writeMP4Matrix(w)
+		w.TryWriteBits(uint64(t.width)<<16, 32)
+		w.TryWriteBits(uint64(t.height)<<16, 32)
+	}))
+	mdhd := mp4FullBox("mdhd", 0, 0, mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteBits(0, 32) // Creation time
+		w.TryWriteBits(0, 32) // Modification time
+		w.TryWriteBits(uint64(t.timescale), 32)
+		w.TryWriteBits(0, 32) // Duration
+		w.TryWriteBits(mp4LanguageUndetermined, 16)
+		w.TryWriteBits(0, 16) // Pre defined
+	}))
+	hdlr := mp4FullBox("hdlr", 0, 0, []byte{0, 0, 0, 0}, []byte(handler), make([]byte, 12), []byte(name), []byte{0})
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, []byte{0, 0, 0, 1}, mp4FullBox("url ", 0, 1)))
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, t.entry),
+		mp4FullBox("stts", 0, 0, []byte{0, 0, 0, 0}),
+		mp4FullBox("stsc", 0, 0, []byte{0, 0, 0, 0}),
+		mp4FullBox("stsz", 0, 0, make([]byte, 8)),
+		mp4FullBox("stco", 0, 0, []byte{0, 0, 0, 0}),
+	)
+	return mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, mp4Box("minf", mediaHeader, dinf, stbl)))
+}
+
+// traf builds the track fragment of samples whose data starts at an offset from the
+// beginning of the moof box.
+func (t *fmp4Track) traf(ss []*fmp4Sample, offset int) []byte {
+	video := t.streamType.IsVideo()
+	flags := uint32(fmp4TrunDataOffsetPresent | fmp4TrunSampleDurationPresent | fmp4TrunSampleSizePresent | fmp4TrunSampleFlagsPresent)
+	if video {
+		flags |= fmp4TrunSampleCompositionTimeOffsetsPresent
+	}
+	return mp4Box("traf",
+		mp4FullBox("tfhd", 0, fmp4TfhdDefaultBaseIsMoof, mp4Fields(func(w *bitio.Writer) {
+			w.TryWriteBits(uint64(t.id), 32)
+		})),
+		mp4FullBox("tfdt", 1, 0, mp4Fields(func(w *bitio.Writer) {
+			w.TryWriteBits(uint64(ss[0].decodeTime), 64)
+		})),
+		mp4FullBox("trun", 1, flags, mp4Fields(func(w *bitio.Writer) {
+			w.TryWriteBits(uint64(len(ss)), 32)
+			w.TryWriteBits(uint64(uint32(offset)), 32)
+			for _, s := range ss {
+				w.TryWriteBits(uint64(s.duration), 32)
+				w.TryWriteBits(uint64(len(s.data)), 32)
+				if s.sync {
+					w.TryWriteBits(fmp4SampleFlagsSync, 32)
+				} else {
+					w.TryWriteBits(fmp4SampleFlagsNonSync, 32)
+				}
+				if video {
+					w.TryWriteBits(uint64(uint32(int32(s.cto))), 32)
+				}
+			}
+		})),
+	)
+}
+
+// mp4VisualSampleEntry builds a visual sample entry.
+// Chapter: 12.1.3 | Link: https://www.iso.org/standard/83102.html
+func mp4VisualSampleEntry(typ string, width, height int, config []byte) []byte {
+	return mp4Box(typ, mp4Fields(func(w *bitio.Writer) {
+		w.TryWrite(make([]byte, 6)) // Reserved
+		w.TryWriteBits(1, 16)       // Data reference index
+		w.TryWrite(make([]byte, 16))
+		w.TryWriteBits(uint64(width), 16)
+		w.TryWriteBits(uint64(height), 16)
+		w.TryWriteBits(0x00480000, 32) // Horizontal resolution
+		w.TryWriteBits(0x00480000, 32) // Vertical resolution
+		w.TryWriteBits(0, 32)          // Reserved
+		w.TryWriteBits(1, 16)          // Frame count
+		w.TryWrite(make([]byte, 32))   // Compressor name
+		w.TryWriteBits(0x0018, 16)     // Depth
+		w.TryWriteBits(0xffff, 16)     // Pre defined
+	}), config)
+}
+
+// mp4AudioSampleEntry builds an audio sample entry.
+// Chapter: 12.2.3 | Link: https://www.iso.org/standard/83102.html
+func mp4AudioSampleEntry(typ string, channels, sampleRate int, config []byte) []byte {
+	return mp4Box(typ, mp4Fields(func(w *bitio.Writer) {
+		w.TryWrite(make([]byte, 6)) // Reserved
+		w.TryWriteBits(1, 16)       // Data reference index
+		w.TryWriteBits(0, 64)       // Reserved
+		w.TryWriteBits(uint64(channels), 16)
+		w.TryWriteBits(16, 16) // Sample size
+		w.TryWriteBits(0, 32)  // Pre defined and reserved
+		w.TryWriteBits(uint64(sampleRate)<<16&0xffffffff, 32)
+	}), config)
+}
+
+// mp4AVCConfigurationBox builds an avcC box.
+// Chapter: 5.3.3.1 | Link: https://www.iso.org/standard/83336.html
+func mp4AVCConfigurationBox(sps *H264SPS, spsData, ppsData []byte) []byte {
+	return mp4Box("avcC", mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteByte(1) // Configuration version
+		w.TryWriteByte(sps.ProfileIDC)
+		w.TryWriteByte(sps.ConstraintFlags)
+		w.TryWriteByte(sps.LevelIDC)
+		w.TryWriteByte(0xfc | 3) // Length size minus one
+		w.TryWriteByte(0xe0 | 1) // Number of SPS
+		w.TryWriteBits(uint64(len(spsData)), 16)
+		w.TryWrite(spsData)
+		w.TryWriteByte(1) // Number of PPS
+		w.TryWriteBits(uint64(len(ppsData)), 16)
+		w.TryWrite(ppsData)
+		switch sps.ProfileIDC {
+		case 100, 110, 122, 144:
+			w.TryWriteByte(0xfc | byte(sps.ChromaFormatIDC))
+			w.TryWriteByte(0xf8 | byte(sps.BitDepthLumaMinus8))
+			w.TryWriteByte(0xf8 | byte(sps.BitDepthChromaMinus8))
+			w.TryWriteByte(0) // Number of SPS extensions
+		}
+	}))
+}
+
+// mp4HEVCConfigurationBox builds an hvcC box.
+// Chapter: 8.3.3.1 | Link: https://www.iso.org/standard/83336.html
+func mp4HEVCConfigurationBox(sps *HEVCSPS, ps map[HEVCNALUnitType][]byte) []byte {
+	return mp4Box("hvcC", mp4Fields(func(w *bitio.Writer) {
+		ptl := sps.ProfileTierLevel
+		w.TryWriteByte(1) // Configuration version
+		w.TryWriteBits(uint64(ptl.ProfileSpace), 2)
+		w.TryWriteBool(ptl.TierFlag)
+		w.TryWriteBits(uint64(ptl.ProfileIDC), 5)
+		w.TryWriteBits(uint64(ptl.ProfileCompatibilityFlags), 32)
+		w.TryWriteBits(ptl.ConstraintIndicatorFlags, 48)
+		w.TryWriteByte(ptl.LevelIDC)
+		w.TryWriteBits(0xf000, 16) // Min spatial segmentation
+		w.TryWriteByte(0xfc)       // Parallelism type
+		w.TryWriteByte(0xfc | byte(sps.ChromaFormatIDC))
+		w.TryWriteByte(0xf8 | byte(sps.BitDepthLumaMinus8))
+		w.TryWriteByte(0xf8 | byte(sps.BitDepthChromaMinus8))
+		w.TryWriteBits(0, 16) // Average frame rate
+		w.TryWriteBits(0, 2)  // Constant frame rate
+		w.TryWriteBits(uint64(sps.MaxSubLayersMinus1)+1, 3)
+		w.TryWriteBool(sps.TemporalIDNesting)
+		w.TryWriteBits(3, 2) // Length size minus one
+		w.TryWriteByte(3)    // Number of arrays
+		for _, t := range []HEVCNALUnitType{HEVCNALUnitTypeVPS, HEVCNALUnitTypeSPS, HEVCNALUnitTypePPS} {
+			w.TryWriteByte(0x80 | byte(t)) // Array completeness and NAL unit type
+			w.TryWriteBits(1, 16)          // Number of NAL units
+			w.TryWriteBits(uint64(len(ps[t])), 16)
+			w.TryWrite(ps[t])
+		}
+	}))
+}
+
+// mp4ESDescriptorBox builds an esds box carrying an AAC audio specific config.
+// Chapter: 7.2.6.5 | Link: https://www.iso.org/standard/55688.html
+func mp4ESDescriptorBox(c *AudioSpecificConfig) []byte {
+	// Configs found in the stream are copied verbatim so that extensions such as SBR and PS
+	// are kept
+	asc := c.Data
+	if len(asc) == 0 {
+		asc = mp4AudioSpecificConfig(c)
+	}
+	decoderSpecificInfo := mp4Descriptor(0x05, asc)
+	decoderConfig := mp4Descriptor(0x04, mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteByte(0x40)        // Object type indication: audio ISO/IEC 14496-3
+		w.TryWriteByte(0x05<<2 | 1) // Stream type: audio
+		w.TryWriteBits(0, 24)       // Buffer size
+		w.TryWriteBits(0, 32)       // Max bitrate
+		w.TryWriteBits(0, 32)       // Average bitrate
+	}), decoderSpecificInfo)
+	slConfig := mp4Descriptor(0x06, []byte{0x02})
+	return mp4FullBox("esds", 0, 0, mp4Descriptor(0x03, []byte{0, 0, 0}, decoderConfig, slConfig))
+}
+
+// mp4AudioSpecificConfig builds an AudioSpecificConfig out of its parsed fields.
+func mp4AudioSpecificConfig(c *AudioSpecificConfig) []byte {
+	return mp4Fields(func(w *bitio.Writer) {
+		if c.AudioObjectType >= 31 {
+			w.TryWriteBits(31, 5)
+			w.TryWriteBits(uint64(c.AudioObjectType-32), 6)
+		} else {
+			w.TryWriteBits(uint64(c.AudioObjectType), 5)
+		}
+		w.TryWriteBits(uint64(c.SamplingFrequencyIndex), 4)
+		if c.SamplingFrequencyIndex == 0xf {
+			w.TryWriteBits(uint64(c.SamplingFrequency), 24)
+		}
+		w.TryWriteBits(uint64(c.ChannelConfiguration), 4)
+		w.TryWriteBool(c.FrameLengthFlag)
+		w.TryWriteBool(false) // Depends on core coder
+		w.TryWriteBool(false) // Extension flag
+	})
+}
+
+// mp4Descriptor builds an MPEG-4 descriptor.
+func mp4Descriptor(tag byte, payloads ...[]byte) []byte {
+	var p []byte
+	for _, v := range payloads {
+		p = append(p, v...)
+	}
+	// Size is written on 4 bytes of 7 bits
+	l := len(p)
+	return append([]byte{tag, 0x80 | byte(l>>21&0x7f), 0x80 | byte(l>>14&0x7f), 0x80 | byte(l>>7&0x7f), byte(l & 0x7f)}, p...)
+}
+
+// mp4AC3SpecificBox builds a dac3 box.
+// Page: 180 | Chapter: F.4 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+func mp4AC3SpecificBox(f *AC3Frame) []byte {
+	return mp4Box("dac3", mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteBits(uint64(f.SampleRateCode), 2)
+		w.TryWriteBits(uint64(f.BSID), 5)
+		w.TryWriteBits(uint64(f.BitstreamMode), 3)
+		w.TryWriteBits(uint64(f.AudioCodingMode), 3)
+		w.TryWriteBool(f.LFEOn)
+		w.TryWriteBits(uint64(f.FrameSizeCode>>1), 5)
+		w.TryWriteBits(0, 5) // Reserved
+	}))
+}
+
+// mp4EAC3SpecificBox builds a dec3 box describing the independent substream of an access
+// unit and its dependent substreams.
+// Page: 183 | Chapter: F.6 | Link: https://www.etsi.org/deliver/etsi_ts/102300_102399/102366/01.04.01_60/ts_102366v010401p.pdf
+func mp4EAC3SpecificBox(d *AC3Data) []byte {
+	f := d.Frames[0]
+	var dataRate, numDepSub int
+	var chanLoc uint16
+	for i, v := range d.Frames {
+		if i > 0 && v.StreamType != EAC3StreamTypeDependent {
+			break
+		}
+		dataRate += v.Bitrate / 1000
+		if i > 0 {
+			numDepSub++
+			// Channel locations are the channel map ones that are not part of a 5.1 layout
+			chanLoc |= v.ChannelMap>>3&0xff<<1 | v.ChannelMap>>1&0x1
+		}
+	}
+	return mp4Box("dec3", mp4Fields(func(w *bitio.Writer) {
+		w.TryWriteBits(uint64(dataRate), 13)
+		w.TryWriteBits(0, 3) // Number of independent substreams minus 1
+		w.TryWriteBits(uint64(f.SampleRateCode), 2)
+		w.TryWriteBits(uint64(f.BSID), 5)
+		w.TryWriteBool(false) // Reserved
+		w.TryWriteBool(false) // Associated service
+		w.TryWriteBits(uint64(f.BitstreamMode), 3)
+		w.TryWriteBits(uint64(f.AudioCodingMode), 3)
+		w.TryWriteBool(f.LFEOn)
+		w.TryWriteBits(0, 3) // Reserved
+		w.TryWriteBits(uint64(numDepSub), 4)
+		if numDepSub > 0 {
+			w.TryWriteBits(uint64(chanLoc), 9)
+		} else {
+			w.TryWriteBool(false) // Reserved
+		}
+		if d.HasJOC() {
+			w.TryWriteBits(0, 7) // Reserved
+			w.TryWriteBool(true) // Extension type A
+			w.TryWriteByte(f.ComplexityIndexTypeA)
+		}
+	}))
+}
+
+// mp4Box builds an ISO BMFF box.
+func mp4Box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	b := make([]byte, 8, size)
+	b[0], b[1], b[2], b[3] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+	copy(b[4:], typ)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// mp4FullBox builds an ISO BMFF full box.
+func mp4FullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	return mp4Box(typ, append([][]byte{{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}}, payloads...)...)
+}
+
+// mp4Fields writes fields with a bit writer.
+func mp4Fields(fn func(w *bitio.Writer)) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	fn(w)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func writeMP4Matrix(w *bitio.Writer) {
+	for _, v := range mp4Matrix {
+		w.TryWriteBits(uint64(v), 32)
+	}
+}
+
+// appendLengthPrefixedNALUnit appends a NAL unit preceded by its length on 4 bytes.
+func appendLengthPrefixedNALUnit(b, nalu []byte) []byte {
+	l := len(nalu)
+	return append(append(b, byte(l>>24), byte(l>>16), byte(l>>8), byte(l)), nalu...)
+}
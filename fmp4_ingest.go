@@ -0,0 +1,849 @@
+package astits
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// fMP4 ingest defaults.
+const (
+	fmp4IngesterDelay    = 63000 // 700ms at 90kHz, added to timestamps so that PCRs precede them
+	fmp4IngesterFirstPID = 0x100
+)
+
+// fMP4 box flags.
+// Chapter: 8.8.7.1 and 8.8.8.1 | Link: https://www.iso.org/standard/83102.html
+const (
+	fmp4TfhdBaseDataOffsetPresent         = 0x1
+	fmp4TfhdSampleDescriptionIndexPresent = 0x2
+	fmp4TfhdDefaultSampleDurationPresent  = 0x8
+	fmp4TfhdDefaultSampleSizePresent      = 0x10
+	fmp4TfhdDefaultSampleFlagsPresent     = 0x20
+	fmp4TrunFirstSampleFlagsPresent       = 0x4
+)
+
+// fMP4 errors.
+var (
+	ErrFMP4BoxTruncated       = errors.New("box truncated")
+	ErrFMP4NoInitSegment      = errors.New("fragment found before the init segment")
+	ErrFMP4SampleOutOfRange   = errors.New("sample data out of range")
+	ErrFMP4UnsupportedProfile = errors.New("AAC profile can't be carried in ADTS")
+)
+
+// FMP4Ingester feeds the samples of fragmented MP4 streams, as written by FMP4Remuxer and CMAF
+// or DASH packagers, into a Muxer.
+//
+// Tracks are added to the muxer when the init segment is ingested: avc1 and avc3 sample entries
+// are muxed as H264, hvc1 and hev1 as HEVC, mp4a as ADTS, ac-3 and ec-3 as AC-3 and E-AC-3 with
+// their DVB descriptors. PIDs start at 0x100 and PCRs are carried by the first video track.
+//
+// Length-prefixed NAL units are converted to Annex B with an access unit delimiter, and the
+// parameter sets of the sample entry are inserted before key frames. Raw AAC frames get ADTS
+// headers.
+type FMP4Ingester struct {
+	m      *Muxer
+	moof   []fmp4IngestRun
+	offset int64 // From the start of the first input
+	tracks map[uint32]*fmp4IngestTrack
+}
+
+type fmp4IngestTrack struct {
+	aacConfig       *AudioSpecificConfig
+	decodeTime      uint64 // Decode time of the next sample, in timescale
+	defaultDuration uint32
+	defaultSize     uint32
+	es              *PMTElementaryStream
+	lengthSize      int
+	parameterSets   []byte // Annex B NAL units inserted before key frames
+	streamID        uint8
+	timescale       uint32
+}
+
+type fmp4IngestRun struct {
+	samples []fmp4IngestSample
+	track   *fmp4IngestTrack
+}
+
+type fmp4IngestSample struct {
+	cto        int64
+	decodeTime uint64
+	offset     int64 // From the start of the input
+	size       uint32
+}
+
+type fmp4IngestBox struct {
+	offset  int64 // Of the payload, from the start of the input
+	payload []byte
+	typ     string
+}
+
+// NewFMP4Ingester creates a new fMP4 ingester writing to a muxer.
+func NewFMP4Ingester(m *Muxer) *FMP4Ingester {
+	return &FMP4Ingester{m: m}
+}
+
+// Ingest reads boxes until EOF. The init segment and the fragments can be ingested in one call
+// or in successive calls, typically one per segment, in which case inputs are considered
+// contiguous. Init segments repeated in later calls only update the tracks they describe.
+func (i *FMP4Ingester) Ingest(r io.Reader) error {
+	for {
+		b, err := readFMP4IngestBox(r, i.offset)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("reading box failed: %w", err)
+		}
+		i.offset = b.offset + int64(len(b.payload))
+
+		switch b.typ {
+		case "moov":
+			if err = i.ingestMOOV(b); err != nil {
+				return fmt.Errorf("ingesting moov failed: %w", err)
+			}
+		case "moof":
+			if i.tracks == nil {
+				return ErrFMP4NoInitSegment
+			}
+			if err = i.ingestMOOF(b); err != nil {
+				return fmt.Errorf("ingesting moof failed: %w", err)
+			}
+		case "mdat":
+			if err = i.ingestMDAT(b); err != nil {
+				return fmt.Errorf("ingesting mdat failed: %w", err)
+			}
+		}
+	}
+}
+
+func readFMP4IngestBox(r io.Reader, offset int64) (b *fmp4IngestBox, err error) {
+	// Header
+	h := make([]byte, 8)
+	if _, err = io.ReadFull(r, h); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = ErrFMP4BoxTruncated
+		}
+		return
+	}
+	b = &fmp4IngestBox{offset: offset + 8, typ: string(h[4:])}
+
+	// Payload
+	size := int64(binary.BigEndian.Uint32(h))
+	switch size {
+	case 0:
+		// Box extends to the end of the input
+		var buf []byte
+		p := make([]byte, 32*1024)
+		for {
+			n, err := r.Read(p)
+			buf = append(buf, p[:n]...)
+			if errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		b.payload = buf
+		return
+	case 1:
+		if _, err = io.ReadFull(r, h); err != nil {
+			return nil, ErrFMP4BoxTruncated
+		}
+		size = int64(binary.BigEndian.Uint64(h)) - 8
+		b.offset += 8
+	}
+	if size < 8 {
+		return nil, ErrFMP4BoxTruncated
+	}
+	b.payload = make([]byte, size-8)
+	if _, err = io.ReadFull(r, b.payload); err != nil {
+		return nil, ErrFMP4BoxTruncated
+	}
+	return
+}
+
+// parseFMP4IngestBoxes splits the payload of a container box into its children.
+func parseFMP4IngestBoxes(b *fmp4IngestBox) (bs []*fmp4IngestBox, err error) {
+	p := b.payload
+	for len(p) > 0 {
+		if len(p) < 8 {
+			return nil, ErrFMP4BoxTruncated
+		}
+		size := int(binary.BigEndian.Uint32(p))
+		if size < 8 || size > len(p) {
+			return nil, ErrFMP4BoxTruncated
+		}
+		bs = append(bs, &fmp4IngestBox{
+			offset:  b.offset + int64(len(b.payload)-len(p)) + 8,
+			payload: p[8:size],
+			typ:     string(p[4:8]),
+		})
+		p = p[size:]
+	}
+	return
+}
+
+// findFMP4IngestBox returns the first box of a path of types.
+func findFMP4IngestBox(b *fmp4IngestBox, path ...string) (*fmp4IngestBox, error) {
+	for _, typ := range path {
+		bs, err := parseFMP4IngestBoxes(b)
+		if err != nil {
+			return nil, err
+		}
+		b = nil
+		for _, c := range bs {
+			if c.typ == typ {
+				b = c
+				break
+			}
+		}
+		if b == nil {
+			return nil, nil
+		}
+	}
+	return b, nil
+}
+
+func (i *FMP4Ingester) ingestMOOV(moov *fmp4IngestBox) error {
+	bs, err := parseFMP4IngestBoxes(moov)
+	if err != nil {
+		return err
+	}
+
+	// Tracks
+	first := i.tracks == nil
+	if first {
+		i.tracks = make(map[uint32]*fmp4IngestTrack)
+	}
+	var pcr *fmp4IngestTrack
+	pid := uint16(fmp4IngesterFirstPID) + uint16(len(i.tracks))
+	for _, b := range bs {
+		if b.typ != "trak" {
+			continue
+		}
+		id, t, err := parseFMP4IngestTrak(b)
+		if err != nil {
+			return fmt.Errorf("parsing trak failed: %w", err)
+		}
+		if t == nil {
+			continue
+		}
+
+		// Known tracks keep their elementary stream and their timing
+		if o, ok := i.tracks[id]; ok {
+			t.decodeTime, t.defaultDuration, t.defaultSize, t.es = o.decodeTime, o.defaultDuration, o.defaultSize, o.es
+			i.tracks[id] = t
+			continue
+		}
+		t.es.ElementaryPID = pid
+		if err = i.m.AddElementaryStream(*t.es); err != nil {
+			return fmt.Errorf("adding elementary stream failed: %w", err)
+		}
+		if pcr == nil || (t.es.StreamType.IsVideo() && !pcr.es.StreamType.IsVideo()) {
+			pcr = t
+		}
+		i.tracks[id] = t
+		pid++
+	}
+	if len(i.tracks) == 0 {
+		return ErrFMP4NoTrack
+	}
+	if first {
+		i.m.SetPCRPID(pcr.es.ElementaryPID)
+	}
+
+	// Defaults
+	mvex, err := findFMP4IngestBox(moov, "mvex")
+	if err != nil || mvex == nil {
+		return err
+	}
+	if bs, err = parseFMP4IngestBoxes(mvex); err != nil {
+		return err
+	}
+	for _, b := range bs {
+		if b.typ != "trex" || len(b.payload) < 20 {
+			continue
+		}
+		if t, ok := i.tracks[binary.BigEndian.Uint32(b.payload[4:])]; ok {
+			t.defaultDuration = binary.BigEndian.Uint32(b.payload[12:])
+			t.defaultSize = binary.BigEndian.Uint32(b.payload[16:])
+		}
+	}
+	return nil
+}
+
+// parseFMP4IngestTrak returns the ID and the track of a trak box, or a nil track if its sample
+// entry is not supported.
+func parseFMP4IngestTrak(trak *fmp4IngestBox) (id uint32, t *fmp4IngestTrack, err error) {
+	// Get boxes
+	var tkhd, mdhd, stsd *fmp4IngestBox
+	if tkhd, err = findFMP4IngestBox(trak, "tkhd"); err != nil {
+		return
+	}
+	if mdhd, err = findFMP4IngestBox(trak, "mdia", "mdhd"); err != nil {
+		return
+	}
+	if stsd, err = findFMP4IngestBox(trak, "mdia", "minf", "stbl", "stsd"); err != nil {
+		return
+	}
+	if tkhd == nil || mdhd == nil || stsd == nil || len(stsd.payload) < 8 {
+		err = ErrFMP4BoxTruncated
+		return
+	}
+
+	// Track ID, timescale and language depend on the box versions
+	t = &fmp4IngestTrack{es: &PMTElementaryStream{}}
+	var language uint16
+	switch {
+	case len(tkhd.payload) >= 24 && tkhd.payload[0] == 1:
+		id = binary.BigEndian.Uint32(tkhd.payload[20:])
+	case len(tkhd.payload) >= 16 && tkhd.payload[0] == 0:
+		id = binary.BigEndian.Uint32(tkhd.payload[12:])
+	default:
+		return 0, nil, ErrFMP4BoxTruncated
+	}
+	switch {
+	case len(mdhd.payload) >= 34 && mdhd.payload[0] == 1:
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[20:])
+		language = binary.BigEndian.Uint16(mdhd.payload[32:])
+	case len(mdhd.payload) >= 22 && mdhd.payload[0] == 0:
+		t.timescale = binary.BigEndian.Uint32(mdhd.payload[12:])
+		language = binary.BigEndian.Uint16(mdhd.payload[20:])
+	default:
+		return 0, nil, ErrFMP4BoxTruncated
+	}
+	if t.timescale == 0 {
+		return 0, nil, nil
+	}
+
+	// Sample entry
+	var entries []*fmp4IngestBox
+	if entries, err = parseFMP4IngestBoxes(&fmp4IngestBox{payload: stsd.payload[8:]}); err != nil || len(entries) == 0 {
+		return 0, nil, err
+	}
+	e := entries[0]
+	switch e.typ {
+	case "avc1", "avc3", "hvc1", "hev1":
+		if len(e.payload) < 78 {
+			return 0, nil, ErrFMP4BoxTruncated
+		}
+		typ, parse := "avcC", parseFMP4IngestAVCC
+		t.es.StreamType = StreamTypeH264Video
+		if e.typ == "hvc1" || e.typ == "hev1" {
+			typ, parse = "hvcC", parseFMP4IngestHVCC
+			t.es.StreamType = StreamTypeH265Video
+		}
+		var c *fmp4IngestBox
+		if c, err = findFMP4IngestBox(&fmp4IngestBox{payload: e.payload[78:]}, typ); err != nil || c == nil {
+			return 0, nil, err
+		}
+		if err = parse(t, c.payload); err != nil {
+			return 0, nil, fmt.Errorf("parsing %s failed: %w", typ, err)
+		}
+	case "mp4a":
+		if len(e.payload) < 28 {
+			return 0, nil, ErrFMP4BoxTruncated
+		}
+		var c *fmp4IngestBox
+		if c, err = findFMP4IngestBox(&fmp4IngestBox{payload: e.payload[28:]}, "esds"); err != nil || c == nil {
+			return 0, nil, err
+		}
+		if err = parseFMP4IngestESDS(t, c.payload); err != nil {
+			return 0, nil, fmt.Errorf("parsing esds failed: %w", err)
+		} else if t.aacConfig == nil {
+			return 0, nil, nil
+		}
+		t.es.StreamType = StreamTypeADTS
+	case "ac-3":
+		t.es.StreamType = StreamTypePrivateData
+		t.es.ElementaryStreamDescriptors = []*Descriptor{{AC3: &DescriptorAC3{}, Tag: DescriptorTagAC3}}
+		t.streamID = StreamIDPrivateStream1
+	case "ec-3":
+		t.es.StreamType = StreamTypePrivateData
+		t.es.ElementaryStreamDescriptors = []*Descriptor{{EnhancedAC3: &DescriptorEnhancedAC3{}, Tag: DescriptorTagEnhancedAC3}}
+		t.streamID = StreamIDPrivateStream1
+	default:
+		return 0, nil, nil
+	}
+
+	// Language is packed as 3 times 5 bits
+	if l := []byte{byte(language>>10&0x1f) + 0x60, byte(language>>5&0x1f) + 0x60, byte(language&0x1f) + 0x60}; language != mp4LanguageUndetermined && language != 0 {
+		t.es.ElementaryStreamDescriptors = append(t.es.ElementaryStreamDescriptors, &Descriptor{
+			ISO639LanguageAndAudioType: &DescriptorISO639LanguageAndAudioType{Language: l},
+			Tag:                        DescriptorTagISO639LanguageAndAudioType,
+		})
+	}
+	return
+}
+
+// parseFMP4IngestAVCC parses an avcC box.
+// Chapter: 5.3.3.1 | Link: https://www.iso.org/standard/83336.html
+func parseFMP4IngestAVCC(t *fmp4IngestTrack, p []byte) error {
+	if len(p) < 7 {
+		return ErrFMP4BoxTruncated
+	}
+	t.lengthSize = int(p[4]&0x3) + 1
+	pos := 5
+	for _, mask := range []byte{0x1f, 0xff} {
+		if pos >= len(p) {
+			return ErrFMP4BoxTruncated
+		}
+		n := int(p[pos] & mask)
+		pos++
+		for idx := 0; idx < n; idx++ {
+			var err error
+			if pos, err = t.appendParameterSet(p, pos); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFMP4IngestHVCC parses an hvcC box.
+// Chapter: 8.3.3.1 | Link: https://www.iso.org/standard/83336.html
+func parseFMP4IngestHVCC(t *fmp4IngestTrack, p []byte) error {
+	if len(p) < 23 {
+		return ErrFMP4BoxTruncated
+	}
+	t.lengthSize = int(p[21]&0x3) + 1
+	pos := 23
+	for idx := 0; idx < int(p[22]); idx++ {
+		if pos+3 > len(p) {
+			return ErrFMP4BoxTruncated
+		}
+		n := int(binary.BigEndian.Uint16(p[pos+1:]))
+		pos += 3
+		for idx := 0; idx < n; idx++ {
+			var err error
+			if pos, err = t.appendParameterSet(p, pos); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendParameterSet appends the NAL unit preceded by its 16 bits length at a position and
+// returns the position following it.
+func (t *fmp4IngestTrack) appendParameterSet(p []byte, pos int) (int, error) {
+	if pos+2 > len(p) {
+		return 0, ErrFMP4BoxTruncated
+	}
+	l := int(binary.BigEndian.Uint16(p[pos:]))
+	pos += 2
+	if pos+l > len(p) {
+		return 0, ErrFMP4BoxTruncated
+	}
+	t.parameterSets = append(append(t.parameterSets, 0x0, 0x0, 0x0, 0x1), p[pos:pos+l]...)
+	return pos + l, nil
+}
+
+// parseFMP4IngestESDS parses the audio specific config of an esds box. The config is left nil
+// when the box doesn't carry one, which is the case of MPEG audio.
+// Chapter: 7.2.6.5 | Link: https://www.iso.org/standard/55688.html
+func parseFMP4IngestESDS(t *fmp4IngestTrack, p []byte) error {
+	if len(p) < 4 {
+		return ErrFMP4BoxTruncated
+	}
+
+	// ES descriptor
+	tag, d, _, err := readMP4Descriptor(p[4:])
+	if err != nil {
+		return err
+	} else if tag != 0x03 || len(d) < 3 {
+		return ErrFMP4BoxTruncated
+	}
+	pos := 3
+	if d[2]&0x80 > 0 { // Stream dependence
+		pos += 2
+	}
+	if d[2]&0x40 > 0 && pos < len(d) { // URL
+		pos += 1 + int(d[pos])
+	}
+	if d[2]&0x20 > 0 { // OCR stream
+		pos += 2
+	}
+
+	// Decoder config and decoder specific info
+	var asc []byte
+	for asc == nil && pos < len(d) {
+		var b, rest []byte
+		if tag, b, rest, err = readMP4Descriptor(d[pos:]); err != nil {
+			return err
+		}
+		pos = len(d) - len(rest)
+		if tag != 0x04 || len(b) < 13 {
+			continue
+		}
+		for b = b[13:]; len(b) > 0; {
+			var v []byte
+			if tag, v, b, err = readMP4Descriptor(b); err != nil {
+				return err
+			}
+			if tag == 0x05 {
+				asc = v
+				break
+			}
+		}
+	}
+	if asc == nil {
+		return nil
+	}
+
+	// ADTS can only carry the first 4 audio object types
+	if t.aacConfig, err = ParseAudioSpecificConfig(asc); err != nil {
+		return fmt.Errorf("parsing audio specific config failed: %w", err)
+	}
+	if t.aacConfig.AudioObjectType < AACAudioObjectTypeMain || t.aacConfig.AudioObjectType > AACAudioObjectTypeLTP || t.aacConfig.SamplingFrequencyIndex >= 0xd {
+		return ErrFMP4UnsupportedProfile
+	}
+	return nil
+}
+
+// readMP4Descriptor returns the tag and the payload of the MPEG-4 descriptor starting b, as well
+// as the bytes following it.
+func readMP4Descriptor(b []byte) (tag byte, payload, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, ErrFMP4BoxTruncated
+	}
+	tag = b[0]
+	var size, pos int
+	for pos = 1; pos < len(b) && pos <= 4; pos++ {
+		size = size<<7 | int(b[pos]&0x7f)
+		if b[pos]&0x80 == 0 {
+			break
+		}
+	}
+	pos++
+	if pos+size > len(b) {
+		return 0, nil, nil, ErrFMP4BoxTruncated
+	}
+	return tag, b[pos : pos+size], b[pos+size:], nil
+}
+
+func (i *FMP4Ingester) ingestMOOF(moof *fmp4IngestBox) error {
+	bs, err := parseFMP4IngestBoxes(moof)
+	if err != nil {
+		return err
+	}
+
+	// Data offsets are relative to the start of the moof by default
+	i.moof = i.moof[:0]
+	next := moof.offset - 8
+	for _, traf := range bs {
+		if traf.typ != "traf" {
+			continue
+		}
+		var cs []*fmp4IngestBox
+		if cs, err = parseFMP4IngestBoxes(traf); err != nil {
+			return err
+		}
+		if len(cs) == 0 || cs[0].typ != "tfhd" || len(cs[0].payload) < 8 {
+			return ErrFMP4BoxTruncated
+		}
+
+		// Track fragment header
+		p := cs[0].payload
+		flags := binary.BigEndian.Uint32(p) & 0xffffff
+		t, ok := i.tracks[binary.BigEndian.Uint32(p[4:])]
+		if !ok {
+			continue
+		}
+		size := 8
+		for _, f := range []uint32{fmp4TfhdBaseDataOffsetPresent, fmp4TfhdSampleDescriptionIndexPresent, fmp4TfhdDefaultSampleDurationPresent, fmp4TfhdDefaultSampleSizePresent, fmp4TfhdDefaultSampleFlagsPresent} {
+			if flags&f > 0 {
+				size += 4
+			}
+		}
+		if flags&fmp4TfhdBaseDataOffsetPresent > 0 {
+			size += 4
+		}
+		if len(p) < size {
+			return ErrFMP4BoxTruncated
+		}
+		base := next
+		pos := 8
+		if flags&fmp4TfhdBaseDataOffsetPresent > 0 {
+			base = int64(binary.BigEndian.Uint64(p[pos:]))
+			pos += 8
+		} else if flags&fmp4TfhdDefaultBaseIsMoof > 0 {
+			base = moof.offset - 8
+		}
+		if flags&fmp4TfhdSampleDescriptionIndexPresent > 0 {
+			pos += 4
+		}
+		duration, sampleSize := t.defaultDuration, t.defaultSize
+		if flags&fmp4TfhdDefaultSampleDurationPresent > 0 {
+			duration = binary.BigEndian.Uint32(p[pos:])
+			pos += 4
+		}
+		if flags&fmp4TfhdDefaultSampleSizePresent > 0 {
+			sampleSize = binary.BigEndian.Uint32(p[pos:])
+		}
+
+		// Runs
+		next = base
+		for _, c := range cs[1:] {
+			switch c.typ {
+			case "tfdt":
+				if len(c.payload) < 8 {
+					return ErrFMP4BoxTruncated
+				}
+				if c.payload[0] == 1 {
+					if len(c.payload) < 12 {
+						return ErrFMP4BoxTruncated
+					}
+					t.decodeTime = binary.BigEndian.Uint64(c.payload[4:])
+				} else {
+					t.decodeTime = uint64(binary.BigEndian.Uint32(c.payload[4:]))
+				}
+			case "trun":
+				r := fmp4IngestRun{track: t}
+				if r.samples, next, err = t.parseTrun(c.payload, base, next, duration, sampleSize); err != nil {
+					return fmt.Errorf("parsing trun failed: %w", err)
+				}
+				i.moof = append(i.moof, r)
+			}
+		}
+	}
+	return nil
+}
+
+// parseTrun returns the samples of a trun box and the offset following their data.
+// Chapter: 8.8.8 | Link: https://www.iso.org/standard/83102.html
+func (t *fmp4IngestTrack) parseTrun(p []byte, base, next int64, duration, size uint32) (ss []fmp4IngestSample, _ int64, err error) {
+	if len(p) < 8 {
+		return nil, 0, ErrFMP4BoxTruncated
+	}
+	version := p[0]
+	flags := binary.BigEndian.Uint32(p) & 0xffffff
+	count := int(binary.BigEndian.Uint32(p[4:]))
+
+	// Check length
+	headerSize, sampleSize := 8, 0
+	if flags&fmp4TrunDataOffsetPresent > 0 {
+		headerSize += 4
+	}
+	if flags&fmp4TrunFirstSampleFlagsPresent > 0 {
+		headerSize += 4
+	}
+	for _, f := range []uint32{fmp4TrunSampleDurationPresent, fmp4TrunSampleSizePresent, fmp4TrunSampleFlagsPresent, fmp4TrunSampleCompositionTimeOffsetsPresent} {
+		if flags&f > 0 {
+			sampleSize += 4
+		}
+	}
+	if len(p) < headerSize || (sampleSize > 0 && (len(p)-headerSize)/sampleSize < count) {
+		return nil, 0, ErrFMP4BoxTruncated
+	}
+
+	// Header
+	pos := 8
+	if flags&fmp4TrunDataOffsetPresent > 0 {
+		next = base + int64(int32(binary.BigEndian.Uint32(p[pos:])))
+		pos += 4
+	}
+	if flags&fmp4TrunFirstSampleFlagsPresent > 0 {
+		pos += 4
+	}
+
+	// Samples
+	for idx := 0; idx < count; idx++ {
+		s := fmp4IngestSample{decodeTime: t.decodeTime, offset: next, size: size}
+		d := duration
+		if flags&fmp4TrunSampleDurationPresent > 0 {
+			d = binary.BigEndian.Uint32(p[pos:])
+			pos += 4
+		}
+		if flags&fmp4TrunSampleSizePresent > 0 {
+			s.size = binary.BigEndian.Uint32(p[pos:])
+			pos += 4
+		}
+		if flags&fmp4TrunSampleFlagsPresent > 0 {
+			pos += 4
+		}
+		if flags&fmp4TrunSampleCompositionTimeOffsetsPresent > 0 {
+			if version == 0 {
+				s.cto = int64(binary.BigEndian.Uint32(p[pos:]))
+			} else {
+				s.cto = int64(int32(binary.BigEndian.Uint32(p[pos:])))
+			}
+			pos += 4
+		}
+		ss = append(ss, s)
+		next += int64(s.size)
+		t.decodeTime += uint64(d)
+	}
+	return ss, next, nil
+}
+
+func (i *FMP4Ingester) ingestMDAT(mdat *fmp4IngestBox) error {
+	// Get samples
+	type item struct {
+		data []byte
+		dts  int64
+		s    fmp4IngestSample
+		t    *fmp4IngestTrack
+	}
+	var is []item
+	for _, r := range i.moof {
+		for _, s := range r.samples {
+			start := s.offset - mdat.offset
+			if start < 0 || start+int64(s.size) > int64(len(mdat.payload)) {
+				return ErrFMP4SampleOutOfRange
+			}
+			is = append(is, item{
+				data: mdat.payload[start : start+int64(s.size)],
+				dts:  fmp4IngestTimestamp(s.decodeTime, r.track.timescale),
+				s:    s,
+				t:    r.track,
+			})
+		}
+	}
+	i.moof = i.moof[:0]
+
+	// Interleave tracks
+	sort.SliceStable(is, func(a, b int) bool { return is[a].dts < is[b].dts })
+
+	// Write
+	for _, v := range is {
+		if err := i.writeSample(v.t, v.s, v.dts, v.data); err != nil {
+			return fmt.Errorf("writing sample failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (i *FMP4Ingester) writeSample(t *fmp4IngestTrack, s fmp4IngestSample, dts int64, b []byte) (err error) {
+	// Convert data
+	var key bool
+	switch t.es.StreamType {
+	case StreamTypeH264Video, StreamTypeH265Video:
+		if b, key, err = t.annexB(b); err != nil {
+			return fmt.Errorf("converting to Annex B failed: %w", err)
+		}
+	case StreamTypeADTS:
+		b = append(adtsHeaderBytes(t.aacConfig, len(b)), b...)
+	}
+
+	// Timestamps
+	pts := dts + s.cto*90000/int64(t.timescale)
+	h := &PESOptionalHeader{
+		DataAlignmentIndicator: true,
+		MarkerBits:             2,
+		PTS:                    newClockReference((pts+fmp4IngesterDelay)%clockReferenceBaseModulo, 0),
+		PTSDTSIndicator:        PTSDTSIndicatorOnlyPTS,
+	}
+	if pts != dts {
+		h.DTS = newClockReference((dts+fmp4IngesterDelay)%clockReferenceBaseModulo, 0)
+		h.PTSDTSIndicator = PTSDTSIndicatorBothPresent
+	}
+	d := &MuxerData{
+		PID: t.es.ElementaryPID,
+		PES: &PESData{
+			Data:   b,
+			Header: &PESHeader{OptionalHeader: h, StreamID: t.streamID},
+		},
+	}
+	if t.es.ElementaryPID == i.m.pmt.PCRPID {
+		d.AdaptationField = &PacketAdaptationField{
+			HasPCR:                true,
+			PCR:                   newClockReference(dts%clockReferenceBaseModulo, 0),
+			RandomAccessIndicator: key,
+		}
+	} else if key {
+		d.AdaptationField = &PacketAdaptationField{RandomAccessIndicator: true}
+	}
+	if _, err = i.m.WriteData(d); err != nil {
+		return fmt.Errorf("muxing failed: %w", err)
+	}
+	return nil
+}
+
+// fmp4IngestTimestamp converts a decode time to 90kHz without overflowing.
+func fmp4IngestTimestamp(t uint64, timescale uint32) int64 {
+	return int64(t/uint64(timescale)*90000 + t%uint64(timescale)*90000/uint64(timescale))
+}
+
+// annexB converts length-prefixed NAL units to Annex B, inserting an access unit delimiter and
+// the parameter sets of the sample entry before key frames that don't have them. An access unit
+// delimiter found in the sample is moved first, ahead of the inserted parameter sets.
+func (t *fmp4IngestTrack) annexB(b []byte) (o []byte, key bool, err error) {
+	hevc := t.es.StreamType == StreamTypeH265Video
+	var aud, nalus []byte
+	var ps bool
+	for len(b) > 0 {
+		if len(b) < t.lengthSize {
+			return nil, false, ErrFMP4SampleOutOfRange
+		}
+		var l int
+		for _, v := range b[:t.lengthSize] {
+			l = l<<8 | int(v)
+		}
+		b = b[t.lengthSize:]
+		if l == 0 {
+			continue
+		} else if l > len(b) {
+			return nil, false, ErrFMP4SampleOutOfRange
+		}
+		if hevc {
+			switch typ := HEVCNALUnitType(b[0] >> 1 & 0x3f); {
+			case typ == HEVCNALUnitTypeAUD:
+				aud = append(aud, 0x0, 0x0, 0x0, 0x1)
+				aud = append(aud, b[:l]...)
+				b = b[l:]
+				continue
+			case typ == HEVCNALUnitTypeVPS || typ == HEVCNALUnitTypeSPS || typ == HEVCNALUnitTypePPS:
+				ps = true
+			case typ.IsIRAP():
+				key = true
+			}
+		} else {
+			switch H264NALUnitType(b[0] & 0x1f) {
+			case H264NALUnitTypeAUD:
+				aud = append(aud, 0x0, 0x0, 0x0, 0x1)
+				aud = append(aud, b[:l]...)
+				b = b[l:]
+				continue
+			case H264NALUnitTypeSPS, H264NALUnitTypePPS:
+				ps = true
+			case H264NALUnitTypeIDRSlice:
+				key = true
+			}
+		}
+		nalus = append(append(nalus, 0x0, 0x0, 0x0, 0x1), b[:l]...)
+		b = b[l:]
+	}
+
+	// Access unit delimiters must come first, followed by the parameter sets
+	switch {
+	case len(aud) > 0:
+		o = aud
+	case hevc:
+		o = []byte{0x0, 0x0, 0x0, 0x1, byte(HEVCNALUnitTypeAUD) << 1, 0x1, 0x50}
+	default:
+		o = []byte{0x0, 0x0, 0x0, 0x1, byte(H264NALUnitTypeAUD), 0xf0}
+	}
+	if key && !ps {
+		o = append(o, t.parameterSets...)
+	}
+	return append(o, nalus...), key, nil
+}
+
+// adtsHeaderBytes returns the header of an ADTS frame without CRC.
+// Chapter: 1.A.2.2 | Link: https://www.iso.org/standard/76383.html
+func adtsHeaderBytes(c *AudioSpecificConfig, payloadLength int) []byte {
+	l := payloadLength + 7
+	return []byte{
+		0xff,
+		0xf1, // MPEG-4, layer 0 and protection absent
+		(c.AudioObjectType-1)<<6 | c.SamplingFrequencyIndex<<2 | c.ChannelConfiguration>>2&0x1,
+		c.ChannelConfiguration&0x3<<6 | byte(l>>11&0x3),
+		byte(l >> 3),
+		byte(l&0x7)<<5 | 0x1f, // Buffer fullness is 0x7ff
+		0xfc,                  // Buffer fullness and a single raw data block
+	}
+}
@@ -0,0 +1,178 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFMP4Ingester(t *testing.T) {
+	// Remux to fMP4
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(fmp4Bytes(t)))
+	init, fragments := &bytes.Buffer{}, &bytes.Buffer{}
+	r := NewFMP4Remuxer(fragments, FMP4RemuxerOptInitWriter(init))
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, r.Remux(d))
+	}
+	assert.NoError(t, r.Close())
+
+	// Ingest
+	buf := &bytes.Buffer{}
+	i := NewFMP4Ingester(NewMuxer(context.Background(), buf))
+	assert.Equal(t, ErrFMP4NoInitSegment, i.Ingest(bytes.NewReader(fragments.Bytes())))
+	assert.NoError(t, i.Ingest(bytes.NewReader(init.Bytes())))
+
+	// Repeated init segment and a moof and its mdat ingested in different calls
+	assert.NoError(t, i.Ingest(bytes.NewReader(init.Bytes())))
+	moof := int(binary.BigEndian.Uint32(fragments.Bytes()))
+	assert.NoError(t, i.Ingest(bytes.NewReader(fragments.Bytes()[:moof])))
+	assert.NoError(t, i.Ingest(bytes.NewReader(fragments.Bytes()[moof:])))
+
+	// Demux
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptParseElementaryStreams())
+	var pmt *PMTData
+	var video, audio []*DemuxerData
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		switch {
+		case d.PMT != nil:
+			pmt = d.PMT
+		case d.PID == 0x100:
+			video = append(video, d)
+		case d.PID == 0x101:
+			audio = append(audio, d)
+		}
+	}
+	assert.NotNil(t, pmt)
+	assert.Equal(t, uint16(0x100), pmt.PCRPID)
+	assert.Len(t, pmt.ElementaryStreams, 2)
+	assert.Equal(t, StreamTypeH264Video, pmt.ElementaryStreams[0].StreamType)
+	assert.Equal(t, StreamTypeADTS, pmt.ElementaryStreams[1].StreamType)
+
+	// Video
+	assert.Len(t, video, 10)
+	h := video[0].PES.Header.OptionalHeader
+	assert.Equal(t, int64(fmp4IngesterDelay+3840), h.PTS.Base)
+	assert.Equal(t, int64(fmp4IngesterDelay), h.DTS.Base)
+	assert.Equal(t, int64(fmp4IngesterDelay+9*3840), video[9].PES.Header.OptionalHeader.DTS.Base)
+	assert.Equal(t, []H264NALUnitType{H264NALUnitTypeAUD, H264NALUnitTypeSPS, H264NALUnitTypePPS, H264NALUnitTypeIDRSlice}, h264NALUnitTypes(video[0].H264))
+	assert.Equal(t, h264IDRBytes(), video[0].H264.NALUnits[3].Data)
+	assert.Equal(t, []H264NALUnitType{H264NALUnitTypeAUD, H264NALUnitTypeNonIDRSlice}, h264NALUnitTypes(video[1].H264))
+	assert.True(t, video[5].H264.IsIDR())
+	assert.NotNil(t, video[5].H264.SPS())
+
+	// Audio
+	var frames []*AACFrame
+	for _, d := range audio {
+		frames = append(frames, d.AAC.Frames...)
+	}
+	assert.Len(t, frames, 20)
+	for idx, f := range frames {
+		assert.Equal(t, []byte{byte(idx)}, f.Data)
+		assert.Equal(t, 48000, f.Config.SamplingFrequency)
+		assert.Equal(t, 2, f.Config.Channels)
+	}
+	assert.Equal(t, int64(fmp4IngesterDelay), audio[0].PES.Header.OptionalHeader.PTS.Base)
+
+	// Errors
+	i = NewFMP4Ingester(NewMuxer(context.Background(), &bytes.Buffer{}))
+	assert.Error(t, i.Ingest(bytes.NewReader(init.Bytes()[:20])))
+}
+
+func h264NALUnitTypes(d *H264Data) (ts []H264NALUnitType) {
+	for _, n := range d.NALUnits {
+		ts = append(ts, n.Type)
+	}
+	return
+}
+
+func TestParseFMP4IngestTrak(t *testing.T) {
+	trak := func(entry []byte, language uint16) *fmp4IngestBox {
+		return &fmp4IngestBox{payload: mp4Box("trak",
+			mp4FullBox("tkhd", 0, 3, make([]byte, 8), []byte{0x0, 0x0, 0x0, 0x2}, make([]byte, 68)),
+			mp4Box("mdia",
+				mp4FullBox("mdhd", 0, 0, make([]byte, 8), []byte{0x0, 0x0, 0xbb, 0x80}, make([]byte, 4), []byte{byte(language >> 8), byte(language), 0x0, 0x0}),
+				mp4Box("minf", mp4Box("stbl", mp4FullBox("stsd", 0, 0, []byte{0x0, 0x0, 0x0, 0x1}, entry))),
+			),
+		)[8:]}
+	}
+	f, err := ParseAC3Data(eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, false), nil)
+	assert.NoError(t, err)
+
+	// E-AC-3 with a language
+	id, tr, err := parseFMP4IngestTrak(trak(mp4AudioSampleEntry("ec-3", 6, 48000, mp4EAC3SpecificBox(f)), 0x15c7)) // "eng"
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), id)
+	assert.Equal(t, uint32(48000), tr.timescale)
+	assert.Equal(t, uint8(StreamIDPrivateStream1), tr.streamID)
+	assert.Equal(t, StreamTypePrivateData, tr.es.StreamType)
+	assert.Equal(t, StreamTypeEAC3Audio, elementaryStreamType(tr.es))
+	assert.Len(t, tr.es.ElementaryStreamDescriptors, 2)
+	assert.Equal(t, []byte("eng"), tr.es.ElementaryStreamDescriptors[1].ISO639LanguageAndAudioType.Language)
+
+	// AC-3
+	f, err = ParseAC3Data(ac3FrameBytes(AC3AudioCodingMode2_0, false), nil)
+	assert.NoError(t, err)
+	_, tr, err = parseFMP4IngestTrak(trak(mp4AudioSampleEntry("ac-3", 2, 48000, mp4AC3SpecificBox(f.Frames[0])), mp4LanguageUndetermined))
+	assert.NoError(t, err)
+	assert.Equal(t, StreamTypeAC3Audio, elementaryStreamType(tr.es))
+	assert.Len(t, tr.es.ElementaryStreamDescriptors, 1)
+
+	// HEVC
+	h, err := ParseHEVCData(hevcBytes())
+	assert.NoError(t, err)
+	_, tr, err = parseFMP4IngestTrak(trak(mp4VisualSampleEntry("hvc1", 3840, 2160, mp4HEVCConfigurationBox(h.SPS(), map[HEVCNALUnitType][]byte{
+		HEVCNALUnitTypeVPS: hevcVPSBytes(),
+		HEVCNALUnitTypeSPS: hevcSPSBytes(),
+		HEVCNALUnitTypePPS: hevcPPSBytes(),
+	})), mp4LanguageUndetermined))
+	assert.NoError(t, err)
+	assert.Equal(t, StreamTypeH265Video, tr.es.StreamType)
+	assert.Equal(t, annexB(hevcVPSBytes(), hevcSPSBytes(), hevcPPSBytes()), tr.parameterSets)
+	b, key, err := tr.annexB(appendLengthPrefixedNALUnit(nil, hevcIDRBytes()))
+	assert.NoError(t, err)
+	assert.True(t, key)
+	d, err := ParseHEVCData(b)
+	assert.NoError(t, err)
+	assert.Len(t, d.NALUnits, 5)
+	assert.Equal(t, HEVCNALUnitTypeAUD, d.NALUnits[0].Type)
+	assert.True(t, d.IsIRAP())
+
+	// The access unit delimiter of the sample comes before the parameter sets
+	aud := append(hevcNALUnitHeader(HEVCNALUnitTypeAUD), 0x10)
+	b, key, err = tr.annexB(appendLengthPrefixedNALUnit(appendLengthPrefixedNALUnit(nil, aud), hevcIDRBytes()))
+	assert.NoError(t, err)
+	assert.True(t, key)
+	assert.Equal(t, annexB(aud, hevcVPSBytes(), hevcSPSBytes(), hevcPPSBytes(), hevcIDRBytes()), b)
+
+	// Unsupported sample entry
+	_, tr, err = parseFMP4IngestTrak(trak(mp4AudioSampleEntry("Opus", 2, 48000, nil), mp4LanguageUndetermined))
+	assert.NoError(t, err)
+	assert.Nil(t, tr)
+
+	// MPEG audio in an mp4a sample entry
+	_, tr, err = parseFMP4IngestTrak(trak(mp4AudioSampleEntry("mp4a", 2, 48000, mp4FullBox("esds", 0, 0, mp4Descriptor(0x03, []byte{0x0, 0x0, 0x0}, mp4Descriptor(0x04, []byte{0x6b, 0x15}, make([]byte, 11))))), mp4LanguageUndetermined))
+	assert.NoError(t, err)
+	assert.Nil(t, tr)
+
+	// Empty tkhd
+	_, _, err = parseFMP4IngestTrak(&fmp4IngestBox{payload: mp4Box("trak",
+		mp4Box("tkhd"),
+		mp4Box("mdia", mp4Box("mdhd"), mp4Box("minf", mp4Box("stbl", mp4FullBox("stsd", 0, 0, []byte{0x0, 0x0, 0x0, 0x0})))),
+	)[8:]})
+	assert.Equal(t, ErrFMP4BoxTruncated, err)
+}
@@ -0,0 +1,217 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mp4TestBox struct {
+	payload []byte
+	typ     string
+}
+
+func mp4TestBoxes(b []byte) (bs []mp4TestBox) {
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		bs = append(bs, mp4TestBox{payload: b[8:size], typ: string(b[4:8])})
+		b = b[size:]
+	}
+	return
+}
+
+func mp4TestBoxTypes(bs []mp4TestBox) (ts []string) {
+	for _, b := range bs {
+		ts = append(ts, b.typ)
+	}
+	return
+}
+
+// fmp4Bytes returns 10 H264 frames with a key frame every 5 frames and B-frame like
+// composition offsets, and 20 AAC frames.
+func fmp4Bytes(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x101, StreamType: StreamTypeADTS})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 10; idx++ {
+		dts := int64(1000 + idx*3840)
+		frame := annexB(append(h264NALUnitHeader(H264NALUnitTypeNonIDRSlice), 0x9a, 0x1))
+		if idx%5 == 0 {
+			frame = annexB(append(h264NALUnitHeader(H264NALUnitTypeAUD), 0xf0), h264SPSBytes(), h264PPSBytes(), h264IDRBytes())
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(dts, 0), RandomAccessIndicator: idx%5 == 0},
+			PID:             0x100,
+			PES: &PESData{
+				Data: frame,
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					DTS:             newClockReference(dts, 0),
+					PTS:             newClockReference(dts+3840, 0),
+					PTSDTSIndicator: PTSDTSIndicatorBothPresent,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x101,
+			PES: &PESData{
+				Data: append(adtsFrameBytes([]byte{byte(2 * idx)}, true), adtsFrameBytes([]byte{byte(2*idx + 1)}, true)...),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(dts, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+func TestFMP4Remuxer(t *testing.T) {
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(fmp4Bytes(t)), DemuxerOptTimeline())
+	init, fragments := &bytes.Buffer{}, &bytes.Buffer{}
+	r := NewFMP4Remuxer(fragments, FMP4RemuxerOptFragmentDuration(200*time.Millisecond), FMP4RemuxerOptInitWriter(init))
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.NoError(t, r.Remux(d))
+	}
+	assert.NoError(t, r.Close())
+
+	// Init segment
+	bs := mp4TestBoxes(init.Bytes())
+	assert.Equal(t, []string{"ftyp", "moov"}, mp4TestBoxTypes(bs))
+	assert.Equal(t, []byte("iso6\x00\x00\x00\x00iso6cmfcdashmp41"), bs[0].payload)
+	moov := mp4TestBoxes(bs[1].payload)
+	assert.Equal(t, []string{"mvhd", "trak", "trak", "mvex"}, mp4TestBoxTypes(moov))
+	assert.Equal(t, []string{"trex", "trex"}, mp4TestBoxTypes(mp4TestBoxes(moov[3].payload)))
+	stsd := func(trak []byte) []byte {
+		mdia := mp4TestBoxes(mp4TestBoxes(trak)[1].payload)
+		minf := mp4TestBoxes(mdia[2].payload)
+		stbl := mp4TestBoxes(minf[2].payload)
+		return stbl[0].payload
+	}
+
+	// Video sample entry
+	entry := mp4TestBoxes(stsd(moov[1].payload)[8:])
+	assert.Equal(t, "avc1", entry[0].typ)
+	assert.Equal(t, []byte{0x7, 0x80, 0x4, 0x38}, entry[0].payload[24:28])
+	avcC := mp4TestBoxes(entry[0].payload[78:])
+	assert.Equal(t, "avcC", avcC[0].typ)
+	sps, pps := h264SPSBytes(), h264PPSBytes()
+	expected := append([]byte{0x1, 100, 0x0, 40, 0xff, 0xe1, 0x0, byte(len(sps))}, sps...)
+	expected = append(append(expected, 0x1, 0x0, byte(len(pps))), pps...)
+	assert.Equal(t, append(expected, 0xfd, 0xf8, 0xf8, 0x0), avcC[0].payload)
+
+	// Audio sample entry
+	entry = mp4TestBoxes(stsd(moov[2].payload)[8:])
+	assert.Equal(t, "mp4a", entry[0].typ)
+	assert.Equal(t, []byte{0x0, 0x2}, entry[0].payload[16:18])
+	assert.Equal(t, []byte{0xbb, 0x80, 0x0, 0x0}, entry[0].payload[24:28])
+	esds := mp4TestBoxes(entry[0].payload[28:])
+	assert.Equal(t, "esds", esds[0].typ)
+	assert.Equal(t, []byte{0x5, 0x80, 0x80, 0x80, 0x2, 0x11, 0x90}, esds[0].payload[4+5+3+5+13:][:7])
+
+	// Fragments
+	bs = mp4TestBoxes(fragments.Bytes())
+	assert.Equal(t, []string{"moof", "mdat", "moof", "mdat"}, mp4TestBoxTypes(bs))
+	idr := append([]byte{0x0, 0x0, 0x0, byte(len(h264IDRBytes()))}, h264IDRBytes()...)
+	for idx, f := range [][]mp4TestBox{mp4TestBoxes(bs[0].payload), mp4TestBoxes(bs[2].payload)} {
+		assert.Equal(t, []string{"mfhd", "traf", "traf"}, mp4TestBoxTypes(f))
+		assert.Equal(t, uint32(idx+1), binary.BigEndian.Uint32(f[0].payload[4:]))
+
+		// Video
+		traf := mp4TestBoxes(f[1].payload)
+		assert.Equal(t, []string{"tfhd", "tfdt", "trun"}, mp4TestBoxTypes(traf))
+		assert.Equal(t, []byte{0x0, 0x2, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1}, traf[0].payload)
+		assert.Equal(t, uint64(idx*5*3840), binary.BigEndian.Uint64(traf[1].payload[4:]))
+		trun := traf[2].payload
+		assert.Equal(t, []byte{0x1, 0x0, 0xf, 0x1}, trun[:4])
+		assert.Equal(t, uint32(5), binary.BigEndian.Uint32(trun[4:]))
+		assert.Equal(t, uint32(len(bs[idx*2].payload)+16), binary.BigEndian.Uint32(trun[8:]))
+		assert.Equal(t, []uint32{3840, uint32(len(idr)), 0x02000000, 3840}, []uint32{
+			binary.BigEndian.Uint32(trun[12:]),
+			binary.BigEndian.Uint32(trun[16:]),
+			binary.BigEndian.Uint32(trun[20:]),
+			binary.BigEndian.Uint32(trun[24:]),
+		})
+		assert.Equal(t, []uint32{3840, 7, 0x01010000, 3840}, []uint32{
+			binary.BigEndian.Uint32(trun[28:]),
+			binary.BigEndian.Uint32(trun[32:]),
+			binary.BigEndian.Uint32(trun[36:]),
+			binary.BigEndian.Uint32(trun[40:]),
+		})
+
+		// Audio
+		traf = mp4TestBoxes(f[2].payload)
+		assert.Equal(t, uint64(idx*10*1024), binary.BigEndian.Uint64(traf[1].payload[4:]))
+		trun = traf[2].payload
+		assert.Equal(t, []byte{0x1, 0x0, 0x7, 0x1}, trun[:4])
+		assert.Equal(t, uint32(10), binary.BigEndian.Uint32(trun[4:]))
+		assert.Equal(t, []uint32{1024, 1, 0x02000000}, []uint32{
+			binary.BigEndian.Uint32(trun[12:]),
+			binary.BigEndian.Uint32(trun[16:]),
+			binary.BigEndian.Uint32(trun[20:]),
+		})
+
+		// Data
+		mdat := bs[idx*2+1].payload
+		assert.Equal(t, idr, mdat[:len(idr)])
+		assert.Equal(t, byte(idx*10), mdat[len(idr)+4*7])
+	}
+
+	// No track
+	r = NewFMP4Remuxer(&bytes.Buffer{})
+	assert.Equal(t, ErrFMP4NoTrack, r.Remux(&DemuxerData{PMT: &PMTData{ElementaryStreams: []*PMTElementaryStream{{StreamType: StreamTypeMPEG1Audio}}}}))
+	assert.Equal(t, ErrFMP4NoTrack, r.Close())
+}
+
+func TestMP4ConfigurationBoxes(t *testing.T) {
+	// AC-3
+	d, err := ParseAC3Data(ac3FrameBytes(AC3AudioCodingMode2_0, false), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0xb, 'd', 'a', 'c', '3', 0x10, 0x10, 0x0}, mp4AC3SpecificBox(d.Frames[0]))
+
+	// E-AC-3 with a dependent substream and JOC
+	d, err = ParseAC3Data(append(eac3FrameBytes(EAC3StreamTypeIndependent, AC3AudioCodingMode3_2, true, 0, true), eac3FrameBytes(EAC3StreamTypeDependent, AC3AudioCodingMode2_0, false, 1<<(15-6), false)...), nil)
+	assert.NoError(t, err)
+	b := mp4EAC3SpecificBox(d)
+	assert.Equal(t, "dec3", string(b[4:8]))
+	assert.Equal(t, uint16(2*256*8*48000/(6*256)/1000), binary.BigEndian.Uint16(b[8:])>>3)
+	assert.Equal(t, byte(1), b[12]>>1&0xf) // Number of dependent substreams
+	assert.Equal(t, byte(0x80), b[13])     // Channel location Lrs/Rrs
+
+	// HE-AACv2 keeps its SBR and PS signalling
+	b = mp4ESDescriptorBox(audioSpecificConfigHEAACv2)
+	assert.Equal(t, append([]byte{0x5, 0x80, 0x80, 0x80, 0x4}, audioSpecificConfigHEAACv2.Data...), b[8+4+5+3+5+13:][:9])
+
+	// HEVC
+	h, err := ParseHEVCData(hevcBytes())
+	assert.NoError(t, err)
+	e := mp4TestBoxes(mp4VisualSampleEntry("hvc1", h.SPS().Width(), h.SPS().Height(), mp4HEVCConfigurationBox(h.SPS(), map[HEVCNALUnitType][]byte{
+		HEVCNALUnitTypeVPS: hevcVPSBytes(),
+		HEVCNALUnitTypeSPS: hevcSPSBytes(),
+		HEVCNALUnitTypePPS: hevcPPSBytes(),
+	})))
+	assert.Equal(t, "hvc1", e[0].typ)
+	assert.Equal(t, []byte{0xf, 0x0, 0x8, 0x70}, e[0].payload[24:28])
+	hvcC := mp4TestBoxes(e[0].payload[78:])[0]
+	assert.Equal(t, "hvcC", hvcC.typ)
+	assert.Equal(t, byte(0x3), hvcC.payload[21]&0x3) // Length size minus one
+	assert.Equal(t, byte(3), hvcC.payload[22])       // Number of arrays
+	assert.Equal(t, byte(0x80|HEVCNALUnitTypeVPS), hvcC.payload[23])
+	assert.Equal(t, hevcVPSBytes(), hvcC.payload[28:28+len(hevcVPSBytes())])
+}
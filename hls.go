@@ -0,0 +1,473 @@
+package astits
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Default HLS segmenter options.
+const (
+	defaultHLSPlaylistName   = "playlist.m3u8"
+	defaultHLSSegmentName    = "segment%d.ts"
+	defaultHLSTargetDuration = 6 * time.Second
+)
+
+// ErrHLSNoProgram is returned when the input doesn't contain any program to segment.
+var ErrHLSNoProgram = errors.New("no program found")
+
+// HLSSegment represents a segment of an HLS media playlist.
+type HLSSegment struct {
+	DateTime      time.Time // Only set when a TDT or a TOT has been received.
+	Discontinuity bool
+	Duration      time.Duration
+	Name          string
+	Sequence      int
+}
+
+// HLSSegmenter cuts a transport stream into HLS segments and writes them along with a
+// media playlist to a directory. Only the first program of the PAT is segmented.
+//
+// Segments are cut at the random access points of the first video stream once the
+// target duration is reached, and at the first random access point following a
+// timestamp discontinuity. Without video, they are cut at any PES of the first stream.
+// Data is remuxed so that every segment starts with a PAT and a PMT and continuity
+// counters are continuous across segments.
+type HLSSegmenter struct {
+	cutPID            uint16
+	dir               string
+	discontinuity     bool
+	frameInterval     int64
+	hasUTC            bool
+	lastDTS           *ClockReference
+	mx                *Muxer
+	optLiveWindow     int
+	optPlaylistName   string
+	optSegmentName    string
+	optTargetDuration time.Duration
+	pcrDiscontinuity  bool
+	pcrPID            uint16 // PID carrying the PCRs in the input
+	pendingPCR        *ClockReference
+	programNumber     uint16
+	removed           []*HLSSegment // Segments that left the live window but are still on disk
+	removedDisc       int           // Discontinuities that left the live window
+	segment           *hlsSegment
+	segments          []*HLSSegment
+	sequence          int
+	streamTypes       map[uint16]StreamType // Indexed by elementary PID
+	targetDuration    time.Duration
+	targetPinned      bool // The target duration can't change once a live playlist has been written
+	utc               time.Time
+	utcPCR            *ClockReference // Unwrapped value of the system clock when utc was received
+	w                 *hlsSegmentWriter
+}
+
+// hlsSegment represents the segment being written.
+type hlsSegment struct {
+	f        *os.File
+	maxPTS   *ClockReference
+	s        *HLSSegment
+	startPTS *ClockReference
+}
+
+// hlsSegmentWriter lets the muxer write to the current segment.
+type hlsSegmentWriter struct {
+	w *bufio.Writer
+}
+
+func (w *hlsSegmentWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+func (w *hlsSegmentWriter) WriteByte(c byte) error {
+	return w.w.WriteByte(c)
+}
+
+// HLSSegmenterOptLive returns the option to write a live playlist that only lists the last
+// windowSize segments. Segments are deleted once they have left the window for as long as
+// the window lasts, so that clients that have just loaded the playlist can still get them.
+// The target duration of the playlist doesn't change once it has been written, so random
+// access points should not be further apart than the target duration.
+func HLSSegmenterOptLive(windowSize int) func(*HLSSegmenter) {
+	return func(s *HLSSegmenter) {
+		s.optLiveWindow = windowSize
+	}
+}
+
+// HLSSegmenterOptPlaylistName returns the option to set the name of the playlist. Default
+// is "playlist.m3u8".
+func HLSSegmenterOptPlaylistName(name string) func(*HLSSegmenter) {
+	return func(s *HLSSegmenter) {
+		s.optPlaylistName = name
+	}
+}
+
+// HLSSegmenterOptSegmentName returns the option to set the name of the segments, which is
+// a format receiving the media sequence number. Default is "segment%d.ts".
+func HLSSegmenterOptSegmentName(format string) func(*HLSSegmenter) {
+	return func(s *HLSSegmenter) {
+		s.optSegmentName = format
+	}
+}
+
+// HLSSegmenterOptTargetDuration returns the option to set the duration after which
+// segments are cut. Default is 6s.
+func HLSSegmenterOptTargetDuration(d time.Duration) func(*HLSSegmenter) {
+	return func(s *HLSSegmenter) {
+		s.optTargetDuration = d
+	}
+}
+
+// NewHLSSegmenter creates a new HLS segmenter writing to a directory.
+func NewHLSSegmenter(dir string, opts ...func(*HLSSegmenter)) *HLSSegmenter {
+	s := &HLSSegmenter{
+		dir:               dir,
+		optPlaylistName:   defaultHLSPlaylistName,
+		optSegmentName:    defaultHLSSegmentName,
+		optTargetDuration: defaultHLSTargetDuration,
+		streamTypes:       make(map[uint16]StreamType),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Segments returns the segments listed in the playlist.
+func (s *HLSSegmenter) Segments() []*HLSSegment {
+	return append([]*HLSSegment{}, s.segments...)
+}
+
+// Segment reads the reader until its end and segments it. In live mode, the playlist is
+// updated every time a segment is complete. Otherwise, it is only written at the end.
+func (s *HLSSegmenter) Segment(ctx context.Context, r io.Reader) (err error) {
+	// Create directory
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("creating directory %s failed: %w", s.dir, err)
+	}
+
+	// Loop through data
+	dmx := NewDemuxer(ctx, r, DemuxerOptAdaptationFieldData(), DemuxerOptTimeline())
+	for {
+		var d *DemuxerData
+		if d, err = dmx.NextData(); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+				break
+			}
+			return fmt.Errorf("fetching next data failed: %w", err)
+		}
+
+		switch {
+		case d.PAT != nil:
+			for _, p := range d.PAT.Programs {
+				// Program number 0 is reserved to NIT
+				if s.programNumber == 0 && p.ProgramNumber > 0 {
+					s.programNumber = p.ProgramNumber
+				}
+			}
+		case d.PMT != nil:
+			if s.mx == nil && d.PMT.ProgramNumber == s.programNumber {
+				if err = s.createMuxer(ctx, d.PMT); err != nil {
+					return fmt.Errorf("creating muxer failed: %w", err)
+				}
+			}
+		case d.TDT != nil:
+			s.updateUTC(d.TDT.UTCTime, dmx.timelines.lastPCR(s.pcrPID))
+		case d.TOT != nil:
+			s.updateUTC(d.TOT.UTCTime, dmx.timelines.lastPCR(s.pcrPID))
+		case d.AdaptationField != nil:
+			if s.mx != nil && d.PID == s.pcrPID && d.AdaptationField.HasPCR {
+				s.pendingPCR = d.AdaptationField.PCR
+				if d.AdaptationField.DiscontinuityIndicator {
+					s.pcrDiscontinuity = true
+				}
+			}
+		case d.PES != nil:
+			if err = s.writePES(d); err != nil {
+				return fmt.Errorf("writing PES failed: %w", err)
+			}
+		}
+	}
+
+	// No program
+	if s.mx == nil {
+		return ErrHLSNoProgram
+	}
+
+	// Close last segment
+	if err = s.closeSegment(nil); err != nil {
+		return fmt.Errorf("closing segment failed: %w", err)
+	}
+	if err = s.writePlaylist(true); err != nil {
+		return fmt.Errorf("writing playlist failed: %w", err)
+	}
+	return nil
+}
+
+func (s *HLSSegmenter) createMuxer(ctx context.Context, pmt *PMTData) error {
+	// Segments are cut on the first video stream, or on the first stream
+	s.w = &hlsSegmentWriter{}
+	s.mx = NewMuxer(ctx, s.w)
+	var hasVideo, hasPCRPID bool
+	for idx, es := range pmt.ElementaryStreams {
+		if err := s.mx.AddElementaryStream(*es); err != nil {
+			return fmt.Errorf("adding elementary stream %d failed: %w", es.ElementaryPID, err)
+		}
+		t := elementaryStreamType(es)
+		s.streamTypes[es.ElementaryPID] = t
+		if idx == 0 || (!hasVideo && t.IsVideo()) {
+			s.cutPID = es.ElementaryPID
+			hasVideo = t.IsVideo()
+		}
+		if es.ElementaryPID == pmt.PCRPID {
+			hasPCRPID = true
+		}
+	}
+	if len(pmt.ElementaryStreams) == 0 {
+		return ErrHLSNoProgram
+	}
+
+	// When PCRs have a dedicated PID, they are moved to the stream segments are cut on
+	s.pcrPID = pmt.PCRPID
+	if hasPCRPID {
+		s.mx.SetPCRPID(pmt.PCRPID)
+	} else {
+		s.mx.SetPCRPID(s.cutPID)
+	}
+	return nil
+}
+
+// updateUTC anchors the UTC time of a TDT or a TOT to the system clock at its arrival,
+// which is given by the last PCR unwrapped on the timeline of the program so that it can
+// be compared with the unwrapped PTSs.
+func (s *HLSSegmenter) updateUTC(t time.Time, pcr *ClockReference) {
+	if s.mx == nil || pcr == nil {
+		return
+	}
+	s.hasUTC = true
+	s.utc = t
+	s.utcPCR = pcr
+}
+
+func (s *HLSSegmenter) writePES(d *DemuxerData) (err error) {
+	// Only streams of the program are written
+	t, ok := s.streamTypes[d.PID]
+	if !ok {
+		return nil
+	}
+
+	// Segments are cut on random access points
+	af := &PacketAdaptationField{}
+	if d.PID == s.cutPID {
+		var pts, dts *ClockReference
+		if d.Timeline != nil {
+			pts, dts = d.Timeline.PTS, d.Timeline.DTS
+			if d.Timeline.Discontinuity {
+				s.discontinuity = true
+			}
+		}
+		if dts == nil {
+			dts = pts
+		}
+
+		rap := !t.IsVideo() ||
+			(d.FirstPacket != nil && d.FirstPacket.Header.HasAdaptationField && d.FirstPacket.AdaptationField.RandomAccessIndicator) ||
+			videoFrameType(t, d.PES.Data) != FrameTypeUnknown
+		if rap && pts != nil && (s.segment == nil || s.discontinuity ||
+			clockReferenceBaseDuration(pts.Base-s.segment.startPTS.Base) >= s.optTargetDuration) {
+			if err = s.cutSegment(pts); err != nil {
+				return fmt.Errorf("cutting segment failed: %w", err)
+			}
+		}
+		af.RandomAccessIndicator = rap && t.IsVideo()
+
+		// Keep track of timestamps
+		if s.segment != nil && pts != nil && (s.segment.maxPTS == nil || pts.Base > s.segment.maxPTS.Base) {
+			s.segment.maxPTS = pts
+		}
+		if dts != nil {
+			if s.lastDTS != nil && !d.Timeline.Discontinuity && dts.Base > s.lastDTS.Base {
+				s.frameInterval = dts.Base - s.lastDTS.Base
+			}
+			s.lastDTS = dts
+		}
+	}
+
+	// Data preceding the first random access point is dropped
+	if s.segment == nil {
+		return nil
+	}
+
+	// PCR
+	if d.PID == s.mx.pmt.PCRPID && s.pendingPCR != nil {
+		af.HasPCR = true
+		af.PCR = s.pendingPCR
+		af.DiscontinuityIndicator = s.pcrDiscontinuity
+		s.pendingPCR = nil
+		s.pcrDiscontinuity = false
+	}
+	if !af.HasPCR && !af.RandomAccessIndicator {
+		af = nil
+	}
+
+	if _, err = s.mx.WriteData(&MuxerData{AdaptationField: af, PES: d.PES, PID: d.PID}); err != nil {
+		return fmt.Errorf("writing data failed: %w", err)
+	}
+	return nil
+}
+
+// cutSegment closes the current segment and opens a new one starting at pts.
+func (s *HLSSegmenter) cutSegment(pts *ClockReference) (err error) {
+	// Close current segment
+	if s.segment != nil {
+		if err = s.closeSegment(pts); err != nil {
+			return fmt.Errorf("closing segment failed: %w", err)
+		}
+		if s.optLiveWindow > 0 {
+			if err = s.writePlaylist(false); err != nil {
+				return fmt.Errorf("writing playlist failed: %w", err)
+			}
+		}
+	}
+
+	// Create file
+	sg := &hlsSegment{
+		s: &HLSSegment{
+			Discontinuity: s.discontinuity && len(s.segments) > 0,
+			Name:          fmt.Sprintf(s.optSegmentName, s.sequence),
+			Sequence:      s.sequence,
+		},
+		startPTS: pts,
+	}
+	if sg.f, err = os.Create(filepath.Join(s.dir, sg.s.Name)); err != nil {
+		return fmt.Errorf("creating %s failed: %w", sg.s.Name, err)
+	}
+	s.discontinuity = false
+	s.segment = sg
+	s.sequence++
+	s.w.w = bufio.NewWriter(sg.f)
+
+	// Date time. Timestamps can't be compared across a discontinuity, in which case the
+	// segment starts when the previous one ends.
+	if s.hasUTC {
+		if l := len(s.segments); sg.s.Discontinuity && l > 0 && !s.segments[l-1].DateTime.IsZero() {
+			s.utc = s.segments[l-1].DateTime.Add(s.segments[l-1].Duration)
+			s.utcPCR = pts
+		}
+		sg.s.DateTime = s.utc.Add(clockReferenceBaseDuration(pts.Base - s.utcPCR.Base))
+	}
+
+	// Segments must start with the tables. The muxer writes them itself before random
+	// access points of its PCR PID.
+	if s.cutPID != s.mx.pmt.PCRPID || !s.streamTypes[s.cutPID].IsVideo() {
+		if _, err = s.mx.WriteTables(); err != nil {
+			return fmt.Errorf("writing tables failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// closeSegment closes the current segment. When the next segment is unknown or follows
+// a discontinuity, the duration is derived from the last PTS and the frame interval.
+func (s *HLSSegmenter) closeSegment(next *ClockReference) (err error) {
+	sg := s.segment
+	if sg == nil {
+		return nil
+	}
+
+	// Flush and close
+	if err = s.w.w.Flush(); err != nil {
+		return fmt.Errorf("flushing %s failed: %w", sg.s.Name, err)
+	}
+	if err = sg.f.Close(); err != nil {
+		return fmt.Errorf("closing %s failed: %w", sg.s.Name, err)
+	}
+	s.segment = nil
+
+	// Duration
+	if next != nil && !s.discontinuity {
+		sg.s.Duration = clockReferenceBaseDuration(next.Base - sg.startPTS.Base)
+	} else if sg.maxPTS != nil {
+		sg.s.Duration = clockReferenceBaseDuration(sg.maxPTS.Base - sg.startPTS.Base + s.frameInterval)
+	}
+	if !s.targetPinned && sg.s.Duration > s.targetDuration {
+		s.targetDuration = sg.s.Duration
+	}
+	s.segments = append(s.segments, sg.s)
+
+	// Sliding window
+	if s.optLiveWindow > 0 && len(s.segments) > s.optLiveWindow {
+		r := s.segments[0]
+		s.segments = s.segments[1:]
+		s.removed = append(s.removed, r)
+		if r.Discontinuity {
+			s.removedDisc++
+		}
+		if len(s.removed) > s.optLiveWindow {
+			if err = os.Remove(filepath.Join(s.dir, s.removed[0].Name)); err != nil {
+				return fmt.Errorf("removing %s failed: %w", s.removed[0].Name, err)
+			}
+			s.removed = s.removed[1:]
+		}
+	}
+	return nil
+}
+
+// writePlaylist writes the playlist atomically.
+func (s *HLSSegmenter) writePlaylist(end bool) (err error) {
+	// The target duration is at least the one of the option
+	if s.optLiveWindow > 0 && !s.targetPinned {
+		if s.targetDuration < s.optTargetDuration {
+			s.targetDuration = s.optTargetDuration
+		}
+		s.targetPinned = true
+	}
+
+	// Header
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int64((s.targetDuration+time.Second-1)/time.Second))
+	if s.optLiveWindow > 0 {
+		var sequence int
+		if len(s.segments) > 0 {
+			sequence = s.segments[0].Sequence
+		}
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", sequence)
+		if s.removedDisc > 0 {
+			fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", s.removedDisc)
+		}
+	} else {
+		b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+
+	// Segments
+	for _, sg := range s.segments {
+		if sg.Discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if !sg.DateTime.IsZero() {
+			fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", sg.DateTime.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", sg.Duration.Seconds(), sg.Name)
+	}
+	if end {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	// Write
+	p := filepath.Join(s.dir, s.optPlaylistName)
+	if err = ioutil.WriteFile(p+".tmp", []byte(b.String()), 0644); err != nil { //nolint:gosec
+		return fmt.Errorf("writing %s failed: %w", p+".tmp", err)
+	}
+	if err = os.Rename(p+".tmp", p); err != nil {
+		return fmt.Errorf("renaming %s failed: %w", p+".tmp", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hlsBytes returns 10s of H264 video and AAC audio with a PES every 200ms, a key frame
+// every second, a TDT after the first PES and a timestamp jump at 6s.
+func hlsBytes(t *testing.T) []byte {
+	return hlsTDTBytes(t, 0, 1)
+}
+
+// hlsTDTBytes returns the same stream as hlsBytes, with timestamps starting at start and
+// a TDT after the PES of index tdt.
+func hlsTDTBytes(t *testing.T, start int64, tdt int) []byte {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x101, StreamType: StreamTypeAACAudio})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 50; idx++ {
+		pcr := start + int64(idx*18000)
+		if idx >= 30 {
+			pcr += 100 * 90000
+		}
+		pcr %= clockReferenceBaseModulo
+		frame := []byte{0x0, 0x0, 0x0, 0x1, 0x41, 0xe0} // P slice
+		if idx%5 == 0 {
+			frame = []byte{0x0, 0x0, 0x0, 0x1, 0x65, 0x88} // IDR slice
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(pcr, 0)},
+			PID:             0x100,
+			PES: &PESData{
+				Data: append(frame, bytes.Repeat([]byte{0x1}, 300)...),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference((pcr+9000)%clockReferenceBaseModulo, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x101,
+			PES: &PESData{
+				Data: bytes.Repeat([]byte{0x2}, 100),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference((pcr+9000)%clockReferenceBaseModulo, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+		if idx == tdt {
+			_, err = mx.WritePacket(&Packet{
+				Header:  &PacketHeader{HasPayload: true, PayloadUnitStartIndicator: true, PID: 0x14},
+				Payload: append([]byte{0x0, 0x70, 0x70, 0x5}, dvbTimeBytes...),
+			})
+			assert.NoError(t, err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestHLSSegmenter(t *testing.T) {
+	b := hlsBytes(t)
+
+	// VOD
+	dir, err := ioutil.TempDir("", "astits")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := NewHLSSegmenter(dir, HLSSegmenterOptTargetDuration(2*time.Second))
+	err = s.Segment(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+
+	// Date times are relative to the PCR received before the TDT, 2.1s - 200ms for segment1
+	assert.Equal(t, []*HLSSegment{
+		{Duration: 2 * time.Second, Name: "segment0.ts", Sequence: 0},
+		{DateTime: dvbTime.Add(1900 * time.Millisecond), Duration: 2 * time.Second, Name: "segment1.ts", Sequence: 1},
+		{DateTime: dvbTime.Add(3900 * time.Millisecond), Duration: 2 * time.Second, Name: "segment2.ts", Sequence: 2},
+		{DateTime: dvbTime.Add(5900 * time.Millisecond), Discontinuity: true, Duration: 2 * time.Second, Name: "segment3.ts", Sequence: 3},
+		{DateTime: dvbTime.Add(7900 * time.Millisecond), Duration: 2 * time.Second, Name: "segment4.ts", Sequence: 4},
+	}, s.Segments())
+	p, err := ioutil.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:2.000,
+segment0.ts
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:01.900Z
+#EXTINF:2.000,
+segment1.ts
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:03.900Z
+#EXTINF:2.000,
+segment2.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:05.900Z
+#EXTINF:2.000,
+segment3.ts
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:07.900Z
+#EXTINF:2.000,
+segment4.ts
+#EXT-X-ENDLIST
+`, string(p))
+
+	// Segments start with the tables and continuity counters are continuous across them
+	var all []byte
+	for idx := 0; idx < 5; idx++ {
+		sb, err := ioutil.ReadFile(filepath.Join(dir, s.Segments()[idx].Name))
+		assert.NoError(t, err)
+		assert.Equal(t, PIDPAT, uint16(sb[1]&0x1f)<<8|uint16(sb[2]))
+		assert.Equal(t, uint16(0x1000), uint16(sb[MpegTsPacketSize+1]&0x1f)<<8|uint16(sb[MpegTsPacketSize+2]))
+		all = append(all, sb...)
+	}
+	a, _ := analyzeTR101290(t, all)
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Live
+	liveDir, err := ioutil.TempDir("", "astits")
+	assert.NoError(t, err)
+	defer os.RemoveAll(liveDir)
+	s = NewHLSSegmenter(liveDir, HLSSegmenterOptLive(2), HLSSegmenterOptPlaylistName("live.m3u8"), HLSSegmenterOptSegmentName("live%d.ts"), HLSSegmenterOptTargetDuration(2*time.Second))
+	err = s.Segment(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	p, err = ioutil.ReadFile(filepath.Join(liveDir, "live.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:2
+#EXT-X-MEDIA-SEQUENCE:3
+#EXT-X-DISCONTINUITY
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:05.900Z
+#EXTINF:2.000,
+live3.ts
+#EXT-X-PROGRAM-DATE-TIME:1993-10-13T12:45:07.900Z
+#EXTINF:2.000,
+live4.ts
+#EXT-X-ENDLIST
+`, string(p))
+	fs, err := ioutil.ReadDir(liveDir)
+	assert.NoError(t, err)
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.Name())
+	}
+	assert.Equal(t, []string{"live.m3u8", "live1.ts", "live2.ts", "live3.ts", "live4.ts"}, names)
+}
+
+func TestHLSSegmenterDateTimeWrap(t *testing.T) {
+	// Timestamps wrap around at 5s and the TDT follows the timestamp jump
+	dir, err := ioutil.TempDir("", "astits")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := NewHLSSegmenter(dir, HLSSegmenterOptTargetDuration(2*time.Second))
+	err = s.Segment(context.Background(), bytes.NewReader(hlsTDTBytes(t, clockReferenceBaseModulo-5*90000, 31)))
+	assert.NoError(t, err)
+
+	// Date times are relative to the PCR received before the TDT, 1.8s + 100ms of PTS offset
+	// for segment4
+	assert.Len(t, s.Segments(), 5)
+	for idx := 0; idx < 4; idx++ {
+		assert.True(t, s.Segments()[idx].DateTime.IsZero())
+	}
+	assert.Equal(t, dvbTime.Add(1900*time.Millisecond), s.Segments()[4].DateTime)
+}
+
+func TestHLSSegmenterLiveTargetDuration(t *testing.T) {
+	// Key frames every second, except for a 2s gap at 2s
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 30; idx++ {
+		frame := []byte{0x0, 0x0, 0x0, 0x1, 0x41, 0xe0} // P slice
+		if idx%5 == 0 && idx != 15 {
+			frame = []byte{0x0, 0x0, 0x0, 0x1, 0x65, 0x88} // IDR slice
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(int64(idx*18000), 0)},
+			PID:             0x100,
+			PES: &PESData{
+				Data: frame,
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(int64(idx*18000+9000), 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	// Target duration doesn't change once the playlist has been written
+	dir, err := ioutil.TempDir("", "astits")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := NewHLSSegmenter(dir, HLSSegmenterOptLive(10), HLSSegmenterOptTargetDuration(time.Second))
+	err = s.Segment(context.Background(), bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	p, err := ioutil.ReadFile(filepath.Join(dir, "playlist.m3u8"))
+	assert.NoError(t, err)
+	assert.Equal(t, `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:1
+#EXT-X-MEDIA-SEQUENCE:0
+#EXTINF:1.000,
+segment0.ts
+#EXTINF:1.000,
+segment1.ts
+#EXTINF:2.000,
+segment2.ts
+#EXTINF:1.000,
+segment3.ts
+#EXTINF:1.000,
+segment4.ts
+#EXT-X-ENDLIST
+`, string(p))
+}
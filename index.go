@@ -0,0 +1,319 @@
+package astits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/icza/bitio"
+)
+
+// Frame types.
+const (
+	FrameTypeUnknown FrameType = 0
+	FrameTypeI       FrameType = 1 // Intra picture that may not be an IRAP/IDR picture.
+	FrameTypeIDR     FrameType = 2
+	FrameTypeCRA     FrameType = 3
+	FrameTypeBLA     FrameType = 4
+)
+
+const (
+	indexMagic   = "ATSI"
+	indexVersion = 1
+)
+
+// Index entry flags.
+const (
+	indexEntryFlagHasPCR                = 0x1
+	indexEntryFlagHasPTS                = 0x2
+	indexEntryFlagRandomAccessIndicator = 0x4
+)
+
+// Errors.
+var (
+	ErrIndexInvalidMagic   = errors.New("invalid index magic")
+	ErrIndexInvalidVersion = errors.New("invalid index version")
+)
+
+// FrameType represents the type of the picture starting at a random access point.
+type FrameType uint8
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameTypeI:
+		return "I"
+	case FrameTypeIDR:
+		return "IDR"
+	case FrameTypeCRA:
+		return "CRA"
+	case FrameTypeBLA:
+		return "BLA"
+	}
+	return "Unknown"
+}
+
+// Index represents the random access points of the video stream of a transport stream.
+type Index struct {
+	Entries    []*IndexEntry
+	PacketSize int
+	PCRPID     uint16
+	PID        uint16
+	StreamType StreamType
+}
+
+// IndexEntry represents a random access point, which is the first packet of a video PES
+// either starting with a key frame or flagged with the random access indicator.
+type IndexEntry struct {
+	FrameType             FrameType
+	Offset                int64           // Byte offset of the first packet of the PES.
+	PacketIndex           int64           // Ordinal of the first packet of the PES.
+	PCR                   *ClockReference // Last PCR received before the first packet of the PES.
+	PTS                   *ClockReference
+	RandomAccessIndicator bool
+}
+
+// indexBuilder builds an index out of the packets of a demuxer.
+type indexBuilder struct {
+	dmx     *Demuxer
+	i       *Index
+	lastPCR *ClockReference
+	pesPCR  *ClockReference // Last PCR received before the first packet of the current video PES
+}
+
+// BuildIndex reads the whole reader and indexes the random access points of the first
+// video stream found in the PMTs. Key frames are detected in H.264, H.265 and MPEG-1/2
+// video PES, other streams only rely on the adaptation field random access indicator.
+func BuildIndex(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) (*Index, error) {
+	b := &indexBuilder{
+		dmx: NewDemuxer(ctx, r, opts...),
+		i:   &Index{},
+	}
+
+	for {
+		// Get next packet
+		p, err := b.dmx.NextPacket()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("fetching next packet failed: %w", err)
+			}
+
+			// Dump the packet pool
+			b.processData(b.dmx.remainingPacketData())
+			return b.i, nil
+		}
+
+		// The packet completes the previous PES, which is processed first
+		b.i.PacketSize = b.dmx.packetBuffer.packetSize
+		b.processData(b.dmx.packetData(p))
+		b.processPacket(p)
+	}
+}
+
+// processPacket keeps track of the PCR and of its value when a video PES starts.
+func (b *indexBuilder) processPacket(p *Packet) {
+	if p.Header.HasAdaptationField && p.AdaptationField.HasPCR && b.i.PCRPID > 0 && p.Header.PID == b.i.PCRPID {
+		b.lastPCR = p.AdaptationField.PCR
+	}
+	if b.i.PID > 0 && p.Header.PID == b.i.PID && p.Header.PayloadUnitStartIndicator {
+		b.pesPCR = b.lastPCR
+	}
+}
+
+// processData processes the data of a payload unit.
+func (b *indexBuilder) processData(ds []*DemuxerData) {
+	for _, d := range ds {
+		switch {
+		case d.PMT != nil:
+			if b.i.PID > 0 {
+				continue
+			}
+			for _, es := range d.PMT.ElementaryStreams {
+				if es.StreamType.IsVideo() {
+					b.i.PCRPID = d.PMT.PCRPID
+					b.i.PID = es.ElementaryPID
+					b.i.StreamType = es.StreamType
+					break
+				}
+			}
+		case d.PES != nil && d.PID == b.i.PID:
+			b.processPES(d.FirstPacket, d.PES)
+		}
+	}
+}
+
+// processPES adds an entry if the PES starts with a random access point.
+func (b *indexBuilder) processPES(first *Packet, d *PESData) {
+	e := &IndexEntry{
+		FrameType:   videoFrameType(b.i.StreamType, d.Data),
+		Offset:      first.Offset,
+		PacketIndex: first.Index,
+		PCR:         b.pesPCR,
+		PTS:         pesPTS(d),
+	}
+	e.RandomAccessIndicator = first.Header.HasAdaptationField && first.AdaptationField.RandomAccessIndicator
+	if e.FrameType == FrameTypeUnknown && !e.RandomAccessIndicator {
+		return
+	}
+	b.i.Entries = append(b.i.Entries, e)
+}
+
+// videoFrameType returns the type of the first picture of a video PES payload if it is
+// a key frame, FrameTypeUnknown otherwise.
+func videoFrameType(t StreamType, b []byte) FrameType {
+	switch t {
+	case StreamTypeH264Video:
+		return h264FrameType(b)
+	case StreamTypeH265Video:
+		d, err := ParseHEVCData(b)
+		if err != nil {
+			return FrameTypeUnknown
+		}
+		nt, ok := d.IRAPType()
+		switch {
+		case !ok:
+			return FrameTypeUnknown
+		case nt.IsIDR():
+			return FrameTypeIDR
+		case nt.IsCRA():
+			return FrameTypeCRA
+		case nt.IsBLA():
+			return FrameTypeBLA
+		}
+		return FrameTypeI
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+		d, err := ParseMPEG2VideoData(b)
+		if err != nil || !d.IsKeyFrame() {
+			return FrameTypeUnknown
+		}
+		return FrameTypeI
+	}
+	return FrameTypeUnknown
+}
+
+// h264FrameType looks for the first slice of an H.264 byte stream and returns its type
+// if it is a key frame.
+func h264FrameType(b []byte) FrameType {
+	d, err := ParseH264Data(b)
+	if err != nil {
+		return FrameTypeUnknown
+	}
+	for _, n := range d.NALUnits {
+		switch n.Type {
+		case H264NALUnitTypeIDRSlice:
+			return FrameTypeIDR
+		case H264NALUnitTypeNonIDRSlice:
+			if n.SliceHeader != nil && n.SliceHeader.IsIntra() {
+				return FrameTypeI
+			}
+			return FrameTypeUnknown
+		}
+	}
+	return FrameTypeUnknown
+}
+
+// WriteTo writes the index in a binary format that can be read with ReadIndex.
+func (i *Index) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bitio.NewWriter(cw)
+
+	// Header
+	_, _ = bw.Write([]byte(indexMagic))
+	bw.TryWriteByte(indexVersion)
+	bw.TryWriteBits(uint64(i.PacketSize), 16)
+	bw.TryWriteBits(uint64(i.PID), 16)
+	bw.TryWriteBits(uint64(i.PCRPID), 16)
+	bw.TryWriteByte(uint8(i.StreamType))
+	bw.TryWriteBits(uint64(len(i.Entries)), 32)
+
+	// Entries
+	for _, e := range i.Entries {
+		var flags uint8
+		if e.PCR != nil {
+			flags |= indexEntryFlagHasPCR
+		}
+		if e.PTS != nil {
+			flags |= indexEntryFlagHasPTS
+		}
+		if e.RandomAccessIndicator {
+			flags |= indexEntryFlagRandomAccessIndicator
+		}
+		bw.TryWriteByte(flags)
+		bw.TryWriteByte(uint8(e.FrameType))
+		bw.TryWriteBits(uint64(e.Offset), 64)
+		bw.TryWriteBits(uint64(e.PacketIndex), 64)
+		if e.PCR != nil {
+			bw.TryWriteBits(uint64(e.PCR.Base), 33)
+			bw.TryWriteBits(uint64(e.PCR.Extension), 15)
+		}
+		if e.PTS != nil {
+			bw.TryWriteBits(uint64(e.PTS.Base), 33)
+			bw.TryWriteBits(0, 7)
+		}
+	}
+
+	if bw.TryError != nil {
+		return cw.n, fmt.Errorf("writing index failed: %w", bw.TryError)
+	}
+	if err := bw.Close(); err != nil {
+		return cw.n, fmt.Errorf("closing bit writer failed: %w", err)
+	}
+	return cw.n, nil
+}
+
+// ReadIndex reads an index written with Index.WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bitio.NewReader(r)
+
+	// Header
+	magic := make([]byte, len(indexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("reading magic failed: %w", err)
+	}
+	if string(magic) != indexMagic {
+		return nil, ErrIndexInvalidMagic
+	}
+	if v := br.TryReadByte(); br.TryError == nil && v != indexVersion {
+		return nil, fmt.Errorf("%w: %d", ErrIndexInvalidVersion, v)
+	}
+	i := &Index{}
+	i.PacketSize = int(br.TryReadBits(16))
+	i.PID = uint16(br.TryReadBits(16))
+	i.PCRPID = uint16(br.TryReadBits(16))
+	i.StreamType = StreamType(br.TryReadByte())
+	count := br.TryReadBits(32)
+
+	// Entries
+	for n := uint64(0); n < count && br.TryError == nil; n++ {
+		flags := br.TryReadByte()
+		e := &IndexEntry{RandomAccessIndicator: flags&indexEntryFlagRandomAccessIndicator > 0}
+		e.FrameType = FrameType(br.TryReadByte())
+		e.Offset = int64(br.TryReadBits(64))
+		e.PacketIndex = int64(br.TryReadBits(64))
+		if flags&indexEntryFlagHasPCR > 0 {
+			e.PCR = newClockReference(int64(br.TryReadBits(33)), int64(br.TryReadBits(15)))
+		}
+		if flags&indexEntryFlagHasPTS > 0 {
+			e.PTS = newClockReference(int64(br.TryReadBits(33)), 0)
+			_ = br.TryReadBits(7)
+		}
+		i.Entries = append(i.Entries, e)
+	}
+
+	if br.TryError != nil {
+		return nil, fmt.Errorf("reading index failed: %w", br.TryError)
+	}
+	return i, nil
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	n int64
+	w io.Writer
+}
+
+func (w *countWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += int64(n)
+	return
+}
@@ -0,0 +1,105 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func h264Bytes(nalUnitType H264NALUnitType, sliceHeader byte) []byte {
+	return []byte{0x0, 0x0, 0x0, 0x1, 0x60 | uint8(nalUnitType), sliceHeader, 0x80}
+}
+
+func indexBytes(t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	mx := NewMuxer(context.Background(), w)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+
+	for idx, v := range []struct {
+		af   *PacketAdaptationField
+		data []byte
+	}{
+		{af: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(1000, 0), RandomAccessIndicator: true}, data: h264Bytes(H264NALUnitTypeIDRSlice, 0x88)},
+		{af: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(2000, 0)}, data: h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0)},
+		{data: h264Bytes(H264NALUnitTypeNonIDRSlice, 0x88)},
+		{af: &PacketAdaptationField{RandomAccessIndicator: true}, data: h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0)},
+	} {
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: v.af,
+			PID:             0x100,
+			PES: &PESData{
+				Data: v.data,
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(int64(90000+3600*idx), 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+func TestBuildIndex(t *testing.T) {
+	// Tables are retransmitted before the last PES since it has the random access indicator
+	b := indexBytes(t)
+	i, err := BuildIndex(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, MpegTsPacketSize, i.PacketSize)
+	assert.Equal(t, uint16(0x100), i.PID)
+	assert.Equal(t, uint16(0x100), i.PCRPID)
+	assert.Equal(t, StreamTypeH264Video, i.StreamType)
+	assert.Equal(t, []*IndexEntry{
+		{
+			FrameType:             FrameTypeIDR,
+			Offset:                2 * MpegTsPacketSize,
+			PacketIndex:           2,
+			PCR:                   newClockReference(1000, 0),
+			PTS:                   newClockReference(90000, 0),
+			RandomAccessIndicator: true,
+		},
+		{
+			FrameType:   FrameTypeI,
+			Offset:      4 * MpegTsPacketSize,
+			PacketIndex: 4,
+			PCR:         newClockReference(2000, 0),
+			PTS:         newClockReference(97200, 0),
+		},
+		{
+			Offset:                7 * MpegTsPacketSize,
+			PacketIndex:           7,
+			PCR:                   newClockReference(2000, 0),
+			PTS:                   newClockReference(100800, 0),
+			RandomAccessIndicator: true,
+		},
+	}, i.Entries)
+	assert.Equal(t, "IDR", i.Entries[0].FrameType.String())
+
+	// Serialization
+	buf := &bytes.Buffer{}
+	n, err := i.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	i2, err := ReadIndex(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, i, i2)
+
+	// Invalid index
+	_, err = ReadIndex(bytes.NewReader([]byte("ABCD")))
+	assert.ErrorIs(t, err, ErrIndexInvalidMagic)
+}
+
+func TestVideoFrameType(t *testing.T) {
+	assert.Equal(t, FrameTypeIDR, videoFrameType(StreamTypeH264Video, h264Bytes(H264NALUnitTypeIDRSlice, 0x88)))
+	assert.Equal(t, FrameTypeI, videoFrameType(StreamTypeH264Video, h264Bytes(H264NALUnitTypeNonIDRSlice, 0x88)))
+	assert.Equal(t, FrameTypeUnknown, videoFrameType(StreamTypeH264Video, h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0)))
+	assert.Equal(t, FrameTypeIDR, videoFrameType(StreamTypeH265Video, hevcBytes()))
+	assert.Equal(t, FrameTypeI, videoFrameType(StreamTypeMPEG2Video, mpeg2VideoIFrameBytes()))
+	assert.Equal(t, FrameTypeUnknown, videoFrameType(StreamTypeADTS, adtsFrameBytes([]byte{0x1}, true)))
+}
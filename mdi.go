@@ -0,0 +1,225 @@
+package astits
+
+import (
+	"io"
+	"time"
+)
+
+// Default MDI measurement interval.
+const defaultMDIInterval = time.Second
+
+// maxDatagramSize is the maximum size of a UDP datagram.
+const maxDatagramSize = 65536
+
+// ArrivalTimeReader represents a reader that knows when the bytes it returns were
+// received. When the demuxer reads from it, packets are stamped with the arrival time of
+// the bytes that completed them.
+type ArrivalTimeReader interface {
+	io.Reader
+	ArrivalTime() time.Time
+}
+
+// DatagramReader reads datagrams, such as the ones of a UDP connection where each read
+// returns a single datagram, and stamps them with their arrival time.
+type DatagramReader struct {
+	arrivalTime time.Time
+	b           []byte
+	buf         []byte
+	optNow      func() time.Time
+	r           io.Reader
+}
+
+// DatagramReaderOptNow returns the option to set the clock used to stamp datagrams.
+// Default is time.Now.
+func DatagramReaderOptNow(now func() time.Time) func(*DatagramReader) {
+	return func(r *DatagramReader) {
+		r.optNow = now
+	}
+}
+
+// NewDatagramReader creates a new datagram reader.
+func NewDatagramReader(r io.Reader, opts ...func(*DatagramReader)) *DatagramReader {
+	dr := &DatagramReader{
+		buf:    make([]byte, maxDatagramSize),
+		optNow: time.Now,
+		r:      r,
+	}
+	for _, opt := range opts {
+		opt(dr)
+	}
+	return dr
+}
+
+// Read implements the io.Reader interface. It reads a new datagram only once the bytes
+// of the previous one have all been returned.
+func (r *DatagramReader) Read(p []byte) (n int, err error) {
+	if len(r.b) == 0 {
+		if n, err = r.r.Read(r.buf); n == 0 {
+			return
+		}
+		r.arrivalTime = r.optNow()
+		r.b = r.buf[:n]
+	}
+	n = copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+// ArrivalTime implements the ArrivalTimeReader interface. It returns the arrival time of
+// the datagram of the last byte read.
+func (r *DatagramReader) ArrivalTime() time.Time {
+	return r.arrivalTime
+}
+
+// Close implements the io.Closer interface and closes the underlying reader if possible.
+func (r *DatagramReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// MDISample represents the Media Delivery Index measured over an interval.
+// https://www.rfc-editor.org/rfc/rfc4445
+type MDISample struct {
+	Bitrate int64 // Media rate used to drain the virtual buffer, in bits per second.
+
+	// DelayFactor is the time needed to drain the maximum variation of the virtual buffer,
+	// i.e. how much buffering is needed to absorb the jitter of the datagram arrivals.
+	DelayFactor time.Duration
+
+	Duration      time.Duration
+	LostPackets   int
+	MediaLossRate float64 // In lost packets per second.
+	Start         time.Time
+}
+
+// MDIAnalyzer measures the Media Delivery Index of a live stream. Packets must carry the
+// arrival time of their datagram, which is the case when they are demuxed from an
+// ArrivalTimeReader such as a DatagramReader. Unless provided, the media rate is derived
+// from the PCRs of the first PCR PID.
+type MDIAnalyzer struct {
+	ccs           map[uint16]uint8 // Indexed by PID
+	clock         *pcrClock
+	lost          int
+	optBitrate    int64
+	optInterval   time.Duration
+	optPacketSize int
+	received      int64
+	start         time.Time
+	vbMax         float64
+	vbMin         float64
+}
+
+// MDIAnalyzerOptBitrate returns the option to set the media rate in bits per second.
+func MDIAnalyzerOptBitrate(bitrate int64) func(*MDIAnalyzer) {
+	return func(a *MDIAnalyzer) {
+		a.optBitrate = bitrate
+	}
+}
+
+// MDIAnalyzerOptInterval returns the option to set the measurement interval. Default is 1s.
+func MDIAnalyzerOptInterval(interval time.Duration) func(*MDIAnalyzer) {
+	return func(a *MDIAnalyzer) {
+		a.optInterval = interval
+	}
+}
+
+// MDIAnalyzerOptPacketSize returns the option to set the packet size, which must match the
+// one of the demuxer for the virtual buffer to be filled at the right rate. Default is 188.
+func MDIAnalyzerOptPacketSize(packetSize int) func(*MDIAnalyzer) {
+	return func(a *MDIAnalyzer) {
+		a.optPacketSize = packetSize
+	}
+}
+
+// NewMDIAnalyzer creates a new MDI analyzer.
+func NewMDIAnalyzer(opts ...func(*MDIAnalyzer)) *MDIAnalyzer {
+	a := &MDIAnalyzer{
+		ccs:           make(map[uint16]uint8),
+		clock:         newPCRClock(),
+		optInterval:   defaultMDIInterval,
+		optPacketSize: MpegTsPacketSize,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Analyze processes a packet, that must be provided in the order of arrival, and returns
+// the MDI of the previous interval once a packet arrives after its end. Packets without
+// arrival time are ignored.
+func (a *MDIAnalyzer) Analyze(p *Packet) (s *MDISample) {
+	// Packets must have an arrival time
+	if p.ArrivalTime.IsZero() {
+		return nil
+	}
+
+	// Get bitrate
+	a.clock.update(p)
+	bitrate := a.optBitrate
+	if bitrate == 0 {
+		bitrate = int64(a.clock.bitrate)
+	}
+	if bitrate == 0 {
+		a.analyzeLoss(p)
+		return nil
+	}
+
+	// Close interval
+	if !a.start.IsZero() && p.ArrivalTime.Sub(a.start) >= a.optInterval {
+		d := p.ArrivalTime.Sub(a.start)
+		s = &MDISample{
+			Bitrate:       bitrate,
+			DelayFactor:   time.Duration((a.vbMax - a.vbMin) * 8 / float64(bitrate) * float64(time.Second)),
+			Duration:      d,
+			LostPackets:   a.lost,
+			MediaLossRate: float64(a.lost) / d.Seconds(),
+			Start:         a.start,
+		}
+		a.start = time.Time{}
+	}
+
+	// Open interval
+	if a.start.IsZero() {
+		a.lost = 0
+		a.received = 0
+		a.start = p.ArrivalTime
+		a.vbMax = 0
+		a.vbMin = 0
+	}
+
+	// Update the virtual buffer, whose size is the difference between the bytes received
+	// and the bytes drained at the media rate. Every packet of a datagram arrives at the
+	// same time, so the minimum is reached before the first one and the maximum after the
+	// last one.
+	drained := p.ArrivalTime.Sub(a.start).Seconds() * float64(bitrate) / 8
+	if vb := float64(a.received) - drained; vb < a.vbMin {
+		a.vbMin = vb
+	}
+	a.received += int64(a.optPacketSize)
+	if vb := float64(a.received) - drained; vb > a.vbMax {
+		a.vbMax = vb
+	}
+
+	a.analyzeLoss(p)
+	return
+}
+
+// analyzeLoss counts packets lost based on the continuity counters.
+func (a *MDIAnalyzer) analyzeLoss(p *Packet) {
+	if p.Header.PID == PIDNull || (p.Header.HasAdaptationField && p.AdaptationField.DiscontinuityIndicator) {
+		delete(a.ccs, p.Header.PID)
+		return
+	}
+	cc, ok := a.ccs[p.Header.PID]
+	if !p.Header.HasPayload {
+		return
+	}
+	a.ccs[p.Header.PID] = p.Header.ContinuityCounter
+	if !ok || p.Header.ContinuityCounter == cc {
+		return
+	}
+	a.lost += int((p.Header.ContinuityCounter - cc - 1) % 16)
+}
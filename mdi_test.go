@@ -0,0 +1,147 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// datagramsReader returns one datagram per read.
+type datagramsReader struct {
+	ds [][]byte
+}
+
+func (r *datagramsReader) Read(p []byte) (int, error) {
+	if len(r.ds) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.ds[0])
+	r.ds = r.ds[1:]
+	return n, nil
+}
+
+func TestDatagramReader(t *testing.T) {
+	b := tr101290Bytes(t, 10)
+	var ds [][]byte
+	for len(b) > 0 {
+		n := 7 * MpegTsPacketSize
+		if n > len(b) {
+			n = len(b)
+		}
+		ds = append(ds, b[:n])
+		b = b[n:]
+	}
+	now := time.Unix(0, 0)
+	r := NewDatagramReader(&datagramsReader{ds: ds}, DatagramReaderOptNow(func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}))
+	dmx := NewDemuxer(context.Background(), r)
+	var ts []time.Time
+	for {
+		p, err := dmx.NextPacket()
+		if err != nil {
+			assert.ErrorIs(t, err, io.EOF)
+			break
+		}
+		ts = append(ts, p.ArrivalTime)
+	}
+	// The first 2 packets are consumed by the packet size detection
+	assert.Len(t, ts, 48)
+	assert.Equal(t, time.Unix(0, 0).Add(time.Millisecond), ts[0])
+	assert.Equal(t, time.Unix(0, 0).Add(2*time.Millisecond), ts[5])
+	assert.Equal(t, time.Unix(0, 0).Add(8*time.Millisecond), ts[47])
+}
+
+func TestMDIAnalyzer(t *testing.T) {
+	const bitrate = 7 * MpegTsPacketSize * 8 * 100
+	a := NewMDIAnalyzer(MDIAnalyzerOptBitrate(bitrate))
+	start := time.Unix(0, 0)
+	var ccs uint8
+	var ss []*MDISample
+	datagram := func(t time.Time, skipped uint8) {
+		ccs += skipped
+		for idx := 0; idx < 7; idx++ {
+			if s := a.Analyze(&Packet{ArrivalTime: t, Header: &PacketHeader{ContinuityCounter: ccs % 16, HasPayload: true, PID: 0x100}}); s != nil {
+				ss = append(ss, s)
+			}
+			ccs++
+		}
+	}
+
+	// Packets without arrival time are ignored
+	assert.Nil(t, a.Analyze(&Packet{Header: &PacketHeader{PID: 0x100}}))
+
+	// Constant
+	for idx := 0; idx < 100; idx++ {
+		datagram(start.Add(time.Duration(idx)*10*time.Millisecond), 0)
+	}
+
+	// Jitter and loss
+	for idx := 100; idx < 200; idx++ {
+		d := time.Duration(idx) * 10 * time.Millisecond
+		var skipped uint8
+		if idx == 150 {
+			d += 5 * time.Millisecond
+			skipped = 3
+		}
+		datagram(start.Add(d), skipped)
+	}
+	datagram(start.Add(2*time.Second), 0)
+
+	assert.Len(t, ss, 2)
+	assert.Equal(t, start, ss[0].Start)
+	assert.Equal(t, time.Second, ss[0].Duration)
+	assert.Equal(t, int64(bitrate), ss[0].Bitrate)
+	assert.InDelta(t, 10*time.Millisecond, ss[0].DelayFactor, 1000)
+	assert.Equal(t, 0, ss[0].LostPackets)
+	assert.InDelta(t, 15*time.Millisecond, ss[1].DelayFactor, 1000)
+	assert.Equal(t, 3, ss[1].LostPackets)
+	assert.Equal(t, 3.0, ss[1].MediaLossRate)
+
+	// Bitrate derived from the PCRs
+	a = NewMDIAnalyzer()
+	b := tr101290Bytes(t, 100)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b))
+	ss = nil
+	for idx := 0; ; idx++ {
+		p, err := dmx.NextPacket()
+		if err != nil {
+			break
+		}
+		// Packets arrive at 40ms intervals by datagrams of 5 packets
+		p.ArrivalTime = start.Add(time.Duration(idx/5) * 40 * time.Millisecond)
+		if s := a.Analyze(p); s != nil {
+			ss = append(ss, s)
+		}
+	}
+	assert.Len(t, ss, 3)
+	assert.Equal(t, int64(5*25*MpegTsPacketSize*8), ss[0].Bitrate)
+	assert.InDelta(t, 40*time.Millisecond, ss[0].DelayFactor, 1000)
+
+	// 192 bytes packets, whose extra bytes follow the sync byte
+	a = NewMDIAnalyzer(MDIAnalyzerOptPacketSize(192))
+	var c []byte
+	for off := 0; off < len(b); off += MpegTsPacketSize {
+		c = append(append(c, syncByte, 0, 0, 0, 0), b[off+1:off+MpegTsPacketSize]...)
+	}
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(c), DemuxerOptPacketSize(192))
+	ss = nil
+	for idx := 0; ; idx++ {
+		p, err := dmx.NextPacket()
+		if err != nil {
+			break
+		}
+		p.ArrivalTime = start.Add(time.Duration(idx/5) * 40 * time.Millisecond)
+		if s := a.Analyze(p); s != nil {
+			ss = append(ss, s)
+		}
+	}
+	assert.Len(t, ss, 3)
+	assert.Equal(t, int64(5*25*192*8), ss[0].Bitrate)
+	assert.InDelta(t, 40*time.Millisecond, ss[0].DelayFactor, 1000)
+}
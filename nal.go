@@ -0,0 +1,148 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/icza/bitio"
+)
+
+// ErrNALExpGolombTooLong is returned when an Exp-Golomb code doesn't fit in 32 bits.
+var ErrNALExpGolombTooLong = errors.New("Exp-Golomb code is too long")
+
+// splitNALUnits splits an Annex B byte stream into NAL units.
+// Start codes and trailing zero bytes are stripped from the returned units.
+func splitNALUnits(b []byte) (nalus [][]byte) {
+	for _, u := range splitStartCodeUnits(b) {
+		nalus = appendNALUnit(nalus, u)
+	}
+	return
+}
+
+// splitStartCodeUnits splits a byte stream on 0x000001 start code prefixes.
+// Prefixes are stripped from the returned units, but zero bytes preceding them
+// are not since they may belong to the previous unit's syntax.
+func splitStartCodeUnits(b []byte) (units [][]byte) {
+	start := -1
+	for i := 0; i+2 < len(b); {
+		// Look for the 0x000001 start code prefix.
+		if b[i] != 0 || b[i+1] != 0 || b[i+2] != 1 {
+			i++
+			continue
+		}
+
+		if start >= 0 && i > start {
+			units = append(units, b[start:i])
+		}
+		i += 3
+		start = i
+	}
+
+	if start >= 0 && start < len(b) {
+		units = append(units, b[start:])
+	}
+	return
+}
+
+// appendNALUnit appends a NAL unit without its trailing zero bytes,
+// which belong either to a 4 bytes start code or to trailing_zero_8bits.
+func appendNALUnit(nalus [][]byte, b []byte) [][]byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	if len(b) == 0 {
+		return nalus
+	}
+	return append(nalus, b)
+}
+
+// unescapeRBSP removes the emulation prevention bytes (0x000003) of a NAL unit payload.
+func unescapeRBSP(b []byte) []byte {
+	// Nothing to unescape
+	if bytes.Index(b, []byte{0, 0, 3}) < 0 {
+		return b
+	}
+
+	o := make([]byte, 0, len(b))
+	var zeros int
+	for _, c := range b {
+		if zeros >= 2 && c == 3 {
+			zeros = 0
+			continue
+		}
+		if c == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		o = append(o, c)
+	}
+	return o
+}
+
+// nalReader is a bit reader for NAL unit RBSPs supporting Exp-Golomb codes.
+type nalReader struct {
+	*bitio.CountReader
+}
+
+// newNALReader creates a new reader on an already unescaped RBSP.
+func newNALReader(rbsp []byte) *nalReader {
+	return &nalReader{CountReader: bitio.NewCountReader(bytes.NewReader(rbsp))}
+}
+
+// TryReadUE reads an unsigned Exp-Golomb code, ue(v). Codes with 32 leading zero bits or
+// more set TryError.
+func (r *nalReader) TryReadUE() uint32 {
+	var leadingZeros uint8
+	for !r.TryReadBool() {
+		if r.TryError != nil {
+			return 0
+		}
+		if leadingZeros++; leadingZeros >= 32 {
+			r.TryError = ErrNALExpGolombTooLong
+			return 0
+		}
+	}
+	if leadingZeros == 0 {
+		return 0
+	}
+	return uint32(1<<leadingZeros-1) + uint32(r.TryReadBits(leadingZeros))
+}
+
+// TryReadSE reads a signed Exp-Golomb code, se(v).
+func (r *nalReader) TryReadSE() int32 {
+	v := r.TryReadUE()
+	if v%2 == 0 {
+		return -int32(v / 2)
+	}
+	return int32(v/2 + 1)
+}
+
+// ColourDescription represents the colour description
+// found in the VUI of H.264 and H.265 sequence parameter sets.
+// https://www.itu.int/rec/T-REC-H.273
+type ColourDescription struct {
+	ColourPrimaries         uint8
+	MatrixCoefficients      uint8
+	TransferCharacteristics uint8
+}
+
+// Colour primaries.
+const (
+	ColourPrimariesBT709  = 1
+	ColourPrimariesBT2020 = 9
+)
+
+// Transfer characteristics.
+const (
+	TransferCharacteristicsBT709      = 1
+	TransferCharacteristicsBT2020     = 14
+	TransferCharacteristicsSMPTE2084  = 16 // PQ
+	TransferCharacteristicsARIBSTDB67 = 18 // HLG
+)
+
+// IsHDR checks whether the transfer characteristics are those of an HDR signal (PQ or HLG).
+func (c ColourDescription) IsHDR() bool {
+	return c.TransferCharacteristics == TransferCharacteristicsSMPTE2084 ||
+		c.TransferCharacteristics == TransferCharacteristicsARIBSTDB67
+}
@@ -0,0 +1,80 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeUE writes an unsigned Exp-Golomb code.
+func writeUE(w *bitio.Writer, v uint32) {
+	v++
+	var n uint8
+	for tmp := v; tmp > 1; tmp >>= 1 {
+		n++
+	}
+	w.TryWriteBits(0, n)
+	w.TryWriteBits(uint64(v), n+1)
+}
+
+// writeSE writes a signed Exp-Golomb code.
+func writeSE(w *bitio.Writer, v int32) {
+	if v <= 0 {
+		writeUE(w, uint32(-v*2))
+	} else {
+		writeUE(w, uint32(v*2-1))
+	}
+}
+
+// writeRBSPTrailingBits writes the rbsp_trailing_bits and aligns the writer.
+func writeRBSPTrailingBits(w *bitio.Writer) {
+	w.TryWriteBool(true)
+	w.Align() //nolint:errcheck
+}
+
+func TestSplitNALUnits(t *testing.T) {
+	assert.Equal(t, [][]byte{{0x1}, {0x2, 0x3}, {0x4}}, splitNALUnits([]byte{
+		0x0, 0x0, 0x0, 0x1, 0x1,
+		0x0, 0x0, 0x1, 0x2, 0x3, 0x0,
+		0x0, 0x0, 0x0, 0x1, 0x4,
+	}))
+	assert.Nil(t, splitNALUnits([]byte{0x1, 0x2, 0x3}))
+	assert.Nil(t, splitNALUnits([]byte{0x0, 0x0, 0x1}))
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	assert.Equal(t, []byte{0x1, 0x2}, unescapeRBSP([]byte{0x1, 0x2}))
+	assert.Equal(t, []byte{0x0, 0x0, 0x1, 0x0, 0x0, 0x3}, unescapeRBSP([]byte{0x0, 0x0, 0x3, 0x1, 0x0, 0x0, 0x3, 0x3}))
+}
+
+func TestNALReader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	writeUE(w, 0)
+	writeUE(w, 1)
+	writeUE(w, 1920)
+	writeSE(w, -3)
+	writeSE(w, 4)
+	writeRBSPTrailingBits(w)
+
+	r := newNALReader(buf.Bytes())
+	assert.Equal(t, uint32(0), r.TryReadUE())
+	assert.Equal(t, uint32(1), r.TryReadUE())
+	assert.Equal(t, uint32(1920), r.TryReadUE())
+	assert.Equal(t, int32(-3), r.TryReadSE())
+	assert.Equal(t, int32(4), r.TryReadSE())
+	assert.NoError(t, r.TryError)
+
+	// Too many leading zeros
+	r = newNALReader([]byte{0, 0, 0, 0, 0x80})
+	assert.Equal(t, uint32(0), r.TryReadUE())
+	assert.ErrorIs(t, r.TryError, ErrNALExpGolombTooLong)
+}
+
+func TestColourDescriptionIsHDR(t *testing.T) {
+	assert.False(t, ColourDescription{TransferCharacteristics: TransferCharacteristicsBT709}.IsHDR())
+	assert.True(t, ColourDescription{TransferCharacteristics: TransferCharacteristicsSMPTE2084}.IsHDR())
+	assert.True(t, ColourDescription{TransferCharacteristics: TransferCharacteristicsARIBSTDB67}.IsHDR())
+}
@@ -3,6 +3,7 @@ package astits
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/icza/bitio"
 )
@@ -26,7 +27,10 @@ const (
 // https://en.wikipedia.org/wiki/MPEG_transport_stream
 type Packet struct {
 	AdaptationField *PacketAdaptationField
+	ArrivalTime     time.Time // Only set when the reader is an ArrivalTimeReader.
 	Header          *PacketHeader
+	Index           int64  // Ordinal of the packet in the input, starting at 0.
+	Offset          int64  // Byte offset of the packet in the input.
 	Payload         []byte // This is only the payload content.
 }
 
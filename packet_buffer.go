@@ -12,9 +12,14 @@ import (
 
 // packetBuffer represents a packet buffer.
 type packetBuffer struct {
+	offset           int64
+	packetIndex      int64
 	packetSize       int
 	r                io.Reader
 	packetReadBuffer []byte
+	optResync        bool
+	skippedBytes     int64  // Bytes skipped while looking for sync bytes.
+	unread           []byte // Bytes read ahead while looking for sync bytes.
 }
 
 // newPacketBuffer creates a new packet buffer.
@@ -28,10 +33,15 @@ func newPacketBuffer(r io.Reader, packetSize int) (pb *packetBuffer, err error)
 	// Packet size is not set.
 	if pb.packetSize == 0 {
 		// Auto detect packet size.
-		if pb.packetSize, err = autoDetectPacketSize(r); err != nil {
+		var consumed int64
+		if pb.packetSize, consumed, err = autoDetectPacketSize(r); err != nil {
 			err = fmt.Errorf("auto detecting packet size failed: %w", err)
 			return
 		}
+
+		// Packets consumed while syncing the reader are lost but still count.
+		pb.offset = consumed
+		pb.packetIndex = consumed / int64(pb.packetSize)
 	}
 	return
 }
@@ -42,18 +52,20 @@ var ErrSingleSyncByte = errors.New("only one sync byte detected")
 // autoDetectPacketSize updates the packet size based on the first bytes
 // Minimum packet size is 188 and is bounded by 2 sync bytes
 // Assumption is made that the first byte of the reader is a sync byte.
-func autoDetectPacketSize(r io.Reader) (int, error) {
+// It also returns the number of bytes consumed, which is not 0 only for
+// readers that can't be rewinded.
+func autoDetectPacketSize(r io.Reader) (int, int64, error) {
 	// Read first bytes
 	const l = 193
 	b := make([]byte, l)
 	shouldRewind, err := peek(r, b)
 	if err != nil {
-		return 0, fmt.Errorf("reading first %d bytes failed: %w", l, err)
+		return 0, 0, fmt.Errorf("reading first %d bytes failed: %w", l, err)
 	}
 
 	// Packet must start with a sync byte.
 	if b[0] != syncByte {
-		return 0, ErrPacketStartSyncByte
+		return 0, 0, ErrPacketStartSyncByte
 	}
 
 	var packetSize int
@@ -67,23 +79,24 @@ func autoDetectPacketSize(r io.Reader) (int, error) {
 		packetSize = idx
 
 		if !shouldRewind {
-			return packetSize, nil
+			return packetSize, 0, nil
 		}
 
 		// Rewind or sync reader.
 		var n int64
 		if n, err = rewind(r); err != nil {
-			return 0, fmt.Errorf("rewinding failed: %w", err)
+			return 0, 0, fmt.Errorf("rewinding failed: %w", err)
 		} else if n == -1 {
 			ls := packetSize - (l - packetSize)
 			_, err := r.Read(make([]byte, ls))
 			if err != nil {
-				return 0, fmt.Errorf("reading %d bytes to sync reader failed: %w", ls, err)
+				return 0, 0, fmt.Errorf("reading %d bytes to sync reader failed: %w", ls, err)
 			}
+			return packetSize, int64(l + ls), nil
 		}
-		return packetSize, nil
+		return packetSize, 0, nil
 	}
-	return 0, fmt.Errorf("%w in first %d bytes", ErrSingleSyncByte, l)
+	return 0, 0, fmt.Errorf("%w in first %d bytes", ErrSingleSyncByte, l)
 }
 
 // peek bufio.Reader can't be rewinded, which leads to packet
@@ -126,7 +139,7 @@ func (pb *packetBuffer) next() (*Packet, error) {
 		pb.packetReadBuffer = make([]byte, pb.packetSize)
 	}
 
-	_, err := io.ReadFull(pb.r, pb.packetReadBuffer)
+	_, err := pb.read(pb.packetReadBuffer)
 	if err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
 			return nil, io.EOF
@@ -134,6 +147,16 @@ func (pb *packetBuffer) next() (*Packet, error) {
 		return nil, fmt.Errorf("reading %d bytes failed: %w", pb.packetSize, err)
 	}
 
+	// Resync if the packet doesn't start with a sync byte.
+	if pb.optResync && pb.packetReadBuffer[0] != syncByte {
+		if err = pb.resync(); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("resyncing failed: %w", err)
+		}
+	}
+
 	r := bitio.NewCountReader(bytes.NewReader(pb.packetReadBuffer))
 	pktBufferLength := int64(len(pb.packetReadBuffer) * 8)
 
@@ -143,5 +166,67 @@ func (pb *packetBuffer) next() (*Packet, error) {
 		return nil, fmt.Errorf("building packet failed: %w", err)
 	}
 
+	// Update arrival time.
+	if ar, ok := pb.r.(ArrivalTimeReader); ok {
+		p.ArrivalTime = ar.ArrivalTime()
+	}
+
+	// Update position.
+	p.Index = pb.packetIndex
+	p.Offset = pb.offset
+	pb.packetIndex++
+	pb.offset += int64(pb.packetSize)
+
 	return p, nil
 }
+
+// read fills b, starting with the bytes read ahead while looking for sync bytes.
+func (pb *packetBuffer) read(b []byte) (int, error) {
+	n := copy(b, pb.unread)
+	pb.unread = pb.unread[n:]
+	if n == len(b) {
+		return n, nil
+	}
+	m, err := io.ReadFull(pb.r, b[n:])
+	return n + m, err
+}
+
+// resync skips bytes until it finds 2 sync bytes separated by the packet size, or a sync
+// byte followed by the last packet of the input, and fills the packet read buffer with
+// the packet starting with the first one.
+func (pb *packetBuffer) resync() error {
+	// The buffer holds 2 packets
+	size := pb.packetSize
+	b := make([]byte, 2*size)
+	n := copy(b, pb.packetReadBuffer)
+	for {
+		// Complete the buffer
+		m, err := pb.read(b[n:])
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("reading %d bytes failed: %w", len(b)-n, err)
+		}
+		n += m
+
+		// Look for the sync bytes
+		for i := 0; i < size && i+size <= n; i++ {
+			if b[i] != syncByte || (i+size < n && b[i+size] != syncByte) {
+				continue
+			}
+			copy(pb.packetReadBuffer, b[i:i+size])
+			pb.unread = append([]byte{}, b[i+size:n]...)
+			pb.offset += int64(i)
+			pb.skippedBytes += int64(i)
+			return nil
+		}
+
+		// End of input
+		if n < len(b) {
+			return io.EOF
+		}
+
+		// Skip the first packet of the buffer
+		n = copy(b, b[size:])
+		pb.offset += int64(size)
+		pb.skippedBytes += int64(size)
+	}
+}
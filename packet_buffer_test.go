@@ -2,6 +2,7 @@ package astits
 
 import (
 	"bytes"
+	"io"
 	"testing"
 
 	"github.com/icza/bitio"
@@ -14,7 +15,7 @@ func TestAutoDetectPacketSize(t *testing.T) {
 	w := bitio.NewWriter(buf)
 	w.WriteByte(uint8(2))
 	w.WriteByte(byte(syncByte))
-	_, err := autoDetectPacketSize(bytes.NewReader(buf.Bytes()))
+	_, _, err := autoDetectPacketSize(bytes.NewReader(buf.Bytes()))
 	assert.ErrorIs(t, err, ErrPacketStartSyncByte)
 
 	// Valid packet size
@@ -27,8 +28,55 @@ func TestAutoDetectPacketSize(t *testing.T) {
 	w.Write(make([]byte, 187))
 	w.Write([]byte("test"))
 	r := bytes.NewReader(buf.Bytes())
-	p, err := autoDetectPacketSize(r)
+	p, n, err := autoDetectPacketSize(r)
 	assert.NoError(t, err)
 	assert.Equal(t, MpegTsPacketSize, p)
+	assert.Equal(t, int64(0), n)
 	assert.Equal(t, 380, r.Len())
 }
+
+func TestPacketBufferOffsets(t *testing.T) {
+	b := seekBytes(t)
+
+	// Packet doesn't start with a sync byte
+	garbage := []byte{0x1, 0x1, 0x1, syncByte, 0x1, 0x1, 0x1, 0x1, 0x1, 0x1}
+	c := append(append(append([]byte{}, b[:3*188]...), garbage...), b[3*188:]...)
+	pb, err := newPacketBuffer(bytes.NewReader(c), 188)
+	assert.NoError(t, err)
+	for idx := 0; idx < 3; idx++ {
+		_, err = pb.next()
+		assert.NoError(t, err)
+	}
+	_, err = pb.next()
+	assert.ErrorIs(t, err, ErrPacketStartSyncByte)
+
+	// Resync, ignoring the sync byte that is not followed by another one
+	pb, err = newPacketBuffer(bytes.NewReader(c), 188)
+	assert.NoError(t, err)
+	pb.optResync = true
+	var n int
+	for {
+		p, err := pb.next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, int64(n), p.Index)
+		if n < 3 {
+			assert.Equal(t, int64(n*188), p.Offset)
+		} else {
+			assert.Equal(t, int64(n*188+len(garbage)), p.Offset)
+		}
+		n++
+	}
+	assert.Equal(t, len(b)/188, n)
+	assert.Equal(t, int64(len(garbage)), pb.skippedBytes)
+
+	// Packets consumed while auto detecting the packet size
+	pb, err = newPacketBuffer(nonSeekableReader{bytes.NewReader(b)}, 0)
+	assert.NoError(t, err)
+	p, err := pb.next()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), p.Index)
+	assert.Equal(t, int64(2*188), p.Offset)
+}
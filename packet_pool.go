@@ -46,9 +46,10 @@ func (b *packetAccumulator) add(p *Packet) []*Packet {
 		mps = append(mps, p)
 	}
 
-	// Check if PSI payload is complete.
+	// Check if PSI payload is complete. Time tables are checked as well, since waiting for
+	// the next one would delay them by up to 30s.
 	if b.programMap != nil &&
-		(b.pid == PIDPAT || b.programMap.exists(b.pid)) {
+		(b.pid == PIDPAT || b.pid == pidTDT || b.programMap.exists(b.pid)) {
 		// TODO Use partial data parsing instead.
 		if _, err := parseData(mps, b.parser, b.programMap); err == nil {
 			ps = mps
@@ -87,9 +88,8 @@ func (b *packetPool) add(p *Packet) (ps []*Packet) {
 		return
 	}
 
-	// Throw away packets that don't have a payload until
-	// we figure out what we're going to do with them
-	// TODO figure out what we're going to do with them :D
+	// Throw away packets that don't have a payload. Their adaptation
+	// field is surfaced by the demuxer if needed.
 	if !p.Header.HasPayload {
 		return
 	}
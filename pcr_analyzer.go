@@ -0,0 +1,303 @@
+package astits
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// pcrDriftWindow is the duration over which the frequency offset is measured to compute
+// the drift rate.
+const pcrDriftWindow = time.Second
+
+// PCRSample represents the metrics of a PCR. Samples returned by PCRAnalyzer form a time
+// series that can be exported as is.
+type PCRSample struct {
+	// Accuracy is the difference between the PCR and the value expected from the previous
+	// PCR, the number of bytes in between and the bitrate (PCR_AC).
+	Accuracy time.Duration
+
+	// Arrival is the arrival time of the PCR relative to the first PCR of the PID. It is
+	// only set when either the bitrate or a wall clock is provided.
+	Arrival time.Duration
+
+	Discontinuity   bool
+	DriftRate       float64 // Rate of change of the frequency offset, in ppm per second.
+	FrequencyOffset float64 // Offset of the program clock frequency from 27 MHz, in ppm.
+	Interval        time.Duration
+
+	// Jitter is the difference between the PCR and the value expected from the linear
+	// regression of the previous PCRs against their arrival times or, when unknown, their
+	// byte offsets (PCR_OJ).
+	Jitter time.Duration
+
+	Offset      int64 // Byte offset of the packet carrying the PCR.
+	PacketIndex int64 // Ordinal of the packet carrying the PCR.
+	PCR         *ClockReference
+	PID         uint16
+}
+
+// PCRStats represents the PCR metrics of a PID.
+type PCRStats struct {
+	AverageInterval time.Duration
+	Bitrate         int64 // Estimated from the byte offsets of the PCRs, in bits per second.
+	Count           int
+	Discontinuities int
+	FrequencyOffset float64 // Last frequency offset, in ppm.
+	MaxAccuracy     time.Duration
+	MaxDriftRate    float64 // In ppm per second.
+	MaxInterval     time.Duration
+	MaxJitter       time.Duration
+	PID             uint16
+}
+
+// PCRAnalyzer measures the interval, the accuracy, the jitter, the frequency offset and
+// the drift rate of the PCRs of each PCR PID. Arrival times are derived from the byte
+// offsets of the packets and the bitrate when provided, or from a wall clock for live
+// streams. Without either, frequency offsets and drift rates are not measured.
+type PCRAnalyzer struct {
+	optBitrate int64
+	optNow     func() time.Time
+	pids       map[uint16]*pcrAnalyzerPID
+	start      time.Time
+}
+
+type pcrAnalyzerPID struct {
+	arrival        linearRegression // PCRs against arrival times, in seconds
+	bits           linearRegression // PCRs against byte offsets, in bits and seconds
+	driftFO        float64
+	driftRate      float64
+	driftStart     *pcrAnalyzerPoint
+	firstArrival   float64
+	hasDriftFO     bool
+	hasFirst       bool
+	intervals      time.Duration
+	intervalsCount int
+	last           *PCRSample
+	stats          *PCRStats
+	timeline       *Timeline
+}
+
+type pcrAnalyzerPoint struct {
+	arrival float64
+	pcr     float64
+}
+
+// PCRAnalyzerOptBitrate returns the option to set the nominal bitrate of the stream in
+// bits per second, used to compute arrival times and accuracies.
+func PCRAnalyzerOptBitrate(bitrate int64) func(*PCRAnalyzer) {
+	return func(a *PCRAnalyzer) {
+		a.optBitrate = bitrate
+	}
+}
+
+// PCRAnalyzerOptNow returns the option to measure arrival times with a wall clock, which
+// is needed for live streams whose packets are analyzed as they are received.
+func PCRAnalyzerOptNow(now func() time.Time) func(*PCRAnalyzer) {
+	return func(a *PCRAnalyzer) {
+		a.optNow = now
+	}
+}
+
+// NewPCRAnalyzer creates a new PCR analyzer.
+func NewPCRAnalyzer(opts ...func(*PCRAnalyzer)) *PCRAnalyzer {
+	a := &PCRAnalyzer{pids: make(map[uint16]*pcrAnalyzerPID)}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Stats returns the PCR metrics of each PCR PID, ordered by PID.
+func (a *PCRAnalyzer) Stats() (ss []*PCRStats) {
+	for _, s := range a.pids {
+		c := *s.stats
+		if s.intervalsCount > 0 {
+			c.AverageInterval = s.intervals / time.Duration(s.intervalsCount)
+		}
+		if slope := s.bits.slope(); slope > 0 {
+			c.Bitrate = int64(1 / slope)
+		}
+		ss = append(ss, &c)
+	}
+	sort.Slice(ss, func(i, j int) bool { return ss[i].PID < ss[j].PID })
+	return
+}
+
+// Analyze processes a packet, that must be provided in the order of the input, and
+// returns the metrics of its PCR or nil if it has none.
+func (a *PCRAnalyzer) Analyze(p *Packet) *PCRSample {
+	// Arrival time
+	var arrival float64
+	hasArrival := true
+	switch {
+	case a.optNow != nil:
+		if a.start.IsZero() {
+			a.start = a.optNow()
+		}
+		arrival = a.optNow().Sub(a.start).Seconds()
+	case a.optBitrate > 0:
+		arrival = float64(p.Offset*8) / float64(a.optBitrate)
+	default:
+		hasArrival = false
+	}
+
+	// No PCR
+	if !p.Header.HasAdaptationField || !p.AdaptationField.HasPCR {
+		return nil
+	}
+
+	// Get PID
+	pid, ok := a.pids[p.Header.PID]
+	if !ok {
+		pid = &pcrAnalyzerPID{
+			stats:    &PCRStats{PID: p.Header.PID},
+			timeline: NewTimeline(),
+		}
+		a.pids[p.Header.PID] = pid
+	}
+	if !pid.hasFirst {
+		pid.firstArrival = arrival
+		pid.hasFirst = true
+	}
+
+	// Unwrap
+	pcr, discontinuity := pid.timeline.UnwrapPCR(p.AdaptationField.PCR, p.AdaptationField.DiscontinuityIndicator)
+	s := &PCRSample{
+		Discontinuity: discontinuity,
+		Offset:        p.Offset,
+		PacketIndex:   p.Index,
+		PCR:           pcr,
+		PID:           p.Header.PID,
+	}
+	if hasArrival {
+		s.Arrival = secondsToDuration(arrival - pid.firstArrival)
+	}
+	y := float64(pcr.Base*300+pcr.Extension) / 27e6
+	bits := float64(p.Offset * 8)
+
+	// Measurements made before a discontinuity are not relevant anymore
+	if discontinuity {
+		pid.arrival = linearRegression{}
+		pid.bits = linearRegression{}
+		pid.driftStart = nil
+		pid.hasDriftFO = false
+		pid.stats.Discontinuities++
+	} else if pid.last != nil {
+		// Interval
+		s.Interval = pcr.Sub(*pid.last.PCR)
+		pid.intervals += s.Interval
+		pid.intervalsCount++
+		if s.Interval > pid.stats.MaxInterval {
+			pid.stats.MaxInterval = s.Interval
+		}
+
+		// Accuracy
+		var bitrate float64
+		if a.optBitrate > 0 {
+			bitrate = float64(a.optBitrate)
+		} else if slope := pid.bits.slope(); slope > 0 {
+			bitrate = 1 / slope
+		}
+		if bitrate > 0 {
+			expected := float64(p.Offset-pid.last.Offset) * 8 / bitrate
+			s.Accuracy = s.Interval - secondsToDuration(expected)
+			if abs := absDuration(s.Accuracy); abs > pid.stats.MaxAccuracy {
+				pid.stats.MaxAccuracy = abs
+			}
+		}
+	}
+
+	// Jitter
+	if hasArrival && pid.arrival.n > 1 {
+		s.Jitter = secondsToDuration(y - pid.arrival.predict(arrival))
+	} else if !hasArrival && pid.bits.n > 1 {
+		s.Jitter = secondsToDuration(y - pid.bits.predict(bits))
+	}
+	pid.bits.add(bits, y)
+	if hasArrival {
+		pid.arrival.add(arrival, y)
+	}
+	if abs := absDuration(s.Jitter); abs > pid.stats.MaxJitter {
+		pid.stats.MaxJitter = abs
+	}
+
+	// Frequency offset and drift rate
+	if hasArrival {
+		if pid.arrival.n > 1 {
+			s.FrequencyOffset = (pid.arrival.slope() - 1) * 1e6
+			pid.stats.FrequencyOffset = s.FrequencyOffset
+		}
+		a.analyzeDrift(pid, pcrAnalyzerPoint{arrival: arrival, pcr: y})
+		s.DriftRate = pid.driftRate
+	}
+
+	// Update
+	pid.last = s
+	pid.stats.Count++
+	return s
+}
+
+// analyzeDrift measures the frequency offset over consecutive windows and derives the
+// drift rate from the difference between 2 consecutive windows.
+func (a *PCRAnalyzer) analyzeDrift(pid *pcrAnalyzerPID, pt pcrAnalyzerPoint) {
+	if pid.driftStart == nil {
+		pid.driftStart = &pt
+		return
+	}
+	d := pt.arrival - pid.driftStart.arrival
+	if d < pcrDriftWindow.Seconds() {
+		return
+	}
+	fo := ((pt.pcr-pid.driftStart.pcr)/d - 1) * 1e6
+	if pid.hasDriftFO {
+		pid.driftRate = (fo - pid.driftFO) / d
+		if abs := math.Abs(pid.driftRate); abs > pid.stats.MaxDriftRate {
+			pid.stats.MaxDriftRate = abs
+		}
+	}
+	pid.driftFO = fo
+	pid.driftStart = &pt
+	pid.hasDriftFO = true
+}
+
+// linearRegression computes a least squares linear regression incrementally. Means and
+// co-moments are updated rather than sums to avoid losing precision with large values.
+type linearRegression struct {
+	cxy   float64
+	m2x   float64
+	meanX float64
+	meanY float64
+	n     int
+}
+
+func (r *linearRegression) add(x, y float64) {
+	r.n++
+	dx := x - r.meanX
+	r.meanX += dx / float64(r.n)
+	r.meanY += (y - r.meanY) / float64(r.n)
+	r.m2x += dx * (x - r.meanX)
+	r.cxy += dx * (y - r.meanY)
+}
+
+func (r *linearRegression) slope() float64 {
+	if r.n < 2 || r.m2x == 0 {
+		return 0
+	}
+	return r.cxy / r.m2x
+}
+
+func (r *linearRegression) predict(x float64) float64 {
+	return r.meanY + r.slope()*(x-r.meanX)
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
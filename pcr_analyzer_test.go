@@ -0,0 +1,99 @@
+package astits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pcrPackets returns packets carrying a PCR every 10 packets of a 1504000 bps stream,
+// whose program clock runs at 27 MHz + ppm. Jitters are added to PCRs, indexed by PCR
+// ordinal and in 27 MHz ticks.
+func pcrPackets(count int, ppm float64, jitters map[int]int64) (ps []*Packet) {
+	for idx := 0; idx < count; idx++ {
+		t := int64(float64(idx)*270000*(1+ppm/1e6)) + jitters[idx]
+		ps = append(ps, &Packet{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(t/300, t%300)},
+			Header:          &PacketHeader{HasAdaptationField: true, PID: 0x100},
+			Index:           int64(idx * 10),
+			Offset:          int64(idx * 10 * MpegTsPacketSize),
+		})
+	}
+	return
+}
+
+func TestPCRAnalyzer(t *testing.T) {
+	// Nominal bitrate
+	a := NewPCRAnalyzer(PCRAnalyzerOptBitrate(1504000))
+	assert.Nil(t, a.Analyze(&Packet{Header: &PacketHeader{PID: 0x100}}))
+	var ss []*PCRSample
+	for _, p := range pcrPackets(300, 100, nil) {
+		ss = append(ss, a.Analyze(p))
+	}
+	s := ss[len(ss)-1]
+	assert.Equal(t, int64(299*10), s.PacketIndex)
+	assert.Equal(t, 2990*time.Millisecond, s.Arrival)
+	assert.InDelta(t, 100, s.FrequencyOffset, 0.1)
+	assert.InDelta(t, 0, s.DriftRate, 0.1)
+	assert.InDelta(t, time.Microsecond, s.Accuracy, 50)
+	assert.InDelta(t, 10*time.Millisecond+time.Microsecond, s.Interval, 50)
+	assert.InDelta(t, 0, s.Jitter, 50)
+	st := a.Stats()
+	assert.Len(t, st, 1)
+	assert.Equal(t, uint16(0x100), st[0].PID)
+	assert.Equal(t, 300, st[0].Count)
+	assert.InDelta(t, 10*time.Millisecond+time.Microsecond, st[0].AverageInterval, 50)
+	assert.InDelta(t, 10*time.Millisecond+time.Microsecond, st[0].MaxInterval, 50)
+	assert.InDelta(t, time.Microsecond, st[0].MaxAccuracy, 50)
+	assert.InDelta(t, 1504000/1.0001, st[0].Bitrate, 1)
+
+	// Bitrate derived from the PCRs
+	a = NewPCRAnalyzer()
+	ss = nil
+	for _, p := range pcrPackets(300, 100, map[int]int64{150: 2700}) {
+		ss = append(ss, a.Analyze(p))
+	}
+	assert.Equal(t, time.Duration(0), ss[100].Arrival)
+	assert.Equal(t, 0.0, ss[100].FrequencyOffset)
+	assert.InDelta(t, 0, ss[100].Accuracy, 50)
+	assert.InDelta(t, 100*time.Microsecond, ss[150].Accuracy, 50)
+	assert.InDelta(t, -100*time.Microsecond, ss[151].Accuracy, 50)
+	assert.InDelta(t, 100*time.Microsecond, ss[150].Jitter, 50)
+	st = a.Stats()
+	assert.InDelta(t, 100*time.Microsecond, st[0].MaxAccuracy, 50)
+	assert.InDelta(t, 100*time.Microsecond, st[0].MaxJitter, 50)
+
+	// Wall clock with a drifting program clock
+	now := time.Unix(0, 0)
+	a = NewPCRAnalyzer(PCRAnalyzerOptNow(func() time.Time { return now }))
+	var t27 int64
+	for idx := 0; idx < 500; idx++ {
+		// Frequency offset increases by 10 ppm every second
+		ppm := float64(idx) / 100 * 10
+		t27 += int64(270000 * (1 + ppm/1e6))
+		ss = append(ss, a.Analyze(&Packet{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(t27/300, t27%300)},
+			Header:          &PacketHeader{HasAdaptationField: true, PID: 0x101},
+			Offset:          int64(idx * 10 * MpegTsPacketSize),
+		}))
+		now = now.Add(10 * time.Millisecond)
+	}
+	st = a.Stats()
+	assert.Len(t, st, 1)
+	assert.InDelta(t, 10, ss[len(ss)-1].DriftRate, 0.5)
+	assert.InDelta(t, 10, st[0].MaxDriftRate, 0.5)
+	assert.InDelta(t, 25, st[0].FrequencyOffset, 2)
+
+	// Discontinuity
+	a = NewPCRAnalyzer(PCRAnalyzerOptBitrate(1504000))
+	ps := pcrPackets(20, 0, nil)
+	ps[10].AdaptationField.DiscontinuityIndicator = true
+	ps[10].AdaptationField.PCR = newClockReference(0, 0)
+	for _, p := range ps[:11] {
+		s = a.Analyze(p)
+	}
+	assert.True(t, s.Discontinuity)
+	assert.Equal(t, time.Duration(0), s.Interval)
+	assert.Equal(t, 1, a.Stats()[0].Discontinuities)
+}
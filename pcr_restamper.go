@@ -0,0 +1,108 @@
+package astits
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrPCRRestamperNoBitrate is returned when restamping a packet on its position without a
+// bitrate.
+var ErrPCRRestamperNoBitrate = errors.New("no bitrate")
+
+// PCRRestamper rewrites PCRs in place so that they match the departure times of their packets
+// once packets have been moved, inserted or dropped.
+//
+// Departure times are either provided or derived from the position of packets and the output
+// bitrate. The first PCR of each PID, as well as the first PCR following a discontinuity
+// indicator, keeps its value and the following ones are shifted by the departure time elapsed
+// since.
+type PCRRestamper struct {
+	optBitrate int64
+	optOPCR    bool
+	packets    int64
+	refs       map[uint16]*pcrRestamperRef
+}
+
+type pcrRestamperRef struct {
+	departure time.Duration
+	pcr       *ClockReference
+}
+
+// PCRRestamperOptBitrate returns the option to derive departure times from the position of
+// packets and the output bitrate.
+func PCRRestamperOptBitrate(bps int64) func(*PCRRestamper) {
+	return func(r *PCRRestamper) {
+		r.optBitrate = bps
+	}
+}
+
+// PCRRestamperOptOPCR returns the option to preserve the original PCR in the OPCR, provided
+// the packet doesn't already carry an OPCR and has room for it.
+func PCRRestamperOptOPCR() func(*PCRRestamper) {
+	return func(r *PCRRestamper) {
+		r.optOPCR = true
+	}
+}
+
+// NewPCRRestamper creates a new PCR restamper.
+func NewPCRRestamper(opts ...func(*PCRRestamper)) *PCRRestamper {
+	r := &PCRRestamper{refs: make(map[uint16]*pcrRestamperRef)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Restamp restamps the PCR of a packet based on its position in the output. Every output
+// packet must go through it, in order.
+func (r *PCRRestamper) Restamp(p *Packet) error {
+	if r.optBitrate <= 0 {
+		return ErrPCRRestamperNoBitrate
+	}
+	bits := r.packets * MpegTsPacketSize * 8
+	r.packets++
+	r.RestampAt(p, time.Duration(bits/r.optBitrate)*time.Second+time.Duration(bits%r.optBitrate)*time.Second/time.Duration(r.optBitrate))
+	return nil
+}
+
+// RestampAt restamps the PCR of a packet leaving at the provided departure time. Packets
+// without PCR are left untouched.
+func (r *PCRRestamper) RestampAt(p *Packet, departure time.Duration) {
+	if !p.Header.HasAdaptationField || !p.AdaptationField.HasPCR {
+		return
+	}
+	af := p.AdaptationField
+
+	// Get reference
+	ref, ok := r.refs[p.Header.PID]
+	if !ok || af.DiscontinuityIndicator {
+		ref = &pcrRestamperRef{departure: departure, pcr: af.PCR}
+		r.refs[p.Header.PID] = ref
+	}
+	pcr := af.PCR
+
+	// Restamp
+	af.PCR = ref.pcr.Add(departure - ref.departure)
+
+	// Preserve original PCR
+	if r.optOPCR && !af.HasOPCR && pcrRestamperOPCRFits(p) {
+		af.HasOPCR = true
+		af.OPCR = pcr
+	}
+}
+
+// pcrRestamperOPCRFits checks whether an OPCR fits in the packet, stuffing bytes of its
+// adaptation field being removed if needed.
+func pcrRestamperOPCRFits(p *Packet) bool {
+	free := MpegTsPacketSize - 1 - mpegTsPacketHeaderSize - 1 - int(calcPacketAdaptationFieldLength(p.AdaptationField))
+	if p.Header.HasPayload {
+		free -= len(p.Payload)
+	}
+	if n := pcrBytesSize - free; n > 0 {
+		if p.AdaptationField.StuffingLength < n {
+			return false
+		}
+		p.AdaptationField.StuffingLength -= n
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package astits
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func pcrRestamperPacket(pid uint16, pcr *ClockReference, payloadSize int) *Packet {
+	p := &Packet{
+		Header:  &PacketHeader{HasPayload: payloadSize > 0, PID: pid},
+		Payload: bytes.Repeat([]byte{0x1}, payloadSize),
+	}
+	if pcr != nil {
+		p.Header.HasAdaptationField = true
+		p.AdaptationField = &PacketAdaptationField{HasPCR: true, PCR: pcr}
+		p.AdaptationField.StuffingLength = MpegTsPacketSize - 5 - int(calcPacketAdaptationFieldLength(p.AdaptationField)) - payloadSize
+	}
+	return p
+}
+
+func TestPCRRestamper(t *testing.T) {
+	// No bitrate
+	r := NewPCRRestamper()
+	assert.ErrorIs(t, r.Restamp(pcrRestamperPacket(0x100, nil, 184)), ErrPCRRestamperNoBitrate)
+
+	// Bitrate, 1ms per packet
+	r = NewPCRRestamper(PCRRestamperOptBitrate(MpegTsPacketSize * 8 * 1000))
+	ps := []*Packet{
+		pcrRestamperPacket(0x100, newClockReference(1000, 10), 100),
+		pcrRestamperPacket(0x101, nil, 184),
+		pcrRestamperPacket(0x200, newClockReference(50, 0), 100),
+		pcrRestamperPacket(0x100, newClockReference(1000, 0), 100),
+		pcrRestamperPacket(0x101, nil, 184),
+		pcrRestamperPacket(0x100, newClockReference(clockReferenceBaseModulo-10, 0), 100),
+	}
+	ps[5].AdaptationField.DiscontinuityIndicator = true
+	for _, p := range ps {
+		assert.NoError(t, r.Restamp(p))
+	}
+	assert.Equal(t, newClockReference(1000, 10), ps[0].AdaptationField.PCR)
+	assert.Equal(t, newClockReference(50, 0), ps[2].AdaptationField.PCR)
+	assert.Equal(t, newClockReference(1270, 10), ps[3].AdaptationField.PCR)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-10, 0), ps[5].AdaptationField.PCR)
+	assert.False(t, ps[3].AdaptationField.HasOPCR)
+
+	// Departure times and OPCR
+	r = NewPCRRestamper(PCRRestamperOptOPCR())
+	ps = []*Packet{
+		pcrRestamperPacket(0x100, newClockReference(clockReferenceBaseModulo-90, 0), 100),
+		pcrRestamperPacket(0x100, newClockReference(0, 0), 100),
+		pcrRestamperPacket(0x100, newClockReference(0, 0), 176),
+		pcrRestamperPacket(0x100, newClockReference(0, 0), 170),
+	}
+	for idx, p := range ps {
+		r.RestampAt(p, time.Duration(idx)*2*time.Millisecond)
+	}
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-90, 0), ps[0].AdaptationField.PCR)
+	assert.Equal(t, newClockReference(90, 0), ps[1].AdaptationField.PCR)
+	assert.True(t, ps[1].AdaptationField.HasOPCR)
+	assert.Equal(t, newClockReference(0, 0), ps[1].AdaptationField.OPCR)
+	assert.False(t, ps[2].AdaptationField.HasOPCR)
+	assert.True(t, ps[3].AdaptationField.HasOPCR)
+	for _, p := range ps {
+		buf := &bytes.Buffer{}
+		n, err := writePacket(bitio.NewWriter(buf), p, MpegTsPacketSize)
+		assert.NoError(t, err)
+		assert.Equal(t, MpegTsPacketSize, n)
+	}
+}
@@ -0,0 +1,367 @@
+package astits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// probeWindowSize is the number of bytes read at the start and at the end of the input.
+var probeWindowSize int64 = 4 << 20
+
+// ProbeInfo represents the timeline of a transport stream.
+type ProbeInfo struct {
+	Bitrate int64 // In bits per second.
+
+	// Discontinuity is set when the timeline is not continuous between the start and the
+	// end of the input, in which case the duration is estimated from the bitrate measured
+	// at the start of the input.
+	Discontinuity bool
+
+	Duration  time.Duration
+	FirstPCR  *ClockReference
+	LastPCR   *ClockReference
+	PCRPID    uint16
+	Size      int64 // In bytes.
+	StartTime time.Duration
+	Streams   []*ProbeStreamInfo
+}
+
+// ProbeStreamInfo represents the timeline of an elementary stream.
+type ProbeStreamInfo struct {
+	Bitrate    int64 // Estimated from the share of packets in the probed windows, in bits per second.
+	Duration   time.Duration
+	FirstPTS   *ClockReference
+	LastPTS    *ClockReference
+	PID        uint16
+	StreamType StreamType
+
+	head    *probeStreamWindow
+	packets int64
+	tail    *probeStreamWindow
+}
+
+// probeStreamWindow represents the presentation times of an elementary stream in a probed
+// window, unwrapped so that wraparounds, discontinuities and reordered frames are handled.
+type probeStreamWindow struct {
+	first    int64
+	firstPTS *ClockReference
+	last     int64
+	lastPTS  *ClockReference
+	max      int64
+	maxPTS   *ClockReference
+	min      int64
+	minPTS   *ClockReference
+	timeline *Timeline
+}
+
+// prober probes the timeline of a transport stream.
+type prober struct {
+	dmx           *Demuxer
+	i             *ProbeInfo
+	lastPCR       *ClockReference // Last PCR of the current window
+	packets       int64
+	pcrPIDFromPMT bool
+	streams       map[uint16]*ProbeStreamInfo // Indexed by PID
+
+	// Bitrate at the start of the input
+	lastContinuousPCR    *ClockReference
+	lastContinuousOffset int64
+	firstPCROffset       int64
+	headDiscontinuity    bool
+	tailDiscontinuity    bool
+}
+
+// Probe reads the start and the end of a seekable input to find its first and last PCRs
+// as well as the earliest and latest PTS of each elementary stream, and returns its duration,
+// start time and estimated bitrates without demuxing the whole input.
+func Probe(ctx context.Context, r io.ReadSeeker, opts ...func(*Demuxer)) (*ProbeInfo, error) {
+	// Get size
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("seeking to end failed: %w", err)
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking to start failed: %w", err)
+	}
+
+	p := &prober{
+		dmx:     NewDemuxer(ctx, r, opts...),
+		i:       &ProbeInfo{Size: size},
+		streams: make(map[uint16]*ProbeStreamInfo),
+	}
+
+	// Probe head
+	headEnd := size
+	if headEnd > probeWindowSize {
+		headEnd = probeWindowSize
+	}
+	if err = p.probe(headEnd, true); err != nil {
+		return nil, fmt.Errorf("probing head failed: %w", err)
+	}
+
+	// Probe tail
+	if headEnd < size {
+		tailStart := size - probeWindowSize
+		if tailStart < headEnd {
+			tailStart = headEnd
+		}
+		if _, err = p.dmx.seekToByte(tailStart); err != nil {
+			return nil, fmt.Errorf("seeking to %d failed: %w", tailStart, err)
+		}
+		// The gap between the windows is not a discontinuity
+		p.lastPCR = nil
+		if err = p.probe(size, false); err != nil {
+			return nil, fmt.Errorf("probing tail failed: %w", err)
+		}
+	}
+
+	p.compute()
+	return p.i, nil
+}
+
+// probe processes packets until the offset end is reached.
+func (p *prober) probe(end int64, isHead bool) error {
+	for {
+		// Get next packet
+		pkt, err := p.dmx.nextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+		if pkt.Offset >= end {
+			return nil
+		}
+		p.packets++
+
+		// PSI
+		if isPSIPayload(pkt.Header.PID, p.dmx.programMap) {
+			p.processPSI(p.dmx.packetData(pkt))
+			continue
+		}
+
+		// PCR
+		if pkt.Header.HasAdaptationField && pkt.AdaptationField.HasPCR {
+			p.processPCR(pkt, isHead)
+		}
+
+		// PTS
+		if h := packetPESOptionalHeader(pkt, p.dmx.programMap); h != nil && h.PTS != nil {
+			p.processPTS(p.stream(pkt.Header.PID), h.PTS, isHead)
+		}
+		if s, ok := p.streams[pkt.Header.PID]; ok {
+			s.packets++
+		}
+	}
+}
+
+// processPSI updates the list of streams.
+func (p *prober) processPSI(ds []*DemuxerData) {
+	for _, d := range ds {
+		if d.PMT == nil {
+			continue
+		}
+		if !p.pcrPIDFromPMT && d.PMT.PCRPID != PIDNull {
+			p.setPCRPIDFromPMT(d.PMT.PCRPID)
+		}
+		for _, es := range d.PMT.ElementaryStreams {
+			p.stream(es.ElementaryPID).StreamType = es.StreamType
+		}
+	}
+}
+
+// setPCRPIDFromPMT replaces the PCR PID guessed from the PCRs found so far with the one of a
+// PMT, in which case PCRs are tracked again from scratch.
+func (p *prober) setPCRPIDFromPMT(pid uint16) {
+	p.pcrPIDFromPMT = true
+	if p.i.PCRPID == pid {
+		return
+	}
+	p.i.PCRPID = pid
+	p.i.FirstPCR, p.i.LastPCR, p.lastPCR = nil, nil, nil
+	p.lastContinuousPCR, p.lastContinuousOffset, p.firstPCROffset = nil, 0, 0
+	p.headDiscontinuity, p.tailDiscontinuity = false, false
+}
+
+// processPCR keeps track of the first and last PCRs of the PCR PID.
+func (p *prober) processPCR(pkt *Packet, isHead bool) {
+	// The first PID carrying PCRs is used until a PMT tells otherwise
+	if p.i.PCRPID == 0 {
+		p.i.PCRPID = pkt.Header.PID
+	}
+	if pkt.Header.PID != p.i.PCRPID {
+		return
+	}
+
+	pcr := pkt.AdaptationField.PCR
+	defer func() { p.i.LastPCR, p.lastPCR = pcr, pcr }()
+
+	// First PCR
+	if p.i.FirstPCR == nil {
+		p.i.FirstPCR = pcr
+		p.firstPCROffset = pkt.Offset
+		p.lastContinuousPCR = pcr
+		p.lastContinuousOffset = pkt.Offset
+		return
+	}
+
+	// Discontinuity
+	if p.lastPCR != nil && (pkt.AdaptationField.DiscontinuityIndicator ||
+		(pcr.Base-p.lastPCR.Base+clockReferenceBaseModulo)%clockReferenceBaseModulo > pcrMaxGap) {
+		if isHead {
+			p.headDiscontinuity = true
+		} else {
+			p.tailDiscontinuity = true
+		}
+	}
+
+	// Continuous PCRs at the start of the input
+	if isHead && !p.headDiscontinuity {
+		p.lastContinuousPCR = pcr
+		p.lastContinuousOffset = pkt.Offset
+	}
+}
+
+// processPTS keeps track of the earliest and latest presentation times of a stream.
+func (p *prober) processPTS(s *ProbeStreamInfo, pts *ClockReference, isHead bool) {
+	// Get window
+	w := s.tail
+	if isHead {
+		w = s.head
+	}
+	if w == nil {
+		w = &probeStreamWindow{timeline: NewTimeline()}
+		if isHead {
+			s.head = w
+		} else {
+			s.tail = w
+		}
+	}
+
+	// Unwrap
+	u, _ := w.timeline.Unwrap(pts)
+	if w.lastPTS == nil {
+		w.first, w.firstPTS = u.Base, pts
+		w.max, w.maxPTS = u.Base, pts
+		w.min, w.minPTS = u.Base, pts
+	}
+	w.last, w.lastPTS = u.Base, pts
+	if u.Base > w.max {
+		w.max, w.maxPTS = u.Base, pts
+	}
+	if u.Base < w.min {
+		w.min, w.minPTS = u.Base, pts
+	}
+}
+
+// stream returns the stream of a PID, creating it if needed.
+func (p *prober) stream(pid uint16) *ProbeStreamInfo {
+	s, ok := p.streams[pid]
+	if !ok {
+		s = &ProbeStreamInfo{PID: pid}
+		p.streams[pid] = s
+	}
+	return s
+}
+
+// compute computes the duration and the bitrates.
+func (p *prober) compute() {
+	// Streams
+	for _, s := range p.streams {
+		s.computeDuration()
+		p.i.Streams = append(p.i.Streams, s)
+	}
+	sort.Slice(p.i.Streams, func(i, j int) bool { return p.i.Streams[i].PID < p.i.Streams[j].PID })
+
+	// Duration
+	switch {
+	case p.i.FirstPCR != nil:
+		p.i.StartTime = p.i.FirstPCR.Duration()
+		p.i.Duration = clockReferenceBaseDuration(p.i.LastPCR.Base - p.i.FirstPCR.Base)
+
+		// Estimate duration based on the bitrate at the start of the input
+		var estimated time.Duration
+		if d := p.lastContinuousPCR.Base - p.i.FirstPCR.Base; d > 0 {
+			bitrate := (p.lastContinuousOffset - p.firstPCROffset) * 8 * 90000 / d
+			if bitrate > 0 {
+				estimated = time.Duration(float64(p.i.Size*8) / float64(bitrate) * float64(time.Second))
+			}
+		}
+
+		// Timeline is not continuous
+		if estimated > 0 && (p.headDiscontinuity || p.tailDiscontinuity ||
+			p.i.Duration > 2*estimated || p.i.Duration < estimated/2) {
+			p.i.Discontinuity = true
+			p.i.Duration = estimated
+		}
+	default:
+		// Fall back on PTS
+		for _, s := range p.i.Streams {
+			if s.FirstPTS == nil {
+				continue
+			}
+			if t := s.FirstPTS.Duration(); p.i.StartTime == 0 || t < p.i.StartTime {
+				p.i.StartTime = t
+			}
+			if s.Duration > p.i.Duration {
+				p.i.Duration = s.Duration
+			}
+		}
+	}
+
+	// Bitrates
+	if p.i.Duration > 0 {
+		p.i.Bitrate = int64(float64(p.i.Size*8) / p.i.Duration.Seconds())
+	}
+	if p.packets > 0 {
+		for _, s := range p.i.Streams {
+			s.Bitrate = p.i.Bitrate * s.packets / p.packets
+		}
+	}
+}
+
+// computeDuration computes the duration between the earliest and the latest presentation
+// times, assuming the timeline is continuous between the windows.
+func (s *ProbeStreamInfo) computeDuration() {
+	// Get windows
+	head, tail := s.head, s.tail
+	if head == nil {
+		head, tail = tail, nil
+	}
+	if head == nil {
+		return
+	}
+
+	// Head only
+	s.FirstPTS = head.minPTS
+	if tail == nil {
+		s.LastPTS = head.maxPTS
+		s.Duration = time.Duration((head.max - head.min) * 1e9 / 90000)
+		return
+	}
+
+	// Head and tail
+	s.LastPTS = tail.maxPTS
+	// Time only moves forward between the windows, unless frames are reordered around the
+	// end of the head window
+	gap := (tail.firstPTS.Base - head.lastPTS.Base + clockReferenceBaseModulo) % clockReferenceBaseModulo
+	if gap > clockReferenceBaseModulo-timelineMaxTimestampGap {
+		gap -= clockReferenceBaseModulo
+	}
+	d := head.last - head.min + gap + tail.max - tail.first
+	if d > 0 {
+		s.Duration = time.Duration(d * 1e9 / 90000)
+	}
+}
+
+// clockReferenceBaseDuration converts a difference of 33 bits bases into a duration,
+// handling wraparounds.
+func clockReferenceBaseDuration(d int64) time.Duration {
+	d = (d + clockReferenceBaseModulo) % clockReferenceBaseModulo
+	return time.Duration(d * 1e9 / 90000)
+}
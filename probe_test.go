@@ -0,0 +1,139 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe(t *testing.T) {
+	b := seekBytes(t)
+
+	// Whole input fits in the window
+	i, err := Probe(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.False(t, i.Discontinuity)
+	assert.Equal(t, 19*time.Second, i.Duration)
+	assert.Equal(t, int64(0), i.FirstPCR.Base)
+	assert.Equal(t, int64(19*90000), i.LastPCR.Base)
+	assert.Equal(t, uint16(0x100), i.PCRPID)
+	assert.Equal(t, int64(len(b)), i.Size)
+	assert.Equal(t, time.Duration(0), i.StartTime)
+	assert.Equal(t, int64(len(b)*8/19), i.Bitrate)
+	assert.Len(t, i.Streams, 1)
+	assert.Equal(t, uint16(0x100), i.Streams[0].PID)
+	assert.Equal(t, StreamTypeH264Video, i.Streams[0].StreamType)
+	assert.Equal(t, int64(9000), i.Streams[0].FirstPTS.Base)
+	assert.Equal(t, int64(19*90000+9000), i.Streams[0].LastPTS.Base)
+	assert.Equal(t, 19*time.Second, i.Streams[0].Duration)
+	assert.True(t, i.Streams[0].Bitrate > 0 && i.Streams[0].Bitrate < i.Bitrate)
+
+	// Only the head and the tail are read
+	defer func(s int64) { probeWindowSize = s }(probeWindowSize)
+	probeWindowSize = 10 * MpegTsPacketSize
+	i, err = Probe(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.False(t, i.Discontinuity)
+	assert.Equal(t, 19*time.Second, i.Duration)
+	assert.Equal(t, int64(19*90000), i.LastPCR.Base)
+	assert.Equal(t, int64(19*90000+9000), i.Streams[0].LastPTS.Base)
+
+	// PCRs of another PID are found before the PMT
+	buf := &bytes.Buffer{}
+	_, err = writePacket(bitio.NewWriter(buf), &Packet{
+		AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(3600*90000, 0)},
+		Header:          &PacketHeader{HasAdaptationField: true, PID: 0x200},
+	}, MpegTsPacketSize)
+	assert.NoError(t, err)
+	i, err = Probe(context.Background(), bytes.NewReader(append(buf.Bytes(), b...)))
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(0x100), i.PCRPID)
+	assert.Equal(t, int64(0), i.FirstPCR.Base)
+	assert.False(t, i.Discontinuity)
+	assert.Equal(t, 19*time.Second, i.Duration)
+}
+
+func TestClockReferenceBaseDuration(t *testing.T) {
+	assert.Equal(t, time.Second, clockReferenceBaseDuration(90000))
+	assert.Equal(t, time.Second, clockReferenceBaseDuration(90000-clockReferenceBaseModulo))
+}
+
+func TestProbeDiscontinuity(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 20; idx++ {
+		// The clock jumps one hour ahead halfway through
+		pcr := int64(idx * 90000)
+		if idx >= 10 {
+			pcr += 3600 * 90000
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(pcr, 0)},
+			PID:             0x100,
+			PES: &PESData{
+				Data:   h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0),
+				Header: &PESHeader{},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	i, err := Probe(context.Background(), bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.True(t, i.Discontinuity)
+	assert.True(t, i.Duration > 15*time.Second && i.Duration < 25*time.Second)
+}
+
+func TestProbeReorderedPTS(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf, MuxerOptTablesRetransmitPeriod(5))
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 20; idx++ {
+		// Frames are reordered by pairs and the PTS wrap around
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x100,
+			PES: &PESData{
+				Data: h264Bytes(H264NALUnitTypeNonIDRSlice, 0xc0),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference((clockReferenceBaseModulo-5*9000+int64(idx^1)*9000)%clockReferenceBaseModulo, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	b := buf.Bytes()
+
+	// Whole input fits in the window
+	i, err := Probe(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, i.Streams, 1)
+	assert.Equal(t, int64(clockReferenceBaseModulo-5*9000), i.Streams[0].FirstPTS.Base)
+	assert.Equal(t, int64(14*9000), i.Streams[0].LastPTS.Base)
+	assert.Equal(t, 1900*time.Millisecond, i.Streams[0].Duration)
+
+	// Tables are only found in the tail
+	defer func(s int64) { probeWindowSize = s }(probeWindowSize)
+	probeWindowSize = int64(len(b)/MpegTsPacketSize/2) * MpegTsPacketSize
+	for off := 0; off < int(probeWindowSize); off += MpegTsPacketSize {
+		if pid := uint16(b[off+1]&0x1f)<<8 | uint16(b[off+2]); pid == PIDPAT || pid == 0x1000 {
+			b[off+1], b[off+2] = 0x1f, 0xff
+		}
+	}
+	i, err = Probe(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, i.Streams, 1)
+	assert.Equal(t, StreamTypeH264Video, i.Streams[0].StreamType)
+	assert.Equal(t, int64(clockReferenceBaseModulo-5*9000), i.Streams[0].FirstPTS.Base)
+	assert.Equal(t, int64(14*9000), i.Streams[0].LastPTS.Base)
+	assert.Equal(t, 1900*time.Millisecond, i.Streams[0].Duration)
+}
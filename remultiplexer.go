@@ -0,0 +1,545 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// Remultiplexer errors.
+var (
+	ErrRemultiplexerBitrateTooLow = errors.New("bitrate too low")
+	ErrRemultiplexerNoInput       = errors.New("no input")
+)
+
+const (
+	remultiplexerClockModulo = clockReferenceBaseModulo * 300
+	remultiplexerClockRate   = 27000000
+	remultiplexerFirstPID    = 0x100
+	remultiplexerFirstPMTPID = 0x1000
+	remultiplexerMaxDelay    = remultiplexerClockRate / 10 // Since PTSs and DTSs are kept
+)
+
+// Remultiplexer multiplexes the programs of several inputs into one transport stream.
+//
+// Packets are sorted on their time, which is interpolated between the PCRs of their input, the
+// first PCR of each input being the start of the output. PIDs and program numbers are
+// renumbered when they conflict, and PAT, PMTs and SDT are rebuilt. PCRs are restamped
+// according to the new times of their packets. When a bitrate is set, null packets are inserted
+// to make it constant, and ErrRemultiplexerBitrateTooLow is returned when it can't hold the
+// inputs, that is when a packet would leave more than 100ms after its time.
+type Remultiplexer struct {
+	ccs                       map[uint16]*wrappingCounter // Tables PIDs
+	inputs                    []*RemultiplexerInput
+	mx                        *Muxer
+	nextTables                int64 // 27MHz
+	optBitrate                int64
+	optOriginalNetworkID      uint16
+	optTablesRetransmitPeriod int64 // 27MHz
+	optTransportStreamID      uint16
+	patUpdated                bool
+	patVersion                wrappingCounter
+	pids                      map[uint16]bool // Output PIDs in use
+	programs                  []*remultiplexerProgram
+	restamper                 *PCRRestamper
+	sdtUpdated                bool
+	sdtVersion                wrappingCounter
+	written                   int64 // Number of packets
+}
+
+// RemultiplexerInput represents an input of a remultiplexer.
+type RemultiplexerInput struct {
+	clockPID    uint16
+	dmx         *Demuxer
+	ended       bool
+	hasPCR      bool
+	lastPCR     int64 // 27MHz
+	lastPos     int64
+	lastT       int64
+	optPIDs     map[uint16]bool
+	optPrograms map[uint16]bool
+	pending     []*remultiplexerPacket
+	pids        map[uint16]uint16                // Input PID --> output PID
+	programs    map[uint16]*remultiplexerProgram // Input program number --> output program
+	r           *Remultiplexer
+	rateBytes   int64
+	rateTicks   int64
+	ready       int // Number of pending packets whose time is known
+	services    map[uint16]*SDTDataService
+	tsid        uint16
+}
+
+type remultiplexerPacket struct {
+	p *Packet
+	t int64 // 27MHz
+}
+
+type remultiplexerProgram struct {
+	number     uint16
+	pmt        *PMTData
+	pmtPID     uint16
+	pmtUpdated bool
+	pmtVersion wrappingCounter
+	service    *SDTDataService
+}
+
+// RemultiplexerOptBitrate returns the option to write a constant bitrate output.
+func RemultiplexerOptBitrate(bps int64) func(*Remultiplexer) {
+	return func(r *Remultiplexer) {
+		r.optBitrate = bps
+	}
+}
+
+// RemultiplexerOptOriginalNetworkID returns the option to set the original network ID of the
+// SDT. Default is 1.
+func RemultiplexerOptOriginalNetworkID(id uint16) func(*Remultiplexer) {
+	return func(r *Remultiplexer) {
+		r.optOriginalNetworkID = id
+	}
+}
+
+// RemultiplexerOptTablesRetransmitPeriod returns the option to set the period at which tables
+// are written. Default is 100ms.
+func RemultiplexerOptTablesRetransmitPeriod(d time.Duration) func(*Remultiplexer) {
+	return func(r *Remultiplexer) {
+		r.optTablesRetransmitPeriod = int64(d * remultiplexerClockRate / time.Second)
+	}
+}
+
+// RemultiplexerOptTransportStreamID returns the option to set the transport stream ID. Default
+// is 1.
+func RemultiplexerOptTransportStreamID(id uint16) func(*Remultiplexer) {
+	return func(r *Remultiplexer) {
+		r.optTransportStreamID = id
+	}
+}
+
+// RemultiplexerInputOptPIDs returns the option to only keep some elementary PIDs of the input.
+// The PCR PID of a program is kept even when it is not selected, but only its PCRs are written.
+func RemultiplexerInputOptPIDs(pids ...uint16) func(*RemultiplexerInput) {
+	return func(i *RemultiplexerInput) {
+		i.optPIDs = make(map[uint16]bool)
+		for _, pid := range pids {
+			i.optPIDs[pid] = true
+		}
+	}
+}
+
+// RemultiplexerInputOptPrograms returns the option to only keep some programs of the input.
+func RemultiplexerInputOptPrograms(programNumbers ...uint16) func(*RemultiplexerInput) {
+	return func(i *RemultiplexerInput) {
+		i.optPrograms = make(map[uint16]bool)
+		for _, n := range programNumbers {
+			i.optPrograms[n] = true
+		}
+	}
+}
+
+// NewRemultiplexer creates a new remultiplexer.
+func NewRemultiplexer(ctx context.Context, w WriterAndByteWriter, opts ...func(*Remultiplexer)) *Remultiplexer {
+	r := &Remultiplexer{
+		ccs:                       make(map[uint16]*wrappingCounter),
+		mx:                        NewMuxer(ctx, w),
+		optOriginalNetworkID:      1,
+		optTablesRetransmitPeriod: remultiplexerClockRate / 10,
+		optTransportStreamID:      1,
+		// table version is 5-bit field.
+		patVersion: newWrappingCounter(0b11111),
+		pids:       map[uint16]bool{PIDPAT: true, PIDCAT: true, pidSDT: true},
+		restamper:  NewPCRRestamper(),
+		sdtVersion: newWrappingCounter(0b11111),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// AddInput adds an input.
+func (r *Remultiplexer) AddInput(dmx *Demuxer, opts ...func(*RemultiplexerInput)) {
+	i := &RemultiplexerInput{
+		dmx:      dmx,
+		pids:     make(map[uint16]uint16),
+		programs: make(map[uint16]*remultiplexerProgram),
+		r:        r,
+		services: make(map[uint16]*SDTDataService),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	r.inputs = append(r.inputs, i)
+}
+
+// Remultiplex writes the packets of all inputs until they end.
+func (r *Remultiplexer) Remultiplex() error {
+	if len(r.inputs) == 0 {
+		return ErrRemultiplexerNoInput
+	}
+
+	for {
+		// Get the input whose next packet comes first
+		var next *RemultiplexerInput
+		for _, i := range r.inputs {
+			for i.ready == 0 && !i.ended {
+				if err := i.read(); err != nil {
+					return err
+				}
+			}
+			if i.ready > 0 && (next == nil || i.pending[0].t < next.pending[0].t) {
+				next = i
+			}
+		}
+		if next == nil {
+			return nil
+		}
+
+		// Write packet
+		p := next.pending[0]
+		next.pending = next.pending[1:]
+		next.ready--
+		if err := r.writePacket(next, p); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the next packet of the input.
+func (i *RemultiplexerInput) read() error {
+	// Get next packet
+	p, err := i.dmx.NextPacket()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// Packets following the last PCR are extrapolated
+		i.ended = true
+		i.updateTimes(i.lastT, i.lastPos)
+		return nil
+	}
+
+	// Learn programs
+	i.processData(i.dmx.packetData(p))
+
+	// Tables are rebuilt
+	pid := p.Header.PID
+	if pid == PIDNull || isPSIPayload(pid, i.dmx.programMap) {
+		return nil
+	}
+	i.pending = append(i.pending, &remultiplexerPacket{p: p})
+
+	// Packets preceding the first PCR start with it
+	if pid != i.clockPID || !p.Header.HasAdaptationField || !p.AdaptationField.HasPCR {
+		return nil
+	}
+	pcr := p.AdaptationField.PCR.Base*300 + p.AdaptationField.PCR.Extension
+	var t int64
+	if i.hasPCR {
+		d := (pcr - i.lastPCR + remultiplexerClockModulo) % remultiplexerClockModulo
+		if b := p.Offset - i.lastPos; d > timelineMaxTimestampGap*300 || b <= 0 {
+			// Discontinuities are ignored
+			d = i.extrapolate(p.Offset) - i.lastT
+		} else {
+			i.rateBytes, i.rateTicks = b, d
+		}
+		t = i.lastT + d
+	}
+	i.updateTimes(t, p.Offset)
+	i.hasPCR = true
+	i.lastPCR = pcr
+	i.lastPos = p.Offset
+	i.lastT = t
+	return nil
+}
+
+// extrapolate returns the time of a position following the last PCR.
+func (i *RemultiplexerInput) extrapolate(pos int64) int64 {
+	if i.rateBytes == 0 {
+		return i.lastT
+	}
+	return i.lastT + (pos-i.lastPos)*i.rateTicks/i.rateBytes
+}
+
+// updateTimes sets the time of pending packets up to a position whose time is known.
+func (i *RemultiplexerInput) updateTimes(t, pos int64) {
+	for _, p := range i.pending[i.ready:] {
+		switch {
+		case !i.hasPCR:
+			p.t = t
+		case pos > i.lastPos && p.p.Offset <= pos:
+			p.t = i.lastT + (p.p.Offset-i.lastPos)*(t-i.lastT)/(pos-i.lastPos)
+		default:
+			p.t = i.extrapolate(p.p.Offset)
+		}
+	}
+	i.ready = len(i.pending)
+}
+
+// processData processes the data of a payload unit.
+func (i *RemultiplexerInput) processData(ds []*DemuxerData) {
+	for _, d := range ds {
+		switch {
+		case d.PAT != nil:
+			i.tsid = d.PAT.TransportStreamID
+		case d.PMT != nil:
+			i.updateProgram(d.PMT, d.PID)
+		case d.SDT != nil && d.SDT.TransportStreamID == i.tsid:
+			for _, s := range d.SDT.Services {
+				i.services[s.ServiceID] = s
+				if prg, ok := i.programs[s.ServiceID]; ok {
+					i.updateService(prg, s)
+				}
+			}
+		}
+	}
+}
+
+func (i *RemultiplexerInput) updateProgram(pmt *PMTData, pmtPID uint16) {
+	if i.optPrograms != nil && !i.optPrograms[pmt.ProgramNumber] {
+		return
+	}
+	r := i.r
+
+	// Add program
+	prg, ok := i.programs[pmt.ProgramNumber]
+	if !ok {
+		prg = &remultiplexerProgram{
+			number:     r.freeProgramNumber(pmt.ProgramNumber),
+			pmtPID:     r.freePID(pmtPID, remultiplexerFirstPMTPID),
+			pmtVersion: newWrappingCounter(0b11111),
+		}
+		r.pids[prg.pmtPID] = true
+		r.programs = append(r.programs, prg)
+		r.patUpdated = true
+		i.programs[pmt.ProgramNumber] = prg
+		if s, ok := i.services[pmt.ProgramNumber]; ok {
+			i.updateService(prg, s)
+		} else {
+			r.sdtUpdated = true
+		}
+
+		// Packets are timed on the PCRs of the first program
+		if i.clockPID == 0 {
+			i.clockPID = pmt.PCRPID
+		}
+	}
+
+	// Update PMT
+	o := &PMTData{
+		PCRPID:             PIDNull,
+		ProgramDescriptors: pmt.ProgramDescriptors,
+		ProgramNumber:      prg.number,
+	}
+	if pmt.PCRPID != PIDNull {
+		o.PCRPID = i.mapPID(pmt.PCRPID)
+	}
+	for _, es := range pmt.ElementaryStreams {
+		if i.optPIDs != nil && !i.optPIDs[es.ElementaryPID] {
+			continue
+		}
+		oes := *es
+		oes.ElementaryPID = i.mapPID(es.ElementaryPID)
+		o.ElementaryStreams = append(o.ElementaryStreams, &oes)
+	}
+	if !reflect.DeepEqual(prg.pmt, o) {
+		prg.pmt = o
+		prg.pmtUpdated = true
+	}
+}
+
+func (i *RemultiplexerInput) updateService(prg *remultiplexerProgram, s *SDTDataService) {
+	o := *s
+	o.ServiceID = prg.number
+	if !reflect.DeepEqual(prg.service, &o) {
+		prg.service = &o
+		i.r.sdtUpdated = true
+	}
+}
+
+func (i *RemultiplexerInput) mapPID(pid uint16) uint16 {
+	if v, ok := i.pids[pid]; ok {
+		return v
+	}
+	v := i.r.freePID(pid, remultiplexerFirstPID)
+	i.r.pids[v] = true
+	i.pids[pid] = v
+	return v
+}
+
+// freePID returns the PID if it is not used by the output, or the first free PID starting
+// from a value otherwise.
+func (r *Remultiplexer) freePID(pid, from uint16) uint16 {
+	if pid >= 0x20 && pid < PIDNull && !r.pids[pid] {
+		return pid
+	}
+	for pid = from; r.pids[pid]; pid++ {
+	}
+	return pid
+}
+
+// freeProgramNumber returns the program number if it is not used by the output, or the first
+// free program number otherwise.
+func (r *Remultiplexer) freeProgramNumber(n uint16) uint16 {
+	used := make(map[uint16]bool)
+	for _, prg := range r.programs {
+		used[prg.number] = true
+	}
+	if n > 0 && !used[n] {
+		return n
+	}
+	for n = 1; used[n]; n++ {
+	}
+	return n
+}
+
+// time returns the time of the next packet slot of a constant bitrate output.
+func (r *Remultiplexer) time() int64 {
+	bits := r.written * MpegTsPacketSize * 8
+	return bits/r.optBitrate*remultiplexerClockRate + bits%r.optBitrate*remultiplexerClockRate/r.optBitrate
+}
+
+func (r *Remultiplexer) writePacket(i *RemultiplexerInput, p *remultiplexerPacket) error {
+	// Only packets of the selected PIDs are kept
+	pid, ok := i.pids[p.p.Header.PID]
+	if !ok {
+		return nil
+	}
+
+	// PIDs that are not selected but carry the PCRs of a program only keep their PCRs. Since
+	// packets have no payload anymore, their continuity counter doesn't change.
+	if i.optPIDs != nil && !i.optPIDs[p.p.Header.PID] {
+		if !p.p.Header.HasAdaptationField || !p.p.AdaptationField.HasPCR {
+			return nil
+		}
+		af := newStuffingAdaptationField(MpegTsPacketSize - 1 - mpegTsPacketHeaderSize)
+		af.DiscontinuityIndicator = p.p.AdaptationField.DiscontinuityIndicator
+		af.HasPCR = true
+		af.PCR = p.p.AdaptationField.PCR
+		af.StuffingLength -= pcrBytesSize
+		p.p = &Packet{
+			AdaptationField: af,
+			Header:          &PacketHeader{HasAdaptationField: true, PID: p.p.Header.PID},
+			Index:           p.p.Index,
+			Offset:          p.p.Offset,
+		}
+	}
+
+	// Constant bitrate outputs are filled with null packets
+	t := p.t
+	if r.optBitrate > 0 {
+		for r.time() < p.t {
+			if err := r.writeTablesIfNeeded(r.time()); err != nil {
+				return err
+			}
+			if r.time() >= p.t {
+				break
+			}
+			if err := r.write(&Packet{
+				Header:  &PacketHeader{HasPayload: true, PID: PIDNull},
+				Payload: bytes.Repeat([]byte{0xff}, MpegTsPacketSize-mpegTsPacketHeaderSize-1),
+			}); err != nil {
+				return fmt.Errorf("writing null packet failed: %w", err)
+			}
+		}
+		if err := r.writeTablesIfNeeded(r.time()); err != nil {
+			return err
+		}
+		t = r.time()
+		if t-p.t > remultiplexerMaxDelay {
+			return ErrRemultiplexerBitrateTooLow
+		}
+	} else if err := r.writeTablesIfNeeded(t); err != nil {
+		return err
+	}
+
+	// Restamp PCR according to the new time of the packet
+	p.p.Header.PID = pid
+	r.restamper.RestampAt(p.p, time.Duration(t*1000/(remultiplexerClockRate/1000000)))
+	if err := r.write(p.p); err != nil {
+		return fmt.Errorf("writing packet failed: %w", err)
+	}
+	return nil
+}
+
+func (r *Remultiplexer) write(p *Packet) error {
+	if _, err := r.mx.WritePacket(p); err != nil {
+		return err
+	}
+	r.written++
+	return nil
+}
+
+// writeTablesIfNeeded writes tables when their period has elapsed or when they have changed.
+func (r *Remultiplexer) writeTablesIfNeeded(t int64) error {
+	updated := r.patUpdated || r.sdtUpdated
+	for _, prg := range r.programs {
+		updated = updated || prg.pmtUpdated
+	}
+	if len(r.programs) == 0 || (!updated && t < r.nextTables) {
+		return nil
+	}
+	r.nextTables = t + r.optTablesRetransmitPeriod
+
+	// PAT
+	pat := &PATData{TransportStreamID: r.optTransportStreamID}
+	for _, prg := range r.programs {
+		pat.Programs = append(pat.Programs, &PATProgram{ProgramMapID: prg.pmtPID, ProgramNumber: prg.number})
+	}
+	if err := r.writeTable(PIDPAT, PSITableIDPAT, pat.TransportStreamID, &r.patVersion, &r.patUpdated, &PSISectionSyntaxData{PAT: pat}); err != nil {
+		return fmt.Errorf("writing PAT failed: %w", err)
+	}
+
+	// PMTs
+	for _, prg := range r.programs {
+		if err := r.writeTable(prg.pmtPID, PSITableIDPMT, prg.number, &prg.pmtVersion, &prg.pmtUpdated, &PSISectionSyntaxData{PMT: prg.pmt}); err != nil {
+			return fmt.Errorf("writing PMT failed: %w", err)
+		}
+	}
+
+	// SDT
+	sdt := &SDTData{OriginalNetworkID: r.optOriginalNetworkID, TransportStreamID: r.optTransportStreamID}
+	for _, prg := range r.programs {
+		s := prg.service
+		if s == nil {
+			s = &SDTDataService{RunningStatus: RunningStatusRunning, ServiceID: prg.number}
+		}
+		sdt.Services = append(sdt.Services, s)
+	}
+	if err := r.writeTable(pidSDT, PSITableIDSDTVariant1, sdt.TransportStreamID, &r.sdtVersion, &r.sdtUpdated, &PSISectionSyntaxData{SDT: sdt}); err != nil {
+		return fmt.Errorf("writing SDT failed: %w", err)
+	}
+	return nil
+}
+
+func (r *Remultiplexer) writeTable(pid uint16, tableID PSITableID, tableIDExtension uint16, version *wrappingCounter, updated *bool, d *PSISectionSyntaxData) error {
+	// Update version
+	v := version.get()
+	if *updated {
+		v = version.inc()
+		*updated = false
+	}
+
+	ps, err := newPSIPackets(pid, tableID, tableIDExtension, uint8(v), d)
+	if err != nil {
+		return err
+	}
+
+	// Write packets
+	cc, ok := r.ccs[pid]
+	if !ok {
+		v := newWrappingCounter(0b1111) // CC is 4 bits.
+		cc = &v
+		r.ccs[pid] = cc
+	}
+	for _, p := range ps {
+		p.Header.ContinuityCounter = uint8(cc.inc())
+		if err = r.write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
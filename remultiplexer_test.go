@@ -0,0 +1,95 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemultiplexer(t *testing.T) {
+	ptss := func(pcr int64) (o []int64) {
+		for idx := int64(0); idx < 5; idx++ {
+			o = append(o, pcr+9000+idx*18000)
+		}
+		return
+	}
+
+	// No input
+	err := NewRemultiplexer(context.Background(), &bytes.Buffer{}).Remultiplex()
+	assert.ErrorIs(t, err, ErrRemultiplexerNoInput)
+
+	// Variable bitrate
+	buf := &bytes.Buffer{}
+	r := NewRemultiplexer(context.Background(), buf, RemultiplexerOptTransportStreamID(3))
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))))
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{count: 5, interval: 18000, pcr: 5000000, videoPID: 0x100}))))
+	err = r.Remultiplex()
+	assert.NoError(t, err)
+	res := testDemux(t, buf.Bytes())
+	assert.Equal(t, &PATData{
+		Programs: []*PATProgram{
+			{ProgramMapID: 0x1000, ProgramNumber: 1},
+			{ProgramMapID: 0x1001, ProgramNumber: 2},
+		},
+		TransportStreamID: 3,
+	}, res.pat)
+	assert.Equal(t, uint16(0x100), res.programs[1].PCRPID)
+	assert.Len(t, res.programs[1].ElementaryStreams, 2)
+	assert.Equal(t, uint16(0x102), res.programs[2].PCRPID)
+	assert.Len(t, res.programs[2].ElementaryStreams, 1)
+	assert.Equal(t, uint16(0x102), res.programs[2].ElementaryStreams[0].ElementaryPID)
+	assert.Equal(t, uint16(3), res.sdt.TransportStreamID)
+	assert.Len(t, res.sdt.Services, 2)
+	assert.Equal(t, uint16(2), res.sdt.Services[1].ServiceID)
+	assert.Equal(t, ptss(0), res.ptss[0x100])
+	assert.Equal(t, ptss(0), res.ptss[0x101])
+	assert.Equal(t, ptss(5000000), res.ptss[0x102])
+	assert.Equal(t, []int64{0, 5400000, 10800000, 16200000, 21600000}, res.pcrs[0x100])
+	assert.Equal(t, int64(5000000*300), res.pcrs[0x102][0])
+
+	// The PCR PID is not selected
+	buf.Reset()
+	r = NewRemultiplexer(context.Background(), buf)
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))), RemultiplexerInputOptPIDs(0x101))
+	err = r.Remultiplex()
+	assert.NoError(t, err)
+	res = testDemux(t, buf.Bytes())
+	assert.Equal(t, uint16(0x100), res.programs[1].PCRPID)
+	assert.Len(t, res.programs[1].ElementaryStreams, 1)
+	assert.Equal(t, uint16(0x101), res.programs[1].ElementaryStreams[0].ElementaryPID)
+	assert.Empty(t, res.ptss[0x100])
+	assert.Equal(t, ptss(0), res.ptss[0x101])
+	assert.Equal(t, []int64{0, 5400000, 10800000, 16200000, 21600000}, res.pcrs[0x100])
+	for _, o := range repairerPacketOffsets(buf.Bytes(), 0x100) {
+		assert.Equal(t, byte(0x20), buf.Bytes()[o+3]&0x30)
+	}
+	a, _ := analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Constant bitrate
+	buf.Reset()
+	r = NewRemultiplexer(context.Background(), buf, RemultiplexerOptBitrate(1000000))
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))), RemultiplexerInputOptPIDs(0x100))
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, pcr: 5000000, videoPID: 0x100}))), RemultiplexerInputOptPrograms(2))
+	err = r.Remultiplex()
+	assert.NoError(t, err)
+	res = testDemux(t, buf.Bytes())
+	assert.Len(t, res.pat.Programs, 1)
+	assert.Len(t, res.programs[1].ElementaryStreams, 1)
+	assert.Equal(t, ptss(0), res.ptss[0x100])
+	assert.Empty(t, res.ptss[0x101])
+	r.written = res.pos[0x100][0]
+	start := r.time()
+	for idx, pcr := range res.pcrs[0x100] {
+		r.written = res.pos[0x100][idx]
+		assert.Equal(t, r.time()-start, pcr)
+	}
+	assert.Greater(t, r.time(), int64(4*5400000))
+
+	// Bitrate too low
+	r = NewRemultiplexer(context.Background(), &bytes.Buffer{}, RemultiplexerOptBitrate(20000))
+	r.AddInput(NewDemuxer(context.Background(), bytes.NewReader(testProgramBytes(t, testProgram{audioPID: 0x101, count: 5, interval: 18000, videoPID: 0x100}))))
+	assert.ErrorIs(t, r.Remultiplex(), ErrRemultiplexerBitrateTooLow)
+}
@@ -0,0 +1,596 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/icza/bitio"
+)
+
+// RepairAction represents a change made by a repairer.
+type RepairAction string
+
+// Repair actions
+const (
+	RepairActionContinuityCounter RepairAction = "continuity_counter" // Continuity counters were renumbered
+	RepairActionDuplicatePacket   RepairAction = "duplicate_packet"   // A duplicate packet was dropped
+	RepairActionIncompleteUnit    RepairAction = "incomplete_unit"    // An incomplete PES or section was dropped
+	RepairActionSyncLoss          RepairAction = "sync_loss"          // A packet followed by a loss of sync was dropped
+	RepairActionTableRegenerated  RepairAction = "table_regenerated"  // A missing or corrupt PAT or PMT was regenerated
+	RepairActionTransportError    RepairAction = "transport_error"    // A packet with the transport error indicator was dropped
+)
+
+// RepairEvent represents a change made by a repairer.
+type RepairEvent struct {
+	Action  RepairAction
+	Message string
+	Offset  int64 // Byte offset in the input of the packet that triggered the change.
+	PID     uint16
+}
+
+// RepairReport represents the changes made by a repairer.
+type RepairReport struct {
+	Counters     map[RepairAction]int
+	Events       []*RepairEvent
+	SkippedBytes int64 // Bytes skipped while looking for sync bytes.
+}
+
+// Repairer repairs damaged transport streams.
+//
+// Packets with the transport error indicator and packets followed by a loss of sync are dropped,
+// as well as duplicate packets. PES and sections in which packets are missing are dropped
+// entirely. When the input doesn't carry a PAT or PMTs within the probe size, they are generated
+// from the PES found in the meantime and written first. PAT and PMTs that are corrupt are
+// replaced with the last good version or, when there is none, with the generated ones. Continuity counters are renumbered so that they are continuous.
+type Repairer struct {
+	optProbeSize int
+	w            *bitio.Writer
+}
+
+type repairing struct {
+	ccs      map[uint16]*repairerCC
+	count    int
+	dmx      *Demuxer
+	last     map[uint16]*repairerLast
+	lastGood map[uint16][]*Packet // Packets of the last good PAT and PMTs
+	orphans  map[uint16]bool      // PIDs whose packets are dropped until their next payload unit
+	pat      *PATData
+	pids     map[uint16]bool
+	pmts     map[uint16]*PMTData
+	probed   bool
+	queue    []*repairerPacket
+	r        *Repairer
+	report   *RepairReport
+	streams  map[uint16]*repairerStream
+	units    map[uint16]*repairerUnit
+}
+
+type repairerCC struct {
+	c     wrappingCounter
+	shift uint8 // Difference between output and input continuity counters
+}
+
+type repairerLast struct {
+	cc      uint8
+	payload []byte
+	pusi    bool
+}
+
+type repairerPacket struct {
+	p *Packet
+	u *repairerUnit
+}
+
+// repairerStream represents a PES stream found while probing.
+type repairerStream struct {
+	hasPCR bool
+	t      StreamType
+}
+
+// repairerUnit represents a PES or sections whose packets are being queued.
+type repairerUnit struct {
+	broken      bool
+	complete    bool
+	drop        bool
+	length      int // Expected payload length, 0 if unknown
+	packets     []*Packet
+	psi         bool
+	read        int
+	replacement []*Packet // Packets written instead of the unit
+	replaced    bool
+}
+
+// RepairerOptProbeSize returns the option to set the number of packets within which tables
+// are expected. Default is 10000.
+func RepairerOptProbeSize(packets int) func(*Repairer) {
+	return func(r *Repairer) {
+		r.optProbeSize = packets
+	}
+}
+
+// NewRepairer creates a new repairer writing 188 bytes packets.
+func NewRepairer(w io.Writer, opts ...func(*Repairer)) *Repairer {
+	r := &Repairer{
+		optProbeSize: 10000,
+		w:            bitio.NewWriter(w),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Repair repairs the packets read from rd and returns a report of the changes. Bytes are
+// skipped until sync bytes are found whenever a packet doesn't start with one.
+func (r *Repairer) Repair(ctx context.Context, rd io.Reader, opts ...func(*Demuxer)) (*RepairReport, error) {
+	rp := &repairing{
+		ccs:      make(map[uint16]*repairerCC),
+		dmx:      NewDemuxer(ctx, rd, append([]func(*Demuxer){DemuxerOptResync()}, opts...)...),
+		last:     make(map[uint16]*repairerLast),
+		lastGood: make(map[uint16][]*Packet),
+		orphans:  make(map[uint16]bool),
+		pids:     make(map[uint16]bool),
+		pmts:     make(map[uint16]*PMTData),
+		r:        r,
+		report:   &RepairReport{Counters: make(map[RepairAction]int)},
+		streams:  make(map[uint16]*repairerStream),
+		units:    make(map[uint16]*repairerUnit),
+	}
+	if err := rp.repair(); err != nil {
+		return nil, err
+	}
+	return rp.report, nil
+}
+
+func (rp *repairing) repair() error {
+	var prev *Packet
+	for {
+		// Get next packet
+		p, err := rp.dmx.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// A packet is only kept once the next one is known to follow it, bytes being skipped
+		// otherwise since the packet was truncated
+		if prev != nil {
+			if n := p.Offset - prev.Offset - int64(rp.dmx.packetBuffer.packetSize); n > 0 {
+				rp.event(RepairActionSyncLoss, prev, fmt.Sprintf("packet dropped before %d bytes were skipped", n))
+				rp.gap(prev.Header.PID)
+			} else if err = rp.process(prev); err != nil {
+				return err
+			}
+		}
+		prev = p
+	}
+	rp.report.SkippedBytes = rp.dmx.SkippedBytes()
+	if prev != nil {
+		if err := rp.process(prev); err != nil {
+			return err
+		}
+	}
+
+	// Payload units are complete when the input ends
+	for _, u := range rp.units {
+		rp.complete(u, true)
+	}
+	if err := rp.probe(); err != nil {
+		return err
+	}
+	if err := rp.flush(); err != nil {
+		return err
+	}
+	return rp.r.w.Close()
+}
+
+func (rp *repairing) event(a RepairAction, p *Packet, msg string) {
+	rp.report.Counters[a]++
+	rp.report.Events = append(rp.report.Events, &RepairEvent{
+		Action:  a,
+		Message: msg,
+		Offset:  p.Offset,
+		PID:     p.Header.PID,
+	})
+}
+
+// gap marks the payload unit being received on a PID as broken.
+func (rp *repairing) gap(pid uint16) {
+	if u, ok := rp.units[pid]; ok {
+		u.broken = true
+	}
+}
+
+func (rp *repairing) process(p *Packet) error {
+	// Errored packets are dropped
+	pid := p.Header.PID
+	if p.Header.TransportErrorIndicator {
+		rp.event(RepairActionTransportError, p, "packet with transport error indicator dropped")
+		rp.gap(pid)
+		return nil
+	}
+	rp.pids[pid] = true
+
+	if pid != PIDNull {
+		// Check continuity
+		if l, ok := rp.last[pid]; ok {
+			switch {
+			case p.Header.HasPayload && p.Header.ContinuityCounter == l.cc && p.Header.PayloadUnitStartIndicator == l.pusi &&
+				bytes.Equal(p.Payload, l.payload):
+				rp.event(RepairActionDuplicatePacket, p, "duplicate packet dropped")
+				return nil
+			case p.Header.HasAdaptationField && p.AdaptationField.DiscontinuityIndicator:
+			case p.Header.HasPayload && p.Header.ContinuityCounter != (l.cc+1)&0xf,
+				!p.Header.HasPayload && p.Header.ContinuityCounter != l.cc:
+				rp.gap(pid)
+			}
+		}
+		l := &repairerLast{cc: p.Header.ContinuityCounter, pusi: p.Header.PayloadUnitStartIndicator}
+		if p.Header.HasPayload {
+			l.payload = p.Payload
+		}
+		rp.last[pid] = l
+
+		// Keep track of streams
+		if p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+			rp.stream(pid).hasPCR = true
+		}
+	}
+
+	// Packets without payload don't belong to any payload unit
+	if !p.Header.HasPayload || pid == PIDNull {
+		rp.queue = append(rp.queue, &repairerPacket{p: p})
+		return rp.flushIfProbed()
+	}
+
+	// Get payload unit
+	u, ok := rp.units[pid]
+	if p.Header.PayloadUnitStartIndicator {
+		if ok {
+			rp.complete(u, false)
+		}
+		u = &repairerUnit{psi: isPSIPayload(pid, rp.dmx.programMap)}
+		if !u.psi {
+			u.length = payloadUnitLength(p.Payload)
+			if t, ok := repairerStreamType(p.Payload); ok {
+				rp.stream(pid).t = t
+			}
+		}
+		rp.units[pid] = u
+		delete(rp.orphans, pid)
+	} else if !ok {
+		// The start of the payload unit is missing
+		if !rp.orphans[pid] {
+			rp.event(RepairActionIncompleteUnit, p, "payload unit without start dropped")
+			rp.orphans[pid] = true
+		}
+		return nil
+	}
+	u.packets = append(u.packets, p)
+	u.read += len(p.Payload)
+	rp.queue = append(rp.queue, &repairerPacket{p: p, u: u})
+
+	// Complete payload unit
+	if u.length > 0 && u.read >= u.length || u.psi && repairerSectionsComplete(u.packets) {
+		rp.complete(u, false)
+	}
+	return rp.flushIfProbed()
+}
+
+func (rp *repairing) stream(pid uint16) *repairerStream {
+	s, ok := rp.streams[pid]
+	if !ok {
+		s = &repairerStream{}
+		rp.streams[pid] = s
+	}
+	return s
+}
+
+func (rp *repairing) complete(u *repairerUnit, eof bool) {
+	first := u.packets[0]
+	pid := first.Header.PID
+	if rp.units[pid] == u {
+		delete(rp.units, pid)
+	}
+	u.complete = true
+	if eof && (u.length > 0 && u.read < u.length || u.psi && !repairerSectionsComplete(u.packets)) {
+		u.broken = true
+	}
+
+	// Only PAT and PMTs are regenerated
+	if !u.psi || (pid != PIDPAT && !rp.dmx.programMap.exists(pid)) {
+		if u.broken {
+			u.drop = true
+			rp.event(RepairActionIncompleteUnit, first, "incomplete payload unit dropped")
+		}
+		return
+	}
+
+	// Keep good tables
+	if !u.broken {
+		if ds, err := parseData(u.packets, nil, rp.dmx.programMap); err == nil && len(ds) > 0 {
+			for _, d := range ds {
+				rp.dmx.updatePrograms(d)
+				switch {
+				case d.PAT != nil:
+					rp.pat = d.PAT
+				case d.PMT != nil:
+					rp.pmts[pid] = d.PMT
+				}
+			}
+			rp.lastGood[pid] = u.packets
+			return
+		}
+	}
+
+	// Corrupt tables are replaced with the last good version, or with the one generated while
+	// probing when there is none yet
+	u.replaced = true
+	u.replacement = rp.lastGood[pid]
+	if len(u.replacement) > 0 {
+		rp.event(RepairActionTableRegenerated, first, "corrupt table replaced with the last good version")
+	}
+}
+
+func (rp *repairing) flushIfProbed() error {
+	rp.count++
+	if !rp.probed {
+		if rp.count < rp.r.optProbeSize {
+			return nil
+		}
+		if err := rp.probe(); err != nil {
+			return err
+		}
+	}
+	return rp.flush()
+}
+
+// probe generates the tables that were not found within the probe size from the PES found in
+// the meantime, and writes them first.
+func (rp *repairing) probe() error {
+	if rp.probed {
+		return nil
+	}
+	rp.probed = true
+
+	// Get streams that are not part of a PMT
+	var pids []uint16
+	for pid, s := range rp.streams {
+		if s.t > 0 {
+			pids = append(pids, pid)
+		}
+	}
+	for _, pmt := range rp.pmts {
+		for _, es := range pmt.ElementaryStreams {
+			for idx, pid := range pids {
+				if pid == es.ElementaryPID {
+					pids = append(pids[:idx], pids[idx+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if len(pids) == 0 {
+		return nil
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	// Get program missing its PMT
+	var ps []*Packet
+	var prg *PATProgram
+	if rp.pat != nil {
+		for _, v := range rp.pat.Programs {
+			if _, ok := rp.pmts[v.ProgramMapID]; !ok && v.ProgramNumber > 0 {
+				prg = v
+				break
+			}
+		}
+		if prg == nil {
+			return nil
+		}
+	} else {
+		// Generate PAT
+		prg = &PATProgram{ProgramMapID: 0x1000, ProgramNumber: 1}
+		for rp.pids[prg.ProgramMapID] {
+			prg.ProgramMapID++
+		}
+		pat := &PATData{Programs: []*PATProgram{prg}, TransportStreamID: 1}
+		var err error
+		if ps, err = newPSIPackets(PIDPAT, PSITableIDPAT, pat.TransportStreamID, 0, &PSISectionSyntaxData{PAT: pat}); err != nil {
+			return fmt.Errorf("generating PAT failed: %w", err)
+		}
+		rp.dmx.updatePrograms(&DemuxerData{PAT: pat})
+		rp.lastGood[PIDPAT] = ps
+		rp.pat = pat
+	}
+
+	// Generate PMT, PCRs being taken from the first PID carrying them
+	pmt := &PMTData{PCRPID: PIDNull, ProgramNumber: prg.ProgramNumber}
+	for _, pid := range pids {
+		if rp.streams[pid].hasPCR && pmt.PCRPID == PIDNull {
+			pmt.PCRPID = pid
+		}
+		pmt.ElementaryStreams = append(pmt.ElementaryStreams, &PMTElementaryStream{ElementaryPID: pid, StreamType: rp.streams[pid].t})
+	}
+	pmtPackets, err := newPSIPackets(prg.ProgramMapID, PSITableIDPMT, prg.ProgramNumber, 0, &PSISectionSyntaxData{PMT: pmt})
+	if err != nil {
+		return fmt.Errorf("generating PMT failed: %w", err)
+	}
+	rp.lastGood[prg.ProgramMapID] = pmtPackets
+	rp.pmts[prg.ProgramMapID] = pmt
+	ps = append(ps, pmtPackets...)
+
+	// Tables are written first, following the packets of the first PAT when there's one
+	var idx int
+	if rp.pat != nil && len(ps) == len(pmtPackets) {
+		var pat *repairerUnit
+		for i, q := range rp.queue {
+			if q.p.Header.PID != PIDPAT {
+				continue
+			}
+			if pat == nil {
+				pat = q.u
+			} else if q.u != pat {
+				break
+			}
+			idx = i + 1
+		}
+	}
+	q := make([]*repairerPacket, 0, len(ps)+len(rp.queue))
+	q = append(q, rp.queue[:idx]...)
+	for _, p := range ps {
+		p.Offset = -1
+		if idx < len(rp.queue) {
+			p.Offset = rp.queue[idx].p.Offset
+		}
+		if p.Header.PayloadUnitStartIndicator {
+			rp.event(RepairActionTableRegenerated, p, "missing table generated from probed streams")
+		}
+		q = append(q, &repairerPacket{p: p})
+	}
+	rp.queue = append(q, rp.queue[idx:]...)
+	return nil
+}
+
+// flush writes the queued packets whose payload unit is complete.
+func (rp *repairing) flush() error {
+	var idx int
+	for ; idx < len(rp.queue); idx++ {
+		q := rp.queue[idx]
+		switch {
+		case q.u == nil:
+			if err := rp.write(q.p); err != nil {
+				return err
+			}
+		case !q.u.complete:
+			rp.queue = rp.queue[idx:]
+			return nil
+		case q.u.drop:
+		case q.u.replaced:
+			if q.p != q.u.packets[0] {
+				continue
+			}
+			if len(q.u.replacement) == 0 {
+				if q.u.replacement = rp.lastGood[q.p.Header.PID]; len(q.u.replacement) == 0 {
+					rp.event(RepairActionIncompleteUnit, q.p, "corrupt table dropped")
+					continue
+				}
+				rp.event(RepairActionTableRegenerated, q.p, "corrupt table replaced with a later good or generated version")
+			}
+			// Replacement packets take the place of the corrupt ones in the continuity
+			for i, p := range q.u.replacement {
+				h := *p.Header
+				h.ContinuityCounter = (q.p.Header.ContinuityCounter + uint8(i)) & 0xf
+				if err := rp.write(&Packet{AdaptationField: p.AdaptationField, Header: &h, Offset: q.p.Offset, Payload: p.Payload}); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := rp.write(q.p); err != nil {
+				return err
+			}
+		}
+	}
+	rp.queue = nil
+	return nil
+}
+
+func (rp *repairing) write(p *Packet) error {
+	// Renumber continuity counters
+	if pid := p.Header.PID; pid != PIDNull {
+		c, ok := rp.ccs[pid]
+		if !ok || (p.Header.HasAdaptationField && p.AdaptationField.DiscontinuityIndicator) {
+			c = &repairerCC{c: newWrappingCounter(0b1111)} // CC is 4 bits.
+			c.c.value = int(p.Header.ContinuityCounter)
+			rp.ccs[pid] = c
+		} else {
+			// Continuity counter is not incremented by packets without payload
+			if p.Header.HasPayload {
+				c.c.inc()
+			}
+			shift := (uint8(c.c.get()) - p.Header.ContinuityCounter) & 0xf
+			if shift != c.shift {
+				c.shift = shift
+				if shift > 0 {
+					rp.event(RepairActionContinuityCounter, p, fmt.Sprintf("continuity counters shifted by %d", shift))
+				}
+			}
+			p.Header.ContinuityCounter = uint8(c.c.get())
+		}
+	}
+
+	if _, err := writePacket(rp.r.w, p, MpegTsPacketSize); err != nil {
+		return fmt.Errorf("writing packet failed: %w", err)
+	}
+	return nil
+}
+
+// repairerSectionsComplete checks whether the sections of a payload unit are complete, which is
+// the case once stuffing bytes follow them.
+func repairerSectionsComplete(ps []*Packet) bool {
+	var b []byte
+	for _, p := range ps {
+		b = append(b, p.Payload...)
+	}
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return false
+	}
+	for b = b[1+int(b[0]):]; len(b) > 0 && b[0] != byte(PSITableIDNull); {
+		if len(b) < 3 || len(b) < 3+(int(b[1]&0xf)<<8|int(b[2])) {
+			return false
+		}
+		b = b[3+(int(b[1]&0xf)<<8|int(b[2])):]
+	}
+	return len(b) > 0
+}
+
+// repairerStreamType guesses the stream type of a PES from its stream ID and the start of its
+// data.
+func repairerStreamType(b []byte) (StreamType, bool) {
+	if !isPESPayload(b) || len(b) < 9 || !hasPESOptionalHeader(b[3]) || len(b) < 9+int(b[8])+4 {
+		return 0, false
+	}
+	id := b[3]
+	d := b[9+int(b[8]):]
+	switch {
+	case id >= 0xe0 && id <= 0xef:
+		// Look for the first start code
+		i := bytes.Index(d, []byte{0x0, 0x0, 0x1})
+		if i < 0 || i+3 >= len(d) {
+			return 0, false
+		}
+		switch v := d[i+3]; {
+		case v == 0x0 || v == 0xb3 || v == 0xb5 || v == 0xb8:
+			return StreamTypeMPEG2Video, true
+		case v == 0x26 || v == 0x28 || v == 0x40 || v == 0x42 || v == 0x44 || v == 0x46:
+			return StreamTypeH265Video, true
+		case v&0x80 == 0 && v&0x1f >= 1 && v&0x1f <= 9:
+			return StreamTypeH264Video, true
+		}
+	case id >= 0xc0 && id <= 0xdf:
+		switch {
+		case d[0] == 0xff && d[1]&0xf6 == 0xf0:
+			return StreamTypeAACAudio, true
+		case d[0] == 0x56 && d[1]&0xe0 == 0xe0:
+			return StreamTypeAACLATMAudio, true
+		case d[0] == 0xff && d[1]&0xe0 == 0xe0:
+			return StreamTypeMPEG1Audio, true
+		}
+	case id == 0xbd:
+		switch {
+		case d[0] == 0xb && d[1] == 0x77 && len(d) >= 6 && d[5]>>3 > 10:
+			return StreamTypeEAC3Audio, true
+		case d[0] == 0xb && d[1] == 0x77:
+			return StreamTypeAC3Audio, true
+		case d[0] == 0x7f && d[1] == 0xfe && d[2] == 0x80 && d[3] == 0x1:
+			return StreamTypeDTSAudio, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,133 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// repairerPacketOffsets returns the offsets of the packets of a PID.
+func repairerPacketOffsets(b []byte, pid uint16) (o []int) {
+	for off := 0; off+MpegTsPacketSize <= len(b); off += MpegTsPacketSize {
+		if uint16(b[off+1]&0x1f)<<8|uint16(b[off+2]) == pid {
+			o = append(o, off)
+		}
+	}
+	return
+}
+
+func TestRepairer(t *testing.T) {
+	ptss := func(skip ...int) (o []int64) {
+		for idx := 0; idx < 20; idx++ {
+			var skipped bool
+			for _, v := range skip {
+				skipped = skipped || v == idx
+			}
+			if !skipped {
+				o = append(o, int64(idx+1)*9000)
+			}
+		}
+		return
+	}
+
+	// Nothing to repair
+	b := testProgramBytes(t, testProgram{audioPID: 0x101, count: 20, interval: 9000, rap: 1, videoPID: 0x100})
+	buf := &bytes.Buffer{}
+	r, err := NewRepairer(buf).Repair(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, b, buf.Bytes())
+	assert.Empty(t, r.Events)
+
+	// Damage stream
+	video := repairerPacketOffsets(b, 0x100)
+	audio := repairerPacketOffsets(b, 0x101)
+	pmt := repairerPacketOffsets(b, 0x1000)
+	c := append([]byte{}, b...)
+
+	// Corrupt second PMT
+	c[pmt[1]+10] ^= 0xff
+
+	// Transport error in video PES 3
+	c[video[2*3+1]+1] |= 0x80
+
+	// Duplicate audio packet
+	dup := append([]byte{}, c[audio[5]:audio[5]+MpegTsPacketSize]...)
+	c = append(c[:audio[5]+MpegTsPacketSize], append(dup, c[audio[5]+MpegTsPacketSize:]...)...)
+
+	// Truncated packet in video PES 10, the next audio packet is lost as well
+	o := video[2*10+1] + MpegTsPacketSize
+	c = append(c[:o+50], c[o+100:]...)
+
+	buf.Reset()
+	r, err = NewRepairer(buf, RepairerOptProbeSize(10)).Repair(context.Background(), bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Counters[RepairActionTransportError])
+	assert.Equal(t, 1, r.Counters[RepairActionSyncLoss])
+	assert.Equal(t, int64(MpegTsPacketSize-50), r.SkippedBytes)
+	assert.Equal(t, 1, r.Counters[RepairActionDuplicatePacket])
+	assert.Equal(t, 1, r.Counters[RepairActionTableRegenerated])
+	assert.Equal(t, 2, r.Counters[RepairActionIncompleteUnit])
+	assert.Equal(t, 3, r.Counters[RepairActionContinuityCounter])
+
+	// Check output
+	res := testDemux(t, buf.Bytes())
+	assert.Equal(t, ptss(3, 10), res.ptss[0x100])
+	assert.Equal(t, ptss(10), res.ptss[0x101])
+	assert.Equal(t, &PMTData{
+		ElementaryStreams: []*PMTElementaryStream{
+			{ElementaryPID: 0x100, StreamType: StreamTypeH264Video},
+			{ElementaryPID: 0x101, StreamType: StreamTypeAACAudio},
+		},
+		PCRPID:        0x100,
+		ProgramNumber: 1,
+	}, res.pmts[0])
+	a, _ := analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Corrupt first PMT, probed before the next one
+	c = append([]byte{}, b...)
+	c[pmt[0]+10] ^= 0xff
+	buf.Reset()
+	r, err = NewRepairer(buf, RepairerOptProbeSize(4)).Repair(context.Background(), bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Counters[RepairActionTableRegenerated])
+	assert.Equal(t, 0, r.Counters[RepairActionIncompleteUnit])
+	assert.Len(t, repairerPacketOffsets(buf.Bytes(), 0x1000), len(pmt)+1)
+	res = testDemux(t, buf.Bytes())
+	assert.Equal(t, ptss(), res.ptss[0x100])
+	a, _ = analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorCRCError])
+
+	// Missing PMTs are generated right after the first PAT
+	c = nil
+	for off := 0; off < len(b); off += MpegTsPacketSize {
+		if pid := uint16(b[off+1]&0x1f)<<8 | uint16(b[off+2]); pid != 0x1000 {
+			c = append(c, b[off:off+MpegTsPacketSize]...)
+		}
+	}
+	buf.Reset()
+	r, err = NewRepairer(buf).Repair(context.Background(), bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Counters[RepairActionTableRegenerated])
+	assert.Equal(t, []int{0}, repairerPacketOffsets(buf.Bytes(), PIDPAT)[:1])
+	assert.Equal(t, []int{MpegTsPacketSize}, repairerPacketOffsets(buf.Bytes(), 0x1000))
+	res = testDemux(t, buf.Bytes())
+	assert.Equal(t, ptss(), res.ptss[0x100])
+
+	// Missing tables
+	c = nil
+	for off := 0; off < len(b); off += MpegTsPacketSize {
+		if pid := uint16(b[off+1]&0x1f)<<8 | uint16(b[off+2]); pid != PIDPAT && pid != 0x1000 {
+			c = append(c, b[off:off+MpegTsPacketSize]...)
+		}
+	}
+	buf.Reset()
+	r, err = NewRepairer(buf).Repair(context.Background(), bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Counters[RepairActionTableRegenerated])
+	res = testDemux(t, buf.Bytes())
+	assert.Len(t, res.pmts, 1)
+	assert.Equal(t, ptss(), res.ptss[0x100])
+}
@@ -0,0 +1,324 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/icza/bitio"
+)
+
+// descriptorTagCA is the tag of the CA descriptor, which we don't parse.
+const descriptorTagCA = 0x9
+
+// Rewriter renumbers the PIDs, the program numbers and the transport stream ID of a transport
+// stream without touching payloads.
+//
+// Packet headers are rewritten as well as the PAT, the CAT and the PMTs, whose PCR PIDs and CA
+// descriptors PIDs are remapped, and the SDT and the EIT of the actual transport stream, whose
+// service IDs follow program numbers. PMTs sent before the first PAT are detected by their table
+// ID. Since their lengths don't change, sections are rewritten in place and their CRC32
+// recomputed, and packets keep their boundaries.
+type Rewriter struct {
+	optPIDs              map[uint16]uint16
+	optProgramNumbers    map[uint16]uint16
+	optTransportStreamID *uint16
+	w                    *bitio.Writer
+}
+
+type rewriting struct {
+	dmx      *Demuxer
+	open     map[*Packet]int // Number of incomplete sections each queued packet belongs to
+	patFound bool
+	pmtPIDs  map[uint16]bool
+	queue    []*Packet
+	r        *Rewriter
+	sections map[uint16]*rewriterSection // Incomplete section per PID
+}
+
+// rewriterSection represents a section whose bytes may be spread across packets.
+type rewriterSection struct {
+	b       []byte
+	chunks  [][]byte // Parts of the packets payloads holding the section
+	packets []*Packet
+}
+
+// RewriterOptPID returns the option to renumber a PID.
+func RewriterOptPID(from, to uint16) func(*Rewriter) {
+	return func(r *Rewriter) {
+		r.optPIDs[from] = to
+	}
+}
+
+// RewriterOptProgramNumber returns the option to renumber a program.
+func RewriterOptProgramNumber(from, to uint16) func(*Rewriter) {
+	return func(r *Rewriter) {
+		r.optProgramNumbers[from] = to
+	}
+}
+
+// RewriterOptTransportStreamID returns the option to set the transport stream ID.
+func RewriterOptTransportStreamID(id uint16) func(*Rewriter) {
+	return func(r *Rewriter) {
+		r.optTransportStreamID = &id
+	}
+}
+
+// NewRewriter creates a new rewriter writing 188 bytes packets.
+func NewRewriter(w io.Writer, opts ...func(*Rewriter)) *Rewriter {
+	r := &Rewriter{
+		optPIDs:           make(map[uint16]uint16),
+		optProgramNumbers: make(map[uint16]uint16),
+		w:                 bitio.NewWriter(w),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Rewrite rewrites the packets read from rd.
+func (r *Rewriter) Rewrite(ctx context.Context, rd io.Reader, opts ...func(*Demuxer)) error {
+	rw := &rewriting{
+		dmx:      NewDemuxer(ctx, rd, opts...),
+		open:     make(map[*Packet]int),
+		pmtPIDs:  make(map[uint16]bool),
+		r:        r,
+		sections: make(map[uint16]*rewriterSection),
+	}
+	return rw.rewrite()
+}
+
+func (rw *rewriting) rewrite() error {
+	for {
+		// Get next packet
+		p, err := rw.dmx.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// PMTs may be sent before the first PAT, in which case their PID is not known yet
+		pid := p.Header.PID
+		if !rw.patFound && p.Header.PayloadUnitStartIndicator && p.Header.HasPayload &&
+			len(p.Payload) > 1+int(p.Payload[0]) && PSITableID(p.Payload[1+int(p.Payload[0])]) == PSITableIDPMT {
+			rw.pmtPIDs[pid] = true
+		}
+
+		// Rewrite tables
+		if p.Header.HasPayload && (pid == PIDPAT || pid == PIDCAT || pid == pidSDT || pid == pidEIT || rw.pmtPIDs[pid]) {
+			rw.processPayload(p)
+		}
+
+		// Rewrite header
+		if v, ok := rw.r.optPIDs[pid]; ok {
+			p.Header.PID = v
+		}
+
+		// Write packets whose sections are complete
+		rw.queue = append(rw.queue, p)
+		if err = rw.flush(); err != nil {
+			return err
+		}
+	}
+
+	// Incomplete sections are written untouched
+	for _, s := range rw.sections {
+		rw.release(s)
+	}
+	if err := rw.flush(); err != nil {
+		return err
+	}
+	return rw.r.w.Close()
+}
+
+func (rw *rewriting) flush() error {
+	var idx int
+	for ; idx < len(rw.queue) && rw.open[rw.queue[idx]] == 0; idx++ {
+		if _, err := writePacket(rw.r.w, rw.queue[idx], MpegTsPacketSize); err != nil {
+			return fmt.Errorf("writing packet failed: %w", err)
+		}
+	}
+	rw.queue = rw.queue[idx:]
+	return nil
+}
+
+// processPayload splits the payload of a PSI packet into sections.
+func (rw *rewriting) processPayload(p *Packet) {
+	pid := p.Header.PID
+	b := p.Payload
+	if p.Header.PayloadUnitStartIndicator {
+		// The pointer field gives the end of the previous section
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return
+		}
+		if s, ok := rw.sections[pid]; ok {
+			rw.processSectionBytes(pid, p, b[1:1+int(b[0])])
+			if rw.sections[pid] == s {
+				rw.release(s)
+				delete(rw.sections, pid)
+			}
+		}
+		b = b[1+int(b[0]):]
+	} else if _, ok := rw.sections[pid]; !ok {
+		return
+	}
+	rw.processSectionBytes(pid, p, b)
+}
+
+func (rw *rewriting) processSectionBytes(pid uint16, p *Packet, b []byte) {
+	for len(b) > 0 {
+		// Get section
+		s, ok := rw.sections[pid]
+		if !ok {
+			// Stuffing
+			if b[0] == byte(PSITableIDNull) {
+				return
+			}
+			s = &rewriterSection{}
+			rw.sections[pid] = s
+		}
+
+		// Append bytes, the section length being known once its header is complete
+		n := 3 - len(s.b)
+		if len(s.b) >= 3 {
+			n = rewriterSectionLength(s.b) - len(s.b)
+		}
+		if n > len(b) {
+			n = len(b)
+		}
+		s.b = append(s.b, b[:n]...)
+		s.chunks = append(s.chunks, b[:n])
+		if len(s.packets) == 0 || s.packets[len(s.packets)-1] != p {
+			s.packets = append(s.packets, p)
+			rw.open[p]++
+		}
+		b = b[n:]
+
+		// Section is complete
+		if len(s.b) >= 3 && len(s.b) == rewriterSectionLength(s.b) {
+			rw.rewriteSection(pid, s)
+			rw.release(s)
+			delete(rw.sections, pid)
+		}
+	}
+}
+
+func rewriterSectionLength(b []byte) int {
+	return 3 + (int(b[1]&0xf)<<8 | int(b[2]))
+}
+
+func (rw *rewriting) release(s *rewriterSection) {
+	for _, p := range s.packets {
+		if rw.open[p]--; rw.open[p] <= 0 {
+			delete(rw.open, p)
+		}
+	}
+}
+
+func (rw *rewriting) rewriteSection(pid uint16, s *rewriterSection) {
+	// Only long sections are rewritten
+	b := append([]byte(nil), s.b...)
+	if len(b) < 12 || b[1]&0x80 == 0 {
+		return
+	}
+	end := len(b) - 4
+
+	switch {
+	case pid == PIDPAT && PSITableID(b[0]) == PSITableIDPAT:
+		rw.patFound = true
+		rw.rewriteTransportStreamID(b[3:])
+		for idx := 8; idx+4 <= end; idx += 4 {
+			// Program number 0 points to the NIT
+			if n := binary.BigEndian.Uint16(b[idx:]); n > 0 {
+				rw.pmtPIDs[uint16(b[idx+2]&0x1f)<<8|uint16(b[idx+3])] = true
+				rw.rewriteProgramNumber(b[idx:])
+			}
+			rw.rewritePID(b[idx+2:])
+		}
+	case pid == PIDCAT && PSITableID(b[0]) == psiTableIDCAT:
+		rw.rewriteDescriptors(b[8:end])
+	case pid == pidSDT && PSITableID(b[0]) == PSITableIDSDTVariant1:
+		// Services start after the original network ID
+		rw.rewriteTransportStreamID(b[3:])
+		for idx := 11; idx+5 <= end; {
+			rw.rewriteProgramNumber(b[idx:])
+			idx += 5 + int(binary.BigEndian.Uint16(b[idx+3:])&0xfff)
+		}
+	case pid == pidEIT && rewriterActualEIT(PSITableID(b[0])) && len(b) >= 18:
+		rw.rewriteProgramNumber(b[3:])
+		rw.rewriteTransportStreamID(b[8:])
+	case rw.pmtPIDs[pid] && PSITableID(b[0]) == PSITableIDPMT:
+		rw.rewriteProgramNumber(b[3:])
+		rw.rewritePID(b[8:])
+		idx := 12 + int(binary.BigEndian.Uint16(b[10:])&0xfff)
+		if idx > end {
+			return
+		}
+		rw.rewriteDescriptors(b[12:idx])
+		for idx+5 <= end {
+			rw.rewritePID(b[idx+1:])
+			l := int(binary.BigEndian.Uint16(b[idx+3:]) & 0xfff)
+			if idx+5+l > end {
+				return
+			}
+			rw.rewriteDescriptors(b[idx+5 : idx+5+l])
+			idx += 5 + l
+		}
+	default:
+		return
+	}
+	if bytes.Equal(b, s.b) {
+		return
+	}
+
+	// Update CRC32
+	cw := NewCRC32Writer(&bytes.Buffer{})
+	_, _ = cw.Write(b[:end])
+	binary.BigEndian.PutUint32(b[end:], cw.CRC32())
+
+	// Write the section back into the packets
+	for _, c := range s.chunks {
+		b = b[copy(c, b):]
+	}
+}
+
+// rewriteDescriptors remaps the PIDs of CA descriptors.
+func (rw *rewriting) rewriteDescriptors(b []byte) {
+	for len(b) >= 2 && len(b) >= 2+int(b[1]) {
+		if b[0] == descriptorTagCA && b[1] >= 4 {
+			rw.rewritePID(b[4:])
+		}
+		b = b[2+int(b[1]):]
+	}
+}
+
+// rewritePID remaps the 13 bits PID starting a 2 bytes field.
+func (rw *rewriting) rewritePID(b []byte) {
+	if v, ok := rw.r.optPIDs[uint16(b[0]&0x1f)<<8|uint16(b[1])]; ok {
+		b[0] = b[0]&0xe0 | uint8(v>>8)&0x1f
+		b[1] = uint8(v)
+	}
+}
+
+// rewriterActualEIT returns whether an EIT table ID describes the actual transport stream.
+func rewriterActualEIT(t PSITableID) bool {
+	return t == PSITableIDEITStart || (t >= 0x50 && t <= 0x5f)
+}
+
+func (rw *rewriting) rewriteTransportStreamID(b []byte) {
+	if rw.r.optTransportStreamID != nil {
+		binary.BigEndian.PutUint16(b, *rw.r.optTransportStreamID)
+	}
+}
+
+func (rw *rewriting) rewriteProgramNumber(b []byte) {
+	if v, ok := rw.r.optProgramNumbers[binary.BigEndian.Uint16(b)]; ok {
+		binary.BigEndian.PutUint16(b, v)
+	}
+}
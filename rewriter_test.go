@@ -0,0 +1,200 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/icza/bitio"
+	"github.com/stretchr/testify/assert"
+)
+
+// rewriterPSIBytes returns the packets of a PSI section, which may span several packets.
+func rewriterPSIBytes(t *testing.T, pid uint16, s *PSISection) []byte {
+	payload := &bytes.Buffer{}
+	err := writePSIData(bitio.NewWriter(payload), &PSIData{Sections: []*PSISection{s}})
+	assert.NoError(t, err)
+	return rewriterPayloadBytes(t, pid, payload.Bytes())
+}
+
+// rewriterPayloadBytes returns the packets of a PSI payload starting with its pointer field.
+func rewriterPayloadBytes(t *testing.T, pid uint16, b []byte) []byte {
+	buf := &bytes.Buffer{}
+	w := bitio.NewWriter(buf)
+	for cc := 0; len(b) > 0; cc++ {
+		n := MpegTsPacketSize - 4
+		if n > len(b) {
+			n = len(b)
+		}
+		_, err := writePacket(w, &Packet{
+			Header:  &PacketHeader{ContinuityCounter: uint8(cc), HasPayload: true, PayloadUnitStartIndicator: cc == 0, PID: pid},
+			Payload: b[:n],
+		}, MpegTsPacketSize)
+		assert.NoError(t, err)
+		b = b[n:]
+	}
+	return buf.Bytes()
+}
+
+func TestRewriter(t *testing.T) {
+	// Nothing to rewrite
+	b := hlsBytes(t)
+	buf := &bytes.Buffer{}
+	err := NewRewriter(buf).Rewrite(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, b, buf.Bytes())
+
+	// PAT and PMT spanning several packets
+	pat := &PATData{
+		Programs: []*PATProgram{
+			{ProgramMapID: 0x10, ProgramNumber: 0},
+			{ProgramMapID: 0x1000, ProgramNumber: 1},
+		},
+		TransportStreamID: 1,
+	}
+	pmt := &PMTData{PCRPID: 0x100, ProgramNumber: 1}
+	for idx := 0; idx < 40; idx++ {
+		pmt.ElementaryStreams = append(pmt.ElementaryStreams, &PMTElementaryStream{ElementaryPID: 0x100 + uint16(idx), StreamType: StreamTypeH264Video})
+	}
+	b = append(rewriterPSIBytes(t, PIDPAT, &PSISection{
+		Header: &PSISectionHeader{SectionLength: calcPATSectionLength(pat), SectionSyntaxIndicator: true, TableID: PSITableIDPAT},
+		Syntax: &PSISectionSyntax{
+			Data:   &PSISectionSyntaxData{PAT: pat},
+			Header: &PSISectionSyntaxHeader{CurrentNextIndicator: true, TableIDExtension: pat.TransportStreamID},
+		},
+	}), rewriterPSIBytes(t, 0x1000, &PSISection{
+		Header: &PSISectionHeader{SectionLength: calcPMTSectionLength(pmt), SectionSyntaxIndicator: true, TableID: PSITableIDPMT},
+		Syntax: &PSISectionSyntax{
+			Data:   &PSISectionSyntaxData{PMT: pmt},
+			Header: &PSISectionSyntaxHeader{CurrentNextIndicator: true, TableIDExtension: pmt.ProgramNumber},
+		},
+	})...)
+	assert.Len(t, b, 3*MpegTsPacketSize)
+	buf.Reset()
+	err = NewRewriter(buf,
+		RewriterOptPID(0x10, 0x20),
+		RewriterOptPID(0x100, 0x200),
+		RewriterOptPID(0x1000, 0x1100),
+		RewriterOptProgramNumber(1, 5),
+		RewriterOptTransportStreamID(7),
+	).Rewrite(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, buf.Bytes(), len(b))
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()))
+	var ds []*DemuxerData
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		ds = append(ds, d)
+	}
+	assert.Len(t, ds, 2)
+	assert.Equal(t, uint16(7), ds[0].PAT.TransportStreamID)
+	assert.Equal(t, []*PATProgram{
+		{ProgramMapID: 0x20, ProgramNumber: 0},
+		{ProgramMapID: 0x1100, ProgramNumber: 5},
+	}, ds[0].PAT.Programs)
+	assert.Equal(t, uint16(0x1100), ds[1].PID)
+	assert.Equal(t, uint16(5), ds[1].PMT.ProgramNumber)
+	assert.Equal(t, uint16(0x200), ds[1].PMT.PCRPID)
+	assert.Len(t, ds[1].PMT.ElementaryStreams, 40)
+	assert.Equal(t, uint16(0x200), ds[1].PMT.ElementaryStreams[0].ElementaryPID)
+	assert.Equal(t, uint16(0x101), ds[1].PMT.ElementaryStreams[1].ElementaryPID)
+
+	// Packet headers
+	b = hlsBytes(t)
+	buf.Reset()
+	err = NewRewriter(buf, RewriterOptPID(0x100, 0x200)).Rewrite(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	dmx = NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()))
+	var count int
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if d.PES != nil && d.PID == 0x200 {
+			count++
+		}
+		if d.PMT != nil {
+			assert.Equal(t, uint16(0x200), d.PMT.PCRPID)
+		}
+	}
+	assert.Equal(t, 50, count)
+}
+
+func TestRewriterServices(t *testing.T) {
+	pat := &PATData{Programs: []*PATProgram{{ProgramMapID: 0x1000, ProgramNumber: 1}}, TransportStreamID: 1}
+	pmt := &PMTData{ElementaryStreams: []*PMTElementaryStream{{ElementaryPID: 0x100, StreamType: StreamTypeH264Video}}, PCRPID: 0x100, ProgramNumber: 1}
+	sdt := &SDTData{OriginalNetworkID: 2, Services: []*SDTDataService{{Descriptors: descriptors, ServiceID: 1}}, TransportStreamID: 1}
+	pmtBytes := rewriterPSIBytes(t, 0x1000, &PSISection{
+		Header: &PSISectionHeader{SectionLength: calcPMTSectionLength(pmt), SectionSyntaxIndicator: true, TableID: PSITableIDPMT},
+		Syntax: &PSISectionSyntax{
+			Data:   &PSISectionSyntaxData{PMT: pmt},
+			Header: &PSISectionSyntaxHeader{CurrentNextIndicator: true, TableIDExtension: pmt.ProgramNumber},
+		},
+	})
+
+	// EIT sections are not written by the muxer
+	e := eitBytes()
+	binary.BigEndian.PutUint16(e, 1) // Transport stream ID
+	eit := append([]byte{uint8(PSITableIDEITStart), 0xf0, uint8(5 + len(e) + 4), 0x0, 0x1, 0xc1, 0x0, 0x0}, e...)
+	cw := NewCRC32Writer(&bytes.Buffer{})
+	_, _ = cw.Write(eit)
+	eit = append(eit, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(eit[len(eit)-4:], cw.CRC32())
+
+	// PMT before the PAT
+	var b []byte
+	b = append(b, pmtBytes...)
+	b = append(b, rewriterPSIBytes(t, PIDPAT, &PSISection{
+		Header: &PSISectionHeader{SectionLength: calcPATSectionLength(pat), SectionSyntaxIndicator: true, TableID: PSITableIDPAT},
+		Syntax: &PSISectionSyntax{
+			Data:   &PSISectionSyntaxData{PAT: pat},
+			Header: &PSISectionSyntaxHeader{CurrentNextIndicator: true, TableIDExtension: pat.TransportStreamID},
+		},
+	})...)
+	b = append(b, pmtBytes...)
+	b = append(b, rewriterPSIBytes(t, pidSDT, &PSISection{
+		Header: &PSISectionHeader{SectionLength: calcSDTSectionLength(sdt), SectionSyntaxIndicator: true, TableID: PSITableIDSDTVariant1},
+		Syntax: &PSISectionSyntax{
+			Data:   &PSISectionSyntaxData{SDT: sdt},
+			Header: &PSISectionSyntaxHeader{CurrentNextIndicator: true, TableIDExtension: sdt.TransportStreamID},
+		},
+	})...)
+	b = append(b, rewriterPayloadBytes(t, pidEIT, append([]byte{0x0}, eit...))...)
+
+	buf := &bytes.Buffer{}
+	err := NewRewriter(buf, RewriterOptProgramNumber(1, 5), RewriterOptTransportStreamID(7)).Rewrite(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	o := buf.Bytes()
+	assert.Equal(t, o[:MpegTsPacketSize], o[2*MpegTsPacketSize:3*MpegTsPacketSize])
+	res := testDemux(t, o[MpegTsPacketSize:])
+	assert.Equal(t, uint16(5), res.programs[5].ProgramNumber)
+	assert.Equal(t, uint16(7), res.sdt.TransportStreamID)
+	assert.Equal(t, uint16(5), res.sdt.Services[0].ServiceID)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(o[3*MpegTsPacketSize:]))
+	_, err = dmx.NextData()
+	assert.NoError(t, err)
+	d, err := dmx.NextData()
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(5), d.EIT.ServiceID)
+	assert.Equal(t, uint16(7), d.EIT.TransportStreamID)
+}
+
+func TestRewriterDescriptors(t *testing.T) {
+	rw := &rewriting{r: NewRewriter(&bytes.Buffer{}, RewriterOptPID(0x50, 0x60))}
+	b := []byte{
+		0x9, 0x4, 0x1, 0x2, 0xe0, 0x50, // CA
+		0xa, 0x4, 'e', 'n', 'g', 0x0, // ISO 639
+		0x9, 0x4, 0x1, 0x2, 0xe0, 0x51, // CA
+	}
+	rw.rewriteDescriptors(b)
+	assert.Equal(t, []byte{0x9, 0x4, 0x1, 0x2, 0xe0, 0x60, 0xa, 0x4, 'e', 'n', 'g', 0x0, 0x9, 0x4, 0x1, 0x2, 0xe0, 0x51}, b)
+}
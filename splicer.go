@@ -0,0 +1,549 @@
+package astits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/icza/bitio"
+)
+
+// Splicer replaces the main program of a transport stream with avails, which are other
+// transport streams, between the out and in points signalled by SCTE-35 splice_insert commands.
+//
+// The main program is the first program of the PAT. Splice points are moved forward to the
+// first video random access point whose PTS is not before the splice time, or to the next video
+// PES once the splice countdown of the video PID has reached 0. Avails start at their first video
+// random access point, their streams are mapped on the streams of the main program having the
+// same type, the others being dropped, and their timestamps are rebased on the PTS of the out
+// point. Their PCRs are carried by the PCR PID of the main program, in packets of their own if
+// needed. The PES of the main program other than video that are in progress at the out point
+// are completed first. The PAT, the PMT and the other programs are left untouched, and the
+// continuity counters of the main program are kept continuous. The network is joined back at the in point, or at the
+// end of the break duration when auto return is set, and the rest of the avail is dropped.
+type Splicer struct {
+	avails func(s *SpliceInsert) (io.Reader, error)
+	w      *bitio.Writer
+}
+
+type splicing struct {
+	avail      *splicerAvail // Avail being inserted
+	ccs        map[uint16]*wrappingCounter
+	countdown  bool // Splice countdown of the video PID has reached 0
+	ctx        context.Context
+	dmx        *Demuxer
+	finishing  map[uint16]bool // Main program PIDs whose PES in progress at the out point are completed
+	in         *SpliceInsert
+	opts       []func(*Demuxer)
+	out        *SpliceInsert
+	pcr        *ClockReference // Last PCR of the main program
+	pcrPID     uint16
+	pids       map[uint16]bool // Main program PIDs that are replaced during breaks
+	pmt        *PMTData
+	pmtPID     uint16
+	s          *Splicer
+	scte35PIDs map[uint16]bool
+	sections   map[uint16][]byte // Incomplete SCTE-35 sections
+	videoPID   uint16
+	videoType  StreamType
+	waitPUSI   map[uint16]bool // Main program PIDs whose packets are dropped until their next PES
+}
+
+type splicerAvail struct {
+	dmx       *Demuxer
+	ended     bool
+	held      []*Packet       // Packets waiting for the PES of the main program in progress at the out point to complete
+	next      *ClockReference // PCR of the last pending packet
+	offset    *int64          // Timestamps are rebased on it
+	pending   []*Packet       // Packets waiting for the main program to reach their PCR
+	pcrPID    uint16
+	pids      map[uint16]uint16 // Avail PID --> main program PID
+	pmtPID    uint16
+	pts       int64 // PTS of the out point
+	started   map[uint16]bool
+	videoPID  uint16
+	videoType StreamType
+}
+
+// NewSplicer creates a new splicer writing 188 bytes packets. avails is called at each out
+// point and returns the avail to insert, or nil if the main program must be kept.
+func NewSplicer(w io.Writer, avails func(s *SpliceInsert) (io.Reader, error)) *Splicer {
+	return &Splicer{
+		avails: avails,
+		w:      bitio.NewWriter(w),
+	}
+}
+
+// Splice splices the packets read from r. Demuxer options apply to avails as well.
+func (s *Splicer) Splice(ctx context.Context, r io.Reader, opts ...func(*Demuxer)) error {
+	sp := &splicing{
+		ccs:        make(map[uint16]*wrappingCounter),
+		ctx:        ctx,
+		dmx:        NewDemuxer(ctx, r, opts...),
+		finishing:  make(map[uint16]bool),
+		opts:       opts,
+		pids:       make(map[uint16]bool),
+		s:          s,
+		scte35PIDs: make(map[uint16]bool),
+		sections:   make(map[uint16][]byte),
+		waitPUSI:   make(map[uint16]bool),
+	}
+	return sp.splice()
+}
+
+func (s *splicing) splice() error {
+	for {
+		// Get next packet
+		p, err := s.dmx.NextPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("fetching next packet failed: %w", err)
+		}
+
+		// Learn programs
+		s.processData(s.dmx.packetData(p))
+		if err = s.processPacket(p); err != nil {
+			return err
+		}
+	}
+	return s.s.w.Close()
+}
+
+// processData processes the data of a payload unit.
+func (s *splicing) processData(ds []*DemuxerData) {
+	for _, d := range ds {
+		switch {
+		case d.PAT != nil:
+			if s.pmtPID == 0 && len(d.PAT.Programs) > 0 {
+				s.pmtPID = d.PAT.Programs[0].ProgramMapID
+			}
+		case d.PMT != nil && d.PID == s.pmtPID:
+			s.pcrPID = d.PMT.PCRPID
+			s.pids = make(map[uint16]bool)
+			s.pmt = d.PMT
+			s.scte35PIDs = make(map[uint16]bool)
+			s.videoPID = 0
+			if d.PMT.PCRPID != PIDNull {
+				s.pids[d.PMT.PCRPID] = true
+			}
+			for _, es := range d.PMT.ElementaryStreams {
+				t := elementaryStreamType(es)
+				switch {
+				case t == StreamTypeSCTE35:
+					s.scte35PIDs[es.ElementaryPID] = true
+					continue
+				case t.IsVideo() && s.videoPID == 0:
+					s.videoPID = es.ElementaryPID
+					s.videoType = t
+				}
+				s.pids[es.ElementaryPID] = true
+			}
+		}
+	}
+}
+
+func (s *splicing) processPacket(p *Packet) error {
+	// Parse cues
+	pid := p.Header.PID
+	if s.scte35PIDs[pid] && p.Header.HasPayload {
+		s.processCue(p)
+	}
+
+	// Keep track of PCRs
+	hasPCR := p.Header.HasAdaptationField && p.AdaptationField.HasPCR
+	if pid == s.pcrPID && hasPCR {
+		s.pcr = p.AdaptationField.PCR
+	}
+
+	// Splice
+	if pid == s.videoPID && p.Header.PayloadUnitStartIndicator {
+		pts, rap := splicerPESStart(s.videoType, p)
+		switch {
+		case s.avail == nil && s.out != nil && s.reached(s.out, pts, rap):
+			if err := s.startBreak(*pts); err != nil {
+				return err
+			}
+		case s.avail != nil && s.in != nil && s.reached(s.in, pts, rap):
+			s.endBreak()
+		}
+	}
+
+	// The splice point follows the packet whose splice countdown reaches 0
+	if pid == s.videoPID && p.Header.HasAdaptationField && p.AdaptationField.HasSplicingCountdown &&
+		int8(p.AdaptationField.SpliceCountdown) == 0 {
+		s.countdown = s.avail == nil && s.out != nil || s.avail != nil && s.in != nil
+	}
+
+	if s.avail != nil {
+		// Avail packets are inserted as the main program moves forward
+		if pid == s.pcrPID && hasPCR {
+			if err := s.avail.writeUntil(s, p.AdaptationField.PCR); err != nil {
+				return err
+			}
+		}
+		if s.pids[pid] {
+			if p.Header.PayloadUnitStartIndicator && s.finishing[pid] {
+				delete(s.finishing, pid)
+				return s.avail.writeHeld(s, pid)
+			}
+			if !s.finishing[pid] || !p.Header.HasPayload {
+				return nil
+			}
+
+			// PCRs are carried by the avail
+			if hasPCR && pid == s.pcrPID {
+				p.AdaptationField.HasPCR = false
+				p.AdaptationField.StuffingLength += pcrBytesSize
+			}
+		}
+	} else if s.waitPUSI[pid] && p.Header.HasPayload {
+		// Packets without payload, such as PCR only packets, don't need to wait
+		if !p.Header.PayloadUnitStartIndicator {
+			return nil
+		}
+		delete(s.waitPUSI, pid)
+	}
+	return s.write(p)
+}
+
+// reached checks whether a video PES is the splice point of a splice insert.
+func (s *splicing) reached(si *SpliceInsert, pts *int64, rap bool) bool {
+	if pts == nil {
+		return false
+	}
+	return s.countdown || rap && (si.PTS == nil || clockReferenceBaseDiff(*pts, si.PTS.Base) >= 0)
+}
+
+func (s *splicing) processCue(p *Packet) {
+	pid := p.Header.PID
+	b := p.Payload
+	if p.Header.PayloadUnitStartIndicator {
+		// The pointer field gives the end of the previous section
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			delete(s.sections, pid)
+			return
+		}
+		if _, ok := s.sections[pid]; ok {
+			s.processCueBytes(pid, b[1:1+int(b[0])])
+		}
+		delete(s.sections, pid)
+		b = b[1+int(b[0]):]
+	} else if _, ok := s.sections[pid]; !ok {
+		return
+	}
+	s.processCueBytes(pid, b)
+}
+
+func (s *splicing) processCueBytes(pid uint16, b []byte) {
+	buf := append(s.sections[pid], b...)
+	for len(buf) > 0 && buf[0] != byte(PSITableIDNull) {
+		// Section is incomplete
+		if len(buf) < 3 || len(buf) < 3+(int(buf[1]&0xf)<<8|int(buf[2])) {
+			s.sections[pid] = buf
+			return
+		}
+		l := 3 + (int(buf[1]&0xf)<<8 | int(buf[2]))
+
+		// Invalid sections are skipped
+		if si, err := parseSpliceInsertSection(buf[:l]); err == nil && si != nil {
+			s.processSpliceInsert(si)
+		}
+		buf = buf[l:]
+	}
+	delete(s.sections, pid)
+}
+
+func (s *splicing) processSpliceInsert(si *SpliceInsert) {
+	switch {
+	case si.IsCancel:
+		if s.out != nil && s.out.EventID == si.EventID {
+			s.out = nil
+		}
+	case si.IsOutOfNetwork:
+		if s.avail == nil {
+			s.out = si
+		}
+	case s.avail != nil:
+		s.in = si
+	}
+}
+
+func (s *splicing) startBreak(pts int64) error {
+	// Get avail
+	out := s.out
+	s.countdown = false
+	s.out = nil
+	r, err := s.s.avails(out)
+	if err != nil {
+		return fmt.Errorf("getting avail failed: %w", err)
+	}
+	if r == nil {
+		return nil
+	}
+	s.avail = &splicerAvail{
+		dmx:     NewDemuxer(s.ctx, r, s.opts...),
+		pids:    make(map[uint16]uint16),
+		pts:     pts,
+		started: make(map[uint16]bool),
+	}
+
+	// PES in progress are completed
+	for pid := range s.pids {
+		if pid != s.videoPID {
+			s.finishing[pid] = true
+		}
+	}
+
+	// Return to the network at the end of the break
+	s.in = nil
+	if out.AutoReturn && out.BreakDuration != nil {
+		s.in = &SpliceInsert{EventID: out.EventID, PTS: newClockReference(rebaseTimestamp(pts, -out.BreakDuration.Base), 0)}
+	}
+
+	// Insert avail packets up to the current time
+	if s.pcr != nil {
+		return s.avail.writeUntil(s, s.pcr)
+	}
+	return nil
+}
+
+func (s *splicing) endBreak() {
+	s.avail = nil
+	s.countdown = false
+	s.finishing = make(map[uint16]bool)
+	s.in = nil
+
+	// Video starts with the current PES
+	for pid := range s.pids {
+		if pid != s.videoPID {
+			s.waitPUSI[pid] = true
+		}
+	}
+}
+
+func (s *splicing) write(p *Packet) error {
+	// Keep continuity counters of the main program continuous
+	if pid := p.Header.PID; s.pids[pid] {
+		if cc, ok := s.ccs[pid]; !ok {
+			v := newWrappingCounter(0b1111) // CC is 4 bits.
+			v.value = int(p.Header.ContinuityCounter)
+			s.ccs[pid] = &v
+		} else {
+			// Continuity counter is not incremented by packets without payload
+			if p.Header.HasPayload {
+				cc.inc()
+			}
+			p.Header.ContinuityCounter = uint8(cc.get())
+		}
+	}
+
+	if _, err := writePacket(s.s.w, p, MpegTsPacketSize); err != nil {
+		return fmt.Errorf("writing packet failed: %w", err)
+	}
+	return nil
+}
+
+// writeUntil writes the avail packets whose PCR is not after the PCR of the main program.
+func (a *splicerAvail) writeUntil(s *splicing, pcr *ClockReference) error {
+	for {
+		// Read packets until the next PCR
+		for !a.ended && a.next == nil {
+			if err := a.read(s); err != nil {
+				return err
+			}
+		}
+		if len(a.pending) == 0 || (a.next != nil && clockReferenceBaseDiff(a.next.Base, pcr.Base) > 0) {
+			return nil
+		}
+
+		// Write packets
+		for _, p := range a.pending {
+			if s.finishing[p.Header.PID] && p.Header.HasPayload {
+				a.held = append(a.held, p)
+				continue
+			}
+			if err := s.write(p); err != nil {
+				return err
+			}
+		}
+		a.next = nil
+		a.pending = nil
+	}
+}
+
+// writeHeld writes the packets held while the PES of the main program in progress at the out
+// point was completed.
+func (a *splicerAvail) writeHeld(s *splicing, pid uint16) error {
+	var held []*Packet
+	for _, p := range a.held {
+		if p.Header.PID != pid {
+			held = append(held, p)
+			continue
+		}
+		if err := s.write(p); err != nil {
+			return err
+		}
+	}
+	a.held = held
+	return nil
+}
+
+func (a *splicerAvail) read(s *splicing) error {
+	// Get next packet
+	p, err := a.dmx.NextPacket()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			a.ended = true
+			return nil
+		}
+		return fmt.Errorf("fetching next avail packet failed: %w", err)
+	}
+
+	// Learn programs
+	a.processData(s, a.dmx.packetData(p))
+
+	// Only streams mapped on the main program and PCRs are kept
+	pid := p.Header.PID
+	mpid, mapped := a.pids[pid]
+	hasPCR := pid == a.pcrPID && p.Header.HasAdaptationField && p.AdaptationField.HasPCR
+	if !mapped && !hasPCR {
+		return nil
+	}
+
+	// Avails start at their first video random access point
+	if a.offset == nil {
+		if !mapped || a.videoPID > 0 && pid != a.videoPID || !p.Header.PayloadUnitStartIndicator {
+			return nil
+		}
+		pts, rap := splicerPESStart(a.videoType, p)
+		if pts == nil || a.videoPID > 0 && !rap {
+			return nil
+		}
+		v := *pts - a.pts
+		a.offset = &v
+	}
+
+	// PCRs are only carried by the PCR PID of the main program, in packets of their own when
+	// the avail carries them on another PID
+	if p.Header.HasAdaptationField && p.AdaptationField.HasPCR {
+		pcr := newClockReference(rebaseTimestamp(p.AdaptationField.PCR.Base, *a.offset), p.AdaptationField.PCR.Extension)
+		keep := hasPCR && mapped && mpid == s.pcrPID
+		if hasPCR {
+			a.next = pcr
+			if !keep && s.pcrPID != PIDNull {
+				a.pending = append(a.pending, newSplicerPCRPacket(s.pcrPID, pcr))
+			}
+		}
+		if keep {
+			p.AdaptationField.PCR = pcr
+		} else {
+			p.AdaptationField.HasPCR = false
+			p.AdaptationField.StuffingLength += pcrBytesSize
+		}
+	}
+	if !mapped {
+		return nil
+	}
+
+	// Other streams start with their next PES
+	if !a.started[pid] {
+		if !p.Header.PayloadUnitStartIndicator {
+			return nil
+		}
+		a.started[pid] = true
+	}
+
+	// Rebase timestamps
+	if p.Header.PayloadUnitStartIndicator {
+		rebasePESTimestamps(p.Payload, *a.offset)
+	}
+	if p.Header.HasAdaptationField && p.AdaptationField.HasOPCR {
+		p.AdaptationField.OPCR = newClockReference(rebaseTimestamp(p.AdaptationField.OPCR.Base, *a.offset), p.AdaptationField.OPCR.Extension)
+	}
+
+	p.Header.PID = mpid
+	a.pending = append(a.pending, p)
+	return nil
+}
+
+// newSplicerPCRPacket creates a packet only holding a PCR.
+func newSplicerPCRPacket(pid uint16, pcr *ClockReference) *Packet {
+	af := newStuffingAdaptationField(MpegTsPacketSize - 1 - mpegTsPacketHeaderSize)
+	af.HasPCR = true
+	af.PCR = pcr
+	af.StuffingLength -= pcrBytesSize
+	return &Packet{
+		AdaptationField: af,
+		Header:          &PacketHeader{HasAdaptationField: true, PID: pid},
+	}
+}
+
+// processData processes the data of a payload unit.
+func (a *splicerAvail) processData(s *splicing, ds []*DemuxerData) {
+	for _, d := range ds {
+		switch {
+		case d.PAT != nil:
+			if a.pmtPID == 0 && len(d.PAT.Programs) > 0 {
+				a.pmtPID = d.PAT.Programs[0].ProgramMapID
+			}
+		case d.PMT != nil && d.PID == a.pmtPID && len(a.pids) == 0:
+			a.updateLayout(s, d.PMT)
+		}
+	}
+}
+
+// updateLayout maps the streams of the avail on the streams of the main program having the
+// same type, on the same PID first.
+func (a *splicerAvail) updateLayout(s *splicing, pmt *PMTData) {
+	a.pcrPID = pmt.PCRPID
+	mapped := make(map[uint16]bool)
+	match := func(es *PMTElementaryStream, samePID bool) {
+		for _, oes := range s.pmt.ElementaryStreams {
+			if mapped[oes.ElementaryPID] || s.scte35PIDs[oes.ElementaryPID] || (samePID && oes.ElementaryPID != es.ElementaryPID) ||
+				elementaryStreamType(oes) != elementaryStreamType(es) {
+				continue
+			}
+			a.pids[es.ElementaryPID] = oes.ElementaryPID
+			mapped[oes.ElementaryPID] = true
+			if oes.ElementaryPID == s.videoPID {
+				a.videoPID = es.ElementaryPID
+				a.videoType = elementaryStreamType(es)
+			}
+			return
+		}
+	}
+	for _, es := range pmt.ElementaryStreams {
+		match(es, true)
+	}
+	for _, es := range pmt.ElementaryStreams {
+		if _, ok := a.pids[es.ElementaryPID]; !ok {
+			match(es, false)
+		}
+	}
+
+	// PCRs may be carried by a PID of their own
+	if _, ok := a.pids[pmt.PCRPID]; !ok && pmt.PCRPID != PIDNull && s.pcrPID != PIDNull && !mapped[s.pcrPID] {
+		a.pids[pmt.PCRPID] = s.pcrPID
+	}
+}
+
+// splicerPESStart returns the PTS of the PES starting in a packet, and whether it is a random
+// access point.
+func splicerPESStart(t StreamType, p *Packet) (pts *int64, rap bool) {
+	b := p.Payload
+	if !isPESPayload(b) || len(b) < 9 || !hasPESOptionalHeader(b[3]) {
+		return
+	}
+	if b[7]>>6&PTSDTSIndicatorOnlyPTS > 0 && len(b) >= 14 {
+		v := decodePTSOrDTS(b[9:14])
+		pts = &v
+	}
+	rap = p.Header.HasAdaptationField && p.AdaptationField.RandomAccessIndicator
+	if !rap && len(b) >= 9+int(b[8]) {
+		rap = videoFrameType(t, b[9+int(b[8]):]) != FrameTypeUnknown
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplicer(t *testing.T) {
+	ptss := func(from, to int) (o []int64) {
+		for idx := from; idx < to; idx++ {
+			o = append(o, int64(idx+1)*9000)
+		}
+		return
+	}
+	avail := testProgramBytes(t, testProgram{audioPID: 0x201, count: 10, data: 0x3, interval: 9000, pcr: 1000000, rap: 5, videoPID: 0x200})
+
+	// Out point moved to the next random access point and auto return
+	b := testProgramBytes(t, testProgram{audioPID: 0x101, count: 20, cues: map[int][]byte{
+		2: scte35SpliceInsertBytes(&SpliceInsert{
+			AutoReturn:     true,
+			BreakDuration:  newClockReference(90000, 0),
+			EventID:        1,
+			IsOutOfNetwork: true,
+			PTS:            newClockReference(45000, 0),
+		}, 0),
+	}, data: 0x1, interval: 9000, rap: 5, videoPID: 0x100})
+	var events []*SpliceInsert
+	buf := &bytes.Buffer{}
+	err := NewSplicer(buf, func(s *SpliceInsert) (io.Reader, error) {
+		events = append(events, s)
+		return bytes.NewReader(avail), nil
+	}).Splice(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint32(1), events[0].EventID)
+	res := testDemux(t, buf.Bytes())
+	assert.Len(t, res.pmts[len(res.pmts)-1].ElementaryStreams, 3)
+	assert.Equal(t, ptss(0, 20), res.ptss[0x100])
+	assert.Equal(t, ptss(0, 20), res.ptss[0x101])
+	assert.Equal(t, append(append(bytes.Repeat([]byte{0x1}, 5), bytes.Repeat([]byte{0x3}, 10)...), bytes.Repeat([]byte{0x1}, 5)...), res.data[0x100])
+	assert.Empty(t, res.ptss[0x200])
+	a, _ := analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Main program carrying its PCRs on a PID of their own and audio PES in progress at the out
+	// point
+	b = testProgramBytes(t, testProgram{audioPID: 0x101, count: 20, cues: map[int][]byte{
+		2: scte35SpliceInsertBytes(&SpliceInsert{
+			AutoReturn:     true,
+			BreakDuration:  newClockReference(90000, 0),
+			EventID:        1,
+			IsOutOfNetwork: true,
+			PTS:            newClockReference(45000, 0),
+		}, 0),
+	}, data: 0x1, interval: 9000, pcrPID: 0x1ff, rap: 5, videoPID: 0x100})
+	var c []byte
+	var videos int
+	for off := 0; off < len(b); off += MpegTsPacketSize {
+		c = append(c, b[off:off+MpegTsPacketSize]...)
+		if pid := uint16(b[off+1]&0x1f)<<8 | uint16(b[off+2]); pid == 0x100 && b[off+1]&0x40 > 0 {
+			if videos++; videos == 6 {
+				c = append(c, append([]byte{0x47, 0x1, 0x1, 0x1f}, bytes.Repeat([]byte{0xee}, MpegTsPacketSize-4)...)...)
+			}
+		}
+	}
+	buf.Reset()
+	err = NewSplicer(buf, func(s *SpliceInsert) (io.Reader, error) {
+		return bytes.NewReader(avail), nil
+	}).Splice(context.Background(), bytes.NewReader(c))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(buf.Bytes(), bytes.Repeat([]byte{0xee}, MpegTsPacketSize-4)))
+	res = testDemux(t, buf.Bytes())
+	assert.Equal(t, ptss(0, 20), res.ptss[0x100])
+	assert.Equal(t, ptss(0, 20), res.ptss[0x101])
+	assert.Empty(t, res.pcrs[0x100])
+	// The PCR of the main program preceding the in point is still replaced by the avail, whose
+	// first PCR is the one of the out point
+	var pcrs []int64
+	for _, r := range [][2]int{{0, 6}, {5, 15}, {16, 20}} {
+		for idx := r[0]; idx < r[1]; idx++ {
+			pcrs = append(pcrs, int64(idx)*9000*300)
+		}
+	}
+	assert.Equal(t, pcrs, res.pcrs[0x1ff])
+	a, _ = analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Splice countdown and immediate in point
+	b = testProgramBytes(t, testProgram{audioPID: 0x101, count: 20, countdown: 7, cues: map[int][]byte{
+		2:  scte35SpliceInsertBytes(&SpliceInsert{EventID: 1, IsOutOfNetwork: true, PTS: newClockReference(900000, 0)}, 0),
+		12: scte35SpliceInsertBytes(&SpliceInsert{EventID: 1, IsImmediate: true}, 0),
+	}, data: 0x1, interval: 9000, rap: 5, videoPID: 0x100})
+	buf.Reset()
+	err = NewSplicer(buf, func(s *SpliceInsert) (io.Reader, error) {
+		return bytes.NewReader(avail), nil
+	}).Splice(context.Background(), bytes.NewReader(b))
+	assert.NoError(t, err)
+	res = testDemux(t, buf.Bytes())
+	assert.Equal(t, ptss(0, 20), res.ptss[0x100])
+	assert.Equal(t, append(append(bytes.Repeat([]byte{0x1}, 8), bytes.Repeat([]byte{0x3}, 7)...), bytes.Repeat([]byte{0x1}, 5)...), res.data[0x100])
+	a, _ = analyzeTR101290(t, buf.Bytes())
+	assert.Equal(t, 0, a.Counters()[TR101290IndicatorContinuityCountError])
+
+	// Cancelled and declined splices
+	for _, cues := range []map[int][]byte{
+		{
+			2: scte35SpliceInsertBytes(&SpliceInsert{EventID: 1, IsOutOfNetwork: true, PTS: newClockReference(45000, 0)}, 0),
+			3: scte35SpliceInsertBytes(&SpliceInsert{EventID: 1, IsCancel: true}, 0),
+		},
+		{2: scte35SpliceInsertBytes(&SpliceInsert{EventID: 2, IsImmediate: true, IsOutOfNetwork: true}, 0)},
+	} {
+		b = testProgramBytes(t, testProgram{audioPID: 0x101, count: 20, cues: cues, data: 0x1, interval: 9000, rap: 5, videoPID: 0x100})
+		buf.Reset()
+		err = NewSplicer(buf, func(s *SpliceInsert) (io.Reader, error) {
+			assert.Equal(t, uint32(2), s.EventID)
+			return nil, nil
+		}).Splice(context.Background(), bytes.NewReader(b))
+		assert.NoError(t, err)
+		assert.Equal(t, b, buf.Bytes())
+	}
+}
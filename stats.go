@@ -0,0 +1,308 @@
+package astits
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default stats window.
+const defaultStatsWindow = time.Second
+
+// Stats collects per PID and per program statistics of the packets and data returned by
+// a Demuxer it is attached to with DemuxerOptStats. Bitrates are measured over a sliding
+// window, timed by the PCRs of the first PCR PID unless a wall clock is provided. It is
+// safe to take snapshots while the demuxer is running.
+type Stats struct {
+	clock      *pcrClock
+	m          *sync.Mutex
+	now        time.Duration
+	nullCount  int64
+	optNow     func() time.Time
+	optWindow  time.Duration
+	packetSize int
+	packets    int64
+	pids       map[uint16]*statsPID
+	pmts       map[uint16]*PMTData // Indexed by program number
+	programs   map[uint16]uint16   // PMT PIDs indexed by program number
+	samples    []statsSample       // Total packets, over the window
+	scrambled  int64
+	start      time.Time
+	streamType map[uint16]StreamType // Indexed by elementary PID
+}
+
+type statsPID struct {
+	pesCount   int64
+	pesMaxSize int
+	pesSize    int64
+	packets    int64
+	samples    []statsSample
+	scrambled  int64
+}
+
+// statsSample is the number of packets received so far at a given time.
+type statsSample struct {
+	packets int64
+	t       time.Duration
+}
+
+// StatsSnapshot represents the statistics collected so far.
+type StatsSnapshot struct {
+	Bitrate          int64   // Over the window, in bits per second.
+	NullShare        float64 // Share of null packets, from 0 to 1.
+	Packets          int64
+	PIDs             []*PIDStats // Ordered by PID
+	Programs         []*ProgramStats
+	ScrambledPackets int64
+	Time             time.Duration // Time elapsed since the first PCR or, with a wall clock, the first packet.
+}
+
+// PIDStats represents the statistics of a PID.
+type PIDStats struct {
+	Bitrate          int64 // Over the window, in bits per second.
+	Packets          int64
+	PESAverageSize   int64
+	PESCount         int64
+	PESMaxSize       int
+	PID              uint16
+	Programs         []uint16 // Numbers of the programs the PID belongs to.
+	ScrambledPackets int64
+	ScrambledRatio   float64 // Ratio of scrambled packets, from 0 to 1.
+	Share            float64 // Share of the packets of the stream, from 0 to 1.
+	StreamType       StreamType
+}
+
+// ProgramStats represents the composition of a program, as found in the PAT and its PMT.
+type ProgramStats struct {
+	ElementaryPIDs []uint16
+	PCRPID         uint16
+	PMTPID         uint16
+	ProgramNumber  uint16
+}
+
+// StatsOptNow returns the option to time bitrates with a wall clock, which is needed for
+// live streams whose packets are demuxed as they are received.
+func StatsOptNow(now func() time.Time) func(*Stats) {
+	return func(s *Stats) {
+		s.optNow = now
+	}
+}
+
+// StatsOptWindow returns the option to set the duration of the window over which
+// bitrates are measured. Default is 1s.
+func StatsOptWindow(window time.Duration) func(*Stats) {
+	return func(s *Stats) {
+		s.optWindow = window
+	}
+}
+
+// NewStats creates a new stats collector.
+func NewStats(opts ...func(*Stats)) *Stats {
+	s := &Stats{
+		clock:      newPCRClock(),
+		m:          &sync.Mutex{},
+		optWindow:  defaultStatsWindow,
+		pids:       make(map[uint16]*statsPID),
+		pmts:       make(map[uint16]*PMTData),
+		programs:   make(map[uint16]uint16),
+		streamType: make(map[uint16]StreamType),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DemuxerOptStats returns the option to collect stats on every packet and data retrieved by
+// the demuxer. Packet stats are collected with both NextPacket and NextData, whereas PES
+// and program stats are only collected with NextData.
+func DemuxerOptStats(s *Stats) func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optStats = s
+	}
+}
+
+func (s *Stats) addPacket(p *Packet, packetSize int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.packetSize = packetSize
+
+	// Update time
+	if s.optNow != nil {
+		if s.start.IsZero() {
+			s.start = s.optNow()
+		}
+		s.now = s.optNow().Sub(s.start)
+	} else {
+		s.clock.update(p)
+		s.now = s.clock.now(p)
+	}
+
+	// Get PID
+	pid, ok := s.pids[p.Header.PID]
+	if !ok {
+		pid = &statsPID{}
+		s.pids[p.Header.PID] = pid
+	}
+
+	// Update counters
+	s.packets++
+	pid.packets++
+	if p.Header.PID == PIDNull {
+		s.nullCount++
+	}
+	if p.Header.TransportScramblingControl != ScramblingControlNotScrambled {
+		s.scrambled++
+		pid.scrambled++
+	}
+
+	// Update windows
+	s.samples = s.addSample(s.samples, s.packets)
+	pid.samples = s.addSample(pid.samples, pid.packets)
+}
+
+// addSample adds the number of packets received so far to a window and drops the samples
+// that are not needed anymore. Samples are merged below 1% of the window to bound memory.
+func (s *Stats) addSample(ss []statsSample, packets int64) []statsSample {
+	if l := len(ss); l > 1 && s.now-ss[l-2].t < s.optWindow/100 {
+		ss[l-1] = statsSample{packets: packets, t: s.now}
+	} else {
+		ss = append(ss, statsSample{packets: packets, t: s.now})
+	}
+
+	// The oldest sample kept is the last one that is before the window
+	var idx int
+	for idx < len(ss)-1 && s.now-ss[idx+1].t >= s.optWindow {
+		idx++
+	}
+	return ss[idx:]
+}
+
+// bitrate returns the bitrate over a window, based on the packet size of the demuxer and
+// on the time elapsed since the oldest sample of the window.
+func (s *Stats) bitrate(ss []statsSample) int64 {
+	if len(ss) == 0 {
+		return 0
+	}
+	start := ss[0]
+	for _, v := range ss[1:] {
+		if s.now-v.t < s.optWindow {
+			break
+		}
+		start = v
+	}
+	d := s.now - start.t
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64((ss[len(ss)-1].packets-start.packets)*int64(s.packetSize)*8) / d.Seconds())
+}
+
+func (s *Stats) addData(d *DemuxerData) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// PES
+	if d.PES != nil {
+		pid, ok := s.pids[d.PID]
+		if !ok {
+			pid = &statsPID{}
+			s.pids[d.PID] = pid
+		}
+		pid.pesCount++
+		pid.pesSize += int64(len(d.PES.Data))
+		if len(d.PES.Data) > pid.pesMaxSize {
+			pid.pesMaxSize = len(d.PES.Data)
+		}
+	}
+
+	// Programs
+	if d.PAT != nil {
+		s.programs = make(map[uint16]uint16)
+		for _, p := range d.PAT.Programs {
+			// Program number 0 is reserved to NIT
+			if p.ProgramNumber > 0 {
+				s.programs[p.ProgramNumber] = p.ProgramMapID
+			}
+		}
+	}
+	if d.PMT != nil {
+		s.pmts[d.PMT.ProgramNumber] = d.PMT
+		for _, es := range d.PMT.ElementaryStreams {
+			s.streamType[es.ElementaryPID] = es.StreamType
+		}
+	}
+}
+
+// Snapshot returns the statistics collected so far.
+func (s *Stats) Snapshot() *StatsSnapshot {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Stream
+	o := &StatsSnapshot{
+		Bitrate:          s.bitrate(s.samples),
+		Packets:          s.packets,
+		ScrambledPackets: s.scrambled,
+		Time:             s.now,
+	}
+	programs := make(map[uint16][]uint16) // Program numbers indexed by PID
+	for number, pmtPID := range s.programs {
+		p := &ProgramStats{
+			PMTPID:        pmtPID,
+			ProgramNumber: number,
+		}
+		programs[pmtPID] = append(programs[pmtPID], number)
+		if pmt, ok := s.pmts[number]; ok {
+			p.PCRPID = pmt.PCRPID
+			for _, es := range pmt.ElementaryStreams {
+				p.ElementaryPIDs = append(p.ElementaryPIDs, es.ElementaryPID)
+				programs[es.ElementaryPID] = append(programs[es.ElementaryPID], number)
+			}
+			if pmt.PCRPID != PIDNull && !uint16sContain(p.ElementaryPIDs, pmt.PCRPID) {
+				programs[pmt.PCRPID] = append(programs[pmt.PCRPID], number)
+			}
+		}
+		o.Programs = append(o.Programs, p)
+	}
+	sort.Slice(o.Programs, func(i, j int) bool { return o.Programs[i].ProgramNumber < o.Programs[j].ProgramNumber })
+	if s.packets > 0 {
+		o.NullShare = float64(s.nullCount) / float64(s.packets)
+	}
+
+	// PIDs
+	for k, v := range s.pids {
+		p := &PIDStats{
+			Bitrate:          s.bitrate(v.samples),
+			Packets:          v.packets,
+			PESCount:         v.pesCount,
+			PESMaxSize:       v.pesMaxSize,
+			PID:              k,
+			Programs:         programs[k],
+			ScrambledPackets: v.scrambled,
+			StreamType:       s.streamType[k],
+		}
+		sort.Slice(p.Programs, func(i, j int) bool { return p.Programs[i] < p.Programs[j] })
+		if v.pesCount > 0 {
+			p.PESAverageSize = v.pesSize / v.pesCount
+		}
+		if v.packets > 0 {
+			p.ScrambledRatio = float64(v.scrambled) / float64(v.packets)
+		}
+		if s.packets > 0 {
+			p.Share = float64(v.packets) / float64(s.packets)
+		}
+		o.PIDs = append(o.PIDs, p)
+	}
+	sort.Slice(o.PIDs, func(i, j int) bool { return o.PIDs[i].PID < o.PIDs[j].PID })
+	return o
+}
+
+func uint16sContain(s []uint16, v uint16) bool {
+	for _, i := range s {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	// Demux
+	s := NewStats()
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(tr101290Bytes(t, 50)), DemuxerOptStats(s))
+	for {
+		_, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+	}
+	o := s.Snapshot()
+	assert.Equal(t, int64(250), o.Packets)
+	// The last 2 packets follow the last PCR
+	assert.Equal(t, 49*40*time.Millisecond+2*8*time.Millisecond, o.Time)
+	assert.Equal(t, []*ProgramStats{{
+		ElementaryPIDs: []uint16{0x100},
+		PCRPID:         0x100,
+		PMTPID:         0x1000,
+		ProgramNumber:  1,
+	}}, o.Programs)
+	assert.Len(t, o.PIDs, 3)
+	assert.Equal(t, &PIDStats{
+		Bitrate:        3 * 25 * MpegTsPacketSize * 8,
+		Packets:        150,
+		PESAverageSize: 500,
+		PESCount:       50,
+		PESMaxSize:     500,
+		PID:            0x100,
+		Programs:       []uint16{1},
+		Share:          0.6,
+		StreamType:     StreamTypeH264Video,
+	}, o.PIDs[1])
+	assert.Equal(t, []uint16{1}, o.PIDs[2].Programs)
+	assert.Equal(t, int64(5*25*MpegTsPacketSize*8), o.Bitrate)
+
+	// Wall clock, null packets and scrambling
+	now := time.Unix(0, 0)
+	s = NewStats(StatsOptNow(func() time.Time { return now }), StatsOptWindow(time.Second))
+	for idx := 0; idx < 200; idx++ {
+		h := &PacketHeader{PID: 0x100}
+		switch idx % 4 {
+		case 0:
+			h.PID = PIDNull
+		case 1:
+			h.TransportScramblingControl = ScramblingControlScrambledWithOddKey
+		}
+		s.addPacket(&Packet{Header: h}, MpegTsPacketSize)
+		now = now.Add(10 * time.Millisecond)
+	}
+	o = s.Snapshot()
+	assert.Equal(t, 0.25, o.NullShare)
+	assert.Equal(t, int64(50), o.ScrambledPackets)
+	assert.Equal(t, int64(150), o.PIDs[0].Packets)
+	assert.InDelta(t, 1/3.0, o.PIDs[0].ScrambledRatio, 0.001)
+	assert.Nil(t, o.PIDs[0].Programs)
+	assert.InDelta(t, 100*MpegTsPacketSize*8, o.Bitrate, 2000)
+	assert.InDelta(t, 75*MpegTsPacketSize*8, o.PIDs[0].Bitrate, 2000)
+	assert.InDelta(t, 25*MpegTsPacketSize*8, o.PIDs[1].Bitrate, 2000)
+
+	// Packet size and samples spanning over more than the window
+	now = time.Unix(0, 0)
+	s = NewStats(StatsOptNow(func() time.Time { return now }), StatsOptWindow(time.Second))
+	for idx := 0; idx < 10; idx++ {
+		if idx > 0 {
+			now = now.Add(300 * time.Millisecond)
+		}
+		s.addPacket(&Packet{Header: &PacketHeader{PID: 0x100}}, 204)
+	}
+	assert.Equal(t, int64(4*204*8*10/12), s.Snapshot().Bitrate)
+}
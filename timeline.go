@@ -0,0 +1,284 @@
+package astits
+
+import (
+	"time"
+)
+
+// timelineMaxTimestampGap is the gap between 2 consecutive PTS or DTS of a program above
+// which we consider there's a discontinuity when the program has no PCR. Audio and video
+// timestamps are not interleaved as tightly as PCRs, hence a bigger value.
+const timelineMaxTimestampGap = 10 * 90000
+
+// DemuxerTimeline represents the timestamps of a PES data unwrapped on the timeline of
+// its program. Unwrapped bases are not limited to 33 bits.
+type DemuxerTimeline struct {
+	DTS *ClockReference
+
+	// Discontinuity is set when a discontinuity occurred on the timeline of the program
+	// since the previous data of the same PID.
+	Discontinuity bool
+
+	PCR           *ClockReference // Last PCR of the program.
+	ProgramNumber uint16
+	PTS           *ClockReference
+}
+
+// Timeline unwraps the 33 bits PCRs, PTSs and DTSs of a program into a monotonic 64 bits
+// timeline. PCRs are the reference of the timeline. Until a PCR is received or when the
+// program has none, timestamps themselves are used as the reference.
+//
+// When a discontinuity is either flagged or inferred from a jump of the reference, the
+// timeline continues from the last reference so that it stays monotonic.
+type Timeline struct {
+	discontinuities int
+	hasReference    bool
+	isPCRReference  bool
+	lastPCR         *ClockReference // Unwrapped
+	reference       int64           // Raw 33 bits base
+	unwrapped       int64
+}
+
+// NewTimeline creates a new timeline.
+func NewTimeline() *Timeline {
+	return &Timeline{}
+}
+
+// Discontinuities returns the number of discontinuities detected so far.
+func (t *Timeline) Discontinuities() int {
+	return t.discontinuities
+}
+
+// LastPCR returns the last unwrapped PCR.
+func (t *Timeline) LastPCR() *ClockReference {
+	return t.lastPCR
+}
+
+// UnwrapPCR unwraps a PCR and makes it the reference of the timeline. A discontinuity
+// is detected when it is flagged or when the PCR goes backward or jumps more than 1s
+// forward. It returns the unwrapped PCR and whether a discontinuity was detected.
+func (t *Timeline) UnwrapPCR(pcr *ClockReference, discontinuity bool) (*ClockReference, bool) {
+	// First reference
+	if !t.hasReference {
+		t.setReference(pcr.Base, pcr.Base, true)
+		t.lastPCR = newClockReference(t.unwrapped, pcr.Extension)
+		return t.lastPCR, false
+	}
+
+	d := clockReferenceBaseDiff(pcr.Base, t.reference)
+	if t.isPCRReference && (discontinuity || d < 0 || d > pcrMaxGap) {
+		discontinuity = true
+		d = 0
+	} else if !t.isPCRReference && discontinuity {
+		d = 0
+	}
+	if discontinuity {
+		t.discontinuities++
+	}
+
+	t.setReference(pcr.Base, t.unwrapped+d, true)
+	t.lastPCR = newClockReference(t.unwrapped, pcr.Extension)
+	return t.lastPCR, discontinuity
+}
+
+// Unwrap unwraps a PTS or a DTS based on the reference of the timeline. When the reference
+// is not a PCR, the timestamp becomes the reference if it is ahead of it. It returns the
+// unwrapped timestamp and whether a discontinuity was detected.
+func (t *Timeline) Unwrap(ts *ClockReference) (*ClockReference, bool) {
+	// First reference
+	if !t.hasReference {
+		t.setReference(ts.Base, ts.Base, false)
+		return newClockReference(t.unwrapped, ts.Extension), false
+	}
+
+	d := clockReferenceBaseDiff(ts.Base, t.reference)
+	if t.isPCRReference {
+		return newClockReference(t.unwrapped+d, ts.Extension), false
+	}
+
+	// Timestamps are the reference
+	var discontinuity bool
+	switch {
+	case d > timelineMaxTimestampGap || d < -timelineMaxTimestampGap:
+		discontinuity = true
+		t.discontinuities++
+		t.setReference(ts.Base, t.unwrapped, false)
+		d = 0
+	case d > 0:
+		t.setReference(ts.Base, t.unwrapped+d, false)
+		d = 0
+	}
+	return newClockReference(t.unwrapped+d, ts.Extension), discontinuity
+}
+
+func (t *Timeline) setReference(reference, unwrapped int64, isPCR bool) {
+	t.hasReference = true
+	t.isPCRReference = isPCR
+	t.reference = reference
+	t.unwrapped = unwrapped
+}
+
+// pcrClock derives time from the PCRs of the first PCR PID it sees and from the byte
+// offsets of packets, interpolated with the bitrate measured between the last 2 PCRs.
+type pcrClock struct {
+	bitrate  float64 // In bits per second.
+	first    time.Duration
+	hasPCR   bool
+	offset   int64
+	pid      uint16
+	t        time.Duration // Relative to the first PCR.
+	timeline *Timeline
+}
+
+func newPCRClock() *pcrClock {
+	return &pcrClock{}
+}
+
+func (c *pcrClock) update(p *Packet) {
+	if !p.Header.HasAdaptationField || !p.AdaptationField.HasPCR {
+		return
+	}
+	if !c.hasPCR {
+		c.pid = p.Header.PID
+		c.timeline = NewTimeline()
+	} else if p.Header.PID != c.pid {
+		return
+	}
+
+	pcr, _ := c.timeline.UnwrapPCR(p.AdaptationField.PCR, p.AdaptationField.DiscontinuityIndicator)
+	if !c.hasPCR {
+		c.first = pcr.Duration()
+	}
+	t := pcr.Duration() - c.first
+	if c.hasPCR && t > c.t && p.Offset > c.offset {
+		c.bitrate = float64((p.Offset-c.offset)*8) / (t - c.t).Seconds()
+	}
+	c.hasPCR = true
+	c.offset = p.Offset
+	c.t = t
+}
+
+func (c *pcrClock) now(p *Packet) time.Duration {
+	if !c.hasPCR || c.bitrate == 0 {
+		return c.t
+	}
+	return c.t + time.Duration(float64((p.Offset-c.offset)*8)/c.bitrate*float64(time.Second))
+}
+
+// DemuxerOptTimeline returns the option to unwrap the timestamps of every PES data on
+// the timeline of its program, which is then available in DemuxerData.Timeline.
+func DemuxerOptTimeline() func(*Demuxer) {
+	return func(d *Demuxer) {
+		d.optTimeline = true
+	}
+}
+
+// demuxerTimelines keeps track of the timeline of each program.
+type demuxerTimelines struct {
+	byPCRPID        map[uint16]*Timeline // Indexed by PCR PID
+	byPID           map[uint16]*Timeline // Indexed by elementary PID
+	byProgram       map[uint16]*Timeline // Indexed by program number
+	discontinuities map[uint16]int       // Indexed by elementary PID
+	pending         map[uint16]bool      // Indexed by PCR PID
+	programNumbers  map[uint16]uint16    // Indexed by elementary PID
+}
+
+func newDemuxerTimelines() *demuxerTimelines {
+	return &demuxerTimelines{
+		byPCRPID:        make(map[uint16]*Timeline),
+		byPID:           make(map[uint16]*Timeline),
+		byProgram:       make(map[uint16]*Timeline),
+		discontinuities: make(map[uint16]int),
+		pending:         make(map[uint16]bool),
+		programNumbers:  make(map[uint16]uint16),
+	}
+}
+
+// updatePMT maps the PIDs of a program to its timeline.
+func (ts *demuxerTimelines) updatePMT(pmt *PMTData) {
+	t, ok := ts.byProgram[pmt.ProgramNumber]
+	if !ok {
+		t = NewTimeline()
+		ts.byProgram[pmt.ProgramNumber] = t
+	}
+	if pmt.PCRPID != PIDNull {
+		ts.byPCRPID[pmt.PCRPID] = t
+	}
+	for _, es := range pmt.ElementaryStreams {
+		ts.byPID[es.ElementaryPID] = t
+		ts.programNumbers[es.ElementaryPID] = pmt.ProgramNumber
+	}
+}
+
+// reset restarts the timeline of each program, which is needed when the reader is moved.
+// PIDs remain mapped to their program.
+func (ts *demuxerTimelines) reset() {
+	for n, t := range ts.byProgram {
+		r := NewTimeline()
+		ts.byProgram[n] = r
+		for pid, v := range ts.byPCRPID {
+			if v == t {
+				ts.byPCRPID[pid] = r
+			}
+		}
+		for pid, v := range ts.byPID {
+			if v == t {
+				ts.byPID[pid] = r
+			}
+		}
+	}
+	ts.discontinuities = make(map[uint16]int)
+	ts.pending = make(map[uint16]bool)
+}
+
+// lastPCR returns the last unwrapped PCR of a PCR PID, if any.
+func (ts *demuxerTimelines) lastPCR(pid uint16) *ClockReference {
+	t, ok := ts.byPCRPID[pid]
+	if !ok {
+		return nil
+	}
+	return t.LastPCR()
+}
+
+// updatePacket unwraps the PCR of a packet. The discontinuity indicator applies to the
+// next PCR of the PID, which may not be in the same packet.
+func (ts *demuxerTimelines) updatePacket(p *Packet) {
+	t, ok := ts.byPCRPID[p.Header.PID]
+	if !ok || !p.Header.HasAdaptationField {
+		return
+	}
+	if p.AdaptationField.DiscontinuityIndicator {
+		ts.pending[p.Header.PID] = true
+	}
+	if p.AdaptationField.HasPCR {
+		t.UnwrapPCR(p.AdaptationField.PCR, ts.pending[p.Header.PID])
+		delete(ts.pending, p.Header.PID)
+	}
+}
+
+// updateData annotates a PES data with its unwrapped timestamps.
+func (ts *demuxerTimelines) updateData(d *DemuxerData) {
+	t, ok := ts.byPID[d.PID]
+	if !ok || d.PES == nil || d.PES.Header == nil {
+		return
+	}
+
+	d.Timeline = &DemuxerTimeline{
+		PCR:           t.LastPCR(),
+		ProgramNumber: ts.programNumbers[d.PID],
+	}
+	if h := d.PES.Header.OptionalHeader; h != nil {
+		if h.DTS != nil {
+			d.Timeline.DTS, _ = t.Unwrap(h.DTS)
+		}
+		if h.PTS != nil {
+			d.Timeline.PTS, _ = t.Unwrap(h.PTS)
+		}
+	}
+
+	// Discontinuities that occurred since the previous data of the PID, including the ones
+	// detected while unwrapping its timestamps
+	if n, ok := ts.discontinuities[d.PID]; ok && n != t.Discontinuities() {
+		d.Timeline.Discontinuity = true
+	}
+	ts.discontinuities[d.PID] = t.Discontinuities()
+}
@@ -0,0 +1,126 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimelineUnwrapPCR(t *testing.T) {
+	tl := NewTimeline()
+
+	// Wraparound
+	pcr, discontinuity := tl.UnwrapPCR(newClockReference(clockReferenceBaseModulo-45000, 1), false)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-45000, 1), pcr)
+	assert.False(t, discontinuity)
+	pcr, discontinuity = tl.UnwrapPCR(newClockReference(45000, 2), false)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+45000, 2), pcr)
+	assert.False(t, discontinuity)
+	assert.Equal(t, pcr, tl.LastPCR())
+
+	// Timestamps are unwrapped based on the PCR
+	pts, _ := tl.Unwrap(newClockReference(clockReferenceBaseModulo-9000, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-9000, 0), pts)
+	pts, _ = tl.Unwrap(newClockReference(54000, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+54000, 0), pts)
+
+	// Flagged discontinuity
+	pcr, discontinuity = tl.UnwrapPCR(newClockReference(1000, 0), true)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+45000, 0), pcr)
+	assert.True(t, discontinuity)
+	pcr, _ = tl.UnwrapPCR(newClockReference(10000, 0), false)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+54000, 0), pcr)
+
+	// Inferred discontinuities
+	pcr, discontinuity = tl.UnwrapPCR(newClockReference(5000, 0), false)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+54000, 0), pcr)
+	assert.True(t, discontinuity)
+	pcr, discontinuity = tl.UnwrapPCR(newClockReference(5000+2*90000, 0), false)
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+54000, 0), pcr)
+	assert.True(t, discontinuity)
+	assert.Equal(t, 3, tl.Discontinuities())
+}
+
+func TestTimelineUnwrap(t *testing.T) {
+	tl := NewTimeline()
+
+	// Wraparound
+	pts, discontinuity := tl.Unwrap(newClockReference(clockReferenceBaseModulo-3600, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-3600, 0), pts)
+	assert.False(t, discontinuity)
+	pts, _ = tl.Unwrap(newClockReference(0, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo, 0), pts)
+
+	// Timestamps going backward slightly
+	pts, discontinuity = tl.Unwrap(newClockReference(clockReferenceBaseModulo-7200, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo-7200, 0), pts)
+	assert.False(t, discontinuity)
+
+	// Discontinuity
+	pts, discontinuity = tl.Unwrap(newClockReference(20*90000, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo, 0), pts)
+	assert.True(t, discontinuity)
+	pts, _ = tl.Unwrap(newClockReference(21*90000, 0))
+	assert.Equal(t, newClockReference(clockReferenceBaseModulo+90000, 0), pts)
+	assert.Equal(t, 1, tl.Discontinuities())
+}
+
+func TestDemuxerTimeline(t *testing.T) {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+
+	start := int64(clockReferenceBaseModulo - 2*45000)
+	for idx := int64(0); idx < 6; idx++ {
+		af := &PacketAdaptationField{HasPCR: true, PCR: newClockReference((start+idx*45000)%clockReferenceBaseModulo, 0)}
+		// The clock goes back to 0 after a flagged discontinuity
+		if idx >= 4 {
+			af.DiscontinuityIndicator = idx == 4
+			af.PCR = newClockReference((idx-4)*45000, 0)
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: af,
+			PID:             0x100,
+			PES: &PESData{
+				Data: []byte{0x1},
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(af.PCR.Base+9000, 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptTimeline())
+	var ptss []int64
+	var discontinuities []bool
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if d.PES == nil {
+			continue
+		}
+		assert.Equal(t, uint16(1), d.Timeline.ProgramNumber)
+		ptss = append(ptss, d.Timeline.PTS.Base)
+		discontinuities = append(discontinuities, d.Timeline.Discontinuity)
+	}
+	// The packet pool drops the PES preceding the discontinuity indicator
+	assert.Equal(t, []int64{
+		start + 9000,
+		start + 45000 + 9000,
+		start + 2*45000 + 9000,
+		start + 3*45000 + 9000,
+		start + 3*45000 + 45000 + 9000,
+	}, ptss)
+	assert.Equal(t, []bool{false, false, false, true, false}, discontinuities)
+}
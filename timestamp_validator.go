@@ -0,0 +1,308 @@
+package astits
+
+import (
+	"fmt"
+	"time"
+)
+
+// timestampValidatorMaxPCRs is the number of PCRs kept per program to find the one that
+// preceded the first packet of a PES.
+const timestampValidatorMaxPCRs = 64
+
+// timestampIntervalsDetection is the number of intervals used to detect the frame
+// duration of a PID.
+const timestampIntervalsDetection = 8
+
+// Timestamp violations.
+const (
+	TimestampViolationAVOffset          TimestampViolationKind = "av_offset"
+	TimestampViolationDTSNotMonotonic   TimestampViolationKind = "dts_not_monotonic"
+	TimestampViolationExcessiveDelay    TimestampViolationKind = "excessive_delay"
+	TimestampViolationIrregularInterval TimestampViolationKind = "irregular_interval"
+	TimestampViolationNegativeDelay     TimestampViolationKind = "negative_delay"
+	TimestampViolationPTSBeforeDTS      TimestampViolationKind = "pts_before_dts"
+)
+
+// TimestampViolationKind represents a kind of timestamp violation.
+type TimestampViolationKind string
+
+// TimestampViolation represents a violation found by TimestampValidator.
+type TimestampViolation struct {
+	DTS           *ClockReference // Unwrapped, nil if the PES has none.
+	Kind          TimestampViolationKind
+	Message       string
+	Offset        int64           // Byte offset of the first packet of the PES.
+	PCR           *ClockReference // Unwrapped PCR preceding the PES, nil if unknown.
+	PID           uint16
+	ProgramNumber uint16
+	PTS           *ClockReference // Unwrapped
+}
+
+// TimestampThresholds represents the thresholds of TimestampValidator.
+type TimestampThresholds struct {
+	// AVMaxOffset is the maximum difference between the buffering delays of the audio
+	// and the video streams of a program.
+	AVMaxOffset time.Duration
+
+	// IntervalTolerance is the maximum difference between the interval of 2 consecutive
+	// decoding timestamps and the frame duration.
+	IntervalTolerance time.Duration
+
+	// MaxDelay is the maximum difference between the decoding timestamp of a PES and the
+	// PCR of its program when it is received.
+	MaxDelay time.Duration
+}
+
+// DefaultTimestampThresholds returns the default thresholds. The maximum delay is the one
+// of ISO/IEC 13818-1 T-STD buffers, still pictures aside.
+func DefaultTimestampThresholds() TimestampThresholds {
+	return TimestampThresholds{
+		AVMaxOffset:       500 * time.Millisecond,
+		IntervalTolerance: 100 * time.Microsecond,
+		MaxDelay:          time.Second,
+	}
+}
+
+// TimestampValidator checks the consistency of the PTSs and DTSs of demuxed PES data:
+// DTS monotonicity, PTS >= DTS, regularity of the intervals against the detected frame
+// duration, buffering delay against the PCR of the program and audio/video offset.
+//
+// It needs the PAT and PMT data and, to check delays and offsets, the PCRs that the
+// demuxer returns as data with DemuxerOptAdaptationFieldData. Streams that don't carry a
+// DTS have their PTS used as their DTS.
+type TimestampValidator struct {
+	optThresholds TimestampThresholds
+	pids          map[uint16]*timestampValidatorPID     // Indexed by elementary PID
+	programs      map[uint16]*timestampValidatorProgram // Indexed by program number
+	violations    []*TimestampViolation
+}
+
+type timestampValidatorPID struct {
+	delay         *time.Duration
+	frameDuration int64
+	intervals     []int64
+	lastDTS       *ClockReference
+	program       *timestampValidatorProgram
+	streamType    StreamType
+}
+
+type timestampValidatorProgram struct {
+	number   uint16
+	pcrPID   uint16
+	pcrs     []timestampValidatorPCR
+	timeline *Timeline
+	video    *timestampValidatorPID
+}
+
+type timestampValidatorPCR struct {
+	offset int64
+	pcr    *ClockReference
+}
+
+// pcr returns the last PCR received before an offset. PES data is returned once the next
+// payload unit starts, after PCRs that don't apply to it.
+func (pgm *timestampValidatorProgram) pcr(offset int64) *ClockReference {
+	for idx := len(pgm.pcrs) - 1; idx >= 0; idx-- {
+		if pgm.pcrs[idx].offset <= offset {
+			return pgm.pcrs[idx].pcr
+		}
+	}
+	return nil
+}
+
+// TimestampValidatorOptThresholds returns the option to set the thresholds.
+func TimestampValidatorOptThresholds(t TimestampThresholds) func(*TimestampValidator) {
+	return func(v *TimestampValidator) {
+		v.optThresholds = t
+	}
+}
+
+// NewTimestampValidator creates a new timestamp validator.
+func NewTimestampValidator(opts ...func(*TimestampValidator)) *TimestampValidator {
+	v := &TimestampValidator{
+		optThresholds: DefaultTimestampThresholds(),
+		pids:          make(map[uint16]*timestampValidatorPID),
+		programs:      make(map[uint16]*timestampValidatorProgram),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Validate processes a data, that must be provided in the order of the demuxer, and
+// returns the violations it triggered.
+func (v *TimestampValidator) Validate(d *DemuxerData) []*TimestampViolation {
+	v.violations = nil
+	switch {
+	case d.PMT != nil:
+		v.updatePMT(d.PMT)
+	case d.AdaptationField != nil && d.AdaptationField.HasPCR:
+		v.updatePCR(d)
+	case d.PES != nil && d.PES.Header != nil && d.PES.Header.OptionalHeader != nil:
+		v.validatePES(d)
+	}
+	return v.violations
+}
+
+func (v *TimestampValidator) updatePMT(pmt *PMTData) {
+	pgm, ok := v.programs[pmt.ProgramNumber]
+	if !ok {
+		pgm = &timestampValidatorProgram{
+			number:   pmt.ProgramNumber,
+			timeline: NewTimeline(),
+		}
+		v.programs[pmt.ProgramNumber] = pgm
+	}
+	pgm.pcrPID = pmt.PCRPID
+	pgm.video = nil
+	for _, es := range pmt.ElementaryStreams {
+		p, ok := v.pids[es.ElementaryPID]
+		if !ok || p.program != pgm {
+			p = &timestampValidatorPID{program: pgm}
+			v.pids[es.ElementaryPID] = p
+		}
+		p.streamType = elementaryStreamType(es)
+		if pgm.video == nil && p.streamType.IsVideo() {
+			pgm.video = p
+		}
+	}
+}
+
+func (v *TimestampValidator) updatePCR(d *DemuxerData) {
+	for _, pgm := range v.programs {
+		if pgm.pcrPID != d.PID {
+			continue
+		}
+		pcr, discontinuity := pgm.timeline.UnwrapPCR(d.AdaptationField.PCR, d.AdaptationField.DiscontinuityIndicator)
+		if discontinuity {
+			v.reset(pgm)
+		}
+		pgm.pcrs = append(pgm.pcrs, timestampValidatorPCR{offset: d.FirstPacket.Offset, pcr: pcr})
+		if len(pgm.pcrs) > timestampValidatorMaxPCRs {
+			pgm.pcrs = pgm.pcrs[1:]
+		}
+	}
+}
+
+// reset forgets the timestamps of a program, which are not comparable anymore after a
+// discontinuity.
+func (v *TimestampValidator) reset(pgm *timestampValidatorProgram) {
+	for _, p := range v.pids {
+		if p.program == pgm {
+			p.delay = nil
+			p.intervals = nil
+			p.lastDTS = nil
+		}
+	}
+	pgm.pcrs = nil
+}
+
+func (v *TimestampValidator) validatePES(d *DemuxerData) {
+	// Get PID
+	p, ok := v.pids[d.PID]
+	if !ok || d.PES.Header.OptionalHeader.PTS == nil {
+		return
+	}
+
+	// Unwrap
+	h := d.PES.Header.OptionalHeader
+	pts, discontinuity := p.program.timeline.Unwrap(h.PTS)
+	if discontinuity {
+		v.reset(p.program)
+	}
+	dts := pts
+	var hasDTS bool
+	if h.DTS != nil {
+		if dts, discontinuity = p.program.timeline.Unwrap(h.DTS); discontinuity {
+			v.reset(p.program)
+		}
+		hasDTS = true
+	}
+	pcr := p.program.pcr(d.FirstPacket.Offset)
+	report := func(k TimestampViolationKind, format string, args ...interface{}) {
+		vl := &TimestampViolation{
+			Kind:          k,
+			Message:       fmt.Sprintf(format, args...),
+			Offset:        d.FirstPacket.Offset,
+			PCR:           pcr,
+			PID:           d.PID,
+			ProgramNumber: p.program.number,
+			PTS:           pts,
+		}
+		if hasDTS {
+			vl.DTS = dts
+		}
+		v.violations = append(v.violations, vl)
+	}
+
+	// PTS must not be before DTS
+	if hasDTS && pts.Base < dts.Base {
+		report(TimestampViolationPTSBeforeDTS, "pts %d is before dts %d", pts.Base, dts.Base)
+	}
+
+	// DTS must increase
+	if p.lastDTS != nil {
+		interval := dts.Base - p.lastDTS.Base
+		if interval <= 0 {
+			report(TimestampViolationDTSNotMonotonic, "dts %d is not after previous dts %d", dts.Base, p.lastDTS.Base)
+		} else {
+			v.validateInterval(p, interval, report)
+		}
+	}
+	p.lastDTS = dts
+
+	// Delay
+	if pcr != nil {
+		delay := dts.Duration() - pcr.Duration()
+		p.delay = &delay
+		switch {
+		case delay < 0:
+			report(TimestampViolationNegativeDelay, "dts is %s before pcr", -delay)
+		case delay > v.optThresholds.MaxDelay:
+			report(TimestampViolationExcessiveDelay, "dts is %s after pcr", delay)
+		}
+	}
+
+	// Audio/video offset
+	if video := p.program.video; video != nil && video.delay != nil && p.delay != nil && p.streamType.IsAudio() {
+		if offset := *p.delay - *video.delay; absDuration(offset) > v.optThresholds.AVMaxOffset {
+			report(TimestampViolationAVOffset, "audio is buffered %s ahead of video", offset)
+		}
+	}
+}
+
+// validateInterval checks the interval between 2 consecutive DTSs against the frame
+// duration, which is the most frequent of the first intervals of the PID. Intervals may
+// be any multiple of the frame duration, since frames may be dropped or grouped in a PES.
+func (v *TimestampValidator) validateInterval(p *timestampValidatorPID, interval int64, report func(TimestampViolationKind, string, ...interface{})) {
+	// Detect frame duration
+	if len(p.intervals) < timestampIntervalsDetection {
+		p.intervals = append(p.intervals, interval)
+		if len(p.intervals) == timestampIntervalsDetection {
+			p.frameDuration = mostFrequentInt64(p.intervals)
+		}
+		return
+	}
+
+	// Compare
+	tolerance := v.optThresholds.IntervalTolerance.Nanoseconds() * 90000 / 1e9
+	frames := (interval + p.frameDuration/2) / p.frameDuration
+	if frames >= 1 && interval-frames*p.frameDuration <= tolerance && frames*p.frameDuration-interval <= tolerance {
+		return
+	}
+	report(TimestampViolationIrregularInterval, "interval %d is not a multiple of the frame duration %d", interval, p.frameDuration)
+}
+
+func mostFrequentInt64(s []int64) (o int64) {
+	counts := make(map[int64]int)
+	var max int
+	for _, i := range s {
+		counts[i]++
+		if c := counts[i]; c > max || (c == max && i < o) {
+			max = c
+			o = i
+		}
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampValidator(t *testing.T) {
+	// Mux 30 video and audio frames of 40ms with a 300ms delay, audio skipping 1s of frames
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	err = mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x101, StreamType: StreamTypeADTS})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := int64(0); idx < 30; idx++ {
+		pcr := idx * 3600
+		dts := pcr + 27000
+		pts := dts + 3600
+		switch idx {
+		case 12:
+			pts = dts - 3600
+		case 15:
+			dts -= 3600
+		case 20:
+			dts += 1800
+		}
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(pcr, 0)},
+			PID:             0x100,
+			PES: &PESData{Data: []byte{0x1}, Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+				DTS:             newClockReference(dts, 0),
+				PTS:             newClockReference(pts, 0),
+				PTSDTSIndicator: PTSDTSIndicatorBothPresent,
+			}}},
+		})
+		assert.NoError(t, err)
+
+		pts = pcr + 27000
+		switch {
+		case idx == 5:
+			pts = pcr - 900
+		case idx >= 25:
+			pts += 90000
+		}
+		_, err = mx.WriteData(&MuxerData{
+			PID: 0x101,
+			PES: &PESData{Data: []byte{0x1}, Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+				PTS:             newClockReference(pts, 0),
+				PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+			}}},
+		})
+		assert.NoError(t, err)
+	}
+
+	// Validate
+	v := NewTimestampValidator()
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()), DemuxerOptAdaptationFieldData())
+	vs := make(map[uint16][]TimestampViolationKind)
+	var first *TimestampViolation
+	for {
+		d, err := dmx.NextData()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		for _, vl := range v.Validate(d) {
+			if first == nil {
+				first = vl
+			}
+			vs[vl.PID] = append(vs[vl.PID], vl.Kind)
+		}
+	}
+	assert.Equal(t, map[uint16][]TimestampViolationKind{
+		0x100: {
+			TimestampViolationPTSBeforeDTS,
+			TimestampViolationDTSNotMonotonic,
+			TimestampViolationIrregularInterval,
+			TimestampViolationIrregularInterval,
+		},
+		0x101: {
+			TimestampViolationDTSNotMonotonic,
+			TimestampViolationNegativeDelay,
+			TimestampViolationExcessiveDelay,
+			TimestampViolationAVOffset,
+			TimestampViolationExcessiveDelay,
+			TimestampViolationAVOffset,
+			TimestampViolationExcessiveDelay,
+			TimestampViolationAVOffset,
+			TimestampViolationExcessiveDelay,
+			TimestampViolationAVOffset,
+			TimestampViolationExcessiveDelay,
+			TimestampViolationAVOffset,
+		},
+	}, vs)
+	assert.Equal(t, uint16(1), first.ProgramNumber)
+	assert.Equal(t, newClockReference(5*3600-900, 0), first.PTS)
+	assert.Nil(t, first.DTS)
+	assert.Equal(t, newClockReference(5*3600, 0), first.PCR)
+}
@@ -0,0 +1,711 @@
+package astits
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/icza/bitio"
+)
+
+// TR 101 290 indicators.
+// Chapter: 5.2 | Link: https://www.etsi.org/deliver/etsi_tr/101200_101299/101290/01.04.01_60/tr_101290v010401p.pdf
+const (
+	// Priority 1
+	TR101290IndicatorTSSyncLoss           TR101290Indicator = "TS_sync_loss"
+	TR101290IndicatorSyncByteError        TR101290Indicator = "Sync_byte_error"
+	TR101290IndicatorPATError             TR101290Indicator = "PAT_error"
+	TR101290IndicatorContinuityCountError TR101290Indicator = "Continuity_count_error"
+	TR101290IndicatorPMTError             TR101290Indicator = "PMT_error"
+	TR101290IndicatorPIDError             TR101290Indicator = "PID_error"
+
+	// Priority 2
+	TR101290IndicatorTransportError                 TR101290Indicator = "Transport_error"
+	TR101290IndicatorCRCError                       TR101290Indicator = "CRC_error"
+	TR101290IndicatorPCRRepetitionError             TR101290Indicator = "PCR_repetition_error"
+	TR101290IndicatorPCRDiscontinuityIndicatorError TR101290Indicator = "PCR_discontinuity_indicator_error"
+	TR101290IndicatorPCRAccuracyError               TR101290Indicator = "PCR_accuracy_error"
+	TR101290IndicatorPTSError                       TR101290Indicator = "PTS_error"
+	TR101290IndicatorCATError                       TR101290Indicator = "CAT_error"
+
+	// Priority 3
+	TR101290IndicatorNITError          TR101290Indicator = "NIT_error"
+	TR101290IndicatorSIRepetitionError TR101290Indicator = "SI_repetition_error"
+	TR101290IndicatorUnreferencedPID   TR101290Indicator = "Unreferenced_PID"
+	TR101290IndicatorSDTError          TR101290Indicator = "SDT_error"
+	TR101290IndicatorEITError          TR101290Indicator = "EIT_error"
+	TR101290IndicatorTDTError          TR101290Indicator = "TDT_error"
+)
+
+// DVB SI PIDs.
+const (
+	pidNIT uint16 = 0x10
+	pidSDT uint16 = 0x11
+	pidEIT uint16 = 0x12
+	pidTDT uint16 = 0x14
+)
+
+// psiTableIDCAT is the table ID of the CAT, which we don't parse.
+const psiTableIDCAT PSITableID = 0x01
+
+// TR101290Indicator represents a TR 101 290 indicator.
+type TR101290Indicator string
+
+// Priority returns the priority of the indicator, from 1 to 3.
+func (i TR101290Indicator) Priority() int {
+	switch i {
+	case TR101290IndicatorTSSyncLoss, TR101290IndicatorSyncByteError, TR101290IndicatorPATError,
+		TR101290IndicatorContinuityCountError, TR101290IndicatorPMTError, TR101290IndicatorPIDError:
+		return 1
+	case TR101290IndicatorTransportError, TR101290IndicatorCRCError, TR101290IndicatorPCRRepetitionError,
+		TR101290IndicatorPCRDiscontinuityIndicatorError, TR101290IndicatorPCRAccuracyError,
+		TR101290IndicatorPTSError, TR101290IndicatorCATError:
+		return 2
+	}
+	return 3
+}
+
+// TR101290Thresholds represents the thresholds of the TR 101 290 indicators.
+type TR101290Thresholds struct {
+	EITMaxInterval             time.Duration
+	NITMaxInterval             time.Duration
+	PATMaxInterval             time.Duration
+	PCRMaxAccuracy             time.Duration
+	PCRMaxDiscontinuity        time.Duration
+	PCRMaxInterval             time.Duration
+	PIDMaxInterval             time.Duration
+	PMTMaxInterval             time.Duration
+	PTSMaxInterval             time.Duration
+	SDTMaxInterval             time.Duration
+	SIMinInterval              time.Duration
+	TDTMaxInterval             time.Duration
+	UnreferencedPIDMaxInterval time.Duration
+}
+
+// DefaultTR101290Thresholds returns the thresholds recommended by TR 101 290.
+func DefaultTR101290Thresholds() TR101290Thresholds {
+	return TR101290Thresholds{
+		EITMaxInterval:             2 * time.Second,
+		NITMaxInterval:             10 * time.Second,
+		PATMaxInterval:             500 * time.Millisecond,
+		PCRMaxAccuracy:             500 * time.Nanosecond,
+		PCRMaxDiscontinuity:        100 * time.Millisecond,
+		PCRMaxInterval:             100 * time.Millisecond,
+		PIDMaxInterval:             5 * time.Second,
+		PMTMaxInterval:             500 * time.Millisecond,
+		PTSMaxInterval:             700 * time.Millisecond,
+		SDTMaxInterval:             2 * time.Second,
+		SIMinInterval:              25 * time.Millisecond,
+		TDTMaxInterval:             30 * time.Second,
+		UnreferencedPIDMaxInterval: 500 * time.Millisecond,
+	}
+}
+
+// TR101290Event represents an error reported by a TR 101 290 indicator.
+type TR101290Event struct {
+	Indicator   TR101290Indicator
+	Message     string
+	Offset      int64 // Byte offset of the packet that triggered the error.
+	PacketIndex int64 // Ordinal of the packet that triggered the error.
+	PID         uint16
+	Time        time.Duration // Time elapsed since the first packet.
+}
+
+// TR101290Analyzer measures the TR 101 290 indicators of the packets of a transport
+// stream. Unless a wall clock is provided, time is derived from the PCRs of the first
+// PCR PID and from the byte offsets of the packets.
+type TR101290Analyzer struct {
+	catReceived     bool
+	catReported     map[uint16]bool // Indexed by PID
+	ccs             map[uint16]*tr101290ContinuityCounter
+	clock           *pcrClock
+	counters        map[TR101290Indicator]int
+	eit             *tr101290Timer
+	es              map[uint16]*tr101290Timer // Indexed by elementary PID
+	events          []*TR101290Event
+	lastPacket      *Packet
+	nit             *tr101290Timer
+	now             time.Duration
+	optNow          func() time.Time
+	optPacketSize   int
+	optThresholds   TR101290Thresholds
+	packet          *Packet
+	pat             *tr101290Timer
+	pcrPIDs         map[uint16]uint16         // Indexed by program number
+	pcrs            map[uint16]*tr101290PCR   // Indexed by PCR PID
+	pmts            map[uint16]*tr101290Timer // Indexed by PMT PID
+	programMap      *programMap
+	ptss            map[uint16]*tr101290Timer // Indexed by elementary PID
+	referenced      map[uint16]bool           // Indexed by PID
+	sdt             *tr101290Timer
+	sections        map[uint64]time.Duration // Indexed by PID, table ID, table ID extension and section number
+	start           time.Time
+	syncLost        bool
+	syncs           int               // Consecutive correct sync bytes
+	tables          map[uint16][]byte // Indexed by PID
+	tdt             *tr101290Timer
+	unreferenced    map[uint16]*tr101290Timer // Indexed by PID
+	unreferencedErr map[uint16]bool           // Indexed by PID
+}
+
+type tr101290ContinuityCounter struct {
+	cc         uint8
+	duplicates int
+}
+
+type tr101290PCR struct {
+	discontinuity bool
+	last          *ClockReference
+	lastBitrate   float64 // In bits per second.
+	lastOffset    int64
+	timer         *tr101290Timer
+}
+
+// tr101290Timer keeps track of the last time something occurred.
+type tr101290Timer struct {
+	last     time.Duration
+	reported bool
+}
+
+func newTR101290Timer(now time.Duration) *tr101290Timer {
+	return &tr101290Timer{last: now}
+}
+
+func (t *tr101290Timer) reset(now time.Duration) {
+	t.last = now
+	t.reported = false
+}
+
+// expired returns true, only once per interval, if nothing occurred for more than max.
+func (t *tr101290Timer) expired(now, max time.Duration) bool {
+	if t.reported || now-t.last <= max {
+		return false
+	}
+	t.reported = true
+	return true
+}
+
+// TR101290OptNow returns the option to measure time with a wall clock, which is
+// needed for live streams whose packets are analyzed as they are received.
+func TR101290OptNow(now func() time.Time) func(*TR101290Analyzer) {
+	return func(a *TR101290Analyzer) {
+		a.optNow = now
+	}
+}
+
+// TR101290OptPacketSize returns the option to set the packet size, which is needed
+// to detect sync byte errors. Default is 188.
+func TR101290OptPacketSize(packetSize int) func(*TR101290Analyzer) {
+	return func(a *TR101290Analyzer) {
+		a.optPacketSize = packetSize
+	}
+}
+
+// TR101290OptThresholds returns the option to set the thresholds.
+func TR101290OptThresholds(t TR101290Thresholds) func(*TR101290Analyzer) {
+	return func(a *TR101290Analyzer) {
+		a.optThresholds = t
+	}
+}
+
+// NewTR101290Analyzer creates a new TR 101 290 analyzer.
+func NewTR101290Analyzer(opts ...func(*TR101290Analyzer)) *TR101290Analyzer {
+	a := &TR101290Analyzer{
+		catReported:     make(map[uint16]bool),
+		ccs:             make(map[uint16]*tr101290ContinuityCounter),
+		clock:           newPCRClock(),
+		counters:        make(map[TR101290Indicator]int),
+		es:              make(map[uint16]*tr101290Timer),
+		optPacketSize:   MpegTsPacketSize,
+		optThresholds:   DefaultTR101290Thresholds(),
+		pcrPIDs:         make(map[uint16]uint16),
+		pcrs:            make(map[uint16]*tr101290PCR),
+		pmts:            make(map[uint16]*tr101290Timer),
+		programMap:      newProgramMap(),
+		ptss:            make(map[uint16]*tr101290Timer),
+		referenced:      make(map[uint16]bool),
+		sections:        make(map[uint64]time.Duration),
+		tables:          make(map[uint16][]byte),
+		unreferenced:    make(map[uint16]*tr101290Timer),
+		unreferencedErr: make(map[uint16]bool),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Counters returns the number of errors reported so far for each indicator.
+func (a *TR101290Analyzer) Counters() map[TR101290Indicator]int {
+	cs := make(map[TR101290Indicator]int)
+	for k, v := range a.counters {
+		cs[k] = v
+	}
+	return cs
+}
+
+// Analyze processes a packet, that must be provided in the order of the input, and
+// returns the errors it triggered. Sync errors are only detected when packets are read
+// by a demuxer created with DemuxerOptResync.
+func (a *TR101290Analyzer) Analyze(p *Packet) []*TR101290Event {
+	// Update time
+	a.events = nil
+	a.packet = p
+	if a.optNow != nil {
+		if a.start.IsZero() {
+			a.start = a.optNow()
+		}
+		a.now = a.optNow().Sub(a.start)
+	} else {
+		a.clock.update(p)
+		a.now = a.clock.now(p)
+	}
+	if a.pat == nil {
+		a.pat = newTR101290Timer(a.now)
+	}
+
+	// Packet level indicators
+	a.analyzeSync(p)
+	a.lastPacket = p
+	if p.Header.TransportErrorIndicator {
+		a.report(TR101290IndicatorTransportError, p.Header.PID, "transport error indicator is set")
+	} else {
+		a.analyzeContinuityCounter(p)
+		a.analyzeTableIDs(p)
+		a.analyzeScrambling(p)
+		a.analyzePCR(p)
+		a.analyzePTS(p)
+		a.analyzeReferences(p)
+
+		a.analyzeTables(p)
+	}
+
+	a.analyzeIntervals()
+	return a.events
+}
+
+func (a *TR101290Analyzer) report(i TR101290Indicator, pid uint16, format string, args ...interface{}) {
+	a.counters[i]++
+	a.events = append(a.events, &TR101290Event{
+		Indicator:   i,
+		Message:     fmt.Sprintf(format, args...),
+		Offset:      a.packet.Offset,
+		PacketIndex: a.packet.Index,
+		PID:         pid,
+		Time:        a.now,
+	})
+}
+
+// tr101290SyncLossBytes is the number of consecutive corrupted sync bytes after which sync
+// is lost, and tr101290SyncBytes the number of consecutive correct sync bytes after which
+// it is acquired again.
+const (
+	tr101290SyncLossBytes = 2
+	tr101290SyncBytes     = 5
+)
+
+// analyzeSync detects bytes that have been skipped while looking for sync bytes. Every
+// packet boundary located in the skipped bytes is a corrupted sync byte.
+func (a *TR101290Analyzer) analyzeSync(p *Packet) {
+	// Corrupted sync bytes
+	if a.lastPacket != nil {
+		if skipped := p.Offset - a.lastPacket.Offset - int64(a.optPacketSize); skipped > 0 {
+			a.report(TR101290IndicatorSyncByteError, p.Header.PID, "%d bytes skipped before sync byte", skipped)
+			a.syncs = 0
+			corrupted := (skipped + int64(a.optPacketSize) - 1) / int64(a.optPacketSize)
+			if !a.syncLost && corrupted >= tr101290SyncLossBytes {
+				a.syncLost = true
+				a.report(TR101290IndicatorTSSyncLoss, p.Header.PID, "%d corrupted sync bytes", corrupted)
+			}
+		}
+	}
+
+	// The packet starts with a correct sync byte
+	a.syncs++
+	if a.syncLost && a.syncs >= tr101290SyncBytes {
+		a.syncLost = false
+	}
+}
+
+// analyzeContinuityCounter detects packets received in the wrong order, lost or
+// received more than twice.
+func (a *TR101290Analyzer) analyzeContinuityCounter(p *Packet) {
+	if p.Header.PID == PIDNull {
+		return
+	}
+
+	c, ok := a.ccs[p.Header.PID]
+	if !ok || (p.Header.HasAdaptationField && p.AdaptationField.DiscontinuityIndicator) {
+		a.ccs[p.Header.PID] = &tr101290ContinuityCounter{cc: p.Header.ContinuityCounter}
+		return
+	}
+
+	switch {
+	case !p.Header.HasPayload:
+		if p.Header.ContinuityCounter != c.cc {
+			a.report(TR101290IndicatorContinuityCountError, p.Header.PID, "got %d, expected %d", p.Header.ContinuityCounter, c.cc)
+		}
+	case p.Header.ContinuityCounter == c.cc:
+		c.duplicates++
+		if c.duplicates > 1 {
+			a.report(TR101290IndicatorContinuityCountError, p.Header.PID, "packet received %d times", c.duplicates+1)
+		}
+	default:
+		if expected := (c.cc + 1) % 16; p.Header.ContinuityCounter != expected {
+			a.report(TR101290IndicatorContinuityCountError, p.Header.PID, "got %d, expected %d", p.Header.ContinuityCounter, expected)
+		}
+		c.duplicates = 0
+	}
+	c.cc = p.Header.ContinuityCounter
+}
+
+// analyzeTableIDs detects sections whose table ID is not allowed on their PID.
+func (a *TR101290Analyzer) analyzeTableIDs(p *Packet) {
+	if !p.Header.PayloadUnitStartIndicator || len(p.Payload) == 0 || int(p.Payload[0])+1 >= len(p.Payload) {
+		return
+	}
+	t := PSITableID(p.Payload[int(p.Payload[0])+1])
+	pid := p.Header.PID
+
+	switch {
+	case pid == PIDPAT:
+		if t != PSITableIDPAT {
+			a.report(TR101290IndicatorPATError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	case pid == PIDCAT:
+		if t != psiTableIDCAT {
+			a.report(TR101290IndicatorCATError, pid, "invalid table id 0x%x", uint16(t))
+		} else {
+			a.catReceived = true
+		}
+	case a.programMap.exists(pid):
+		if t != PSITableIDPMT {
+			a.report(TR101290IndicatorPMTError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	case pid == pidNIT:
+		if t != PSITableIDNITVariant1 && t != PSITableIDNITVariant2 && t != PSITableIDST {
+			a.report(TR101290IndicatorNITError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	case pid == pidSDT:
+		if t != PSITableIDSDTVariant1 && t != PSITableIDSDTVariant2 && t != PSITableIDBAT && t != PSITableIDST {
+			a.report(TR101290IndicatorSDTError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	case pid == pidEIT:
+		if (t < PSITableIDEITStart || t > PSITableIDEITEnd) && t != PSITableIDST {
+			a.report(TR101290IndicatorEITError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	case pid == pidTDT:
+		if t != PSITableIDTDT && t != PSITableIDTOT && t != PSITableIDST {
+			a.report(TR101290IndicatorTDTError, pid, "invalid table id 0x%x", uint16(t))
+		}
+	}
+}
+
+// analyzeScrambling detects scrambled PAT and PMTs, and scrambled packets without CAT.
+func (a *TR101290Analyzer) analyzeScrambling(p *Packet) {
+	if p.Header.TransportScramblingControl == ScramblingControlNotScrambled {
+		return
+	}
+	pid := p.Header.PID
+	switch {
+	case pid == PIDPAT:
+		a.report(TR101290IndicatorPATError, pid, "PAT is scrambled")
+	case a.programMap.exists(pid):
+		a.report(TR101290IndicatorPMTError, pid, "PMT is scrambled")
+	case !a.catReceived && !a.catReported[pid]:
+		a.catReported[pid] = true
+		a.report(TR101290IndicatorCATError, pid, "packet is scrambled but no CAT has been received")
+	}
+}
+
+// analyzePCR detects PCR discontinuities and inaccurate PCRs.
+func (a *TR101290Analyzer) analyzePCR(p *Packet) {
+	// PCR PIDs are only known once PMTs have been received
+	pid := p.Header.PID
+	s, ok := a.pcrs[pid]
+	if !ok {
+		return
+	}
+
+	if !p.Header.HasAdaptationField {
+		return
+	}
+	if p.AdaptationField.DiscontinuityIndicator {
+		s.discontinuity = true
+	}
+	if !p.AdaptationField.HasPCR {
+		return
+	}
+	pcr := p.AdaptationField.PCR
+
+	// Repetition, which is also checked for packets without PCR
+	if s.timer.expired(a.now, a.optThresholds.PCRMaxInterval) {
+		a.report(TR101290IndicatorPCRRepetitionError, pid, "no PCR for more than %s", a.optThresholds.PCRMaxInterval)
+	}
+	s.timer.reset(a.now)
+
+	if s.last != nil && !s.discontinuity {
+		// Discontinuity
+		d := pcr.Sub(*s.last)
+		if d < 0 || d > a.optThresholds.PCRMaxDiscontinuity {
+			a.report(TR101290IndicatorPCRDiscontinuityIndicatorError, pid, "PCR jumped %s without discontinuity indicator", d)
+		} else if d > 0 {
+			// Accuracy, assuming the bitrate has not changed since the previous PCR
+			bitrate := float64((p.Offset-s.lastOffset)*8) / d.Seconds()
+			if s.lastBitrate > 0 {
+				expected := time.Duration(float64((p.Offset-s.lastOffset)*8) / s.lastBitrate * float64(time.Second))
+				if ac := d - expected; ac > a.optThresholds.PCRMaxAccuracy || ac < -a.optThresholds.PCRMaxAccuracy {
+					a.report(TR101290IndicatorPCRAccuracyError, pid, "PCR is off by %s", ac)
+				}
+			}
+			s.lastBitrate = bitrate
+		}
+	} else {
+		s.lastBitrate = 0
+	}
+	s.discontinuity = false
+	s.last = pcr
+	s.lastOffset = p.Offset
+}
+
+// analyzePTS keeps track of the PTS of elementary streams.
+func (a *TR101290Analyzer) analyzePTS(p *Packet) {
+	if _, ok := a.es[p.Header.PID]; !ok {
+		return
+	}
+	if h := packetPESOptionalHeader(p, a.programMap); h != nil && h.PTS != nil {
+		if t, ok := a.ptss[p.Header.PID]; ok {
+			t.reset(a.now)
+		} else {
+			a.ptss[p.Header.PID] = newTR101290Timer(a.now)
+		}
+	}
+}
+
+// analyzeReferences keeps track of the packets of referenced and unreferenced PIDs.
+func (a *TR101290Analyzer) analyzeReferences(p *Packet) {
+	pid := p.Header.PID
+	if t, ok := a.es[pid]; ok {
+		t.reset(a.now)
+		return
+	}
+	if pid <= 0x1f || pid == PIDNull || a.referenced[pid] {
+		return
+	}
+	if _, ok := a.unreferenced[pid]; !ok {
+		a.unreferenced[pid] = newTR101290Timer(a.now)
+	}
+}
+
+// isTablePID checks whether the PID carries tables that need to be parsed.
+func (a *TR101290Analyzer) isTablePID(pid uint16) bool {
+	return pid == PIDPAT || a.programMap.exists(pid) || pid == pidNIT || pid == pidSDT || pid == pidEIT || pid == pidTDT
+}
+
+// analyzeTables accumulates the payloads of table PIDs and analyzes every section as soon
+// as it is complete. Unlike the packet pool, it doesn't wait for the next payload unit start
+// and keeps sections whose CRC is invalid.
+func (a *TR101290Analyzer) analyzeTables(p *Packet) {
+	pid := p.Header.PID
+	if !a.isTablePID(pid) || !p.Header.HasPayload {
+		return
+	}
+
+	// Accumulate, starting with the first section of the payload unit
+	b, ok := a.tables[pid]
+	switch {
+	case p.Header.PayloadUnitStartIndicator:
+		if len(p.Payload) < 1 || int(p.Payload[0])+1 > len(p.Payload) {
+			delete(a.tables, pid)
+			return
+		}
+		b = append([]byte{}, p.Payload[int(p.Payload[0])+1:]...)
+	case ok:
+		b = append(b, p.Payload...)
+	default:
+		return
+	}
+
+	// Analyze complete sections until stuffing is reached
+	for len(b) >= 3 && PSITableID(b[0]) != PSITableIDNull {
+		l := 3 + (int(b[1]&0xf)<<8 | int(b[2]))
+		if len(b) < l {
+			break
+		}
+		a.analyzeSection(p, b[:l])
+		b = b[l:]
+	}
+	if len(b) == 0 || PSITableID(b[0]) == PSITableIDNull {
+		delete(a.tables, pid)
+		return
+	}
+	a.tables[pid] = b
+}
+
+// analyzeSection checks the CRC and the repetition rate of a section, and updates the
+// programs.
+func (a *TR101290Analyzer) analyzeSection(p *Packet, section []byte) {
+	// Parse, the section being preceded by a pointer field and followed by stuffing
+	pid := p.Header.PID
+	b := make([]byte, 0, len(section)+2)
+	b = append(append(append(b, 0), section...), byte(PSITableIDNull))
+	d, err := parsePSIData(bitio.NewCountReader(bytes.NewReader(b)))
+	if err != nil {
+		if errors.Is(err, ErrPSIInvalidCRC32) {
+			a.report(TR101290IndicatorCRCError, pid, "%s", err)
+		}
+		return
+	}
+
+	for _, s := range d.Sections {
+		// Repetition rate
+		if s.Syntax != nil && s.Syntax.Header != nil && pid >= pidNIT && pid <= pidTDT {
+			k := uint64(pid)<<40 | uint64(s.Header.TableID)<<24 | uint64(s.Syntax.Header.TableIDExtension)<<8 | uint64(s.Syntax.Header.SectionNumber)
+			if last, ok := a.sections[k]; ok && a.now-last < a.optThresholds.SIMinInterval {
+				a.report(TR101290IndicatorSIRepetitionError, pid, "section of table id 0x%x repeated after %s", uint16(s.Header.TableID), a.now-last)
+			}
+			a.sections[k] = a.now
+		}
+
+		// Tables
+		switch {
+		case pid == PIDPAT && s.Header.TableID == PSITableIDPAT:
+			a.pat.reset(a.now)
+		case pid == pidNIT && s.Header.TableID == PSITableIDNITVariant1:
+			a.nit = a.resetTimer(a.nit)
+		case pid == pidSDT && s.Header.TableID == PSITableIDSDTVariant1:
+			a.sdt = a.resetTimer(a.sdt)
+		case pid == pidEIT && s.Header.TableID == PSITableIDEITStart:
+			a.eit = a.resetTimer(a.eit)
+		case pid == pidTDT && s.Header.TableID == PSITableIDTDT:
+			a.tdt = a.resetTimer(a.tdt)
+		}
+	}
+
+	// Programs
+	for _, d := range d.toData(p, pid) {
+		switch {
+		case d.PAT != nil:
+			for _, pgm := range d.PAT.Programs {
+				// Program number 0 is reserved to NIT.
+				if pgm.ProgramNumber == 0 {
+					continue
+				}
+				a.programMap.set(pgm.ProgramMapID, pgm.ProgramNumber)
+				a.referenced[pgm.ProgramMapID] = true
+				if _, ok := a.pmts[pgm.ProgramMapID]; !ok {
+					a.pmts[pgm.ProgramMapID] = newTR101290Timer(a.now)
+				}
+				delete(a.unreferenced, pgm.ProgramMapID)
+			}
+		case d.PMT != nil:
+			a.pmts[pid] = a.resetTimer(a.pmts[pid])
+			// The PCR PID of the program may have changed
+			if pcrPID, ok := a.pcrPIDs[d.PMT.ProgramNumber]; ok && pcrPID != d.PMT.PCRPID {
+				delete(a.pcrPIDs, d.PMT.ProgramNumber)
+				if !a.isPCRPID(pcrPID) {
+					delete(a.pcrs, pcrPID)
+				}
+			}
+			if d.PMT.PCRPID != PIDNull {
+				a.pcrPIDs[d.PMT.ProgramNumber] = d.PMT.PCRPID
+				if _, ok := a.pcrs[d.PMT.PCRPID]; !ok {
+					a.pcrs[d.PMT.PCRPID] = &tr101290PCR{timer: newTR101290Timer(a.now)}
+				}
+				a.referenced[d.PMT.PCRPID] = true
+				delete(a.unreferenced, d.PMT.PCRPID)
+			}
+			for _, es := range d.PMT.ElementaryStreams {
+				if _, ok := a.es[es.ElementaryPID]; !ok {
+					a.es[es.ElementaryPID] = newTR101290Timer(a.now)
+				}
+				a.referenced[es.ElementaryPID] = true
+				delete(a.unreferenced, es.ElementaryPID)
+			}
+		}
+	}
+}
+
+// isPCRPID checks whether a PID is the PCR PID of a program.
+func (a *TR101290Analyzer) isPCRPID(pid uint16) bool {
+	for _, v := range a.pcrPIDs {
+		if v == pid {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *TR101290Analyzer) resetTimer(t *tr101290Timer) *tr101290Timer {
+	if t == nil {
+		return newTR101290Timer(a.now)
+	}
+	t.reset(a.now)
+	return t
+}
+
+// tr101290TimerPIDs returns the PIDs of a set of timers in ascending order, so that events
+// are emitted in a deterministic order.
+func tr101290TimerPIDs(m map[uint16]*tr101290Timer) []uint16 {
+	pids := make([]uint16, 0, len(m))
+	for pid := range m {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids
+}
+
+// analyzeIntervals detects things that didn't occur for too long.
+func (a *TR101290Analyzer) analyzeIntervals() {
+	th := a.optThresholds
+	if a.pat.expired(a.now, th.PATMaxInterval) {
+		a.report(TR101290IndicatorPATError, PIDPAT, "no PAT for more than %s", th.PATMaxInterval)
+	}
+	for _, pid := range tr101290TimerPIDs(a.pmts) {
+		if a.pmts[pid].expired(a.now, th.PMTMaxInterval) {
+			a.report(TR101290IndicatorPMTError, pid, "no PMT for more than %s", th.PMTMaxInterval)
+		}
+	}
+	for _, pid := range tr101290TimerPIDs(a.es) {
+		if a.es[pid].expired(a.now, th.PIDMaxInterval) {
+			a.report(TR101290IndicatorPIDError, pid, "no packet for more than %s", th.PIDMaxInterval)
+		}
+	}
+	pcrPIDs := make([]uint16, 0, len(a.pcrs))
+	for pid := range a.pcrs {
+		pcrPIDs = append(pcrPIDs, pid)
+	}
+	sort.Slice(pcrPIDs, func(i, j int) bool { return pcrPIDs[i] < pcrPIDs[j] })
+	for _, pid := range pcrPIDs {
+		if a.pcrs[pid].timer.expired(a.now, th.PCRMaxInterval) {
+			a.report(TR101290IndicatorPCRRepetitionError, pid, "no PCR for more than %s", th.PCRMaxInterval)
+		}
+	}
+	for _, pid := range tr101290TimerPIDs(a.ptss) {
+		if a.ptss[pid].expired(a.now, th.PTSMaxInterval) {
+			a.report(TR101290IndicatorPTSError, pid, "no PTS for more than %s", th.PTSMaxInterval)
+		}
+	}
+	for _, pid := range tr101290TimerPIDs(a.unreferenced) {
+		if !a.unreferencedErr[pid] && a.now-a.unreferenced[pid].last > th.UnreferencedPIDMaxInterval {
+			a.unreferencedErr[pid] = true
+			a.report(TR101290IndicatorUnreferencedPID, pid, "PID not referenced for more than %s", th.UnreferencedPIDMaxInterval)
+		}
+	}
+
+	// SI tables are only checked once they have been received
+	if a.nit != nil && a.nit.expired(a.now, th.NITMaxInterval) {
+		a.report(TR101290IndicatorNITError, pidNIT, "no NIT for more than %s", th.NITMaxInterval)
+	}
+	if a.sdt != nil && a.sdt.expired(a.now, th.SDTMaxInterval) {
+		a.report(TR101290IndicatorSDTError, pidSDT, "no SDT for more than %s", th.SDTMaxInterval)
+	}
+	if a.eit != nil && a.eit.expired(a.now, th.EITMaxInterval) {
+		a.report(TR101290IndicatorEITError, pidEIT, "no EIT for more than %s", th.EITMaxInterval)
+	}
+	if a.tdt != nil && a.tdt.expired(a.now, th.TDTMaxInterval) {
+		a.report(TR101290IndicatorTDTError, pidTDT, "no TDT for more than %s", th.TDTMaxInterval)
+	}
+}
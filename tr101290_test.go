@@ -0,0 +1,257 @@
+package astits
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tr101290Bytes returns a constant bitrate stream with tables, PCR and PTS every 40ms.
+func tr101290Bytes(t *testing.T, count int) []byte {
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf, MuxerOptTablesRetransmitPeriod(1))
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < count; idx++ {
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(int64(idx*3600), 0)},
+			PID:             0x100,
+			PES: &PESData{
+				Data: bytes.Repeat([]byte{0x1}, 500),
+				Header: &PESHeader{OptionalHeader: &PESOptionalHeader{
+					PTS:             newClockReference(int64(idx*3600+9000), 0),
+					PTSDTSIndicator: PTSDTSIndicatorOnlyPTS,
+				}},
+			},
+		})
+		assert.NoError(t, err)
+	}
+	return buf.Bytes()
+}
+
+func analyzeTR101290(t *testing.T, b []byte, opts ...func(*TR101290Analyzer)) (*TR101290Analyzer, []*TR101290Event) {
+	a := NewTR101290Analyzer(opts...)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(b), DemuxerOptPacketSize(MpegTsPacketSize), DemuxerOptResync())
+	var es []*TR101290Event
+	for {
+		p, err := dmx.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		es = append(es, a.Analyze(p)...)
+	}
+	return a, es
+}
+
+func TestTR101290Analyzer(t *testing.T) {
+	b := tr101290Bytes(t, 50)
+
+	// Valid
+	a, es := analyzeTR101290(t, b)
+	assert.Empty(t, es)
+	assert.Equal(t, map[TR101290Indicator]int{}, a.Counters())
+
+	// Corrupted
+	c := append([]byte{}, b...)
+	c[20*188+1] |= 0x80  // Transport error indicator
+	c[40*188+10] ^= 0xff // PAT CRC
+	c = append(c[:60*188], append(bytes.Repeat([]byte{0x1}, 10), c[60*188:]...)...)
+	a, es = analyzeTR101290(t, c)
+	cs := a.Counters()
+	assert.Equal(t, 1, cs[TR101290IndicatorTransportError])
+	assert.Equal(t, 1, cs[TR101290IndicatorCRCError])
+	assert.Equal(t, 1, cs[TR101290IndicatorSyncByteError])
+	assert.Equal(t, 0, cs[TR101290IndicatorTSSyncLoss])
+	for _, e := range es {
+		if e.Indicator == TR101290IndicatorSyncByteError {
+			assert.Equal(t, int64(60*188+10), e.Offset)
+			assert.Equal(t, int64(60), e.PacketIndex)
+			assert.Equal(t, uint16(0), e.PID)
+		}
+	}
+
+	// Sync is lost after 2 corrupted sync bytes and acquired again after 5 correct ones
+	garbage := func(b []byte, off, n int) []byte {
+		return append(append(append([]byte{}, b[:off]...), bytes.Repeat([]byte{0x1}, n)...), b[off:]...)
+	}
+	c = garbage(b, 80*188, 200)
+	c = garbage(c, 60*188, 10)
+	c = garbage(c, 57*188, 200)
+	c = garbage(c, 55*188, 200)
+	c = garbage(c, 20*188, 200)
+	a, _ = analyzeTR101290(t, c)
+	cs = a.Counters()
+	assert.Equal(t, 5, cs[TR101290IndicatorSyncByteError])
+	assert.Equal(t, 3, cs[TR101290IndicatorTSSyncLoss])
+	assert.Equal(t, 1, TR101290IndicatorSyncByteError.Priority())
+	assert.Equal(t, 2, TR101290IndicatorCRCError.Priority())
+	assert.Equal(t, 3, TR101290IndicatorSDTError.Priority())
+
+	// Missing tables and PCRs
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf, MuxerOptTablesRetransmitPeriod(1000))
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x100, StreamType: StreamTypeH264Video})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x100)
+	for idx := 0; idx < 10; idx++ {
+		_, err = mx.WriteData(&MuxerData{
+			AdaptationField: &PacketAdaptationField{HasPCR: true, PCR: newClockReference(int64(idx*45000), 0)},
+			PID:             0x100,
+			PES:             &PESData{Data: []byte{0x1}, Header: &PESHeader{}},
+		})
+		assert.NoError(t, err)
+	}
+	a, _ = analyzeTR101290(t, buf.Bytes())
+	cs = a.Counters()
+	assert.Equal(t, 1, cs[TR101290IndicatorPATError])
+	assert.Equal(t, 1, cs[TR101290IndicatorPMTError])
+	assert.Equal(t, 9, cs[TR101290IndicatorPCRRepetitionError])
+}
+
+func TestTR101290AnalyzerPackets(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewTR101290Analyzer(TR101290OptNow(func() time.Time { return now }), TR101290OptThresholds(TR101290Thresholds{
+		PATMaxInterval:             time.Hour,
+		PCRMaxDiscontinuity:        100 * time.Millisecond,
+		PCRMaxInterval:             time.Hour,
+		PIDMaxInterval:             time.Hour,
+		PMTMaxInterval:             time.Hour,
+		UnreferencedPIDMaxInterval: 500 * time.Millisecond,
+	}))
+	var offset int64
+	analyze := func(h PacketHeader, af *PacketAdaptationField) []TR101290Indicator {
+		h.HasAdaptationField = af != nil
+		p := &Packet{AdaptationField: af, Header: &h, Offset: offset}
+		offset += MpegTsPacketSize
+		var is []TR101290Indicator
+		for _, e := range a.Analyze(p) {
+			is = append(is, e.Indicator)
+		}
+		return is
+	}
+
+	// Continuity counter
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 15, HasPayload: true, PID: 0x100}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, HasPayload: true, PID: 0x100}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, HasPayload: true, PID: 0x100}, nil))
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorContinuityCountError}, analyze(PacketHeader{ContinuityCounter: 0, HasPayload: true, PID: 0x100}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x100}, nil))
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorContinuityCountError}, analyze(PacketHeader{ContinuityCounter: 2, HasPayload: true, PID: 0x100}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 5, HasPayload: true, PID: 0x100}, &PacketAdaptationField{DiscontinuityIndicator: true}))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: PIDNull}, nil))
+
+	// PCRs are ignored until the PMT is received
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(0, 0)}))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(90000*100, 0)}))
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: 0x101, StreamType: StreamTypeMetadata})
+	assert.NoError(t, err)
+	mx.SetPCRPID(0x101)
+	_, err = mx.WriteTables()
+	assert.NoError(t, err)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()))
+	for {
+		p, err := dmx.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		p.Offset = offset
+		offset += MpegTsPacketSize
+		assert.Empty(t, a.Analyze(p))
+	}
+
+	// PCR
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(0, 0)}))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(3600, 0)}))
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorPCRAccuracyError}, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(3600*3, 0)}))
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorPCRDiscontinuityIndicatorError}, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{HasPCR: true, PCR: newClockReference(0, 0)}))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 0, PID: 0x101}, &PacketAdaptationField{DiscontinuityIndicator: true, HasPCR: true, PCR: newClockReference(90000*10, 0)}))
+
+	// Scrambling
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorCATError}, analyze(PacketHeader{ContinuityCounter: 6, HasPayload: true, PID: 0x100, TransportScramblingControl: ScramblingControlScrambledWithEvenKey}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 7, HasPayload: true, PID: 0x100, TransportScramblingControl: ScramblingControlScrambledWithEvenKey}, nil))
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorPATError}, analyze(PacketHeader{ContinuityCounter: 0, HasPayload: true, PID: PIDPAT, TransportScramblingControl: ScramblingControlScrambledWithEvenKey}, nil))
+
+	// Unreferenced PID
+	now = now.Add(time.Second)
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorUnreferencedPID}, analyze(PacketHeader{ContinuityCounter: 8, HasPayload: true, PID: 0x100}, nil))
+	assert.Empty(t, analyze(PacketHeader{ContinuityCounter: 9, HasPayload: true, PID: 0x100}, nil))
+}
+
+func TestTR101290AnalyzerSections(t *testing.T) {
+	a := NewTR101290Analyzer()
+
+	// Every section of a payload unit is checked, even when it spans several packets
+	pat := &PATData{TransportStreamID: 1}
+	for idx := 0; idx < 40; idx++ {
+		pat.Programs = append(pat.Programs, &PATProgram{ProgramMapID: uint16(0x1000 + idx), ProgramNumber: uint16(idx + 1)})
+	}
+	ps, err := newPSIPackets(PIDPAT, PSITableIDPAT, 1, 0, &PSISectionSyntaxData{PAT: pat})
+	assert.NoError(t, err)
+	section := ps[0].Payload[1 : 1+3+(int(ps[0].Payload[2]&0xf)<<8|int(ps[0].Payload[3]))]
+	corrupted := append([]byte{}, section...)
+	corrupted[len(corrupted)-1] ^= 0xff
+	b := append(append([]byte{0}, section...), corrupted...)
+	size := MpegTsPacketSize - mpegTsPacketHeaderSize
+	b = append(b, bytes.Repeat([]byte{0xff}, 2*size-len(b))...)
+	var is []TR101290Indicator
+	for idx := 0; idx < 2; idx++ {
+		for _, e := range a.Analyze(&Packet{
+			Header:  &PacketHeader{ContinuityCounter: uint8(idx), HasPayload: true, PayloadUnitStartIndicator: idx == 0, PID: PIDPAT},
+			Payload: b[idx*size : (idx+1)*size],
+		}) {
+			is = append(is, e.Indicator)
+		}
+	}
+	assert.Equal(t, []TR101290Indicator{TR101290IndicatorCRCError}, is)
+}
+
+func TestTR101290AnalyzerEventsOrder(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := NewTR101290Analyzer(TR101290OptNow(func() time.Time { return now }), TR101290OptThresholds(TR101290Thresholds{
+		PATMaxInterval:             time.Hour,
+		PCRMaxInterval:             time.Hour,
+		PIDMaxInterval:             time.Second,
+		PMTMaxInterval:             time.Hour,
+		PTSMaxInterval:             time.Hour,
+		UnreferencedPIDMaxInterval: time.Hour,
+	}))
+	buf := &bytes.Buffer{}
+	mx := NewMuxer(context.Background(), buf)
+	pids := []uint16{0x100, 0x101, 0x102, 0x103, 0x104, 0x105, 0x106, 0x107}
+	for _, pid := range pids {
+		err := mx.AddElementaryStream(PMTElementaryStream{ElementaryPID: pid, StreamType: StreamTypeMetadata})
+		assert.NoError(t, err)
+	}
+	mx.SetPCRPID(0x100)
+	_, err := mx.WriteTables()
+	assert.NoError(t, err)
+	dmx := NewDemuxer(context.Background(), bytes.NewReader(buf.Bytes()))
+	for {
+		p, err := dmx.NextPacket()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		assert.Empty(t, a.Analyze(p))
+	}
+
+	// Events are sorted by PID
+	now = now.Add(2 * time.Second)
+	var o []uint16
+	for _, e := range a.Analyze(&Packet{Header: &PacketHeader{PID: PIDNull}}) {
+		if e.Indicator == TR101290IndicatorPIDError {
+			o = append(o, e.PID)
+		}
+	}
+	assert.Equal(t, pids, o)
+}